@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	"github.com/jghiloni/prometheus-remote-write/writertest"
+)
+
+func bench(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	url := fs.String("url", "", "remote write target URL")
+	format := fs.String("format", writer.Protobuf.String(), "format to send payloads in")
+	compression := fs.String("compression", writer.Snappy.String(), "compression to send payloads with")
+	tenant := fs.String("tenant", "", "tenant to send payloads as")
+	username := fs.String("username", "", "basic auth username")
+	password := fs.String("password", "", "basic auth password")
+	series := fs.Int("series", 1000, "number of synthetic series in each push")
+	samples := fs.Int("samples", 1, "number of samples per series in each push")
+	labels := fs.Int("labels", 3, "number of labels on each series besides __name__ (at least 1)")
+	rate := fs.Float64("rate", 1, "pushes per second to aim for")
+	duration := fs.Duration("duration", time.Minute, "how long to push for")
+	concurrency := fs.Int("concurrency", 1, "number of pushes that may be in progress at once")
+	fs.Parse(args)
+
+	if *url == "" {
+		fs.Usage()
+		return fmt.Errorf("-url is required")
+	}
+	if *series <= 0 || *samples <= 0 || *labels <= 0 || *rate <= 0 || *concurrency <= 0 {
+		return errors.New("-series, -samples, -labels, -rate and -concurrency must be positive")
+	}
+
+	options := writer.RemoteMetricsWriterOptions{Tenant: *tenant}
+
+	var err error
+	if options.Format, err = writer.ParseFormat(*format); err != nil {
+		return err
+	}
+	if options.Compression, err = writer.ParseCompression(*compression); err != nil {
+		return err
+	}
+	if *username != "" {
+		options.BasicAuth = &writer.BasicAuth{Username: *username, Password: *password}
+	}
+
+	w, err := writer.NewRemoteMetricsWriter(*url, options)
+	if err != nil {
+		return err
+	}
+
+	// pushes in progress when the duration is up are allowed to finish, so they are sent with ctx rather than done
+	done, cancel := context.WithTimeout(ctx, *duration)
+	defer cancel()
+
+	gen := writertest.NewSeriesGenerator(*series, *labels)
+	interval := time.Duration(float64(time.Second) / *rate)
+	stats := &benchStats{}
+	slots := make(chan struct{}, *concurrency)
+	var wg sync.WaitGroup
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	for {
+		select {
+		case slots <- struct{}{}:
+			ts := gen.Next(*samples, interval, time.Now())
+			wg.Go(func() {
+				defer func() { <-slots }()
+				pushStart := time.Now()
+				_, err := w.WriteTimeSeries(ctx, ts, nil)
+				stats.record(time.Since(pushStart), len(ts)**samples, err)
+			})
+		default:
+			// every slot is busy, so this push is skipped rather than letting a backlog build up
+			stats.skip()
+		}
+
+		select {
+		case <-done.Done():
+			wg.Wait()
+			stats.print(time.Since(start))
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+type benchStats struct {
+	mu       sync.Mutex
+	pushes   int
+	failures int
+	skipped  int
+	samples  int
+	total    time.Duration
+	slowest  time.Duration
+	lastErr  error
+}
+
+func (s *benchStats) record(d time.Duration, samples int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pushes++
+	s.total += d
+	s.slowest = max(s.slowest, d)
+	if err != nil {
+		s.failures++
+		s.lastErr = err
+		return
+	}
+	s.samples += samples
+}
+
+func (s *benchStats) skip() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.skipped++
+}
+
+func (s *benchStats) print(elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Printf("pushes:     %d (%d failed, %d skipped)\n", s.pushes, s.failures, s.skipped)
+	fmt.Printf("samples:    %d (%.1f/s)\n", s.samples, float64(s.samples)/elapsed.Seconds())
+	if s.pushes > 0 {
+		fmt.Printf("latency:    %s mean, %s max\n", s.total/time.Duration(s.pushes), s.slowest)
+	}
+	if s.lastErr != nil {
+		fmt.Printf("last error: %s\n", s.lastErr)
+	}
+}
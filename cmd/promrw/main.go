@@ -0,0 +1,106 @@
+// Command promrw works with Prometheus remote write targets from the command line.
+//
+//	promrw replay -dir DIR -url URL [flags]
+//
+// replays the payloads a writer.FileSender recorded in DIR to the remote write target at URL
+//
+//	promrw bench -url URL [flags]
+//
+// pushes synthetic series to the remote write target at URL at a fixed rate, for capacity testing receivers
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var err error
+	switch os.Args[1] {
+	case "replay":
+		err = replay(ctx, os.Args[2:])
+	case "bench":
+		err = bench(ctx, os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		usage()
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: promrw <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  replay    send payloads recorded by a file sender to a remote write target")
+	fmt.Fprintln(os.Stderr, "  bench     push synthetic series to a remote write target at a fixed rate")
+}
+
+func replay(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	dir := fs.String("dir", "", "directory holding the recorded payload files")
+	url := fs.String("url", "", "remote write target URL")
+	format := fs.String("format", writer.Protobuf.String(), "format to send payloads in")
+	compression := fs.String("compression", writer.Snappy.String(), "compression to send payloads with")
+	tenant := fs.String("tenant", "", "tenant to send payloads as")
+	username := fs.String("username", "", "basic auth username")
+	password := fs.String("password", "", "basic auth password")
+	shift := fs.Duration("shift", 0, "amount to move every timestamp by, e.g. 2h or -30m")
+	remove := fs.Bool("remove", false, "delete each file once it has been sent")
+	retries := fs.Int("retries", 3, "number of times to retry a failed send")
+	fs.Parse(args)
+
+	if *dir == "" || *url == "" {
+		fs.Usage()
+		return fmt.Errorf("-dir and -url are required")
+	}
+
+	options := writer.RemoteMetricsWriterOptions{
+		Tenant:     *tenant,
+		MaxRetries: *retries,
+		MinBackoff: 100 * time.Millisecond,
+	}
+
+	var err error
+	if options.Format, err = writer.ParseFormat(*format); err != nil {
+		return err
+	}
+	if options.Compression, err = writer.ParseCompression(*compression); err != nil {
+		return err
+	}
+	if *username != "" {
+		options.BasicAuth = &writer.BasicAuth{Username: *username, Password: *password}
+	}
+
+	w, err := writer.NewRemoteMetricsWriter(*url, options)
+	if err != nil {
+		return err
+	}
+
+	sent, err := writer.Replay(ctx, *dir, w, writer.ReplayOptions{TimeShift: *shift, RemoveReplayed: *remove})
+	fmt.Printf("replayed %d series\n", sent)
+
+	return err
+}
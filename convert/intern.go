@@ -0,0 +1,154 @@
+package convert
+
+import (
+	"math"
+	"sync"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// DefaultInternerLimit is the number of strings an Interner holds before it starts over
+const DefaultInternerLimit = 1 << 16
+
+// Interner shares the label names and values of converted series, which repeat massively across the series of a
+// large registry. Besides deduplicating strings, it remembers the _bucket, _sum and _count names and the le and
+// quantile values it builds, so they are only allocated once. Giving the same Interner to every conversion carries
+// that over from one push to the next. It is safe for concurrent use, and a nil *Interner interns nothing.
+//
+// Once it holds Limit strings, the Interner forgets everything and starts over, so label values that keep changing
+// cannot make it grow without bound
+type Interner struct {
+	limit int
+
+	mu       sync.Mutex
+	strings  map[string]string
+	suffixed map[[2]string]string
+	floats   map[float64]string
+}
+
+// NewInterner returns an Interner that holds at most limit strings, or DefaultInternerLimit if limit is not positive
+func NewInterner(limit int) *Interner {
+	if limit <= 0 {
+		limit = DefaultInternerLimit
+	}
+
+	i := &Interner{limit: limit}
+	i.reset()
+
+	return i
+}
+
+func (i *Interner) reset() {
+	i.strings = map[string]string{}
+	i.suffixed = map[[2]string]string{}
+	i.floats = map[float64]string{}
+}
+
+// size returns the number of strings held. i.mu must be held
+func (i *Interner) size() int {
+	return len(i.strings) + len(i.suffixed) + len(i.floats)
+}
+
+// Len returns the number of strings the Interner holds
+func (i *Interner) Len() int {
+	if i == nil {
+		return 0
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.size()
+}
+
+// Intern returns the interned copy of s
+func (i *Interner) Intern(s string) string {
+	if i == nil {
+		return s
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.intern(s)
+}
+
+// intern is Intern with i.mu held
+func (i *Interner) intern(s string) string {
+	if interned, ok := i.strings[s]; ok {
+		return interned
+	}
+
+	if i.size() >= i.limit {
+		i.reset()
+	}
+	i.strings[s] = s
+
+	return s
+}
+
+// suffix returns name+suffix, building it only the first time
+func (i *Interner) suffix(name, suffix string) string {
+	if i == nil {
+		return name + suffix
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	key := [2]string{name, suffix}
+	if s, ok := i.suffixed[key]; ok {
+		return s
+	}
+
+	if i.size() >= i.limit {
+		i.reset()
+	}
+	s := name + suffix
+	i.suffixed[key] = s
+
+	return s
+}
+
+// float returns formatFloat(f), formatting it only the first time
+func (i *Interner) float(f float64) string {
+	// NaN never equals itself, so it could never be found again
+	if i == nil || math.IsNaN(f) {
+		return formatFloat(f)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if s, ok := i.floats[f]; ok {
+		return s
+	}
+
+	if i.size() >= i.limit {
+		i.reset()
+	}
+	s := formatFloat(f)
+	i.floats[f] = s
+
+	return s
+}
+
+// labels is Labels with every name and value interned
+func (i *Interner) labels(name string, pairs []*dto.LabelPair, extra ...prompb.Label) []prompb.Label {
+	if i == nil {
+		return Labels(name, pairs, extra...)
+	}
+
+	labels := Labels(name, pairs, extra...)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for j := range labels {
+		labels[j].Name = i.intern(labels[j].Name)
+		labels[j].Value = i.intern(labels[j].Value)
+	}
+
+	return labels
+}
@@ -0,0 +1,82 @@
+package convert
+
+import (
+	"strings"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// MergeSeries combines series with identical label sets into one series carrying all of their samples, exemplars and
+// native histograms, so that a label set is only sent once. Series keep the position of their first occurrence. A
+// sample or histogram whose timestamp the merged series already has is dropped, since receivers reject two values for
+// the same timestamp. The series' sample slices are only copied when something is merged into them
+func MergeSeries(series []prompb.TimeSeries) []prompb.TimeSeries {
+	index := make(map[string]int, len(series))
+	merged := make([]prompb.TimeSeries, 0, len(series))
+	// copied records which merged series own their sample slices, so appending to them cannot touch the input
+	copied := map[int]bool{}
+
+	for _, ts := range series {
+		key := labelsKey(ts.Labels)
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(merged)
+			merged = append(merged, ts)
+			continue
+		}
+
+		target := &merged[i]
+		if !copied[i] {
+			target.Samples = append([]prompb.Sample(nil), target.Samples...)
+			target.Exemplars = append([]prompb.Exemplar(nil), target.Exemplars...)
+			target.Histograms = append([]prompb.Histogram(nil), target.Histograms...)
+			copied[i] = true
+		}
+
+		for _, s := range ts.Samples {
+			if !hasSampleAt(target.Samples, s.Timestamp) {
+				target.Samples = append(target.Samples, s)
+			}
+		}
+		for _, h := range ts.Histograms {
+			if !hasHistogramAt(target.Histograms, h.Timestamp) {
+				target.Histograms = append(target.Histograms, h)
+			}
+		}
+		target.Exemplars = append(target.Exemplars, ts.Exemplars...)
+	}
+
+	return merged
+}
+
+func labelsKey(labels []prompb.Label) string {
+	var sb strings.Builder
+	for _, l := range labels {
+		sb.WriteString(l.Name)
+		sb.WriteByte(0xff)
+		sb.WriteString(l.Value)
+		sb.WriteByte(0xff)
+	}
+
+	return sb.String()
+}
+
+func hasSampleAt(samples []prompb.Sample, timestamp int64) bool {
+	for _, s := range samples {
+		if s.Timestamp == timestamp {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasHistogramAt(histograms []prompb.Histogram, timestamp int64) bool {
+	for _, h := range histograms {
+		if h.Timestamp == timestamp {
+			return true
+		}
+	}
+
+	return false
+}
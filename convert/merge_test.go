@@ -0,0 +1,52 @@
+package convert_test
+
+import (
+	"github.com/jghiloni/prometheus-remote-write/convert"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+	"google.golang.org/protobuf/proto"
+)
+
+var _ = Describe("MergeSeries", func() {
+	up := []prompb.Label{{Name: "__name__", Value: "up"}}
+	down := []prompb.Label{{Name: "__name__", Value: "down"}}
+
+	It("merges series with the same labels", func() {
+		input := []prompb.TimeSeries{
+			{Labels: up, Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}}},
+			{Labels: down, Samples: []prompb.Sample{{Value: 0, Timestamp: 1000}}},
+			{Labels: up, Samples: []prompb.Sample{{Value: 2, Timestamp: 2000}, {Value: 3, Timestamp: 1000}}},
+		}
+
+		merged := convert.MergeSeries(input)
+		Expect(merged).Should(HaveLen(2))
+		Expect(merged[0].Labels).Should(Equal(up))
+		Expect(merged[0].Samples).Should(Equal([]prompb.Sample{{Value: 1, Timestamp: 1000}, {Value: 2, Timestamp: 2000}}))
+		Expect(merged[1].Labels).Should(Equal(down))
+
+		Expect(input[0].Samples).Should(HaveLen(1))
+	})
+
+	It("merges gathered metrics when asked", func() {
+		family := func(ts int64) *dto.MetricFamily {
+			return &dto.MetricFamily{
+				Name: proto.String("up"),
+				Type: dto.MetricType_GAUGE.Enum(),
+				Metric: []*dto.Metric{{
+					Gauge:       &dto.Gauge{Value: proto.Float64(1)},
+					TimestampMs: proto.Int64(ts),
+				}},
+			}
+		}
+		families := []*dto.MetricFamily{family(1000), family(2000)}
+
+		series, _ := convert.FromMetricFamilies(families, convert.MetricFamilyOptions{})
+		Expect(series).Should(HaveLen(2))
+
+		series, _ = convert.FromMetricFamilies(families, convert.MetricFamilyOptions{MergeDuplicateSeries: true})
+		Expect(series).Should(HaveLen(1))
+		Expect(series[0].Samples).Should(HaveLen(2))
+	})
+})
@@ -0,0 +1,529 @@
+package convert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/jghiloni/go-commonutils/v2/slices"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// MetricFamilyOptions are the optional settings for FromMetricFamilies and FromMetricFamily.
+//
+//	Timestamp is used for the samples of metrics that do not carry a timestamp of their own, which is the case for
+//	everything a client_golang registry gathers. If it is zero, the time of the conversion is used
+//	If TimestampFunc is set, it is called with every metric and its family, and the timestamp in milliseconds it
+//	returns is used for the metric's samples instead of the metric's own timestamp or Timestamp, to backfill
+//	measurements taken earlier, say. Returning 0 keeps the timestamp the metric would otherwise get
+//	Gauge histograms always get the GAUGE reset hint. If Tracker is set, native histograms of other types get a YES or
+//	NO reset hint based on what Tracker saw in earlier conversions; otherwise their hint is left UNKNOWN
+//	If CreatedTimestampZeros is set, a counter with a created timestamp gets an extra zero sample at that timestamp the
+//	first time Tracker sees it, so rate() and increase() count its first increments. It has no effect without Tracker
+//	MissingExemplarTimestamp decides what happens to exemplars that carry no timestamp. By default they get the
+//	timestamp of the sample they belong to
+//	If DropZeroCounters is set, counters that are still zero are left out. Together with CreatedTimestampZeros, a
+//	counter's first non-zero push still starts from zero at its created timestamp
+//	If CounterResetZeros is set, a counter that Tracker saw go down (or whose created timestamp changed) since the last
+//	conversion gets an explicit zero sample just before its new value, so backends that miss resets between pushes
+//	still see them. It has no effect without Tracker
+//	If Interner is set, the label names and values of the series are interned with it
+//	If MergeDuplicateSeries is set, metrics that convert to the same label set (for example from overlapping gatherers)
+//	are merged into a single series with MergeSeries. It only applies to FromMetricFamilies and
+//	FromMetricFamiliesContext
+//	NameLabelPolicy decides what happens to metrics that already carry a __name__ label, as metrics bridged from other
+//	systems sometimes do. By default the family's name replaces it
+//	ValueTransforms maps family names to a ValueTransform applied to the values of their metrics before they are
+//	converted: counter, gauge and untyped values, summary quantiles and sums, and histogram sums. Counts and buckets
+//	are not transformed. It allows light corrections, such as Scale or Clamp, without changing instrumented code
+//	If NormalizeUnits is set, families named in a non-base unit (request_duration_milliseconds, cache_size_kb) are
+//	converted to the base unit, renamed and rescaled, with the function of the same name. ValueTransforms are then
+//	looked up by the family's new name, and apply to values in the base unit
+//	If UntypedAsGauge is set, untyped families are converted as gauges with the function of the same name, so their
+//	metadata reports GAUGE instead of UNKNOWN. If UntypedGaugeSuffix is also set, it is appended to their names, and
+//	ValueTransforms are looked up by the new names
+//	If NewSeriesMetadataOnly is set, FromMetricFamilies and FromMetricFamiliesContext only return metadata for families
+//	with a metric Tracker has not seen before (or not since it expired), so steady-state pushes carry none. It has no
+//	effect without Tracker
+type MetricFamilyOptions struct {
+	Timestamp                time.Time
+	TimestampFunc            func(family *dto.MetricFamily, m *dto.Metric) int64
+	Tracker                  *SeriesTracker
+	CreatedTimestampZeros    bool
+	MissingExemplarTimestamp ExemplarTimestampPolicy
+	DropZeroCounters         bool
+	CounterResetZeros        bool
+	Interner                 *Interner
+	MergeDuplicateSeries     bool
+	NameLabelPolicy          NameLabelPolicy
+	ValueTransforms          map[string]ValueTransform
+	NormalizeUnits           bool
+	UntypedAsGauge           bool
+	UntypedGaugeSuffix       string
+	NewSeriesMetadataOnly    bool
+}
+
+// NameLabelPolicy decides how metrics that carry their own __name__ label are converted
+type NameLabelPolicy int
+
+const (
+	// NameLabelOverride drops the metric's __name__ label in favor of the family's name
+	NameLabelOverride NameLabelPolicy = iota
+	// NameLabelRespect names the metric's series after its __name__ label instead of its family
+	NameLabelRespect
+	// NameLabelError makes FromMetricFamiliesContext fail with ErrNameLabel. FromMetricFamilies and FromMetricFamily
+	// cannot return errors, so they leave the metric out instead
+	NameLabelError
+)
+
+// ErrNameLabel is returned for metrics that carry a __name__ label when the NameLabelPolicy is NameLabelError
+var ErrNameLabel = errors.New("metric carries its own __name__ label")
+
+// ExemplarTimestampPolicy decides how exemplars without a timestamp are converted
+type ExemplarTimestampPolicy int
+
+const (
+	// ExemplarSampleTimestamp gives the exemplar the timestamp of the sample it belongs to
+	ExemplarSampleTimestamp ExemplarTimestampPolicy = iota
+	// ExemplarCurrentTime gives the exemplar the time of the conversion, which is MetricFamilyOptions.Timestamp, even
+	// if the sample it belongs to carries a timestamp of its own
+	ExemplarCurrentTime
+	// ExemplarDrop leaves the exemplar out
+	ExemplarDrop
+)
+
+// FromMetricFamilies converts gathered metric families (from a prometheus.Gatherer or ParseText) into series and
+// metadata that can be passed to RemoteMetricsWriter.WriteTimeSeries. One metadata entry is returned per family.
+func FromMetricFamilies(families []*dto.MetricFamily, options MetricFamilyOptions) ([]prompb.TimeSeries, []prompb.MetricMetadata) {
+	series, metadata, _ := FromMetricFamiliesContext(context.Background(), families, options)
+	return series, metadata
+}
+
+// FromMetricFamiliesContext is FromMetricFamilies, except that it checks ctx between families and stops with ctx's
+// error once it is done, so a very large conversion can be abandoned
+func FromMetricFamiliesContext(ctx context.Context, families []*dto.MetricFamily, options MetricFamilyOptions) ([]prompb.TimeSeries, []prompb.MetricMetadata, error) {
+	if options.Timestamp.IsZero() {
+		options.Timestamp = time.Now()
+	}
+
+	series := make([]prompb.TimeSeries, 0, len(families))
+	metadata := make([]prompb.MetricMetadata, 0, len(families))
+	for _, family := range families {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+
+		if options.NameLabelPolicy == NameLabelError {
+			for _, metric := range family.GetMetric() {
+				if nameLabelIndex(metric.GetLabel()) >= 0 {
+					return nil, nil, fmt.Errorf("%w in family %s", ErrNameLabel, family.GetName())
+				}
+			}
+		}
+
+		family = transformFamily(family, options)
+		if !options.NewSeriesMetadataOnly || options.Tracker == nil ||
+			options.Tracker.hasNewMetrics(family, options.Timestamp.UnixMilli()) {
+			metadata = append(metadata, Metadata(family))
+		}
+		series = append(series, fromMetricFamily(family, options)...)
+	}
+
+	if options.MergeDuplicateSeries {
+		series = MergeSeries(series)
+	}
+
+	return series, metadata, nil
+}
+
+// FromMetricFamily converts the metrics of a single family into series with sorted labels. Counters, gauges and
+// untyped metrics become one series each. Summaries become one series per quantile plus _sum and _count series.
+// Histograms with classic buckets become _bucket, _sum and _count series (_gsum and _gcount for gauge histograms), and
+// histograms with native buckets become a series carrying a native histogram; a histogram with both gets both.
+func FromMetricFamily(family *dto.MetricFamily, options MetricFamilyOptions) []prompb.TimeSeries {
+	if options.Timestamp.IsZero() {
+		options.Timestamp = time.Now()
+	}
+
+	return fromMetricFamily(transformFamily(family, options), options)
+}
+
+// transformFamily applies the family-wide rewrites options asks for, NormalizeUnits and then UntypedAsGauge
+func transformFamily(family *dto.MetricFamily, options MetricFamilyOptions) *dto.MetricFamily {
+	if options.NormalizeUnits {
+		family = NormalizeUnits(family)
+	}
+
+	if options.UntypedAsGauge {
+		family = UntypedAsGauge(family, options.UntypedGaugeSuffix)
+	}
+
+	return family
+}
+
+// fromMetricFamily is FromMetricFamily for a family transformFamily has already been applied to, with
+// options.Timestamp set
+func fromMetricFamily(family *dto.MetricFamily, options MetricFamilyOptions) []prompb.TimeSeries {
+	transform := options.ValueTransforms[family.GetName()]
+	series := make([]prompb.TimeSeries, 0, len(family.GetMetric()))
+	for _, m := range family.GetMetric() {
+		name, metric, ok := applyNameLabelPolicy(family.GetName(), m, options.NameLabelPolicy)
+		if !ok {
+			continue
+		}
+
+		if transform != nil {
+			metric = transformValues(metric, transform)
+		}
+
+		ts := options.Timestamp.UnixMilli()
+		if metric.TimestampMs != nil {
+			ts = metric.GetTimestampMs()
+		}
+		if options.TimestampFunc != nil {
+			if override := options.TimestampFunc(family, m); override != 0 {
+				ts = override
+			}
+		}
+
+		switch {
+		case metric.GetCounter() != nil:
+			if options.DropZeroCounters && metric.GetCounter().GetValue() == 0 {
+				continue
+			}
+			series = append(series, convertCounter(name, metric, ts, options))
+		case metric.GetGauge() != nil:
+			series = append(series, prompb.TimeSeries{
+				Labels:  options.Interner.labels(name, metric.GetLabel()),
+				Samples: []prompb.Sample{{Value: metric.GetGauge().GetValue(), Timestamp: ts}},
+			})
+		case metric.GetUntyped() != nil:
+			series = append(series, prompb.TimeSeries{
+				Labels:  options.Interner.labels(name, metric.GetLabel()),
+				Samples: []prompb.Sample{{Value: metric.GetUntyped().GetValue(), Timestamp: ts}},
+			})
+		case metric.GetSummary() != nil:
+			series = append(series, convertSummary(name, metric, ts, options)...)
+		case metric.GetHistogram() != nil:
+			series = append(series, convertHistogram(name, family.GetType() == dto.MetricType_GAUGE_HISTOGRAM, metric, ts, options)...)
+		}
+	}
+
+	return series
+}
+
+// applyNameLabelPolicy returns the name the series of metric get and the metric to convert, with any __name__ label
+// removed. It reports false if the metric is to be left out
+func applyNameLabelPolicy(name string, metric *dto.Metric, policy NameLabelPolicy) (string, *dto.Metric, bool) {
+	i := nameLabelIndex(metric.GetLabel())
+	if i < 0 {
+		return name, metric, true
+	}
+
+	switch policy {
+	case NameLabelRespect:
+		name = metric.GetLabel()[i].GetValue()
+	case NameLabelError:
+		return "", nil, false
+	}
+
+	pairs := metric.GetLabel()
+	labels := make([]*dto.LabelPair, 0, len(pairs)-1)
+	labels = append(append(labels, pairs[:i]...), pairs[i+1:]...)
+
+	// a new metric rather than a copy, since protobuf messages must not be copied
+	stripped := &dto.Metric{
+		Label:       labels,
+		Gauge:       metric.GetGauge(),
+		Counter:     metric.GetCounter(),
+		Summary:     metric.GetSummary(),
+		Untyped:     metric.GetUntyped(),
+		Histogram:   metric.GetHistogram(),
+		TimestampMs: metric.TimestampMs,
+	}
+
+	return name, stripped, true
+}
+
+func nameLabelIndex(pairs []*dto.LabelPair) int {
+	for i, pair := range pairs {
+		if pair.GetName() == metricNameLabel {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Metadata returns the remote write metadata describing family
+func Metadata(family *dto.MetricFamily) prompb.MetricMetadata {
+	return prompb.MetricMetadata{
+		Type:             MetricType(family.GetType()),
+		MetricFamilyName: family.GetName(),
+		Help:             family.GetHelp(),
+		Unit:             family.GetUnit(),
+	}
+}
+
+// MetricType maps a client_model metric type onto the matching remote write metadata type. The two enums do not share
+// values, so they cannot simply be cast. Untyped metrics are reported as UNKNOWN
+func MetricType(t dto.MetricType) prompb.MetricMetadata_MetricType {
+	switch t {
+	case dto.MetricType_COUNTER:
+		return prompb.MetricMetadata_COUNTER
+	case dto.MetricType_GAUGE:
+		return prompb.MetricMetadata_GAUGE
+	case dto.MetricType_SUMMARY:
+		return prompb.MetricMetadata_SUMMARY
+	case dto.MetricType_HISTOGRAM:
+		return prompb.MetricMetadata_HISTOGRAM
+	case dto.MetricType_GAUGE_HISTOGRAM:
+		return prompb.MetricMetadata_GAUGEHISTOGRAM
+	default:
+		return prompb.MetricMetadata_UNKNOWN
+	}
+}
+
+// Labels converts label pairs into remote write labels, adds the __name__ label and any extra labels, and sorts the
+// result by name as the remote write specification requires
+func Labels(name string, pairs []*dto.LabelPair, extra ...prompb.Label) []prompb.Label {
+	return labelPairs(pairs, append(extra, prompb.Label{Name: metricNameLabel, Value: name})...)
+}
+
+// Exemplar converts a client_model exemplar into a remote write exemplar. If e has no timestamp, the result's
+// timestamp is 0
+func Exemplar(e *dto.Exemplar) prompb.Exemplar {
+	return prompb.Exemplar{
+		Labels:    labelPairs(e.GetLabel()),
+		Value:     e.GetValue(),
+		Timestamp: exemplarTimestamp(e),
+	}
+}
+
+func exemplarTimestamp(e *dto.Exemplar) int64 {
+	if e.GetTimestamp() == nil {
+		return 0
+	}
+
+	return e.GetTimestamp().AsTime().UnixMilli()
+}
+
+// convertExemplars converts exemplars (skipping nil ones) that belong to a sample taken at ts, applying
+// options.MissingExemplarTimestamp to those without a timestamp
+func convertExemplars(exemplars []*dto.Exemplar, ts int64, options MetricFamilyOptions) []prompb.Exemplar {
+	kept := slices.Filter(exemplars, func(e *dto.Exemplar) bool {
+		return e != nil && (e.GetTimestamp() != nil || options.MissingExemplarTimestamp != ExemplarDrop)
+	})
+
+	return slices.Map(kept, func(e *dto.Exemplar) prompb.Exemplar {
+		converted := Exemplar(e)
+		if e.GetTimestamp() == nil {
+			converted.Timestamp = ts
+			if options.MissingExemplarTimestamp == ExemplarCurrentTime {
+				converted.Timestamp = options.Timestamp.UnixMilli()
+			}
+		}
+
+		return converted
+	})
+}
+
+// BucketSpans converts the spans of a native histogram into their remote write equivalent
+func BucketSpans(spans []*dto.BucketSpan) []prompb.BucketSpan {
+	converted := make([]prompb.BucketSpan, len(spans))
+	for i, s := range spans {
+		converted[i] = prompb.BucketSpan{Offset: s.GetOffset(), Length: s.GetLength()}
+	}
+
+	return converted
+}
+
+func labelPairs(pairs []*dto.LabelPair, extra ...prompb.Label) []prompb.Label {
+	labels := make([]prompb.Label, 0, len(pairs)+len(extra))
+	for _, pair := range pairs {
+		labels = append(labels, prompb.Label{Name: pair.GetName(), Value: pair.GetValue()})
+	}
+	labels = append(labels, extra...)
+	sortLabels(labels)
+
+	return labels
+}
+
+func convertCounter(name string, metric *dto.Metric, ts int64, options MetricFamilyOptions) prompb.TimeSeries {
+	counter := metric.GetCounter()
+	s := prompb.TimeSeries{
+		Labels:  options.Interner.labels(name, metric.GetLabel()),
+		Samples: []prompb.Sample{{Value: counter.GetValue(), Timestamp: ts}},
+	}
+	if e := counter.GetExemplar(); e != nil {
+		s.Exemplars = convertExemplars([]*dto.Exemplar{e}, ts, options)
+	}
+
+	if options.Tracker == nil {
+		return s
+	}
+
+	var created int64
+	if ct := counter.GetCreatedTimestamp(); ct != nil {
+		created = ct.AsTime().UnixMilli()
+	}
+
+	prev, seen := options.Tracker.observe(s.Labels, counter.GetValue(), created, ts)
+	switch {
+	case !seen && options.CreatedTimestampZeros && created != 0 && created < ts:
+		s.Samples = append([]prompb.Sample{{Value: 0, Timestamp: created}}, s.Samples...)
+	case seen && options.CounterResetZeros && prev.isReset(counter.GetValue(), created):
+		// the zero goes at the new created timestamp if it falls between the two pushes, or just before this sample
+		zero := ts - 1
+		if created > prev.timestamp && created < ts {
+			zero = created
+		}
+		if zero > prev.timestamp {
+			s.Samples = append([]prompb.Sample{{Value: 0, Timestamp: zero}}, s.Samples...)
+		}
+	}
+
+	return s
+}
+
+func convertSummary(name string, metric *dto.Metric, ts int64, options MetricFamilyOptions) []prompb.TimeSeries {
+	summary := metric.GetSummary()
+	series := make([]prompb.TimeSeries, 0, len(summary.GetQuantile())+2)
+	for _, q := range summary.GetQuantile() {
+		series = append(series, prompb.TimeSeries{
+			Labels:  options.Interner.labels(name, metric.GetLabel(), prompb.Label{Name: "quantile", Value: options.Interner.float(q.GetQuantile())}),
+			Samples: []prompb.Sample{{Value: q.GetValue(), Timestamp: ts}},
+		})
+	}
+
+	return append(series,
+		prompb.TimeSeries{
+			Labels:  options.Interner.labels(options.Interner.suffix(name, "_sum"), metric.GetLabel()),
+			Samples: []prompb.Sample{{Value: summary.GetSampleSum(), Timestamp: ts}},
+		},
+		prompb.TimeSeries{
+			Labels:  options.Interner.labels(options.Interner.suffix(name, "_count"), metric.GetLabel()),
+			Samples: []prompb.Sample{{Value: float64(summary.GetSampleCount()), Timestamp: ts}},
+		},
+	)
+}
+
+func convertHistogram(name string, gauge bool, metric *dto.Metric, ts int64, options MetricFamilyOptions) []prompb.TimeSeries {
+	histogram := metric.GetHistogram()
+
+	var series []prompb.TimeSeries
+	if native, ok := convertNativeHistogram(histogram, ts); ok {
+		labels := options.Interner.labels(name, metric.GetLabel())
+		switch {
+		case gauge:
+			native.ResetHint = prompb.Histogram_GAUGE
+		case options.Tracker != nil:
+			var created int64
+			if ct := histogram.GetCreatedTimestamp(); ct != nil {
+				created = ct.AsTime().UnixMilli()
+			}
+			native.ResetHint = options.Tracker.hint(labels, nativeCount(histogram), created, ts)
+		}
+
+		series = append(series, prompb.TimeSeries{
+			Labels:     labels,
+			Exemplars:  convertExemplars(histogram.GetExemplars(), ts, options),
+			Histograms: []prompb.Histogram{native},
+		})
+
+		// a histogram exposed with native buckets only has no classic series to send
+		if len(histogram.GetBucket()) == 0 {
+			return series
+		}
+	}
+
+	count := float64(histogram.GetSampleCount())
+	if histogram.GetSampleCountFloat() > 0 {
+		count = histogram.GetSampleCountFloat()
+	}
+
+	bucketName := options.Interner.suffix(name, "_bucket")
+	hasInf := false
+	for _, b := range histogram.GetBucket() {
+		value := float64(b.GetCumulativeCount())
+		if b.GetCumulativeCountFloat() > 0 {
+			value = b.GetCumulativeCountFloat()
+		}
+		hasInf = hasInf || math.IsInf(b.GetUpperBound(), 1)
+
+		s := prompb.TimeSeries{
+			Labels:  options.Interner.labels(bucketName, metric.GetLabel(), prompb.Label{Name: "le", Value: options.Interner.float(b.GetUpperBound())}),
+			Samples: []prompb.Sample{{Value: value, Timestamp: ts}},
+		}
+		if e := b.GetExemplar(); e != nil {
+			s.Exemplars = convertExemplars([]*dto.Exemplar{e}, ts, options)
+		}
+		series = append(series, s)
+	}
+
+	// client_golang leaves the +Inf bucket implicit, while parsed exposition text includes it
+	if !hasInf {
+		series = append(series, prompb.TimeSeries{
+			Labels:  options.Interner.labels(bucketName, metric.GetLabel(), prompb.Label{Name: "le", Value: "+Inf"}),
+			Samples: []prompb.Sample{{Value: count, Timestamp: ts}},
+		})
+	}
+
+	sumName, countName := options.Interner.suffix(name, "_sum"), options.Interner.suffix(name, "_count")
+	if gauge {
+		sumName, countName = options.Interner.suffix(name, "_gsum"), options.Interner.suffix(name, "_gcount")
+	}
+
+	return append(series,
+		prompb.TimeSeries{
+			Labels:  options.Interner.labels(sumName, metric.GetLabel()),
+			Samples: []prompb.Sample{{Value: histogram.GetSampleSum(), Timestamp: ts}},
+		},
+		prompb.TimeSeries{
+			Labels:  options.Interner.labels(countName, metric.GetLabel()),
+			Samples: []prompb.Sample{{Value: count, Timestamp: ts}},
+		},
+	)
+}
+
+// convertNativeHistogram returns the native part of h, if it has one. client_golang only sets the schema, zero
+// threshold and spans on histograms that have native buckets enabled
+func convertNativeHistogram(h *dto.Histogram, ts int64) (prompb.Histogram, bool) {
+	if h.Schema == nil && h.ZeroThreshold == nil && len(h.GetPositiveSpan()) == 0 && len(h.GetNegativeSpan()) == 0 {
+		return prompb.Histogram{}, false
+	}
+
+	native := prompb.Histogram{
+		Sum:            h.GetSampleSum(),
+		Schema:         h.GetSchema(),
+		ZeroThreshold:  h.GetZeroThreshold(),
+		NegativeSpans:  BucketSpans(h.GetNegativeSpan()),
+		NegativeDeltas: h.GetNegativeDelta(),
+		NegativeCounts: h.GetNegativeCount(),
+		PositiveSpans:  BucketSpans(h.GetPositiveSpan()),
+		PositiveDeltas: h.GetPositiveDelta(),
+		PositiveCounts: h.GetPositiveCount(),
+		Timestamp:      ts,
+	}
+
+	if h.GetSampleCountFloat() > 0 {
+		native.Count = &prompb.Histogram_CountFloat{CountFloat: h.GetSampleCountFloat()}
+		native.ZeroCount = &prompb.Histogram_ZeroCountFloat{ZeroCountFloat: h.GetZeroCountFloat()}
+	} else {
+		native.Count = &prompb.Histogram_CountInt{CountInt: h.GetSampleCount()}
+		native.ZeroCount = &prompb.Histogram_ZeroCountInt{ZeroCountInt: h.GetZeroCount()}
+	}
+
+	return native, true
+}
+
+func nativeCount(h *dto.Histogram) float64 {
+	if h.GetSampleCountFloat() > 0 {
+		return h.GetSampleCountFloat()
+	}
+
+	return float64(h.GetSampleCount())
+}
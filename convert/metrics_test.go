@@ -0,0 +1,698 @@
+package convert_test
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/jghiloni/prometheus-remote-write/convert"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func seriesNames(series []prompb.TimeSeries) []string {
+	names := make([]string, 0, len(series))
+	for _, s := range series {
+		for _, l := range s.Labels {
+			if l.Name == "__name__" {
+				names = append(names, l.Value)
+			}
+		}
+	}
+	return names
+}
+
+// labelsSuffix renders the labels other than __name__ as {name=value,...}, or nothing if there are none
+func labelsSuffix(labels []prompb.Label) string {
+	var pairs []string
+	for _, l := range labels[1:] {
+		pairs = append(pairs, l.Name+"="+l.Value)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var _ = Describe("FromMetricFamilies", func() {
+	now := time.UnixMilli(1700000000000)
+
+	It("converts gathered counters, summaries and classic histograms", func() {
+		r := prometheus.NewRegistry()
+		c := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests_total", Help: "Requests"}, []string{"path", "code"})
+		s := prometheus.NewSummary(prometheus.SummaryOpts{Name: "latency_seconds", Objectives: map[float64]float64{0.5: 0.05}})
+		h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "size_bytes", Buckets: []float64{10, 100}})
+		r.MustRegister(c, s, h)
+
+		c.WithLabelValues("/", "200").Inc()
+		s.Observe(1)
+		h.Observe(50)
+
+		families, err := r.Gather()
+		Expect(err).ShouldNot(HaveOccurred())
+
+		series, metadata := convert.FromMetricFamilies(families, convert.MetricFamilyOptions{Timestamp: now})
+		Expect(seriesNames(series)).Should(Equal([]string{
+			"latency_seconds", "latency_seconds_sum", "latency_seconds_count",
+			"requests_total",
+			"size_bytes_bucket", "size_bytes_bucket", "size_bytes_bucket", "size_bytes_sum", "size_bytes_count",
+		}))
+
+		Expect(series[3].Labels).Should(Equal([]prompb.Label{
+			{Name: "__name__", Value: "requests_total"},
+			{Name: "code", Value: "200"},
+			{Name: "path", Value: "/"},
+		}))
+		Expect(series[3].Samples).Should(Equal([]prompb.Sample{{Value: 1, Timestamp: now.UnixMilli()}}))
+		Expect(series[3].Exemplars).Should(BeEmpty())
+
+		Expect(series[5].Labels).Should(ContainElement(prompb.Label{Name: "le", Value: "100"}))
+		Expect(series[5].Samples[0].Value).Should(Equal(1.0))
+		Expect(series[6].Labels).Should(ContainElement(prompb.Label{Name: "le", Value: "+Inf"}))
+
+		Expect(metadata).Should(Equal([]prompb.MetricMetadata{
+			{Type: prompb.MetricMetadata_SUMMARY, MetricFamilyName: "latency_seconds"},
+			{Type: prompb.MetricMetadata_COUNTER, MetricFamilyName: "requests_total", Help: "Requests"},
+			{Type: prompb.MetricMetadata_HISTOGRAM, MetricFamilyName: "size_bytes"},
+		}))
+	})
+
+	It("keeps timestamps carried by the metric", func() {
+		series := convert.FromMetricFamily(&dto.MetricFamily{
+			Name:   proto.String("up"),
+			Type:   dto.MetricType_UNTYPED.Enum(),
+			Metric: []*dto.Metric{{Untyped: &dto.Untyped{Value: proto.Float64(1)}, TimestampMs: proto.Int64(42)}},
+		}, convert.MetricFamilyOptions{Timestamp: now})
+
+		Expect(series).Should(HaveLen(1))
+		Expect(series[0].Samples).Should(Equal([]prompb.Sample{{Value: 1, Timestamp: 42}}))
+	})
+
+	It("takes timestamps from TimestampFunc over all others", func() {
+		sensor := func(name string) []*dto.LabelPair {
+			return []*dto.LabelPair{{Name: proto.String("sensor"), Value: proto.String(name)}}
+		}
+		family := &dto.MetricFamily{
+			Name: proto.String("temperature_celsius"),
+			Type: dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{
+				{Label: sensor("a"), Gauge: &dto.Gauge{Value: proto.Float64(20)}, TimestampMs: proto.Int64(42)},
+				{Label: sensor("b"), Gauge: &dto.Gauge{Value: proto.Float64(21)}},
+				{Label: sensor("c"), Gauge: &dto.Gauge{Value: proto.Float64(22)}},
+			},
+		}
+		measured := map[string]int64{"a": 1000, "b": 2000}
+
+		series := convert.FromMetricFamily(family, convert.MetricFamilyOptions{
+			Timestamp: now,
+			TimestampFunc: func(f *dto.MetricFamily, m *dto.Metric) int64 {
+				Expect(f).Should(BeIdenticalTo(family))
+				return measured[m.GetLabel()[0].GetValue()]
+			},
+		})
+
+		Expect(series).Should(HaveLen(3))
+		Expect(series[0].Samples[0].Timestamp).Should(Equal(int64(1000)))
+		Expect(series[1].Samples[0].Timestamp).Should(Equal(int64(2000)))
+		Expect(series[2].Samples[0].Timestamp).Should(Equal(now.UnixMilli()))
+	})
+
+	It("applies value transforms to the values of their family", func() {
+		families := []*dto.MetricFamily{
+			{
+				Name: proto.String("latency_milliseconds"),
+				Type: dto.MetricType_SUMMARY.Enum(),
+				Metric: []*dto.Metric{{Summary: &dto.Summary{
+					SampleCount: proto.Uint64(4),
+					SampleSum:   proto.Float64(2000),
+					Quantile:    []*dto.Quantile{{Quantile: proto.Float64(0.5), Value: proto.Float64(500)}},
+				}}},
+			},
+			{
+				Name:   proto.String("battery_percent"),
+				Type:   dto.MetricType_GAUGE.Enum(),
+				Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(104)}}},
+			},
+			{
+				Name:   proto.String("up"),
+				Type:   dto.MetricType_GAUGE.Enum(),
+				Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(1)}}},
+			},
+		}
+
+		series, _ := convert.FromMetricFamilies(families, convert.MetricFamilyOptions{
+			Timestamp: now,
+			ValueTransforms: map[string]convert.ValueTransform{
+				"latency_milliseconds": convert.Scale(0.001),
+				"battery_percent":      convert.Clamp(0, 100),
+			},
+		})
+
+		values := map[string]float64{}
+		for _, ts := range series {
+			values[ts.Labels[0].Value+labelsSuffix(ts.Labels)] = ts.Samples[0].Value
+		}
+		Expect(values).Should(Equal(map[string]float64{
+			"latency_milliseconds{quantile=0.5}": 0.5,
+			"latency_milliseconds_sum":           2,
+			"latency_milliseconds_count":         4,
+			"battery_percent":                    100,
+			"up":                                 1,
+		}))
+		Expect(families[0].GetMetric()[0].GetSummary().GetSampleSum()).Should(Equal(2000.0))
+	})
+
+	It("converts native histograms", func() {
+		series := convert.FromMetricFamily(&dto.MetricFamily{
+			Name: proto.String("native"),
+			Type: dto.MetricType_HISTOGRAM.Enum(),
+			Metric: []*dto.Metric{{Histogram: &dto.Histogram{
+				SampleSum:     proto.Float64(3),
+				Schema:        proto.Int32(3),
+				ZeroThreshold: proto.Float64(1e-128),
+				PositiveSpan:  []*dto.BucketSpan{{Offset: proto.Int32(0), Length: proto.Uint32(2)}},
+				PositiveDelta: []int64{1, 0},
+			}}},
+		}, convert.MetricFamilyOptions{Timestamp: now})
+
+		Expect(series).Should(HaveLen(1))
+		Expect(series[0].Samples).Should(BeEmpty())
+		Expect(series[0].Histograms).Should(HaveLen(1))
+		Expect(series[0].Histograms[0].Schema).Should(Equal(int32(3)))
+		Expect(series[0].Histograms[0].PositiveSpans).Should(Equal([]prompb.BucketSpan{{Offset: 0, Length: 2}}))
+		Expect(series[0].Histograms[0].Timestamp).Should(Equal(now.UnixMilli()))
+	})
+
+	// Before the conversion moved out of the writer, the type was cast from one enum to the other, so a counter was
+	// reported as UNKNOWN, a gauge as COUNTER, and so on
+	It("maps metric types onto metadata types", func() {
+		expected := map[dto.MetricType]prompb.MetricMetadata_MetricType{
+			dto.MetricType_COUNTER:         prompb.MetricMetadata_COUNTER,
+			dto.MetricType_GAUGE:           prompb.MetricMetadata_GAUGE,
+			dto.MetricType_SUMMARY:         prompb.MetricMetadata_SUMMARY,
+			dto.MetricType_UNTYPED:         prompb.MetricMetadata_UNKNOWN,
+			dto.MetricType_HISTOGRAM:       prompb.MetricMetadata_HISTOGRAM,
+			dto.MetricType_GAUGE_HISTOGRAM: prompb.MetricMetadata_GAUGEHISTOGRAM,
+		}
+		Expect(expected).Should(HaveLen(len(dto.MetricType_name)))
+
+		for typ, metadataType := range expected {
+			Expect(convert.MetricType(typ)).Should(Equal(metadataType), typ.String())
+
+			_, metadata := convert.FromMetricFamilies([]*dto.MetricFamily{{Name: proto.String("m"), Type: typ.Enum()}}, convert.MetricFamilyOptions{})
+			Expect(metadata).Should(Equal([]prompb.MetricMetadata{{Type: metadataType, MetricFamilyName: "m"}}), typ.String())
+		}
+	})
+
+	Describe("reset hints", func() {
+		native := func(typ dto.MetricType, count uint64) *dto.MetricFamily {
+			return &dto.MetricFamily{
+				Name: proto.String("native"),
+				Type: typ.Enum(),
+				Metric: []*dto.Metric{{Histogram: &dto.Histogram{
+					SampleCount: proto.Uint64(count),
+					Schema:      proto.Int32(0),
+				}}},
+			}
+		}
+
+		hint := func(family *dto.MetricFamily, options convert.MetricFamilyOptions) prompb.Histogram_ResetHint {
+			series := convert.FromMetricFamily(family, options)
+			Expect(series).Should(HaveLen(1))
+			return series[0].Histograms[0].ResetHint
+		}
+
+		It("marks gauge histograms", func() {
+			Expect(hint(native(dto.MetricType_GAUGE_HISTOGRAM, 1), convert.MetricFamilyOptions{})).Should(Equal(prompb.Histogram_GAUGE))
+		})
+
+		It("detects resets from the count", func() {
+			options := convert.MetricFamilyOptions{Tracker: convert.NewSeriesTracker()}
+			Expect(hint(native(dto.MetricType_HISTOGRAM, 5), options)).Should(Equal(prompb.Histogram_UNKNOWN))
+			Expect(hint(native(dto.MetricType_HISTOGRAM, 7), options)).Should(Equal(prompb.Histogram_NO))
+			Expect(hint(native(dto.MetricType_HISTOGRAM, 2), options)).Should(Equal(prompb.Histogram_YES))
+			Expect(hint(native(dto.MetricType_HISTOGRAM, 2), options)).Should(Equal(prompb.Histogram_NO))
+		})
+
+		It("leaves the hint unknown without a tracker", func() {
+			Expect(hint(native(dto.MetricType_HISTOGRAM, 5), convert.MetricFamilyOptions{})).Should(Equal(prompb.Histogram_UNKNOWN))
+		})
+	})
+
+	It("injects a zero sample at the created timestamp of new counters", func() {
+		family := &dto.MetricFamily{
+			Name: proto.String("jobs_total"),
+			Type: dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{{Counter: &dto.Counter{
+				Value:            proto.Float64(3),
+				CreatedTimestamp: timestamppb.New(now.Add(-time.Minute)),
+			}}},
+		}
+		options := convert.MetricFamilyOptions{Timestamp: now, Tracker: convert.NewSeriesTracker(), CreatedTimestampZeros: true}
+
+		series := convert.FromMetricFamily(family, options)
+		Expect(series[0].Samples).Should(Equal([]prompb.Sample{
+			{Value: 0, Timestamp: now.Add(-time.Minute).UnixMilli()},
+			{Value: 3, Timestamp: now.UnixMilli()},
+		}))
+
+		series = convert.FromMetricFamily(family, options)
+		Expect(series[0].Samples).Should(HaveLen(1))
+
+		options.Tracker = nil
+		series = convert.FromMetricFamily(family, options)
+		Expect(series[0].Samples).Should(HaveLen(1))
+	})
+
+	It("injects the zero sample again once the series has expired from the tracker", func() {
+		family := &dto.MetricFamily{
+			Name: proto.String("jobs_total"),
+			Type: dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{{Counter: &dto.Counter{
+				Value:            proto.Float64(3),
+				CreatedTimestamp: timestamppb.New(now.Add(-time.Hour)),
+			}}},
+		}
+		options := convert.MetricFamilyOptions{
+			Timestamp:             now,
+			Tracker:               convert.NewExpiringSeriesTracker(10 * time.Minute),
+			CreatedTimestampZeros: true,
+		}
+
+		Expect(convert.FromMetricFamily(family, options)[0].Samples).Should(HaveLen(2))
+
+		options.Timestamp = now.Add(5 * time.Minute)
+		Expect(convert.FromMetricFamily(family, options)[0].Samples).Should(HaveLen(1))
+
+		options.Timestamp = now.Add(20 * time.Minute)
+		Expect(convert.FromMetricFamily(family, options)[0].Samples).Should(HaveLen(2))
+	})
+
+	It("only returns metadata for families with new series when asked to", func() {
+		family := func(values ...string) *dto.MetricFamily {
+			f := &dto.MetricFamily{Name: proto.String("queue_depth"), Type: dto.MetricType_GAUGE.Enum()}
+			for _, v := range values {
+				f.Metric = append(f.Metric, &dto.Metric{
+					Label: []*dto.LabelPair{{Name: proto.String("queue"), Value: proto.String(v)}},
+					Gauge: &dto.Gauge{Value: proto.Float64(1)},
+				})
+			}
+			return f
+		}
+		options := convert.MetricFamilyOptions{
+			Timestamp:             now,
+			Tracker:               convert.NewExpiringSeriesTracker(time.Hour),
+			NewSeriesMetadataOnly: true,
+		}
+
+		series, metadata := convert.FromMetricFamilies([]*dto.MetricFamily{family("a")}, options)
+		Expect(series).Should(HaveLen(1))
+		Expect(metadata).Should(HaveLen(1))
+
+		series, metadata = convert.FromMetricFamilies([]*dto.MetricFamily{family("a")}, options)
+		Expect(series).Should(HaveLen(1))
+		Expect(metadata).Should(BeEmpty())
+
+		_, metadata = convert.FromMetricFamilies([]*dto.MetricFamily{family("a", "b")}, options)
+		Expect(metadata).Should(HaveLen(1))
+
+		options.Timestamp = now.Add(2 * time.Hour)
+		_, metadata = convert.FromMetricFamilies([]*dto.MetricFamily{family("a", "b")}, options)
+		Expect(metadata).Should(HaveLen(1))
+
+		options.Tracker = nil
+		_, metadata = convert.FromMetricFamilies([]*dto.MetricFamily{family("a")}, options)
+		Expect(metadata).Should(HaveLen(1))
+	})
+
+	It("handles exemplars without timestamps", func() {
+		family := &dto.MetricFamily{
+			Name: proto.String("hits_total"),
+			Type: dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{{Counter: &dto.Counter{
+				Value: proto.Float64(1),
+				Exemplar: &dto.Exemplar{
+					Label: []*dto.LabelPair{{Name: proto.String("trace_id"), Value: proto.String("abc")}},
+					Value: proto.Float64(1),
+				},
+			}, TimestampMs: proto.Int64(now.UnixMilli() - 1000)}},
+		}
+
+		series := convert.FromMetricFamily(family, convert.MetricFamilyOptions{Timestamp: now})
+		Expect(series[0].Exemplars).Should(Equal([]prompb.Exemplar{{
+			Labels:    []prompb.Label{{Name: "trace_id", Value: "abc"}},
+			Value:     1,
+			Timestamp: now.UnixMilli() - 1000,
+		}}))
+
+		series = convert.FromMetricFamily(family, convert.MetricFamilyOptions{Timestamp: now, MissingExemplarTimestamp: convert.ExemplarCurrentTime})
+		Expect(series[0].Exemplars[0].Timestamp).Should(Equal(now.UnixMilli()))
+
+		series = convert.FromMetricFamily(family, convert.MetricFamilyOptions{Timestamp: now, MissingExemplarTimestamp: convert.ExemplarDrop})
+		Expect(series[0].Exemplars).Should(BeEmpty())
+	})
+
+	It("drops counters that are still zero", func() {
+		family := &dto.MetricFamily{
+			Name: proto.String("errors_total"),
+			Type: dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{
+				{Label: []*dto.LabelPair{{Name: proto.String("code"), Value: proto.String("500")}}, Counter: &dto.Counter{Value: proto.Float64(0)}},
+				{Label: []*dto.LabelPair{{Name: proto.String("code"), Value: proto.String("503")}}, Counter: &dto.Counter{Value: proto.Float64(2)}},
+			},
+		}
+
+		Expect(convert.FromMetricFamily(family, convert.MetricFamilyOptions{})).Should(HaveLen(2))
+
+		series := convert.FromMetricFamily(family, convert.MetricFamilyOptions{DropZeroCounters: true})
+		Expect(series).Should(HaveLen(1))
+		Expect(series[0].Labels[1].Value).Should(Equal("503"))
+	})
+
+	It("adds a zero sample when a counter resets", func() {
+		counter := func(value float64) *dto.MetricFamily {
+			return &dto.MetricFamily{
+				Name:   proto.String("restarts_total"),
+				Type:   dto.MetricType_COUNTER.Enum(),
+				Metric: []*dto.Metric{{Counter: &dto.Counter{Value: proto.Float64(value)}}},
+			}
+		}
+		options := convert.MetricFamilyOptions{Tracker: convert.NewSeriesTracker(), CounterResetZeros: true}
+
+		options.Timestamp = now
+		Expect(convert.FromMetricFamily(counter(10), options)[0].Samples).Should(HaveLen(1))
+
+		options.Timestamp = now.Add(15 * time.Second)
+		Expect(convert.FromMetricFamily(counter(12), options)[0].Samples).Should(HaveLen(1))
+
+		options.Timestamp = now.Add(30 * time.Second)
+		Expect(convert.FromMetricFamily(counter(3), options)[0].Samples).Should(Equal([]prompb.Sample{
+			{Value: 0, Timestamp: now.Add(30*time.Second).UnixMilli() - 1},
+			{Value: 3, Timestamp: now.Add(30 * time.Second).UnixMilli()},
+		}))
+	})
+
+	It("interns label strings across conversions", func() {
+		family := func() *dto.MetricFamily {
+			return &dto.MetricFamily{
+				Name: proto.String("size_bytes"),
+				Type: dto.MetricType_HISTOGRAM.Enum(),
+				Metric: []*dto.Metric{{
+					Label: []*dto.LabelPair{{Name: proto.String("path"), Value: proto.String("/" + "api")}},
+					Histogram: &dto.Histogram{
+						SampleCount: proto.Uint64(1),
+						SampleSum:   proto.Float64(50),
+						Bucket:      []*dto.Bucket{{UpperBound: proto.Float64(100), CumulativeCount: proto.Uint64(1)}},
+					},
+				}},
+			}
+		}
+		interner := convert.NewInterner(0)
+		options := convert.MetricFamilyOptions{Timestamp: now, Interner: interner}
+
+		first := convert.FromMetricFamily(family(), options)
+		second := convert.FromMetricFamily(family(), options)
+		Expect(first).Should(Equal(convert.FromMetricFamily(family(), convert.MetricFamilyOptions{Timestamp: now})))
+		Expect(second).Should(Equal(first))
+
+		for i := range first {
+			for j := range first[i].Labels {
+				Expect(unsafe.StringData(second[i].Labels[j].Value)).Should(Equal(unsafe.StringData(first[i].Labels[j].Value)))
+			}
+		}
+	})
+
+	It("starts over when the interner is full", func() {
+		interner := convert.NewInterner(2)
+		interner.Intern("a")
+		interner.Intern("b")
+		Expect(interner.Len()).Should(Equal(2))
+
+		interner.Intern("c")
+		Expect(interner.Len()).Should(Equal(1))
+
+		var nilInterner *convert.Interner
+		Expect(nilInterner.Intern("a")).Should(Equal("a"))
+		Expect(nilInterner.Len()).Should(BeZero())
+	})
+
+	It("stops converting once the context is done", func() {
+		families := []*dto.MetricFamily{{
+			Name:   proto.String("up"),
+			Type:   dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(1)}}},
+		}}
+
+		series, metadata, err := convert.FromMetricFamiliesContext(context.Background(), families, convert.MetricFamilyOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(series).Should(HaveLen(1))
+		Expect(metadata).Should(HaveLen(1))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, _, err = convert.FromMetricFamiliesContext(ctx, families, convert.MetricFamilyOptions{})
+		Expect(err).Should(MatchError(context.Canceled))
+	})
+
+	It("applies the policy for metrics with their own __name__ label", func() {
+		families := []*dto.MetricFamily{{
+			Name: proto.String("bridged"),
+			Type: dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{{
+				Label: []*dto.LabelPair{
+					{Name: proto.String("__name__"), Value: proto.String("original")},
+					{Name: proto.String("source"), Value: proto.String("statsd")},
+				},
+				Gauge: &dto.Gauge{Value: proto.Float64(1)},
+			}},
+		}}
+
+		series, _ := convert.FromMetricFamilies(families, convert.MetricFamilyOptions{Timestamp: now})
+		Expect(series[0].Labels).Should(Equal([]prompb.Label{
+			{Name: "__name__", Value: "bridged"},
+			{Name: "source", Value: "statsd"},
+		}))
+
+		series, _ = convert.FromMetricFamilies(families, convert.MetricFamilyOptions{
+			Timestamp:       now,
+			NameLabelPolicy: convert.NameLabelRespect,
+		})
+		Expect(series[0].Labels).Should(Equal([]prompb.Label{
+			{Name: "__name__", Value: "original"},
+			{Name: "source", Value: "statsd"},
+		}))
+		Expect(families[0].Metric[0].Label).Should(HaveLen(2))
+
+		_, _, err := convert.FromMetricFamiliesContext(context.Background(), families, convert.MetricFamilyOptions{
+			NameLabelPolicy: convert.NameLabelError,
+		})
+		Expect(err).Should(MatchError(convert.ErrNameLabel))
+		Expect(convert.FromMetricFamily(families[0], convert.MetricFamilyOptions{NameLabelPolicy: convert.NameLabelError})).Should(BeEmpty())
+	})
+
+	It("reports untyped families as gauges when asked to", func() {
+		families := []*dto.MetricFamily{{
+			Name:   proto.String("bridged_value"),
+			Type:   dto.MetricType_UNTYPED.Enum(),
+			Metric: []*dto.Metric{{Untyped: &dto.Untyped{Value: proto.Float64(7)}}},
+		}}
+
+		_, metadata := convert.FromMetricFamilies(families, convert.MetricFamilyOptions{Timestamp: now})
+		Expect(metadata[0].Type).Should(Equal(prompb.MetricMetadata_UNKNOWN))
+
+		series, metadata := convert.FromMetricFamilies(families, convert.MetricFamilyOptions{
+			Timestamp:          now,
+			UntypedAsGauge:     true,
+			UntypedGaugeSuffix: "_gauge",
+		})
+		Expect(metadata).Should(Equal([]prompb.MetricMetadata{{
+			Type:             prompb.MetricMetadata_GAUGE,
+			MetricFamilyName: "bridged_value_gauge",
+		}}))
+		Expect(series).Should(HaveLen(1))
+		Expect(series[0].Labels).Should(Equal([]prompb.Label{{Name: "__name__", Value: "bridged_value_gauge"}}))
+		Expect(series[0].Samples[0].Value).Should(Equal(7.0))
+		Expect(families[0].GetType()).Should(Equal(dto.MetricType_UNTYPED))
+	})
+
+	// Before the conversion moved out of the writer, a classic histogram was sent as a native histogram with no
+	// buckets and no count, so only its sum reached the receiver
+	Describe("classic histograms", func() {
+		classic := func(typ dto.MetricType, h *dto.Histogram) *dto.MetricFamily {
+			return &dto.MetricFamily{
+				Name:   proto.String("size_bytes"),
+				Type:   typ.Enum(),
+				Metric: []*dto.Metric{{Label: []*dto.LabelPair{{Name: proto.String("job"), Value: proto.String("api")}}, Histogram: h}},
+			}
+		}
+
+		It("become cumulative _bucket series with a +Inf bucket, and _sum and _count series", func() {
+			series := convert.FromMetricFamily(classic(dto.MetricType_HISTOGRAM, &dto.Histogram{
+				SampleCount: proto.Uint64(5),
+				SampleSum:   proto.Float64(320),
+				Bucket: []*dto.Bucket{
+					{UpperBound: proto.Float64(10), CumulativeCount: proto.Uint64(1)},
+					{UpperBound: proto.Float64(100), CumulativeCount: proto.Uint64(4)},
+				},
+			}), convert.MetricFamilyOptions{Timestamp: now})
+
+			var rendered []string
+			for _, s := range series {
+				Expect(s.Histograms).Should(BeEmpty())
+				Expect(s.Samples).Should(HaveLen(1))
+				Expect(s.Samples[0].Timestamp).Should(Equal(now.UnixMilli()))
+				rendered = append(rendered, fmt.Sprintf("%s%s %g", s.Labels[0].Value, labelsSuffix(s.Labels), s.Samples[0].Value))
+			}
+			Expect(rendered).Should(Equal([]string{
+				"size_bytes_bucket{job=api,le=10} 1",
+				"size_bytes_bucket{job=api,le=100} 4",
+				"size_bytes_bucket{job=api,le=+Inf} 5",
+				"size_bytes_sum{job=api} 320",
+				"size_bytes_count{job=api} 5",
+			}))
+		})
+
+		It("keep a +Inf bucket that is already exposed and use float counts", func() {
+			series := convert.FromMetricFamily(classic(dto.MetricType_HISTOGRAM, &dto.Histogram{
+				SampleCountFloat: proto.Float64(2.5),
+				Bucket: []*dto.Bucket{
+					{UpperBound: proto.Float64(1), CumulativeCountFloat: proto.Float64(1.5)},
+					{UpperBound: proto.Float64(math.Inf(1)), CumulativeCountFloat: proto.Float64(2.5)},
+				},
+			}), convert.MetricFamilyOptions{Timestamp: now})
+
+			Expect(seriesNames(series)).Should(Equal([]string{"size_bytes_bucket", "size_bytes_bucket", "size_bytes_sum", "size_bytes_count"}))
+			Expect(series[0].Samples[0].Value).Should(Equal(1.5))
+			Expect(series[1].Samples[0].Value).Should(Equal(2.5))
+			Expect(series[3].Samples[0].Value).Should(Equal(2.5))
+		})
+
+		It("name the sum and count of gauge histograms _gsum and _gcount", func() {
+			series := convert.FromMetricFamily(classic(dto.MetricType_GAUGE_HISTOGRAM, &dto.Histogram{
+				SampleCount: proto.Uint64(1),
+				Bucket:      []*dto.Bucket{{UpperBound: proto.Float64(10), CumulativeCount: proto.Uint64(1)}},
+			}), convert.MetricFamilyOptions{Timestamp: now})
+
+			Expect(seriesNames(series)).Should(Equal([]string{"size_bytes_bucket", "size_bytes_bucket", "size_bytes_gsum", "size_bytes_gcount"}))
+		})
+
+		It("are sent alongside the native histogram when both are exposed", func() {
+			series := convert.FromMetricFamily(classic(dto.MetricType_HISTOGRAM, &dto.Histogram{
+				SampleCount: proto.Uint64(1),
+				Schema:      proto.Int32(0),
+				Bucket:      []*dto.Bucket{{UpperBound: proto.Float64(10), CumulativeCount: proto.Uint64(1)}},
+			}), convert.MetricFamilyOptions{Timestamp: now})
+
+			Expect(seriesNames(series)).Should(Equal([]string{"size_bytes", "size_bytes_bucket", "size_bytes_bucket", "size_bytes_sum", "size_bytes_count"}))
+			Expect(series[0].Histograms).Should(HaveLen(1))
+		})
+	})
+
+	// Before the conversion moved out of the writer, a summary became a single series with no samples, which
+	// receivers reject
+	It("converts summaries into quantile, _sum and _count series", func() {
+		series := convert.FromMetricFamily(&dto.MetricFamily{
+			Name: proto.String("latency_seconds"),
+			Type: dto.MetricType_SUMMARY.Enum(),
+			Metric: []*dto.Metric{
+				{
+					Label: []*dto.LabelPair{{Name: proto.String("job"), Value: proto.String("api")}},
+					Summary: &dto.Summary{
+						SampleCount: proto.Uint64(4),
+						SampleSum:   proto.Float64(1.5),
+						Quantile: []*dto.Quantile{
+							{Quantile: proto.Float64(0.5), Value: proto.Float64(0.25)},
+							{Quantile: proto.Float64(0.99), Value: proto.Float64(0.75)},
+						},
+					},
+				},
+				{Summary: &dto.Summary{SampleCount: proto.Uint64(0)}},
+			},
+		}, convert.MetricFamilyOptions{Timestamp: now})
+
+		var rendered []string
+		for _, s := range series {
+			Expect(s.Samples).Should(Equal([]prompb.Sample{{Value: s.Samples[0].Value, Timestamp: now.UnixMilli()}}))
+			rendered = append(rendered, fmt.Sprintf("%s%s %g", s.Labels[0].Value, labelsSuffix(s.Labels), s.Samples[0].Value))
+		}
+		Expect(rendered).Should(Equal([]string{
+			"latency_seconds{job=api,quantile=0.5} 0.25",
+			"latency_seconds{job=api,quantile=0.99} 0.75",
+			"latency_seconds_sum{job=api} 1.5",
+			"latency_seconds_count{job=api} 4",
+			"latency_seconds_sum 0",
+			"latency_seconds_count 0",
+		}))
+	})
+
+	// Before the conversion moved out of the writer, samples took the metric's own timestamp, which client_golang
+	// never sets, so every gathered sample was sent at 0 (1970) and rejected as out of bounds
+	It("stamps samples of metrics without a timestamp with the time of the conversion", func() {
+		family := &dto.MetricFamily{
+			Name: proto.String("queue_depth"),
+			Type: dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{
+				{Gauge: &dto.Gauge{Value: proto.Float64(3)}},
+				{Label: []*dto.LabelPair{{Name: proto.String("queue"), Value: proto.String("b")}}, Gauge: &dto.Gauge{Value: proto.Float64(4)}},
+			},
+		}
+
+		series := convert.FromMetricFamily(family, convert.MetricFamilyOptions{Timestamp: now})
+		Expect(series[0].Samples[0].Timestamp).Should(Equal(now.UnixMilli()))
+		Expect(series[1].Samples[0].Timestamp).Should(Equal(now.UnixMilli()))
+
+		before := time.Now().UnixMilli()
+		series = convert.FromMetricFamily(family, convert.MetricFamilyOptions{})
+		after := time.Now().UnixMilli()
+		Expect(series[0].Samples[0].Timestamp).Should(BeNumerically(">=", before))
+		Expect(series[0].Samples[0].Timestamp).Should(BeNumerically("<=", after))
+		Expect(series[1].Samples[0].Timestamp).Should(Equal(series[0].Samples[0].Timestamp))
+	})
+
+	// Before the conversion moved out of the writer, labels kept the order of the metric's label pairs with __name__
+	// appended last, while the remote write specification requires them sorted by name
+	It("sorts labels by name", func() {
+		pairs := []*dto.LabelPair{
+			{Name: proto.String("zone"), Value: proto.String("a")},
+			{Name: proto.String("code"), Value: proto.String("200")},
+			{Name: proto.String("Method"), Value: proto.String("GET")},
+		}
+
+		Expect(convert.Labels("requests_total", pairs)).Should(Equal([]prompb.Label{
+			{Name: "Method", Value: "GET"},
+			{Name: "__name__", Value: "requests_total"},
+			{Name: "code", Value: "200"},
+			{Name: "zone", Value: "a"},
+		}))
+
+		series := convert.FromMetricFamily(&dto.MetricFamily{
+			Name: proto.String("requests_total"),
+			Type: dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{{
+				Label: pairs,
+				Counter: &dto.Counter{Value: proto.Float64(1), Exemplar: &dto.Exemplar{
+					Label: []*dto.LabelPair{
+						{Name: proto.String("trace_id"), Value: proto.String("abc")},
+						{Name: proto.String("span_id"), Value: proto.String("def")},
+					},
+					Value: proto.Float64(1),
+				}},
+			}},
+		}, convert.MetricFamilyOptions{Timestamp: now})
+
+		Expect(series[0].Labels).Should(Equal(convert.Labels("requests_total", pairs)))
+		Expect(series[0].Exemplars[0].Labels).Should(Equal([]prompb.Label{
+			{Name: "span_id", Value: "def"},
+			{Name: "trace_id", Value: "abc"},
+		}))
+		Expect(pairs[0].GetName()).Should(Equal("zone"))
+	})
+})
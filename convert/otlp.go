@@ -0,0 +1,608 @@
+// Package convert turns metrics from other representations into the prompb types that a RemoteMetricsWriter sends
+package convert
+
+import (
+	"encoding/hex"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/prometheus/prompb"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+const (
+	metricNameLabel = "__name__"
+
+	// minimum and maximum native histogram schemas Prometheus accepts
+	minNativeSchema = -4
+	maxNativeSchema = 8
+)
+
+// staleNaN is the value Prometheus uses to mark a series as stale
+var staleNaN = math.Float64frombits(0x7ff0000000000002)
+
+// OTLPOptions are the optional settings for FromOTLP.
+//
+//	If AddMetricSuffixes is set, monotonic cumulative sums get a _total suffix and well-known units (s, ms, By, ...)
+//	are appended to metric names, following the OpenTelemetry to Prometheus naming conventions
+type OTLPOptions struct {
+	AddMetricSuffixes bool
+}
+
+// otlpAttribute is an attribute with its value rendered as a label value
+type otlpAttribute struct {
+	key   string
+	value string
+}
+
+// FromOTLP converts OpenTelemetry metrics into series and metadata that can be passed to
+// RemoteMetricsWriter.WriteTimeSeries.
+//
+// Gauges and non-monotonic sums become gauges, monotonic sums become counters, histograms become classic histograms
+// (_bucket, _sum and _count series), exponential histograms become native histograms and summaries become classic
+// summaries. The service.name, service.namespace and service.instance.id resource attributes become the job and
+// instance labels. The instrumentation scope's name and version become the otel_scope_name and otel_scope_version
+// labels, and its attributes become otel_scope_ labels, so metrics of the same name from different scopes stay
+// separate series. Sums and histograms with delta temporality are skipped, since Prometheus only stores cumulative
+// values.
+func FromOTLP(metrics pmetric.Metrics, options OTLPOptions) ([]prompb.TimeSeries, []prompb.MetricMetadata) {
+	var series []prompb.TimeSeries
+	var metadata []prompb.MetricMetadata
+	seen := map[string]bool{}
+
+	for i := 0; i < metrics.ResourceMetrics().Len(); i++ {
+		rm := metrics.ResourceMetrics().At(i)
+		resourceLabels := resourceLabels(attributes(rm.Resource().Attributes()))
+		for j := 0; j < rm.ScopeMetrics().Len(); j++ {
+			sm := rm.ScopeMetrics().At(j)
+			labels := append(scopeLabels(sm.Scope()), resourceLabels...)
+			for k := 0; k < sm.Metrics().Len(); k++ {
+				m := sm.Metrics().At(k)
+				name, metricType, ok := otlpMetricName(m, options)
+				if !ok {
+					continue
+				}
+
+				converted := convertOTLPMetric(name, m, labels)
+				if len(converted) == 0 {
+					continue
+				}
+				series = append(series, converted...)
+
+				if !seen[name] {
+					seen[name] = true
+					metadata = append(metadata, prompb.MetricMetadata{
+						Type:             metricType,
+						MetricFamilyName: name,
+						Help:             m.Description(),
+						Unit:             m.Unit(),
+					})
+				}
+			}
+		}
+	}
+
+	return series, metadata
+}
+
+// FromOTLPProto is FromOTLP for a serialized OTLP ExportMetricsServiceRequest, such as the body of an OTLP/HTTP
+// protobuf request
+func FromOTLPProto(data []byte, options OTLPOptions) ([]prompb.TimeSeries, []prompb.MetricMetadata, error) {
+	metrics, err := (&pmetric.ProtoUnmarshaler{}).UnmarshalMetrics(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	series, metadata := FromOTLP(metrics, options)
+	return series, metadata, nil
+}
+
+func otlpMetricName(m pmetric.Metric, options OTLPOptions) (string, prompb.MetricMetadata_MetricType, bool) {
+	name := sanitizeMetricName(m.Name())
+	if name == "" {
+		return "", prompb.MetricMetadata_UNKNOWN, false
+	}
+
+	var metricType prompb.MetricMetadata_MetricType
+	switch m.Type() {
+	case pmetric.MetricTypeGauge:
+		metricType = prompb.MetricMetadata_GAUGE
+	case pmetric.MetricTypeSum:
+		if m.Sum().AggregationTemporality() != pmetric.AggregationTemporalityCumulative {
+			return "", prompb.MetricMetadata_UNKNOWN, false
+		}
+
+		metricType = prompb.MetricMetadata_GAUGE
+		if m.Sum().IsMonotonic() {
+			metricType = prompb.MetricMetadata_COUNTER
+		}
+	case pmetric.MetricTypeHistogram:
+		if m.Histogram().AggregationTemporality() != pmetric.AggregationTemporalityCumulative {
+			return "", prompb.MetricMetadata_UNKNOWN, false
+		}
+		metricType = prompb.MetricMetadata_HISTOGRAM
+	case pmetric.MetricTypeExponentialHistogram:
+		if m.ExponentialHistogram().AggregationTemporality() != pmetric.AggregationTemporalityCumulative {
+			return "", prompb.MetricMetadata_UNKNOWN, false
+		}
+		metricType = prompb.MetricMetadata_HISTOGRAM
+	case pmetric.MetricTypeSummary:
+		metricType = prompb.MetricMetadata_SUMMARY
+	default:
+		return "", prompb.MetricMetadata_UNKNOWN, false
+	}
+
+	if options.AddMetricSuffixes {
+		if unit := unitSuffix(m.Unit()); unit != "" && !strings.HasSuffix(name, "_"+unit) {
+			name += "_" + unit
+		}
+
+		if metricType == prompb.MetricMetadata_COUNTER && !strings.HasSuffix(name, "_total") {
+			name += "_total"
+		}
+	}
+
+	return name, metricType, true
+}
+
+func convertOTLPMetric(name string, m pmetric.Metric, resourceLabels []prompb.Label) []prompb.TimeSeries {
+	switch m.Type() {
+	case pmetric.MetricTypeGauge:
+		return convertOTLPNumbers(name, m.Gauge().DataPoints(), resourceLabels)
+	case pmetric.MetricTypeSum:
+		return convertOTLPNumbers(name, m.Sum().DataPoints(), resourceLabels)
+	case pmetric.MetricTypeHistogram:
+		var series []prompb.TimeSeries
+		points := m.Histogram().DataPoints()
+		for i := 0; i < points.Len(); i++ {
+			series = append(series, convertOTLPHistogram(name, points.At(i), resourceLabels)...)
+		}
+		return series
+	case pmetric.MetricTypeExponentialHistogram:
+		var series []prompb.TimeSeries
+		points := m.ExponentialHistogram().DataPoints()
+		for i := 0; i < points.Len(); i++ {
+			p := points.At(i)
+			h, ok := convertOTLPExponential(p)
+			if !ok {
+				continue
+			}
+
+			series = append(series, prompb.TimeSeries{
+				Labels:     otlpLabels(name, resourceLabels, attributes(p.Attributes())),
+				Histograms: []prompb.Histogram{h},
+				Exemplars:  convertOTLPExemplars(p.Exemplars()),
+			})
+		}
+		return series
+	case pmetric.MetricTypeSummary:
+		var series []prompb.TimeSeries
+		points := m.Summary().DataPoints()
+		for i := 0; i < points.Len(); i++ {
+			series = append(series, convertOTLPSummary(name, points.At(i), resourceLabels)...)
+		}
+		return series
+	default:
+		return nil
+	}
+}
+
+func convertOTLPNumbers(name string, points pmetric.NumberDataPointSlice, resourceLabels []prompb.Label) []prompb.TimeSeries {
+	series := make([]prompb.TimeSeries, 0, points.Len())
+	for i := 0; i < points.Len(); i++ {
+		p := points.At(i)
+		value := p.DoubleValue()
+		if p.ValueType() == pmetric.NumberDataPointValueTypeInt {
+			value = float64(p.IntValue())
+		}
+		if p.Flags().NoRecordedValue() {
+			value = staleNaN
+		}
+
+		series = append(series, prompb.TimeSeries{
+			Labels:    otlpLabels(name, resourceLabels, attributes(p.Attributes())),
+			Samples:   []prompb.Sample{{Value: value, Timestamp: timestampMillis(p.Timestamp())}},
+			Exemplars: convertOTLPExemplars(p.Exemplars()),
+		})
+	}
+
+	return series
+}
+
+func convertOTLPHistogram(name string, p pmetric.HistogramDataPoint, resourceLabels []prompb.Label) []prompb.TimeSeries {
+	ts := timestampMillis(p.Timestamp())
+	stale := p.Flags().NoRecordedValue()
+	sample := func(v float64) []prompb.Sample {
+		if stale {
+			v = staleNaN
+		}
+		return []prompb.Sample{{Value: v, Timestamp: ts}}
+	}
+
+	attrs := attributes(p.Attributes())
+	bounds, counts := p.ExplicitBounds(), p.BucketCounts()
+	series := make([]prompb.TimeSeries, 0, bounds.Len()+3)
+
+	var cumulative uint64
+	for i := 0; i < bounds.Len(); i++ {
+		if i < counts.Len() {
+			cumulative += counts.At(i)
+		}
+		series = append(series, prompb.TimeSeries{
+			Labels:  otlpLabels(name+"_bucket", resourceLabels, attrs, prompb.Label{Name: "le", Value: formatFloat(bounds.At(i))}),
+			Samples: sample(float64(cumulative)),
+		})
+	}
+
+	series = append(series,
+		prompb.TimeSeries{
+			Labels:    otlpLabels(name+"_bucket", resourceLabels, attrs, prompb.Label{Name: "le", Value: "+Inf"}),
+			Samples:   sample(float64(p.Count())),
+			Exemplars: convertOTLPExemplars(p.Exemplars()),
+		},
+		prompb.TimeSeries{
+			Labels:  otlpLabels(name+"_sum", resourceLabels, attrs),
+			Samples: sample(p.Sum()),
+		},
+		prompb.TimeSeries{
+			Labels:  otlpLabels(name+"_count", resourceLabels, attrs),
+			Samples: sample(float64(p.Count())),
+		},
+	)
+
+	return series
+}
+
+func convertOTLPSummary(name string, p pmetric.SummaryDataPoint, resourceLabels []prompb.Label) []prompb.TimeSeries {
+	ts := timestampMillis(p.Timestamp())
+	stale := p.Flags().NoRecordedValue()
+	sample := func(v float64) []prompb.Sample {
+		if stale {
+			v = staleNaN
+		}
+		return []prompb.Sample{{Value: v, Timestamp: ts}}
+	}
+
+	attrs := attributes(p.Attributes())
+	quantiles := p.QuantileValues()
+	series := make([]prompb.TimeSeries, 0, quantiles.Len()+2)
+	for i := 0; i < quantiles.Len(); i++ {
+		q := quantiles.At(i)
+		series = append(series, prompb.TimeSeries{
+			Labels:  otlpLabels(name, resourceLabels, attrs, prompb.Label{Name: "quantile", Value: formatFloat(q.Quantile())}),
+			Samples: sample(q.Value()),
+		})
+	}
+
+	return append(series,
+		prompb.TimeSeries{
+			Labels:  otlpLabels(name+"_sum", resourceLabels, attrs),
+			Samples: sample(p.Sum()),
+		},
+		prompb.TimeSeries{
+			Labels:  otlpLabels(name+"_count", resourceLabels, attrs),
+			Samples: sample(float64(p.Count())),
+		},
+	)
+}
+
+// convertOTLPExponential maps an exponential histogram data point onto a native histogram. OTLP bucket i covers
+// (base^i, base^(i+1)], while native histogram bucket i covers (base^(i-1), base^i], so indexes are shifted by one.
+// Scales above 8 are reduced by merging neighbouring buckets; scales below -4 cannot be represented
+func convertOTLPExponential(p pmetric.ExponentialHistogramDataPoint) (prompb.Histogram, bool) {
+	if p.Scale() < minNativeSchema {
+		return prompb.Histogram{}, false
+	}
+
+	scaleDown := int32(0)
+	if p.Scale() > maxNativeSchema {
+		scaleDown = p.Scale() - maxNativeSchema
+	}
+
+	h := prompb.Histogram{
+		Count:         &prompb.Histogram_CountInt{CountInt: p.Count()},
+		Sum:           p.Sum(),
+		Schema:        p.Scale() - scaleDown,
+		ZeroThreshold: p.ZeroThreshold(),
+		ZeroCount:     &prompb.Histogram_ZeroCountInt{ZeroCountInt: p.ZeroCount()},
+		Timestamp:     timestampMillis(p.Timestamp()),
+	}
+	h.PositiveSpans, h.PositiveDeltas = exponentialBuckets(p.Positive(), scaleDown)
+	h.NegativeSpans, h.NegativeDeltas = exponentialBuckets(p.Negative(), scaleDown)
+
+	if p.Flags().NoRecordedValue() {
+		h = prompb.Histogram{
+			Count:     &prompb.Histogram_CountInt{},
+			Sum:       staleNaN,
+			Schema:    h.Schema,
+			ZeroCount: &prompb.Histogram_ZeroCountInt{},
+			Timestamp: h.Timestamp,
+		}
+	}
+
+	return h, true
+}
+
+func exponentialBuckets(b pmetric.ExponentialHistogramDataPointBuckets, scaleDown int32) ([]prompb.BucketSpan, []int64) {
+	type bucket struct {
+		index int32
+		count uint64
+	}
+
+	var merged []bucket
+	counts := b.BucketCounts()
+	for i := 0; i < counts.Len(); i++ {
+		count := counts.At(i)
+		if count == 0 {
+			continue
+		}
+
+		index := ((b.Offset() + int32(i)) >> scaleDown) + 1
+		if n := len(merged); n > 0 && merged[n-1].index == index {
+			merged[n-1].count += count
+			continue
+		}
+		merged = append(merged, bucket{index: index, count: count})
+	}
+
+	var spans []prompb.BucketSpan
+	deltas := make([]int64, 0, len(merged))
+	var prevCount int64
+	var nextIndex int32
+	for i, bkt := range merged {
+		if i == 0 || bkt.index != nextIndex {
+			offset := bkt.index
+			if i > 0 {
+				offset = bkt.index - nextIndex
+			}
+			spans = append(spans, prompb.BucketSpan{Offset: offset})
+		}
+
+		spans[len(spans)-1].Length++
+		deltas = append(deltas, int64(bkt.count)-prevCount)
+		prevCount = int64(bkt.count)
+		nextIndex = bkt.index + 1
+	}
+
+	return spans, deltas
+}
+
+func convertOTLPExemplars(exemplars pmetric.ExemplarSlice) []prompb.Exemplar {
+	if exemplars.Len() == 0 {
+		return nil
+	}
+
+	converted := make([]prompb.Exemplar, 0, exemplars.Len())
+	for i := 0; i < exemplars.Len(); i++ {
+		e := exemplars.At(i)
+
+		var labels []prompb.Label
+		if traceID := e.TraceID(); !traceID.IsEmpty() {
+			labels = append(labels, prompb.Label{Name: "trace_id", Value: hex.EncodeToString(traceID[:])})
+		}
+		if spanID := e.SpanID(); !spanID.IsEmpty() {
+			labels = append(labels, prompb.Label{Name: "span_id", Value: hex.EncodeToString(spanID[:])})
+		}
+		labels = append(labels, attributeLabels(attributes(e.FilteredAttributes()))...)
+		sortLabels(labels)
+
+		value := e.DoubleValue()
+		if e.ValueType() == pmetric.ExemplarValueTypeInt {
+			value = float64(e.IntValue())
+		}
+
+		converted = append(converted, prompb.Exemplar{
+			Labels:    labels,
+			Value:     value,
+			Timestamp: timestampMillis(e.Timestamp()),
+		})
+	}
+
+	return converted
+}
+
+// attributes renders the values of m as label values, in the order they were added
+func attributes(m pcommon.Map) []otlpAttribute {
+	attrs := make([]otlpAttribute, 0, m.Len())
+	m.Range(func(key string, value pcommon.Value) bool {
+		attrs = append(attrs, otlpAttribute{key: key, value: value.AsString()})
+		return true
+	})
+
+	return attrs
+}
+
+// scopeLabels returns the otel_scope_ labels that identify the instrumentation scope metrics were recorded with.
+// Scope attributes named name or version would collide with the scope's own name and version, so they are dropped
+func scopeLabels(scope pcommon.InstrumentationScope) []prompb.Label {
+	var labels []prompb.Label
+	if scope.Name() != "" {
+		labels = append(labels, prompb.Label{Name: "otel_scope_name", Value: scope.Name()})
+	}
+	if scope.Version() != "" {
+		labels = append(labels, prompb.Label{Name: "otel_scope_version", Value: scope.Version()})
+	}
+
+	for _, l := range attributeLabels(attributes(scope.Attributes())) {
+		if l.Name == "name" || l.Name == "version" {
+			continue
+		}
+		labels = append(labels, prompb.Label{Name: "otel_scope_" + l.Name, Value: l.Value})
+	}
+
+	return labels
+}
+
+func resourceLabels(attributes []otlpAttribute) []prompb.Label {
+	var name, namespace, instance string
+	for _, attr := range attributes {
+		switch attr.key {
+		case "service.name":
+			name = attr.value
+		case "service.namespace":
+			namespace = attr.value
+		case "service.instance.id":
+			instance = attr.value
+		}
+	}
+
+	var labels []prompb.Label
+	if name != "" {
+		if namespace != "" {
+			name = namespace + "/" + name
+		}
+		labels = append(labels, prompb.Label{Name: "job", Value: name})
+	}
+
+	if instance != "" {
+		labels = append(labels, prompb.Label{Name: "instance", Value: instance})
+	}
+
+	return labels
+}
+
+// attributeLabels sanitizes attribute keys into label names. If two keys map to the same name, their values are
+// joined with a semicolon, as the OpenTelemetry specification requires
+func attributeLabels(attributes []otlpAttribute) []prompb.Label {
+	labels := make([]prompb.Label, 0, len(attributes))
+	index := make(map[string]int, len(attributes))
+	for _, attr := range attributes {
+		name := sanitizeLabelName(attr.key)
+		if name == "" {
+			continue
+		}
+
+		if i, ok := index[name]; ok {
+			labels[i].Value += ";" + attr.value
+			continue
+		}
+
+		index[name] = len(labels)
+		labels = append(labels, prompb.Label{Name: name, Value: attr.value})
+	}
+
+	return labels
+}
+
+// otlpLabels builds the sorted label set of a series. Data point attributes and extra labels win over resource
+// labels with the same name
+func otlpLabels(name string, resourceLabels []prompb.Label, attributes []otlpAttribute, extra ...prompb.Label) []prompb.Label {
+	labels := append(attributeLabels(attributes), extra...)
+
+	for _, rl := range resourceLabels {
+		found := false
+		for _, l := range labels {
+			if l.Name == rl.Name {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			labels = append(labels, rl)
+		}
+	}
+
+	labels = append(labels, prompb.Label{Name: metricNameLabel, Value: name})
+	sortLabels(labels)
+
+	return labels
+}
+
+func sortLabels(labels []prompb.Label) {
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].Name < labels[j].Name
+	})
+}
+
+func sanitizeMetricName(name string) string {
+	return sanitize(name, true)
+}
+
+func sanitizeLabelName(name string) string {
+	sanitized := sanitize(name, false)
+	if sanitized != "" && sanitized[0] >= '0' && sanitized[0] <= '9' {
+		sanitized = "key_" + sanitized
+	}
+
+	return sanitized
+}
+
+func sanitize(name string, allowColons bool) string {
+	if name == "" {
+		return ""
+	}
+
+	var sb strings.Builder
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+			sb.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 && allowColons {
+				sb.WriteByte('_')
+			}
+			sb.WriteRune(r)
+		case r == ':' && allowColons:
+			sb.WriteRune(r)
+		default:
+			sb.WriteByte('_')
+		}
+	}
+
+	return sb.String()
+}
+
+var unitSuffixes = map[string]string{
+	"d":    "days",
+	"h":    "hours",
+	"min":  "minutes",
+	"s":    "seconds",
+	"ms":   "milliseconds",
+	"us":   "microseconds",
+	"ns":   "nanoseconds",
+	"By":   "bytes",
+	"KiBy": "kibibytes",
+	"MiBy": "mebibytes",
+	"GiBy": "gibibytes",
+	"KBy":  "kilobytes",
+	"MBy":  "megabytes",
+	"GBy":  "gigabytes",
+	"m":    "meters",
+	"V":    "volts",
+	"A":    "amperes",
+	"J":    "joules",
+	"W":    "watts",
+	"g":    "grams",
+	"Cel":  "celsius",
+	"Hz":   "hertz",
+	"%":    "percent",
+}
+
+func unitSuffix(unit string) string {
+	// annotations such as {requests} carry no unit
+	if strings.HasPrefix(unit, "{") {
+		return ""
+	}
+
+	if suffix, ok := unitSuffixes[unit]; ok {
+		return suffix
+	}
+
+	return ""
+}
+
+func timestampMillis(ts pcommon.Timestamp) int64 {
+	return int64(ts / 1e6)
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,185 @@
+package convert_test
+
+import (
+	"github.com/jghiloni/prometheus-remote-write/convert"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+var _ = Describe("FromOTLP", func() {
+	ts := pcommon.Timestamp(uint64(1700000000000) * 1e6)
+
+	// newScope returns the scope metrics of a new resource for the checkout service
+	newScope := func(metrics pmetric.Metrics) pmetric.ScopeMetrics {
+		rm := metrics.ResourceMetrics().AppendEmpty()
+		rm.Resource().Attributes().PutStr("service.name", "checkout")
+		rm.Resource().Attributes().PutStr("service.instance.id", "pod-1")
+		return rm.ScopeMetrics().AppendEmpty()
+	}
+
+	It("Converts gauges and counters", func() {
+		metrics := pmetric.NewMetrics()
+		scope := newScope(metrics)
+
+		gauge := scope.Metrics().AppendEmpty()
+		gauge.SetName("queue.depth")
+		gauge.SetDescription("items waiting")
+		p := gauge.SetEmptyGauge().DataPoints().AppendEmpty()
+		p.Attributes().PutStr("queue", "orders")
+		p.SetTimestamp(ts)
+		p.SetIntValue(12)
+
+		sum := scope.Metrics().AppendEmpty()
+		sum.SetName("http.server.duration.sum")
+		sum.SetUnit("s")
+		sum.SetEmptySum().SetIsMonotonic(true)
+		sum.Sum().SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+		p = sum.Sum().DataPoints().AppendEmpty()
+		p.SetTimestamp(ts)
+		p.SetDoubleValue(3.5)
+
+		series, metadata := convert.FromOTLP(metrics, convert.OTLPOptions{AddMetricSuffixes: true})
+		Expect(series).Should(HaveLen(2))
+
+		Expect(series[0].Labels).Should(Equal([]prompb.Label{
+			{Name: "__name__", Value: "queue_depth"},
+			{Name: "instance", Value: "pod-1"},
+			{Name: "job", Value: "checkout"},
+			{Name: "queue", Value: "orders"},
+		}))
+		Expect(series[0].Samples).Should(Equal([]prompb.Sample{{Value: 12, Timestamp: 1700000000000}}))
+		Expect(series[1].Labels[0]).Should(Equal(prompb.Label{Name: "__name__", Value: "http_server_duration_sum_seconds_total"}))
+		Expect(series[1].Samples[0].Value).Should(Equal(3.5))
+
+		Expect(metadata).Should(HaveLen(2))
+		Expect(metadata[0].Type).Should(Equal(prompb.MetricMetadata_GAUGE))
+		Expect(metadata[0].Help).Should(Equal("items waiting"))
+		Expect(metadata[1].Type).Should(Equal(prompb.MetricMetadata_COUNTER))
+	})
+
+	It("Skips delta temporality", func() {
+		metrics := pmetric.NewMetrics()
+		m := newScope(metrics).Metrics().AppendEmpty()
+		m.SetName("requests")
+		m.SetEmptySum().SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+		m.Sum().DataPoints().AppendEmpty().SetDoubleValue(1)
+
+		series, metadata := convert.FromOTLP(metrics, convert.OTLPOptions{})
+		Expect(series).Should(BeEmpty())
+		Expect(metadata).Should(BeEmpty())
+	})
+
+	It("Converts explicit bucket histograms to classic histograms", func() {
+		metrics := pmetric.NewMetrics()
+		m := newScope(metrics).Metrics().AppendEmpty()
+		m.SetName("latency")
+		m.SetEmptyHistogram().SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+		p := m.Histogram().DataPoints().AppendEmpty()
+		p.SetTimestamp(ts)
+		p.SetCount(6)
+		p.SetSum(2.5)
+		p.BucketCounts().FromRaw([]uint64{1, 2, 3})
+		p.ExplicitBounds().FromRaw([]float64{0.1, 1})
+
+		series, _ := convert.FromOTLP(metrics, convert.OTLPOptions{})
+		Expect(series).Should(HaveLen(5))
+
+		values := map[string]float64{}
+		for _, s := range series {
+			key := ""
+			for _, l := range s.Labels {
+				if l.Name == "__name__" || l.Name == "le" {
+					key += l.Value + " "
+				}
+			}
+			values[key] = s.Samples[0].Value
+		}
+		Expect(values).Should(Equal(map[string]float64{
+			"latency_bucket 0.1 ":  1,
+			"latency_bucket 1 ":    3,
+			"latency_bucket +Inf ": 6,
+			"latency_sum ":         2.5,
+			"latency_count ":       6,
+		}))
+	})
+
+	It("Converts exponential histograms to native histograms", func() {
+		metrics := pmetric.NewMetrics()
+		m := newScope(metrics).Metrics().AppendEmpty()
+		m.SetName("size")
+		m.SetEmptyExponentialHistogram().SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+		p := m.ExponentialHistogram().DataPoints().AppendEmpty()
+		p.SetTimestamp(ts)
+		p.SetCount(6)
+		p.SetSum(10)
+		p.SetScale(2)
+		p.SetZeroCount(1)
+		p.Positive().SetOffset(-1)
+		p.Positive().BucketCounts().FromRaw([]uint64{2, 0, 3})
+
+		series, metadata := convert.FromOTLP(metrics, convert.OTLPOptions{})
+		Expect(series).Should(HaveLen(1))
+		Expect(metadata[0].Type).Should(Equal(prompb.MetricMetadata_HISTOGRAM))
+
+		h := series[0].Histograms[0]
+		Expect(h.Schema).Should(Equal(int32(2)))
+		Expect(h.Sum).Should(Equal(10.0))
+		Expect(h.Timestamp).Should(Equal(int64(1700000000000)))
+		Expect(h.PositiveSpans).Should(Equal([]prompb.BucketSpan{{Offset: 0, Length: 1}, {Offset: 1, Length: 1}}))
+		Expect(h.PositiveDeltas).Should(Equal([]int64{2, 1}))
+	})
+
+	It("Keeps the instrumentation scope as otel_scope_ labels", func() {
+		metrics := pmetric.NewMetrics()
+		for _, version := range []string{"1.0.0", "2.0.0"} {
+			scope := newScope(metrics)
+			scope.Scope().SetName("checkout/http")
+			scope.Scope().SetVersion(version)
+			scope.Scope().Attributes().PutStr("library.language", "go")
+			scope.Scope().Attributes().PutStr("name", "ignored")
+
+			m := scope.Metrics().AppendEmpty()
+			m.SetName("requests_in_flight")
+			p := m.SetEmptyGauge().DataPoints().AppendEmpty()
+			p.SetTimestamp(ts)
+			p.SetIntValue(3)
+		}
+
+		series, metadata := convert.FromOTLP(metrics, convert.OTLPOptions{})
+		Expect(series).Should(HaveLen(2))
+		Expect(metadata).Should(HaveLen(1))
+		Expect(series[0].Labels).Should(Equal([]prompb.Label{
+			{Name: "__name__", Value: "requests_in_flight"},
+			{Name: "instance", Value: "pod-1"},
+			{Name: "job", Value: "checkout"},
+			{Name: "otel_scope_library_language", Value: "go"},
+			{Name: "otel_scope_name", Value: "checkout/http"},
+			{Name: "otel_scope_version", Value: "1.0.0"},
+		}))
+		Expect(series[1].Labels).Should(ContainElement(prompb.Label{Name: "otel_scope_version", Value: "2.0.0"}))
+	})
+
+	It("Reads serialized OTLP requests", func() {
+		metrics := pmetric.NewMetrics()
+		m := newScope(metrics).Metrics().AppendEmpty()
+		m.SetName("up")
+		p := m.SetEmptyGauge().DataPoints().AppendEmpty()
+		p.SetTimestamp(ts)
+		p.SetDoubleValue(1)
+
+		data, err := (&pmetric.ProtoMarshaler{}).MarshalMetrics(metrics)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		series, metadata, err := convert.FromOTLPProto(data, convert.OTLPOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		expected, expectedMetadata := convert.FromOTLP(metrics, convert.OTLPOptions{})
+		Expect(series).Should(Equal(expected))
+		Expect(metadata).Should(Equal(expectedMetadata))
+
+		_, _, err = convert.FromOTLPProto([]byte{0xff}, convert.OTLPOptions{})
+		Expect(err).Should(HaveOccurred())
+	})
+})
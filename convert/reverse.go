@@ -0,0 +1,338 @@
+package convert
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ToMetricFamilies is the inverse of FromMetricFamilies: it groups series back into metric families using the
+// metadata to decide each family's type. The _bucket, _sum and _count series of a classic histogram (and the quantile,
+// _sum and _count series of a summary) are reassembled into a single metric, as long as the metadata for the base name
+// says it is a histogram or summary. Series without metadata become untyped, or histograms if they carry native
+// histograms.
+//
+// A series with several samples becomes one metric per sample, each carrying its timestamp. Families are returned
+// sorted by name, with metrics in the order their series appeared.
+func ToMetricFamilies(series []prompb.TimeSeries, metadata []prompb.MetricMetadata) []*dto.MetricFamily {
+	byName := make(map[string]prompb.MetricMetadata, len(metadata))
+	for _, md := range metadata {
+		byName[md.MetricFamilyName] = md
+	}
+
+	builders := map[string]*familyBuilder{}
+	for _, s := range series {
+		name := labelValue(s.Labels, metricNameLabel)
+		familyName, suffix, md := familyFor(name, byName)
+		if _, ok := byName[familyName]; !ok && len(s.Histograms) > 0 {
+			md.Type = prompb.MetricMetadata_HISTOGRAM
+		}
+
+		b, ok := builders[familyName]
+		if !ok {
+			b = newFamilyBuilder(familyName, md)
+			builders[familyName] = b
+		}
+		b.add(s, suffix)
+	}
+
+	families := make([]*dto.MetricFamily, 0, len(builders))
+	for _, b := range builders {
+		families = append(families, b.family)
+	}
+
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].GetName() < families[j].GetName()
+	})
+
+	return families
+}
+
+// ToMetricType maps a remote write metadata type onto the matching client_model metric type. Info and state set
+// metrics are reported as gauges, and unknown metrics as untyped
+func ToMetricType(t prompb.MetricMetadata_MetricType) dto.MetricType {
+	switch t {
+	case prompb.MetricMetadata_COUNTER:
+		return dto.MetricType_COUNTER
+	case prompb.MetricMetadata_GAUGE, prompb.MetricMetadata_INFO, prompb.MetricMetadata_STATESET:
+		return dto.MetricType_GAUGE
+	case prompb.MetricMetadata_SUMMARY:
+		return dto.MetricType_SUMMARY
+	case prompb.MetricMetadata_HISTOGRAM:
+		return dto.MetricType_HISTOGRAM
+	case prompb.MetricMetadata_GAUGEHISTOGRAM:
+		return dto.MetricType_GAUGE_HISTOGRAM
+	default:
+		return dto.MetricType_UNTYPED
+	}
+}
+
+// familyFor works out which family a series named name belongs to, and which classic histogram or summary suffix (if
+// any) it carries
+func familyFor(name string, byName map[string]prompb.MetricMetadata) (string, string, prompb.MetricMetadata) {
+	if md, ok := byName[name]; ok {
+		return name, "", md
+	}
+
+	for _, suffix := range []string{"_bucket", "_sum", "_count", "_gsum", "_gcount"} {
+		base, found := strings.CutSuffix(name, suffix)
+		if !found {
+			continue
+		}
+
+		md, ok := byName[base]
+		if !ok {
+			continue
+		}
+
+		switch md.Type {
+		case prompb.MetricMetadata_HISTOGRAM, prompb.MetricMetadata_GAUGEHISTOGRAM, prompb.MetricMetadata_SUMMARY:
+			return base, suffix, md
+		}
+	}
+
+	return name, "", prompb.MetricMetadata{MetricFamilyName: name}
+}
+
+type familyBuilder struct {
+	family *dto.MetricFamily
+	// grouped holds the metrics that classic histogram and summary series are merged into, keyed by their labels
+	// (without le or quantile) and timestamp
+	grouped map[string]*dto.Metric
+}
+
+func newFamilyBuilder(name string, md prompb.MetricMetadata) *familyBuilder {
+	family := &dto.MetricFamily{
+		Name: &name,
+		Type: ToMetricType(md.Type).Enum(),
+	}
+	if md.Help != "" {
+		family.Help = &md.Help
+	}
+	if md.Unit != "" {
+		family.Unit = &md.Unit
+	}
+
+	return &familyBuilder{family: family, grouped: map[string]*dto.Metric{}}
+}
+
+func (b *familyBuilder) add(s prompb.TimeSeries, suffix string) {
+	switch b.family.GetType() {
+	case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+		for _, h := range s.Histograms {
+			b.family.Metric = append(b.family.Metric, &dto.Metric{
+				Label:       toLabelPairs(s.Labels),
+				Histogram:   toNativeHistogram(h, s.Exemplars),
+				TimestampMs: &h.Timestamp,
+			})
+		}
+		b.addClassic(s, suffix, "le")
+	case dto.MetricType_SUMMARY:
+		b.addClassic(s, suffix, "quantile")
+	default:
+		for i, sample := range s.Samples {
+			m := &dto.Metric{
+				Label:       toLabelPairs(s.Labels),
+				TimestampMs: &sample.Timestamp,
+			}
+
+			value := sample.Value
+			switch b.family.GetType() {
+			case dto.MetricType_COUNTER:
+				m.Counter = &dto.Counter{Value: &value}
+				if i == len(s.Samples)-1 && len(s.Exemplars) > 0 {
+					m.Counter.Exemplar = toExemplar(s.Exemplars[len(s.Exemplars)-1])
+				}
+			case dto.MetricType_GAUGE:
+				m.Gauge = &dto.Gauge{Value: &value}
+			default:
+				m.Untyped = &dto.Untyped{Value: &value}
+			}
+
+			b.family.Metric = append(b.family.Metric, m)
+		}
+	}
+}
+
+// addClassic merges the samples of one _bucket, _sum, _count or quantile series into the metrics of the family
+func (b *familyBuilder) addClassic(s prompb.TimeSeries, suffix, boundLabel string) {
+	bound := labelValue(s.Labels, boundLabel)
+	if suffix == "" && bound == "" {
+		// a series with the family's own name and no le or quantile label carries nothing to merge
+		return
+	}
+
+	for _, sample := range s.Samples {
+		m := b.metric(s.Labels, boundLabel, sample.Timestamp)
+
+		value := sample.Value
+		switch {
+		case suffix == "_sum" || suffix == "_gsum":
+			if m.Summary != nil {
+				m.Summary.SampleSum = &value
+			} else {
+				m.Histogram.SampleSum = &value
+			}
+		case suffix == "_count" || suffix == "_gcount":
+			if m.Summary != nil {
+				m.Summary.SampleCount = proto64(value)
+			} else if isCount(value) {
+				m.Histogram.SampleCount = proto64(value)
+			} else {
+				m.Histogram.SampleCountFloat = &value
+			}
+		case suffix == "_bucket":
+			upper, err := strconv.ParseFloat(bound, 64)
+			if err != nil || math.IsInf(upper, 1) {
+				// the +Inf bucket is implied by the sample count
+				continue
+			}
+
+			bucket := &dto.Bucket{UpperBound: &upper}
+			if isCount(value) {
+				bucket.CumulativeCount = proto64(value)
+			} else {
+				bucket.CumulativeCountFloat = &value
+			}
+			for _, e := range s.Exemplars {
+				if e.Timestamp <= sample.Timestamp {
+					bucket.Exemplar = toExemplar(e)
+				}
+			}
+			m.Histogram.Bucket = append(m.Histogram.Bucket, bucket)
+			sort.Slice(m.Histogram.Bucket, func(i, j int) bool {
+				return m.Histogram.Bucket[i].GetUpperBound() < m.Histogram.Bucket[j].GetUpperBound()
+			})
+		case m.Summary != nil:
+			q, err := strconv.ParseFloat(bound, 64)
+			if err != nil {
+				continue
+			}
+			m.Summary.Quantile = append(m.Summary.Quantile, &dto.Quantile{Quantile: &q, Value: &value})
+		}
+	}
+}
+
+// metric returns the metric that classic series with labels (ignoring boundLabel) at timestamp are merged into,
+// creating it if needed
+func (b *familyBuilder) metric(labels []prompb.Label, boundLabel string, timestamp int64) *dto.Metric {
+	filtered := make([]prompb.Label, 0, len(labels))
+	for _, l := range labels {
+		if l.Name != boundLabel && l.Name != metricNameLabel {
+			filtered = append(filtered, l)
+		}
+	}
+
+	var sb strings.Builder
+	for _, l := range filtered {
+		sb.WriteString(l.Name)
+		sb.WriteByte(0xff)
+		sb.WriteString(l.Value)
+		sb.WriteByte(0xff)
+	}
+	sb.WriteString(strconv.FormatInt(timestamp, 10))
+	key := sb.String()
+
+	if m, ok := b.grouped[key]; ok {
+		return m
+	}
+
+	m := &dto.Metric{
+		Label:       toLabelPairs(filtered),
+		TimestampMs: &timestamp,
+	}
+	if b.family.GetType() == dto.MetricType_SUMMARY {
+		m.Summary = &dto.Summary{}
+	} else {
+		m.Histogram = &dto.Histogram{}
+	}
+
+	b.grouped[key] = m
+	b.family.Metric = append(b.family.Metric, m)
+
+	return m
+}
+
+func toLabelPairs(labels []prompb.Label) []*dto.LabelPair {
+	pairs := make([]*dto.LabelPair, 0, len(labels))
+	for _, l := range labels {
+		if l.Name == metricNameLabel {
+			continue
+		}
+		pairs = append(pairs, &dto.LabelPair{Name: &l.Name, Value: &l.Value})
+	}
+
+	return pairs
+}
+
+func toExemplar(e prompb.Exemplar) *dto.Exemplar {
+	return &dto.Exemplar{
+		Label:     toLabelPairs(e.Labels),
+		Value:     &e.Value,
+		Timestamp: timestamppb.New(time.UnixMilli(e.Timestamp)),
+	}
+}
+
+func toBucketSpans(spans []prompb.BucketSpan) []*dto.BucketSpan {
+	converted := make([]*dto.BucketSpan, len(spans))
+	for i, s := range spans {
+		converted[i] = &dto.BucketSpan{Offset: &s.Offset, Length: &s.Length}
+	}
+
+	return converted
+}
+
+func toNativeHistogram(h prompb.Histogram, exemplars []prompb.Exemplar) *dto.Histogram {
+	native := &dto.Histogram{
+		SampleSum:     &h.Sum,
+		Schema:        &h.Schema,
+		ZeroThreshold: &h.ZeroThreshold,
+		NegativeSpan:  toBucketSpans(h.NegativeSpans),
+		NegativeDelta: h.NegativeDeltas,
+		NegativeCount: h.NegativeCounts,
+		PositiveSpan:  toBucketSpans(h.PositiveSpans),
+		PositiveDelta: h.PositiveDeltas,
+		PositiveCount: h.PositiveCounts,
+	}
+
+	if _, ok := h.GetCount().(*prompb.Histogram_CountFloat); ok {
+		count, zero := h.GetCountFloat(), h.GetZeroCountFloat()
+		native.SampleCountFloat = &count
+		native.ZeroCountFloat = &zero
+	} else {
+		count, zero := h.GetCountInt(), h.GetZeroCountInt()
+		native.SampleCount = &count
+		native.ZeroCount = &zero
+	}
+
+	for _, e := range exemplars {
+		native.Exemplars = append(native.Exemplars, toExemplar(e))
+	}
+
+	return native
+}
+
+func labelValue(labels []prompb.Label, name string) string {
+	for _, l := range labels {
+		if l.Name == name {
+			return l.Value
+		}
+	}
+
+	return ""
+}
+
+func isCount(v float64) bool {
+	return v >= 0 && v == math.Trunc(v) && v < math.MaxUint64
+}
+
+func proto64(v float64) *uint64 {
+	u := uint64(v)
+	return &u
+}
@@ -0,0 +1,82 @@
+package convert_test
+
+import (
+	"time"
+
+	"github.com/jghiloni/prometheus-remote-write/convert"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("ToMetricFamilies", func() {
+	now := time.UnixMilli(1700000000000)
+
+	It("round trips gathered metrics", func() {
+		r := prometheus.NewRegistry()
+		c := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests_total", Help: "Requests"}, []string{"code"})
+		s := prometheus.NewSummary(prometheus.SummaryOpts{Name: "latency_seconds", Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01}})
+		h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "size_bytes", Buckets: []float64{10, 100}})
+		r.MustRegister(c, s, h)
+
+		c.WithLabelValues("200").Add(2)
+		c.WithLabelValues("500").Inc()
+		s.Observe(1)
+		h.Observe(50)
+		h.Observe(500)
+
+		gathered, err := r.Gather()
+		Expect(err).ShouldNot(HaveOccurred())
+
+		families := convert.ToMetricFamilies(convert.FromMetricFamilies(gathered, convert.MetricFamilyOptions{Timestamp: now}))
+		Expect(families).Should(HaveLen(3))
+
+		Expect(families[0].GetName()).Should(Equal("latency_seconds"))
+		Expect(families[0].GetType()).Should(Equal(dto.MetricType_SUMMARY))
+		Expect(families[0].GetMetric()).Should(HaveLen(1))
+		summary := families[0].GetMetric()[0].GetSummary()
+		Expect(summary.GetSampleCount()).Should(Equal(uint64(1)))
+		Expect(summary.GetSampleSum()).Should(Equal(1.0))
+		Expect(summary.GetQuantile()).Should(HaveLen(2))
+
+		Expect(families[1].GetName()).Should(Equal("requests_total"))
+		Expect(families[1].GetType()).Should(Equal(dto.MetricType_COUNTER))
+		Expect(families[1].GetHelp()).Should(Equal("Requests"))
+		Expect(families[1].GetMetric()).Should(HaveLen(2))
+		Expect(families[1].GetMetric()[0].GetLabel()[0].GetValue()).Should(Equal("200"))
+		Expect(families[1].GetMetric()[0].GetCounter().GetValue()).Should(Equal(2.0))
+		Expect(families[1].GetMetric()[0].GetTimestampMs()).Should(Equal(now.UnixMilli()))
+
+		Expect(families[2].GetName()).Should(Equal("size_bytes"))
+		Expect(families[2].GetMetric()).Should(HaveLen(1))
+		histogram := families[2].GetMetric()[0].GetHistogram()
+		Expect(histogram.GetSampleCount()).Should(Equal(uint64(2)))
+		Expect(histogram.GetSampleSum()).Should(Equal(550.0))
+		Expect(histogram.GetBucket()).Should(HaveLen(2))
+		Expect(histogram.GetBucket()[0].GetUpperBound()).Should(Equal(10.0))
+		Expect(histogram.GetBucket()[0].GetCumulativeCount()).Should(Equal(uint64(0)))
+		Expect(histogram.GetBucket()[1].GetCumulativeCount()).Should(Equal(uint64(1)))
+	})
+
+	It("treats series without metadata as untyped, one metric per sample", func() {
+		families := convert.ToMetricFamilies([]prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "orphan"}, {Name: "job", Value: "x"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}, {Value: 2, Timestamp: 2000}},
+		}}, nil)
+
+		Expect(families).Should(HaveLen(1))
+		Expect(families[0].GetType()).Should(Equal(dto.MetricType_UNTYPED))
+		Expect(families[0].GetMetric()).Should(HaveLen(2))
+		Expect(families[0].GetMetric()[1].GetUntyped().GetValue()).Should(Equal(2.0))
+		Expect(families[0].GetMetric()[1].GetTimestampMs()).Should(Equal(int64(2000)))
+		Expect(families[0].GetMetric()[1].GetLabel()).Should(HaveLen(1))
+	})
+
+	It("maps metadata types onto metric types", func() {
+		Expect(convert.ToMetricType(prompb.MetricMetadata_GAUGEHISTOGRAM)).Should(Equal(dto.MetricType_GAUGE_HISTOGRAM))
+		Expect(convert.ToMetricType(prompb.MetricMetadata_INFO)).Should(Equal(dto.MetricType_GAUGE))
+		Expect(convert.ToMetricType(prompb.MetricMetadata_UNKNOWN)).Should(Equal(dto.MetricType_UNTYPED))
+	})
+})
@@ -0,0 +1,55 @@
+package convert
+
+import (
+	"context"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Snapshot is a set of metric families gathered at one point in time, such as one interval of a buffer that is
+// drained later
+type Snapshot struct {
+	Timestamp time.Time
+	Families  []*dto.MetricFamily
+}
+
+// FromSnapshots converts several snapshots into one set of series and metadata, so that each series carries one
+// sample per snapshot instead of being sent once per snapshot. Each snapshot is converted at its own Timestamp (the
+// time of the conversion if it is zero) with the other options, and series with the same labels are merged with
+// MergeSeries. Snapshots should be passed oldest first, both so that Tracker sees them in order and so that the
+// merged samples are in timestamp order. One metadata entry is returned per family name
+func FromSnapshots(snapshots []Snapshot, options MetricFamilyOptions) ([]prompb.TimeSeries, []prompb.MetricMetadata) {
+	series, metadata, _ := FromSnapshotsContext(context.Background(), snapshots, options)
+	return series, metadata
+}
+
+// FromSnapshotsContext is FromSnapshots, except that it checks ctx between families and stops with ctx's error once it
+// is done. Like FromMetricFamiliesContext, it also fails with ErrNameLabel under NameLabelError
+func FromSnapshotsContext(ctx context.Context, snapshots []Snapshot, options MetricFamilyOptions) ([]prompb.TimeSeries, []prompb.MetricMetadata, error) {
+	var series []prompb.TimeSeries
+	var metadata []prompb.MetricMetadata
+	seen := map[string]bool{}
+
+	for _, snapshot := range snapshots {
+		opts := options
+		opts.Timestamp = snapshot.Timestamp
+		opts.MergeDuplicateSeries = false
+
+		s, m, err := FromMetricFamiliesContext(ctx, snapshot.Families, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		series = append(series, s...)
+		for _, md := range m {
+			if !seen[md.MetricFamilyName] {
+				seen[md.MetricFamilyName] = true
+				metadata = append(metadata, md)
+			}
+		}
+	}
+
+	return MergeSeries(series), metadata, nil
+}
@@ -0,0 +1,42 @@
+package convert_test
+
+import (
+	"time"
+
+	"github.com/jghiloni/prometheus-remote-write/convert"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+	"google.golang.org/protobuf/proto"
+)
+
+var _ = Describe("FromSnapshots", func() {
+	gauge := func(v float64) []*dto.MetricFamily {
+		return []*dto.MetricFamily{{
+			Name:   proto.String("temperature"),
+			Help:   proto.String("the temperature"),
+			Type:   dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(v)}}},
+		}}
+	}
+
+	It("converts each snapshot at its own time into one series per label set", func() {
+		start := time.UnixMilli(60_000)
+		snapshots := []convert.Snapshot{
+			{Timestamp: start, Families: gauge(20)},
+			{Timestamp: start.Add(time.Minute), Families: gauge(21)},
+			{Timestamp: start.Add(2 * time.Minute), Families: gauge(22)},
+		}
+
+		series, metadata := convert.FromSnapshots(snapshots, convert.MetricFamilyOptions{})
+		Expect(series).Should(HaveLen(1))
+		Expect(series[0].Samples).Should(Equal([]prompb.Sample{
+			{Value: 20, Timestamp: 60_000},
+			{Value: 21, Timestamp: 120_000},
+			{Value: 22, Timestamp: 180_000},
+		}))
+		Expect(metadata).Should(HaveLen(1))
+		Expect(metadata[0].MetricFamilyName).Should(Equal("temperature"))
+	})
+})
@@ -0,0 +1,49 @@
+package convert
+
+import (
+	"slices"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// TargetInfoName is the name of the series that carries resource attributes
+const TargetInfoName = "target_info"
+
+// TargetInfo builds the target_info series (and its metadata) that the OpenTelemetry to Prometheus conventions use to
+// carry resource attributes, so they can be joined onto other series by job and instance instead of being stamped on
+// all of them. As with FromOTLP, service.name (prefixed by service.namespace) becomes the job label and
+// service.instance.id the instance label. Every other attribute becomes a label with a sanitized name. The series has
+// a single sample of 1 at timestamp
+func TargetInfo(attributes map[string]string, timestamp time.Time) (prompb.TimeSeries, prompb.MetricMetadata) {
+	// sorted, so that attributes whose names sanitize to the same label are always joined in the same order
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	attrs := make([]otlpAttribute, 0, len(keys))
+	var others []otlpAttribute
+	for _, key := range keys {
+		attr := otlpAttribute{key: key, value: attributes[key]}
+		attrs = append(attrs, attr)
+
+		switch key {
+		case "service.name", "service.namespace", "service.instance.id":
+		default:
+			others = append(others, attr)
+		}
+	}
+
+	series := prompb.TimeSeries{
+		Labels:  otlpLabels(TargetInfoName, resourceLabels(attrs), others),
+		Samples: []prompb.Sample{{Value: 1, Timestamp: timestamp.UnixMilli()}},
+	}
+
+	return series, prompb.MetricMetadata{
+		Type:             prompb.MetricMetadata_GAUGE,
+		MetricFamilyName: TargetInfoName,
+		Help:             "Target metadata",
+	}
+}
@@ -0,0 +1,37 @@
+package convert_test
+
+import (
+	"time"
+
+	"github.com/jghiloni/prometheus-remote-write/convert"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("TargetInfo", func() {
+	It("turns resource attributes into a target_info series", func() {
+		now := time.UnixMilli(1700000000000)
+		series, metadata := convert.TargetInfo(map[string]string{
+			"service.name":           "checkout",
+			"service.namespace":      "shop",
+			"service.instance.id":    "pod-1",
+			"service.version":        "1.2.3",
+			"deployment.environment": "prod",
+		}, now)
+
+		Expect(series.Labels).Should(Equal([]prompb.Label{
+			{Name: "__name__", Value: "target_info"},
+			{Name: "deployment_environment", Value: "prod"},
+			{Name: "instance", Value: "pod-1"},
+			{Name: "job", Value: "shop/checkout"},
+			{Name: "service_version", Value: "1.2.3"},
+		}))
+		Expect(series.Samples).Should(Equal([]prompb.Sample{{Value: 1, Timestamp: now.UnixMilli()}}))
+		Expect(metadata).Should(Equal(prompb.MetricMetadata{
+			Type:             prompb.MetricMetadata_GAUGE,
+			MetricFamilyName: "target_info",
+			Help:             "Target metadata",
+		}))
+	})
+})
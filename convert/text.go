@@ -0,0 +1,36 @@
+package convert
+
+import (
+	"io"
+	"sort"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// ParseText reads metrics in the Prometheus text exposition format (the output of a /metrics endpoint, or a
+// node_exporter textfile) and returns them as metric families sorted by name, with every metric's labels sorted, ready
+// to be passed to RemoteMetricsWriter.WriteMetricFamilies
+func ParseText(r io.Reader) ([]*dto.MetricFamily, error) {
+	var parser expfmt.TextParser
+	byName, err := parser.TextToMetricFamilies(r)
+	if err != nil {
+		return nil, err
+	}
+
+	families := make([]*dto.MetricFamily, 0, len(byName))
+	for _, family := range byName {
+		for _, metric := range family.GetMetric() {
+			sort.Slice(metric.Label, func(i, j int) bool {
+				return metric.Label[i].GetName() < metric.Label[j].GetName()
+			})
+		}
+		families = append(families, family)
+	}
+
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].GetName() < families[j].GetName()
+	})
+
+	return families, nil
+}
@@ -0,0 +1,39 @@
+package convert_test
+
+import (
+	"strings"
+
+	"github.com/jghiloni/prometheus-remote-write/convert"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var _ = Describe("ParseText", func() {
+	It("returns families sorted by name with sorted labels", func() {
+		families, err := convert.ParseText(strings.NewReader(`# HELP zeta_total Things counted
+# TYPE zeta_total counter
+zeta_total{b="2",a="1"} 3
+# TYPE alpha gauge
+alpha 1.5
+`))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(families).Should(HaveLen(2))
+
+		Expect(families[0].GetName()).Should(Equal("alpha"))
+		Expect(families[0].GetType()).Should(Equal(dto.MetricType_GAUGE))
+		Expect(families[0].GetMetric()[0].GetGauge().GetValue()).Should(Equal(1.5))
+
+		Expect(families[1].GetName()).Should(Equal("zeta_total"))
+		Expect(families[1].GetHelp()).Should(Equal("Things counted"))
+		labels := families[1].GetMetric()[0].GetLabel()
+		Expect(labels).Should(HaveLen(2))
+		Expect(labels[0].GetName()).Should(Equal("a"))
+		Expect(labels[1].GetName()).Should(Equal("b"))
+	})
+
+	It("returns parse errors", func() {
+		_, err := convert.ParseText(strings.NewReader("not a metric line {\n"))
+		Expect(err).Should(HaveOccurred())
+	})
+})
@@ -0,0 +1,144 @@
+package convert
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// SeriesTracker remembers the last observation of the counters and native histograms it has seen, so consecutive
+// conversions can tell which series are new and which have been reset since the last push, and which metrics it has
+// seen at all, so metadata can be limited to families with new series. It is safe for concurrent use. Series are
+// remembered for the lifetime of the tracker, unless it was created with NewExpiringSeriesTracker
+type SeriesTracker struct {
+	mu       sync.Mutex
+	previous map[string]observation
+	// seen holds the timestamp every metric was last seen at, by family name and labels
+	seen map[string]int64
+
+	// ttl is how long, in milliseconds of sample time, a series is remembered after it was last seen. 0 is forever
+	ttl       int64
+	lastSweep int64
+}
+
+type observation struct {
+	value     float64
+	created   int64
+	timestamp int64
+}
+
+// NewSeriesTracker returns an empty SeriesTracker
+func NewSeriesTracker() *SeriesTracker {
+	return &SeriesTracker{previous: map[string]observation{}, seen: map[string]int64{}}
+}
+
+// NewExpiringSeriesTracker returns an empty SeriesTracker that forgets series that have not been seen for ttl, judged
+// by the timestamps of their samples. A series seen again after that is new again: it gets its created timestamp zero
+// sample and its family's metadata once more. This bounds the tracker's memory when series churn, and resends what a
+// failed push may have lost. It is NewSeriesTracker if ttl is not positive
+func NewExpiringSeriesTracker(ttl time.Duration) *SeriesTracker {
+	t := NewSeriesTracker()
+	t.ttl = max(ttl.Milliseconds(), 0)
+	return t
+}
+
+// observe records the value (or count), created timestamp (0 if unknown) and sample timestamp of the series with
+// labels, and returns the previous observation of it, if there was one that has not expired
+func (t *SeriesTracker) observe(labels []prompb.Label, value float64, created, ts int64) (observation, bool) {
+	key := seriesKey(labels)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sweep(ts)
+
+	prev, seen := t.previous[key]
+	t.previous[key] = observation{value: value, created: created, timestamp: ts}
+
+	return prev, seen && !t.expired(prev.timestamp, ts)
+}
+
+// hasNewMetrics records that the metrics of family were seen at ts (or their own timestamps), and reports whether
+// any of them had not been seen before, or not since they expired
+func (t *SeriesTracker) hasNewMetrics(family *dto.MetricFamily, ts int64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sweep(ts)
+
+	found := false
+	for _, m := range family.GetMetric() {
+		at := ts
+		if m.TimestampMs != nil {
+			at = m.GetTimestampMs()
+		}
+
+		key := seriesKey(Labels(family.GetName(), m.GetLabel()))
+		last, seen := t.seen[key]
+		if !seen || t.expired(last, at) {
+			found = true
+		}
+		t.seen[key] = at
+	}
+
+	return found
+}
+
+// expired reports whether a series last seen at last has expired by now
+func (t *SeriesTracker) expired(last, now int64) bool {
+	return t.ttl > 0 && now-last > t.ttl
+}
+
+// sweep forgets the series that have expired by now, at most once per ttl so it does not slow down every
+// conversion. t.mu must be held
+func (t *SeriesTracker) sweep(now int64) {
+	if t.ttl <= 0 || now-t.lastSweep <= t.ttl {
+		return
+	}
+	t.lastSweep = now
+
+	for key, o := range t.previous {
+		if t.expired(o.timestamp, now) {
+			delete(t.previous, key)
+		}
+	}
+	for key, last := range t.seen {
+		if t.expired(last, now) {
+			delete(t.seen, key)
+		}
+	}
+}
+
+// hint returns the reset hint for a native histogram: YES if its count went down or its created timestamp changed
+// since the last observation, NO if it has been seen before otherwise, and UNKNOWN the first time it is seen
+func (t *SeriesTracker) hint(labels []prompb.Label, count float64, created, ts int64) prompb.Histogram_ResetHint {
+	prev, seen := t.observe(labels, count, created, ts)
+
+	switch {
+	case !seen:
+		return prompb.Histogram_UNKNOWN
+	case prev.isReset(count, created):
+		return prompb.Histogram_YES
+	default:
+		return prompb.Histogram_NO
+	}
+}
+
+// isReset reports whether a counter or histogram observed as o before has been reset, given its current value (or
+// count) and created timestamp
+func (o observation) isReset(value float64, created int64) bool {
+	return value < o.value || (created != 0 && o.created != 0 && created != o.created)
+}
+
+func seriesKey(labels []prompb.Label) string {
+	var sb strings.Builder
+	for _, l := range labels {
+		sb.WriteString(l.Name)
+		sb.WriteByte(0xff)
+		sb.WriteString(l.Value)
+		sb.WriteByte(0xff)
+	}
+
+	return sb.String()
+}
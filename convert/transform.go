@@ -0,0 +1,51 @@
+package convert
+
+import (
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// ValueTransform changes a sample value during conversion, to scale or clamp it, say
+type ValueTransform func(float64) float64
+
+// Scale returns a ValueTransform that multiplies values by factor, to convert milliseconds to seconds, for example
+func Scale(factor float64) ValueTransform {
+	return func(v float64) float64 { return v * factor }
+}
+
+// Clamp returns a ValueTransform that keeps values between lo and hi
+func Clamp(lo, hi float64) ValueTransform {
+	return func(v float64) float64 { return min(max(v, lo), hi) }
+}
+
+// transformValues returns a copy of metric with transform applied to its values: the value of a counter, gauge or
+// untyped metric, and the quantiles and sum of a summary or the sum of a histogram. Counts and buckets are left as
+// they are, since they count observations rather than measure them
+func transformValues(metric *dto.Metric, transform ValueTransform) *dto.Metric {
+	metric = proto.Clone(metric).(*dto.Metric)
+
+	if c := metric.GetCounter(); c != nil {
+		c.Value = proto.Float64(transform(c.GetValue()))
+	}
+
+	if g := metric.GetGauge(); g != nil {
+		g.Value = proto.Float64(transform(g.GetValue()))
+	}
+
+	if u := metric.GetUntyped(); u != nil {
+		u.Value = proto.Float64(transform(u.GetValue()))
+	}
+
+	if s := metric.GetSummary(); s != nil {
+		s.SampleSum = proto.Float64(transform(s.GetSampleSum()))
+		for _, q := range s.GetQuantile() {
+			q.Value = proto.Float64(transform(q.GetValue()))
+		}
+	}
+
+	if h := metric.GetHistogram(); h != nil {
+		h.SampleSum = proto.Float64(transform(h.GetSampleSum()))
+	}
+
+	return metric
+}
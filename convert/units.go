@@ -0,0 +1,95 @@
+package convert
+
+import (
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// unitConversion converts a non-base unit to the base unit Prometheus naming conventions call for
+type unitConversion struct {
+	base   string
+	factor float64
+}
+
+// unitConversions maps the unit suffixes NormalizeUnits recognizes to their base unit
+var unitConversions = map[string]unitConversion{
+	"nanoseconds":  {"seconds", 1e-9},
+	"ns":           {"seconds", 1e-9},
+	"microseconds": {"seconds", 1e-6},
+	"milliseconds": {"seconds", 1e-3},
+	"ms":           {"seconds", 1e-3},
+	"minutes":      {"seconds", 60},
+	"hours":        {"seconds", 3600},
+	"days":         {"seconds", 86400},
+	"kilobytes":    {"bytes", 1e3},
+	"kb":           {"bytes", 1e3},
+	"megabytes":    {"bytes", 1e6},
+	"mb":           {"bytes", 1e6},
+	"gigabytes":    {"bytes", 1e9},
+	"gb":           {"bytes", 1e9},
+	"kibibytes":    {"bytes", 1 << 10},
+	"kib":          {"bytes", 1 << 10},
+	"mebibytes":    {"bytes", 1 << 20},
+	"mib":          {"bytes", 1 << 20},
+	"gibibytes":    {"bytes", 1 << 30},
+	"gib":          {"bytes", 1 << 30},
+	"percent":      {"ratio", 0.01},
+}
+
+// NormalizeUnits returns family converted to base units if its name ends in a unit NormalizeUnits recognizes (before
+// any _total suffix), and family itself otherwise. Durations become seconds, sizes bytes and percentages ratios, as
+// Prometheus naming conventions ask: the unit in the name (and Unit, if set) is replaced, and the values are scaled,
+// including the quantiles and sums of summaries and the bucket bounds and sums of classic histograms. Families with
+// native histograms cannot have their buckets rescaled, so they are left as they are
+func NormalizeUnits(family *dto.MetricFamily) *dto.MetricFamily {
+	name, conversion, ok := baseUnitName(family.GetName())
+	if !ok {
+		return family
+	}
+
+	for _, m := range family.GetMetric() {
+		if h := m.GetHistogram(); h != nil && (h.GetSchema() != 0 || h.GetZeroThreshold() != 0 || len(h.GetPositiveSpan()) > 0 ||
+			len(h.GetNegativeSpan()) > 0) {
+			return family
+		}
+	}
+
+	family = proto.Clone(family).(*dto.MetricFamily)
+	family.Name = proto.String(name)
+	if family.Unit != nil {
+		family.Unit = proto.String(conversion.base)
+	}
+
+	scale := Scale(conversion.factor)
+	for i, m := range family.GetMetric() {
+		m = transformValues(m, scale)
+		for _, b := range m.GetHistogram().GetBucket() {
+			b.UpperBound = proto.Float64(scale(b.GetUpperBound()))
+		}
+		family.Metric[i] = m
+	}
+
+	return family
+}
+
+// baseUnitName returns name with its unit replaced by the base unit, and the conversion that takes it there
+func baseUnitName(name string) (string, unitConversion, bool) {
+	stem, suffix := name, ""
+	if trimmed, ok := strings.CutSuffix(name, "_total"); ok {
+		stem, suffix = trimmed, "_total"
+	}
+
+	i := strings.LastIndexByte(stem, '_')
+	if i < 0 {
+		return name, unitConversion{}, false
+	}
+
+	conversion, ok := unitConversions[strings.ToLower(stem[i+1:])]
+	if !ok {
+		return name, unitConversion{}, false
+	}
+
+	return stem[:i+1] + conversion.base + suffix, conversion, true
+}
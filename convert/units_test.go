@@ -0,0 +1,100 @@
+package convert_test
+
+import (
+	"time"
+
+	"github.com/jghiloni/prometheus-remote-write/convert"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+	"google.golang.org/protobuf/proto"
+)
+
+var _ = Describe("NormalizeUnits", func() {
+	now := time.UnixMilli(1700000000000)
+
+	It("converts durations to seconds, including histogram buckets", func() {
+		family := &dto.MetricFamily{
+			Name: proto.String("request_latency_ms"),
+			Type: dto.MetricType_HISTOGRAM.Enum(),
+			Metric: []*dto.Metric{{Histogram: &dto.Histogram{
+				SampleCount: proto.Uint64(3),
+				SampleSum:   proto.Float64(700),
+				Bucket: []*dto.Bucket{
+					{UpperBound: proto.Float64(100), CumulativeCount: proto.Uint64(1)},
+					{UpperBound: proto.Float64(500), CumulativeCount: proto.Uint64(3)},
+				},
+			}}},
+		}
+
+		series, metadata := convert.FromMetricFamilies([]*dto.MetricFamily{family}, convert.MetricFamilyOptions{
+			Timestamp:      now,
+			NormalizeUnits: true,
+		})
+
+		Expect(metadata).Should(HaveLen(1))
+		Expect(metadata[0].MetricFamilyName).Should(Equal("request_latency_seconds"))
+
+		var les []string
+		var sum float64
+		for _, ts := range series {
+			for _, l := range ts.Labels {
+				if l.Name == "le" {
+					les = append(les, l.Value)
+				}
+				if l.Name == "__name__" && l.Value == "request_latency_seconds_sum" {
+					sum = ts.Samples[0].Value
+				}
+			}
+		}
+		Expect(les).Should(ConsistOf("0.1", "0.5", "+Inf"))
+		Expect(sum).Should(BeNumerically("~", 0.7, 1e-9))
+		Expect(family.GetName()).Should(Equal("request_latency_ms"))
+	})
+
+	It("keeps the _total suffix of counters and updates the unit", func() {
+		family := convert.NormalizeUnits(&dto.MetricFamily{
+			Name:   proto.String("transferred_kilobytes_total"),
+			Unit:   proto.String("kilobytes"),
+			Type:   dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{{Counter: &dto.Counter{Value: proto.Float64(2.5)}}},
+		})
+
+		Expect(family.GetName()).Should(Equal("transferred_bytes_total"))
+		Expect(family.GetUnit()).Should(Equal("bytes"))
+		Expect(family.GetMetric()[0].GetCounter().GetValue()).Should(Equal(2500.0))
+	})
+
+	It("applies value transforms by the normalized name", func() {
+		series, _ := convert.FromMetricFamilies([]*dto.MetricFamily{{
+			Name:   proto.String("disk_used_percent"),
+			Type:   dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(120)}}},
+		}}, convert.MetricFamilyOptions{
+			Timestamp:       now,
+			NormalizeUnits:  true,
+			ValueTransforms: map[string]convert.ValueTransform{"disk_used_ratio": convert.Clamp(0, 1)},
+		})
+
+		Expect(series).Should(HaveLen(1))
+		Expect(series[0].Labels).Should(ContainElement(prompb.Label{Name: "__name__", Value: "disk_used_ratio"}))
+		Expect(series[0].Samples[0].Value).Should(Equal(1.0))
+	})
+
+	It("leaves families in base units or with native histograms alone", func() {
+		seconds := &dto.MetricFamily{Name: proto.String("uptime_seconds"), Type: dto.MetricType_GAUGE.Enum()}
+		Expect(convert.NormalizeUnits(seconds)).Should(BeIdenticalTo(seconds))
+
+		native := &dto.MetricFamily{
+			Name: proto.String("latency_milliseconds"),
+			Type: dto.MetricType_HISTOGRAM.Enum(),
+			Metric: []*dto.Metric{{Histogram: &dto.Histogram{
+				SampleCount:  proto.Uint64(1),
+				Schema:       proto.Int32(3),
+				PositiveSpan: []*dto.BucketSpan{{Offset: proto.Int32(0), Length: proto.Uint32(1)}},
+			}}},
+		}
+		Expect(convert.NormalizeUnits(native)).Should(BeIdenticalTo(native))
+	})
+})
@@ -0,0 +1,33 @@
+package convert
+
+import (
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// UntypedAsGauge returns an untyped family as a gauge family, and any other family as it is. The values of its metrics
+// move from Untyped to Gauge, so its metadata reports a GAUGE rather than an UNKNOWN type, which several backends
+// handle poorly in query-time functions. If suffix is not empty, it is appended to the family's name, unless the name
+// already ends with it, so the coerced series can be told apart
+func UntypedAsGauge(family *dto.MetricFamily, suffix string) *dto.MetricFamily {
+	if family.GetType() != dto.MetricType_UNTYPED {
+		return family
+	}
+
+	family = proto.Clone(family).(*dto.MetricFamily)
+	family.Type = dto.MetricType_GAUGE.Enum()
+	if suffix != "" && !strings.HasSuffix(family.GetName(), suffix) {
+		family.Name = proto.String(family.GetName() + suffix)
+	}
+
+	for _, m := range family.GetMetric() {
+		if u := m.GetUntyped(); u != nil {
+			m.Gauge = &dto.Gauge{Value: proto.Float64(u.GetValue())}
+			m.Untyped = nil
+		}
+	}
+
+	return family
+}
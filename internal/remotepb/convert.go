@@ -0,0 +1,190 @@
+package remotepb
+
+import (
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// FromPrompb converts wr to the generated WriteRequest
+func FromPrompb(wr prompb.WriteRequest) *WriteRequest {
+	out := &WriteRequest{
+		Timeseries: make([]*TimeSeries, len(wr.Timeseries)),
+		Metadata:   make([]*MetricMetadata, len(wr.Metadata)),
+	}
+
+	for i, ts := range wr.Timeseries {
+		series := &TimeSeries{
+			Labels:     fromLabels(ts.Labels),
+			Samples:    make([]*Sample, len(ts.Samples)),
+			Exemplars:  make([]*Exemplar, len(ts.Exemplars)),
+			Histograms: make([]*Histogram, len(ts.Histograms)),
+		}
+		for j, s := range ts.Samples {
+			series.Samples[j] = &Sample{Value: s.Value, Timestamp: s.Timestamp}
+		}
+		for j, e := range ts.Exemplars {
+			series.Exemplars[j] = &Exemplar{Labels: fromLabels(e.Labels), Value: e.Value, Timestamp: e.Timestamp}
+		}
+		for j, h := range ts.Histograms {
+			series.Histograms[j] = fromHistogram(h)
+		}
+		out.Timeseries[i] = series
+	}
+
+	for i, md := range wr.Metadata {
+		out.Metadata[i] = &MetricMetadata{
+			Type:             MetricMetadata_MetricType(md.Type),
+			MetricFamilyName: md.MetricFamilyName,
+			Help:             md.Help,
+			Unit:             md.Unit,
+		}
+	}
+
+	return out
+}
+
+// ToPrompb converts m back to a prompb.WriteRequest
+func ToPrompb(m *WriteRequest) prompb.WriteRequest {
+	var wr prompb.WriteRequest
+	if len(m.GetTimeseries()) > 0 {
+		wr.Timeseries = make([]prompb.TimeSeries, len(m.GetTimeseries()))
+	}
+
+	for i, ts := range m.GetTimeseries() {
+		series := prompb.TimeSeries{Labels: toLabels(ts.GetLabels())}
+		for _, s := range ts.GetSamples() {
+			series.Samples = append(series.Samples, prompb.Sample{Value: s.GetValue(), Timestamp: s.GetTimestamp()})
+		}
+		for _, e := range ts.GetExemplars() {
+			series.Exemplars = append(series.Exemplars, prompb.Exemplar{
+				Labels:    toLabels(e.GetLabels()),
+				Value:     e.GetValue(),
+				Timestamp: e.GetTimestamp(),
+			})
+		}
+		for _, h := range ts.GetHistograms() {
+			series.Histograms = append(series.Histograms, toHistogram(h))
+		}
+		wr.Timeseries[i] = series
+	}
+
+	for _, md := range m.GetMetadata() {
+		wr.Metadata = append(wr.Metadata, prompb.MetricMetadata{
+			Type:             prompb.MetricMetadata_MetricType(md.GetType()),
+			MetricFamilyName: md.GetMetricFamilyName(),
+			Help:             md.GetHelp(),
+			Unit:             md.GetUnit(),
+		})
+	}
+
+	return wr
+}
+
+func fromLabels(labels []prompb.Label) []*Label {
+	converted := make([]*Label, len(labels))
+	for i, l := range labels {
+		converted[i] = &Label{Name: l.Name, Value: l.Value}
+	}
+
+	return converted
+}
+
+func toLabels(labels []*Label) []prompb.Label {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	converted := make([]prompb.Label, len(labels))
+	for i, l := range labels {
+		converted[i] = prompb.Label{Name: l.GetName(), Value: l.GetValue()}
+	}
+
+	return converted
+}
+
+func fromSpans(spans []prompb.BucketSpan) []*BucketSpan {
+	converted := make([]*BucketSpan, len(spans))
+	for i, s := range spans {
+		converted[i] = &BucketSpan{Offset: s.Offset, Length: s.Length}
+	}
+
+	return converted
+}
+
+func toSpans(spans []*BucketSpan) []prompb.BucketSpan {
+	if len(spans) == 0 {
+		return nil
+	}
+
+	converted := make([]prompb.BucketSpan, len(spans))
+	for i, s := range spans {
+		converted[i] = prompb.BucketSpan{Offset: s.GetOffset(), Length: s.GetLength()}
+	}
+
+	return converted
+}
+
+func fromHistogram(h prompb.Histogram) *Histogram {
+	converted := &Histogram{
+		Sum:            h.Sum,
+		Schema:         h.Schema,
+		ZeroThreshold:  h.ZeroThreshold,
+		NegativeSpans:  fromSpans(h.NegativeSpans),
+		NegativeDeltas: h.NegativeDeltas,
+		NegativeCounts: h.NegativeCounts,
+		PositiveSpans:  fromSpans(h.PositiveSpans),
+		PositiveDeltas: h.PositiveDeltas,
+		PositiveCounts: h.PositiveCounts,
+		ResetHint:      Histogram_ResetHint(h.ResetHint),
+		Timestamp:      h.Timestamp,
+		CustomValues:   h.CustomValues,
+	}
+
+	switch c := h.GetCount().(type) {
+	case *prompb.Histogram_CountInt:
+		converted.Count = &Histogram_CountInt{CountInt: c.CountInt}
+	case *prompb.Histogram_CountFloat:
+		converted.Count = &Histogram_CountFloat{CountFloat: c.CountFloat}
+	}
+
+	switch z := h.GetZeroCount().(type) {
+	case *prompb.Histogram_ZeroCountInt:
+		converted.ZeroCount = &Histogram_ZeroCountInt{ZeroCountInt: z.ZeroCountInt}
+	case *prompb.Histogram_ZeroCountFloat:
+		converted.ZeroCount = &Histogram_ZeroCountFloat{ZeroCountFloat: z.ZeroCountFloat}
+	}
+
+	return converted
+}
+
+func toHistogram(h *Histogram) prompb.Histogram {
+	converted := prompb.Histogram{
+		Sum:            h.GetSum(),
+		Schema:         h.GetSchema(),
+		ZeroThreshold:  h.GetZeroThreshold(),
+		NegativeSpans:  toSpans(h.GetNegativeSpans()),
+		NegativeDeltas: h.GetNegativeDeltas(),
+		NegativeCounts: h.GetNegativeCounts(),
+		PositiveSpans:  toSpans(h.GetPositiveSpans()),
+		PositiveDeltas: h.GetPositiveDeltas(),
+		PositiveCounts: h.GetPositiveCounts(),
+		ResetHint:      prompb.Histogram_ResetHint(h.GetResetHint()),
+		Timestamp:      h.GetTimestamp(),
+		CustomValues:   h.GetCustomValues(),
+	}
+
+	switch c := h.GetCount().(type) {
+	case *Histogram_CountInt:
+		converted.Count = &prompb.Histogram_CountInt{CountInt: c.CountInt}
+	case *Histogram_CountFloat:
+		converted.Count = &prompb.Histogram_CountFloat{CountFloat: c.CountFloat}
+	}
+
+	switch z := h.GetZeroCount().(type) {
+	case *Histogram_ZeroCountInt:
+		converted.ZeroCount = &prompb.Histogram_ZeroCountInt{ZeroCountInt: z.ZeroCountInt}
+	case *Histogram_ZeroCountFloat:
+		converted.ZeroCount = &prompb.Histogram_ZeroCountFloat{ZeroCountFloat: z.ZeroCountFloat}
+	}
+
+	return converted
+}
@@ -0,0 +1,6 @@
+// Package remotepb holds the remote write 1.0 messages generated for the official Go protobuf runtime
+// (google.golang.org/protobuf), and conversions between them and the gogo generated messages of prompb. The writer
+// uses them for the GoProtobuf and JSONProto formats, and the receiver to decode JSONProto payloads.
+package remotepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative remote.proto
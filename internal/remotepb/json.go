@@ -0,0 +1,37 @@
+package remotepb
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/prometheus/prometheus/prompb"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// MarshalJSON encodes wr with the canonical protobuf JSON mapping (https://protobuf.dev/programming-guides/json/).
+// protojson writes fields in the order remote.proto declares them but deliberately varies its whitespace, so the
+// output is compacted to keep it byte for byte stable
+func MarshalJSON(wr prompb.WriteRequest) ([]byte, error) {
+	data, err := protojson.Marshal(FromPrompb(wr))
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	if err = json.Compact(buf, data); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON is the inverse of MarshalJSON. Field names may be either the lowerCamelCase JSON names or the
+// original proto field names, as the mapping allows
+func UnmarshalJSON(data []byte) (prompb.WriteRequest, error) {
+	var m WriteRequest
+	if err := protojson.Unmarshal(data, &m); err != nil {
+		return prompb.WriteRequest{}, err
+	}
+
+	return ToPrompb(&m), nil
+}
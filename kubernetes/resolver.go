@@ -0,0 +1,201 @@
+// Package kubernetes finds remote write targets from Kubernetes EndpointSlices, for agents that run in a cluster and
+// write directly to the receiver pods behind a Service.
+package kubernetes
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+)
+
+// The defaults below are where Kubernetes puts the API server and service account credentials inside every pod
+const (
+	DefaultAPIServer     = "https://kubernetes.default.svc"
+	DefaultTokenFile     = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	DefaultCAFile        = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	DefaultNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// ErrNoService is returned by NewEndpointSliceResolver when no Service name is given
+var ErrNoService = errors.New("a service name is required")
+
+// EndpointSliceResolverOptions are the settings of an EndpointSliceResolver.
+//
+//	Service is the name of the Service whose endpoints are the targets, and must be set
+//	If Namespace is not set, it defaults to the namespace of the pod the process runs in
+//	If PortName is not set, the first port of each EndpointSlice is used
+//	If Scheme is not set, it defaults to http
+//	Path is added to every target URL, e.g. /api/v1/push
+//	If APIServer is not set, it defaults to DefaultAPIServer
+//	If TokenFile is not set, it defaults to DefaultTokenFile. It is read on every request, so rotated tokens are used
+//	If HTTPClient is not set, a client that trusts CAFile (default DefaultCAFile) is used
+type EndpointSliceResolverOptions struct {
+	Service    string
+	Namespace  string
+	PortName   string
+	Scheme     string
+	Path       string
+	APIServer  string
+	TokenFile  string
+	CAFile     string
+	HTTPClient *http.Client
+}
+
+// EndpointSliceResolver is a writer.TargetResolver that lists the ready endpoints of a Service from its
+// EndpointSlices. The writer asks it for targets every ResolveInterval, so changes to the Service's pods are picked
+// up within that interval
+type EndpointSliceResolver struct {
+	options EndpointSliceResolverOptions
+}
+
+// NewEndpointSliceResolver fills in the defaults of options and returns a resolver using them
+func NewEndpointSliceResolver(options EndpointSliceResolverOptions) (*EndpointSliceResolver, error) {
+	if strings.TrimSpace(options.Service) == "" {
+		return nil, ErrNoService
+	}
+
+	if options.Namespace == "" {
+		namespace, err := os.ReadFile(DefaultNamespaceFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading the pod's namespace: %w", err)
+		}
+		options.Namespace = strings.TrimSpace(string(namespace))
+	}
+
+	if options.Scheme == "" {
+		options.Scheme = "http"
+	}
+
+	if options.APIServer == "" {
+		options.APIServer = DefaultAPIServer
+	}
+
+	if options.TokenFile == "" {
+		options.TokenFile = DefaultTokenFile
+	}
+
+	if options.CAFile == "" {
+		options.CAFile = DefaultCAFile
+	}
+
+	if options.HTTPClient == nil {
+		ca, err := os.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading the cluster CA: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", options.CAFile)
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		options.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	return &EndpointSliceResolver{options: options}, nil
+}
+
+// endpointSliceList holds the parts of a discovery.k8s.io/v1 EndpointSliceList the resolver uses
+type endpointSliceList struct {
+	Items []struct {
+		Endpoints []struct {
+			Addresses  []string `json:"addresses"`
+			Conditions struct {
+				Ready *bool `json:"ready"`
+			} `json:"conditions"`
+		} `json:"endpoints"`
+		Ports []struct {
+			Name *string `json:"name"`
+			Port *int32  `json:"port"`
+		} `json:"ports"`
+	} `json:"items"`
+}
+
+// Resolve lists the Service's EndpointSlices and returns a target for every ready endpoint address, sorted by URL
+func (r *EndpointSliceResolver) Resolve(ctx context.Context) ([]writer.Target, error) {
+	u := fmt.Sprintf("%s/apis/discovery.k8s.io/v1/namespaces/%s/endpointslices?labelSelector=%s",
+		strings.TrimSuffix(r.options.APIServer, "/"), url.PathEscape(r.options.Namespace),
+		url.QueryEscape("kubernetes.io/service-name="+r.options.Service))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	token, err := os.ReadFile(r.options.TokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading the service account token: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+
+	resp, err := r.options.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("listing endpoint slices: %s", resp.Status)
+	}
+
+	var list endpointSliceList
+	if err = json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var targets []writer.Target
+	for _, slice := range list.Items {
+		port := int32(-1)
+		for _, p := range slice.Ports {
+			if p.Port != nil && (r.options.PortName == "" || (p.Name != nil && *p.Name == r.options.PortName)) {
+				port = *p.Port
+				break
+			}
+		}
+		if port < 0 {
+			continue
+		}
+
+		for _, e := range slice.Endpoints {
+			// endpoints without a ready condition are ready, as the EndpointSlice API specifies
+			if e.Conditions.Ready != nil && !*e.Conditions.Ready {
+				continue
+			}
+
+			for _, address := range e.Addresses {
+				target := (&url.URL{
+					Scheme: r.options.Scheme,
+					Host:   net.JoinHostPort(address, strconv.Itoa(int(port))),
+					Path:   r.options.Path,
+				}).String()
+
+				if !seen[target] {
+					seen[target] = true
+					targets = append(targets, writer.Target{URL: target})
+				}
+			}
+		}
+	}
+
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].URL < targets[j].URL
+	})
+
+	return targets, nil
+}
@@ -0,0 +1,147 @@
+package kubernetes_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/jghiloni/prometheus-remote-write/kubernetes"
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const endpointSlices = `{
+  "kind": "EndpointSliceList",
+  "items": [
+    {
+      "endpoints": [
+        {"addresses": ["10.0.0.2"], "conditions": {"ready": true}},
+        {"addresses": ["10.0.0.1"]},
+        {"addresses": ["10.0.0.3"], "conditions": {"ready": false}}
+      ],
+      "ports": [{"name": "grpc", "port": 9095}, {"name": "http", "port": 8080}]
+    },
+    {
+      "endpoints": [{"addresses": ["10.0.1.1"], "conditions": {"ready": true}}],
+      "ports": [{"name": "http", "port": 8080}]
+    }
+  ]
+}`
+
+var _ = Describe("EndpointSliceResolver", func() {
+	var (
+		api       *httptest.Server
+		tokenFile string
+	)
+
+	BeforeEach(func() {
+		tokenFile = filepath.Join(GinkgoT().TempDir(), "token")
+		Expect(os.WriteFile(tokenFile, []byte("sa-token\n"), 0o600)).Should(Succeed())
+
+		api = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer sa-token" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Path != "/apis/discovery.k8s.io/v1/namespaces/monitoring/endpointslices" ||
+				r.URL.Query().Get("labelSelector") != "kubernetes.io/service-name=mimir-distributor" {
+				http.NotFound(w, r)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(endpointSlices))
+		}))
+		DeferCleanup(api.Close)
+	})
+
+	newResolver := func(portName string) *kubernetes.EndpointSliceResolver {
+		r, err := kubernetes.NewEndpointSliceResolver(kubernetes.EndpointSliceResolverOptions{
+			Service:    "mimir-distributor",
+			Namespace:  "monitoring",
+			PortName:   portName,
+			Path:       "/api/v1/push",
+			APIServer:  api.URL,
+			TokenFile:  tokenFile,
+			HTTPClient: api.Client(),
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		return r
+	}
+
+	It("Returns the ready endpoints on the named port", func() {
+		targets, err := newResolver("http").Resolve(context.Background())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(targets).Should(Equal([]writer.Target{
+			{URL: "http://10.0.0.1:8080/api/v1/push"},
+			{URL: "http://10.0.0.2:8080/api/v1/push"},
+			{URL: "http://10.0.1.1:8080/api/v1/push"},
+		}))
+	})
+
+	It("Uses the first port when none is named", func() {
+		targets, err := newResolver("").Resolve(context.Background())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(targets[0].URL).Should(Equal("http://10.0.0.1:9095/api/v1/push"))
+	})
+
+	It("Requires a service", func() {
+		_, err := kubernetes.NewEndpointSliceResolver(kubernetes.EndpointSliceResolverOptions{})
+		Expect(err).Should(MatchError(kubernetes.ErrNoService))
+	})
+
+	It("Keeps a writer's targets up to date", func() {
+		var (
+			mu     sync.Mutex
+			pushed []string
+		)
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			pushed = append(pushed, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer receiver.Close()
+
+		// point every resolved target at the fake receiver, keeping the endpoint address in the path
+		resolver := resolverFunc(func(ctx context.Context) ([]writer.Target, error) {
+			targets, err := newResolver("http").Resolve(ctx)
+			for i, t := range targets {
+				targets[i].URL = receiver.URL + "/" + strings.TrimPrefix(t.URL, "http://")
+			}
+			return targets, err
+		})
+
+		w, err := writer.NewRemoteMetricsWriter(receiver.URL+"/service", writer.RemoteMetricsWriterOptions{
+			TargetResolver: resolver,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+		}}, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(pushed).Should(ConsistOf(
+			"/10.0.0.1:8080/api/v1/push",
+			"/10.0.0.2:8080/api/v1/push",
+			"/10.0.1.1:8080/api/v1/push",
+		))
+		inspector, ok := writer.AsInspector(w)
+		Expect(ok).Should(BeTrue())
+		Expect(inspector.TargetStats()).Should(HaveLen(3))
+	})
+})
+
+type resolverFunc func(context.Context) ([]writer.Target, error)
+
+func (f resolverFunc) Resolve(ctx context.Context) ([]writer.Target, error) {
+	return f(ctx)
+}
@@ -0,0 +1,39 @@
+package receiver
+
+import (
+	"errors"
+)
+
+const (
+	// ProtoMessageV1 is the proto parameter of the Content-Type header identifying a remote write 1.0 payload
+	ProtoMessageV1 = "prometheus.WriteRequest"
+	// ProtoMessageV2 is the proto parameter of the Content-Type header identifying a remote write 2.0 payload
+	ProtoMessageV2 = "io.prometheus.write.v2.Request"
+
+	// SamplesWrittenHeader reports how many samples of the request were written
+	SamplesWrittenHeader = "X-Prometheus-Remote-Write-Samples-Written"
+	// HistogramsWrittenHeader reports how many histograms of the request were written
+	HistogramsWrittenHeader = "X-Prometheus-Remote-Write-Histograms-Written"
+	// ExemplarsWrittenHeader reports how many exemplars of the request were written
+	ExemplarsWrittenHeader = "X-Prometheus-Remote-Write-Exemplars-Written"
+
+	// DefaultMaxBodySize is the limit on the size of request bodies, both as sent and once decompressed, when
+	// HandlerOptions.MaxBodySize is zero
+	DefaultMaxBodySize = 32 << 20
+)
+
+var (
+	ErrNilWriteFunc           = errors.New("nil write function passed")
+	ErrUnsupportedEncoding    = errors.New("unsupported content encoding")
+	ErrUnsupportedContentType = errors.New("unsupported content type")
+	ErrInvalidSymbolRef       = errors.New("symbol reference out of range")
+	ErrEmptyLabelSet          = errors.New("series has no labels")
+	ErrMissingMetricName      = errors.New("series has no __name__ label")
+	ErrInvalidMetricName      = errors.New("invalid metric name")
+	ErrInvalidLabelName       = errors.New("invalid label name")
+	ErrUnsortedLabels         = errors.New("labels are not sorted by name")
+	ErrDuplicateLabelName     = errors.New("duplicate label name")
+	ErrDuplicateSeries        = errors.New("duplicate series")
+	ErrOutOfOrderTimestamps   = errors.New("timestamps are not in increasing order")
+	ErrBodyTooLarge           = errors.New("request body too large")
+)
@@ -0,0 +1,219 @@
+package receiver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/jghiloni/prometheus-remote-write/internal/remotepb"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// WriteFunc is called with every successfully decoded (and, if enabled, validated) WriteRequest. If it returns an
+// error, the client receives a 500 Internal Server Error so that it may retry the request
+type WriteFunc func(context.Context, *prompb.WriteRequest) error
+
+// HandlerOptions are the optional settings for a remote write Handler.
+//
+//	If Validate is true, every request is checked with Validate before WriteFunc is called, and invalid requests are
+//	rejected with 400 Bad Request so that compliant clients do not retry them
+//	MaxBodySize limits the size in bytes of a request body, both as sent and once decompressed. Larger requests are
+//	rejected with 413 Request Entity Too Large before they are decoded. If it is zero, DefaultMaxBodySize is used; if
+//	it is negative, bodies are not limited
+type HandlerOptions struct {
+	Validate    bool
+	MaxBodySize int64
+}
+
+type handlerImpl struct {
+	write       WriteFunc
+	validate    bool
+	maxBodySize int64
+}
+
+// NewHandler returns an http.Handler that decodes remote write requests and passes them to write. Both remote write
+// 1.0 (prometheus.WriteRequest) and 2.0 (io.prometheus.write.v2.Request) payloads are accepted, selected by the proto
+// parameter of the Content-Type header; 2.0 payloads are converted to a prompb.WriteRequest before write is called.
+// application/json bodies may use either the canonical protobuf JSON mapping or encoding/json's form of prompb.
+// Requests are answered with the status codes the remote write specification expects: 204 on success, 400 for
+// malformed or invalid payloads, 413 for bodies over HandlerOptions.MaxBodySize, 415 for unknown encodings, content
+// types or proto messages, and 500 if write returns an error. Successful responses carry the X-Prometheus-Remote-Write-*-Written headers defined by remote write 2.0.
+func NewHandler(write WriteFunc, options HandlerOptions) (http.Handler, error) {
+	if write == nil {
+		return nil, ErrNilWriteFunc
+	}
+
+	maxBodySize := options.MaxBodySize
+	switch {
+	case maxBodySize == 0:
+		maxBodySize = DefaultMaxBodySize
+	case maxBodySize < 0:
+		maxBodySize = math.MaxInt64
+	}
+
+	return &handlerImpl{
+		write:       write,
+		validate:    options.Validate,
+		maxBodySize: maxBodySize,
+	}, nil
+}
+
+func (h *handlerImpl) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	encoded, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxBodySize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			err = fmt.Errorf("%w: more than %d bytes", ErrBodyTooLarge, tooLarge.Limit)
+		}
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	wr, err := decode(r.Header.Get("Content-Encoding"), r.Header.Get("Content-Type"), encoded, h.maxBodySize)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	if h.validate {
+		if err = Validate(wr); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err = h.write(r.Context(), wr); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	setWrittenHeaders(w.Header(), wr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func setWrittenHeaders(h http.Header, wr *prompb.WriteRequest) {
+	var samples, histograms, exemplars int
+	for _, ts := range wr.Timeseries {
+		samples += len(ts.Samples)
+		histograms += len(ts.Histograms)
+		exemplars += len(ts.Exemplars)
+	}
+
+	h.Set(SamplesWrittenHeader, strconv.Itoa(samples))
+	h.Set(HistogramsWrittenHeader, strconv.Itoa(histograms))
+	h.Set(ExemplarsWrittenHeader, strconv.Itoa(exemplars))
+}
+
+func statusFor(err error) int {
+	if errors.Is(err, ErrUnsupportedEncoding) || errors.Is(err, ErrUnsupportedContentType) {
+		return http.StatusUnsupportedMediaType
+	}
+
+	if errors.Is(err, ErrBodyTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	return http.StatusBadRequest
+}
+
+// decode decompresses and unmarshals encoded. A body that decompresses to more than maxSize bytes fails with
+// ErrBodyTooLarge without being decompressed in full
+func decode(contentEncoding string, contentType string, encoded []byte, maxSize int64) (*prompb.WriteRequest, error) {
+	var decoded []byte
+	var err error
+
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case "", "identity":
+		decoded = encoded
+	case "snappy":
+		var n int
+		// snappy blocks start with their decoded length, so oversized bodies are rejected before allocating for them
+		if n, err = snappy.DecodedLen(encoded); err == nil {
+			if int64(n) > maxSize {
+				return nil, fmt.Errorf("%w: decodes to %d bytes, more than %d", ErrBodyTooLarge, n, maxSize)
+			}
+			decoded, err = snappy.Decode(nil, encoded)
+		}
+	case "gzip":
+		var gr *gzip.Reader
+		gr, err = gzip.NewReader(bytes.NewReader(encoded))
+		if err == nil {
+			// one byte past the limit tells a body of exactly maxSize bytes from a larger one
+			decoded, err = io.ReadAll(io.LimitReader(gr, maxSize+1))
+			gr.Close()
+			if err == nil && int64(len(decoded)) > maxSize {
+				return nil, fmt.Errorf("%w: decodes to more than %d bytes", ErrBodyTooLarge, maxSize)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedEncoding, contentEncoding)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType := "application/x-protobuf"
+	params := map[string]string{}
+	if strings.TrimSpace(contentType) != "" {
+		mediaType, params, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q", ErrUnsupportedContentType, contentType)
+		}
+	}
+
+	wr := &prompb.WriteRequest{}
+	switch strings.ToLower(mediaType) {
+	case "application/x-protobuf":
+		switch params["proto"] {
+		case "", ProtoMessageV1:
+			err = wr.Unmarshal(decoded)
+		case ProtoMessageV2:
+			wr, err = DecodeV2(decoded)
+		default:
+			return nil, fmt.Errorf("%w %q", ErrUnsupportedContentType, contentType)
+		}
+	case "application/json":
+		wr, err = decodeJSON(decoded)
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedContentType, contentType)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return wr, nil
+}
+
+// decodeJSON reads both JSON encodings the writer produces. The canonical protobuf JSON mapping is tried first; the
+// encoding/json form of prompb's Go structs uses field names that mapping does not know, so it falls through to
+// encoding/json
+func decodeJSON(decoded []byte) (*prompb.WriteRequest, error) {
+	if wr, err := remotepb.UnmarshalJSON(decoded); err == nil {
+		return &wr, nil
+	}
+
+	wr := &prompb.WriteRequest{}
+	if err := json.Unmarshal(decoded, wr); err != nil {
+		return nil, err
+	}
+
+	return wr, nil
+}
@@ -0,0 +1,246 @@
+package receiver_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/jghiloni/prometheus-remote-write/receiver"
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+)
+
+func post(h http.Handler, wr prompb.WriteRequest, headers map[string]string) *httptest.ResponseRecorder {
+	data, err := wr.Marshal()
+	Expect(err).ShouldNot(HaveOccurred())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(snappy.Encode(nil, data)))
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func series(name string, labels []prompb.Label, timestamps ...int64) prompb.TimeSeries {
+	ts := prompb.TimeSeries{
+		Labels: append([]prompb.Label{{Name: "__name__", Value: name}}, labels...),
+	}
+	for _, t := range timestamps {
+		ts.Samples = append(ts.Samples, prompb.Sample{Value: 1, Timestamp: t})
+	}
+	return ts
+}
+
+var _ = Describe("Receiver", func() {
+	var received []*prompb.WriteRequest
+	var write receiver.WriteFunc
+
+	BeforeEach(func() {
+		received = nil
+		write = func(_ context.Context, wr *prompb.WriteRequest) error {
+			received = append(received, wr)
+			return nil
+		}
+	})
+
+	It("Requires a write function", func() {
+		_, err := receiver.NewHandler(nil, receiver.HandlerOptions{})
+		Expect(err).Should(MatchError(receiver.ErrNilWriteFunc))
+	})
+
+	It("Accepts snappy protobuf requests", func() {
+		h, err := receiver.NewHandler(write, receiver.HandlerOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		rec := post(h, prompb.WriteRequest{Timeseries: []prompb.TimeSeries{series("up", nil, 1000)}}, nil)
+		Expect(rec.Code).Should(Equal(http.StatusNoContent))
+		Expect(received).Should(HaveLen(1))
+		Expect(received[0].Timeseries).Should(HaveLen(1))
+		Expect(rec.Header().Get(receiver.SamplesWrittenHeader)).Should(Equal("1"))
+		Expect(rec.Header().Get(receiver.HistogramsWrittenHeader)).Should(Equal("0"))
+		Expect(rec.Header().Get(receiver.ExemplarsWrittenHeader)).Should(Equal("0"))
+	})
+
+	It("Negotiates remote write 2.0 payloads", func() {
+		h, err := receiver.NewHandler(write, receiver.HandlerOptions{Validate: true})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		v2 := writev2.Request{
+			Symbols: []string{"", "__name__", "http_requests_total", "job", "api", "Total requests"},
+			Timeseries: []writev2.TimeSeries{{
+				LabelsRefs: []uint32{1, 2, 3, 4},
+				Samples:    []writev2.Sample{{Value: 1, Timestamp: 1000}, {Value: 2, Timestamp: 2000}},
+				Metadata:   writev2.Metadata{Type: writev2.Metadata_METRIC_TYPE_COUNTER, HelpRef: 5},
+			}},
+		}
+		data, err := v2.Marshal()
+		Expect(err).ShouldNot(HaveOccurred())
+
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(snappy.Encode(nil, data)))
+		req.Header.Set("Content-Encoding", "snappy")
+		req.Header.Set("Content-Type", "application/x-protobuf;proto="+receiver.ProtoMessageV2)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		Expect(rec.Code).Should(Equal(http.StatusNoContent))
+		Expect(rec.Header().Get(receiver.SamplesWrittenHeader)).Should(Equal("2"))
+		Expect(received).Should(HaveLen(1))
+		Expect(received[0].Timeseries[0].Labels).Should(Equal([]prompb.Label{
+			{Name: "__name__", Value: "http_requests_total"},
+			{Name: "job", Value: "api"},
+		}))
+		Expect(received[0].Metadata).Should(HaveLen(1))
+		Expect(received[0].Metadata[0].Type).Should(Equal(prompb.MetricMetadata_COUNTER))
+		Expect(received[0].Metadata[0].Help).Should(Equal("Total requests"))
+
+		v2.Timeseries[0].LabelsRefs = []uint32{1, 42}
+		data, err = v2.Marshal()
+		Expect(err).ShouldNot(HaveOccurred())
+		req = httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(snappy.Encode(nil, data)))
+		req.Header.Set("Content-Encoding", "snappy")
+		req.Header.Set("Content-Type", "application/x-protobuf;proto="+receiver.ProtoMessageV2)
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		Expect(rec.Code).Should(Equal(http.StatusBadRequest))
+		Expect(rec.Body.String()).Should(ContainSubstring(receiver.ErrInvalidSymbolRef.Error()))
+	})
+
+	It("Decodes both JSON formats the writer sends", func() {
+		h, err := receiver.NewHandler(write, receiver.HandlerOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		wr := prompb.WriteRequest{
+			Timeseries: []prompb.TimeSeries{{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "latency"}},
+				Samples: []prompb.Sample{{Value: 2.5, Timestamp: 1700000000000}},
+				Histograms: []prompb.Histogram{{
+					Count:          &prompb.Histogram_CountInt{CountInt: 3},
+					Sum:            7.5,
+					Schema:         1,
+					PositiveSpans:  []prompb.BucketSpan{{Offset: 0, Length: 2}},
+					PositiveDeltas: []int64{1, 1},
+					ResetHint:      prompb.Histogram_GAUGE,
+					Timestamp:      1700000000000,
+				}},
+			}},
+			Metadata: []prompb.MetricMetadata{{Type: prompb.MetricMetadata_HISTOGRAM, MetricFamilyName: "latency", Unit: "seconds"}},
+		}
+
+		// encoding/json cannot read prompb's histogram oneofs back, so the JSON request only carries samples
+		samplesOnly := prompb.WriteRequest{Timeseries: []prompb.TimeSeries{series("up", nil, 1000)}}
+		for format, request := range map[writer.Format]prompb.WriteRequest{writer.JSONProto: wr, writer.JSON: samplesOnly} {
+			data, err := format.Marshal(request)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(data))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			Expect(rec.Code).Should(Equal(http.StatusNoContent), format.String())
+			Expect(*received[len(received)-1]).Should(Equal(request), format.String())
+		}
+	})
+
+	It("Rejects bodies over the size limit with 413", func() {
+		h, err := receiver.NewHandler(write, receiver.HandlerOptions{MaxBodySize: 1024})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		// a body of zeros compresses well, so only the decoded size goes over the limit
+		zeros := make([]byte, 4096)
+		var gzipped bytes.Buffer
+		gw := gzip.NewWriter(&gzipped)
+		_, err = gw.Write(zeros)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(gw.Close()).Should(Succeed())
+
+		for encoding, body := range map[string][]byte{
+			"identity": zeros,
+			"snappy":   snappy.Encode(nil, zeros),
+			"gzip":     gzipped.Bytes(),
+		} {
+			if encoding != "identity" {
+				Expect(len(body)).Should(BeNumerically("<", 1024), encoding)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(body))
+			req.Header.Set("Content-Encoding", encoding)
+			req.Header.Set("Content-Type", "application/x-protobuf")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			Expect(rec.Code).Should(Equal(http.StatusRequestEntityTooLarge), encoding)
+			Expect(rec.Body.String()).Should(ContainSubstring(receiver.ErrBodyTooLarge.Error()), encoding)
+		}
+		Expect(received).Should(BeEmpty())
+
+		rec := post(h, prompb.WriteRequest{Timeseries: []prompb.TimeSeries{series("up", nil, 1000)}}, nil)
+		Expect(rec.Code).Should(Equal(http.StatusNoContent))
+
+		unlimited, err := receiver.NewHandler(write, receiver.HandlerOptions{MaxBodySize: -1})
+		Expect(err).ShouldNot(HaveOccurred())
+		rec = post(unlimited, prompb.WriteRequest{Timeseries: []prompb.TimeSeries{series(strings.Repeat("a", receiver.DefaultMaxBodySize+1), nil, 1000)}}, nil)
+		Expect(rec.Code).Should(Equal(http.StatusNoContent))
+	})
+
+	It("Rejects unknown encodings and content types with 415", func() {
+		h, err := receiver.NewHandler(write, receiver.HandlerOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		rec := post(h, prompb.WriteRequest{}, map[string]string{"Content-Encoding": "br"})
+		Expect(rec.Code).Should(Equal(http.StatusUnsupportedMediaType))
+
+		rec = post(h, prompb.WriteRequest{}, map[string]string{"Content-Type": "text/plain"})
+		Expect(rec.Code).Should(Equal(http.StatusUnsupportedMediaType))
+
+		rec = post(h, prompb.WriteRequest{}, map[string]string{"Content-Type": "application/x-protobuf;proto=io.prometheus.write.v3.Request"})
+		Expect(rec.Code).Should(Equal(http.StatusUnsupportedMediaType))
+		Expect(received).Should(BeEmpty())
+	})
+
+	It("Only validates when asked to", func() {
+		wr := prompb.WriteRequest{Timeseries: []prompb.TimeSeries{
+			series("up", []prompb.Label{{Name: "job", Value: "a"}, {Name: "instance", Value: "b"}}, 1000),
+		}}
+
+		h, err := receiver.NewHandler(write, receiver.HandlerOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(post(h, wr, nil).Code).Should(Equal(http.StatusNoContent))
+
+		h, err = receiver.NewHandler(write, receiver.HandlerOptions{Validate: true})
+		Expect(err).ShouldNot(HaveOccurred())
+		rec := post(h, wr, nil)
+		Expect(rec.Code).Should(Equal(http.StatusBadRequest))
+		Expect(rec.Body.String()).Should(ContainSubstring(receiver.ErrUnsortedLabels.Error()))
+		Expect(received).Should(HaveLen(1))
+	})
+
+	It("Reports every spec violation", func() {
+		wr := &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{
+			series("up", []prompb.Label{{Name: "instance", Value: "a"}}, 2000, 1000),
+			series("up", []prompb.Label{{Name: "instance", Value: "a"}}, 3000),
+			series("1up", []prompb.Label{{Name: "bad-label", Value: "x"}}, 1000),
+			{Labels: []prompb.Label{{Name: "job", Value: "a"}, {Name: "job", Value: "b"}}},
+		}}
+
+		err := receiver.Validate(wr)
+		Expect(err).Should(MatchError(receiver.ErrOutOfOrderTimestamps))
+		Expect(err).Should(MatchError(receiver.ErrDuplicateSeries))
+		Expect(err).Should(MatchError(receiver.ErrInvalidMetricName))
+		Expect(err).Should(MatchError(receiver.ErrInvalidLabelName))
+		Expect(err).Should(MatchError(receiver.ErrDuplicateLabelName))
+		Expect(err).Should(MatchError(receiver.ErrMissingMetricName))
+		Expect(err).ShouldNot(MatchError(receiver.ErrUnsortedLabels))
+	})
+})
@@ -0,0 +1,161 @@
+package receiver
+
+import (
+	"fmt"
+
+	"github.com/prometheus/prometheus/prompb"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+)
+
+// DecodeV2 decodes an uncompressed remote write 2.0 request (io.prometheus.write.v2.Request) into the 1.0 form the rest
+// of this package works with. Each family's metadata is taken from the first of its series that carries any
+func DecodeV2(data []byte) (*prompb.WriteRequest, error) {
+	var req writev2.Request
+	if err := req.Unmarshal(data); err != nil {
+		return nil, err
+	}
+
+	wr := &prompb.WriteRequest{
+		Timeseries: make([]prompb.TimeSeries, 0, len(req.Timeseries)),
+	}
+
+	families := map[string]bool{}
+	for i, series := range req.Timeseries {
+		labels, err := resolveLabels(req.Symbols, series.LabelsRefs)
+		if err != nil {
+			return nil, fmt.Errorf("series %d: %w", i, err)
+		}
+
+		ts := prompb.TimeSeries{
+			Labels:     labels,
+			Samples:    make([]prompb.Sample, 0, len(series.Samples)),
+			Exemplars:  make([]prompb.Exemplar, 0, len(series.Exemplars)),
+			Histograms: make([]prompb.Histogram, 0, len(series.Histograms)),
+		}
+
+		for _, s := range series.Samples {
+			ts.Samples = append(ts.Samples, prompb.Sample{Value: s.Value, Timestamp: s.Timestamp})
+		}
+
+		for _, e := range series.Exemplars {
+			exemplarLabels, err := resolveLabels(req.Symbols, e.LabelsRefs)
+			if err != nil {
+				return nil, fmt.Errorf("series %d exemplar: %w", i, err)
+			}
+			ts.Exemplars = append(ts.Exemplars, prompb.Exemplar{Labels: exemplarLabels, Value: e.Value, Timestamp: e.Timestamp})
+		}
+
+		for _, h := range series.Histograms {
+			ts.Histograms = append(ts.Histograms, convertHistogramV2(h))
+		}
+
+		wr.Timeseries = append(wr.Timeseries, ts)
+
+		name := metricName(labels)
+		if name == "" || families[name] {
+			continue
+		}
+		families[name] = true
+
+		help, err := resolveSymbol(req.Symbols, series.Metadata.HelpRef)
+		if err != nil {
+			return nil, fmt.Errorf("series %d help: %w", i, err)
+		}
+		unit, err := resolveSymbol(req.Symbols, series.Metadata.UnitRef)
+		if err != nil {
+			return nil, fmt.Errorf("series %d unit: %w", i, err)
+		}
+
+		wr.Metadata = append(wr.Metadata, prompb.MetricMetadata{
+			// the 1.0 and 2.0 metric type enums share their values
+			Type:             prompb.MetricMetadata_MetricType(series.Metadata.Type),
+			MetricFamilyName: name,
+			Help:             help,
+			Unit:             unit,
+		})
+	}
+
+	return wr, nil
+}
+
+func resolveSymbol(symbols []string, ref uint32) (string, error) {
+	if int(ref) >= len(symbols) {
+		return "", fmt.Errorf("%w: %d", ErrInvalidSymbolRef, ref)
+	}
+
+	return symbols[ref], nil
+}
+
+func resolveLabels(symbols []string, refs []uint32) ([]prompb.Label, error) {
+	if len(refs)%2 != 0 {
+		return nil, fmt.Errorf("%w: odd number of label references", ErrInvalidSymbolRef)
+	}
+
+	labels := make([]prompb.Label, 0, len(refs)/2)
+	for i := 0; i < len(refs); i += 2 {
+		name, err := resolveSymbol(symbols, refs[i])
+		if err != nil {
+			return nil, err
+		}
+		value, err := resolveSymbol(symbols, refs[i+1])
+		if err != nil {
+			return nil, err
+		}
+		labels = append(labels, prompb.Label{Name: name, Value: value})
+	}
+
+	return labels, nil
+}
+
+func metricName(labels []prompb.Label) string {
+	for _, l := range labels {
+		if l.Name == metricNameLabel {
+			return l.Value
+		}
+	}
+
+	return ""
+}
+
+func convertBucketSpansV2(spans []writev2.BucketSpan) []prompb.BucketSpan {
+	converted := make([]prompb.BucketSpan, len(spans))
+	for i, s := range spans {
+		converted[i] = prompb.BucketSpan{Offset: s.Offset, Length: s.Length}
+	}
+
+	return converted
+}
+
+func convertHistogramV2(h writev2.Histogram) prompb.Histogram {
+	converted := prompb.Histogram{
+		Sum:            h.Sum,
+		Schema:         h.Schema,
+		ZeroThreshold:  h.ZeroThreshold,
+		NegativeSpans:  convertBucketSpansV2(h.NegativeSpans),
+		NegativeDeltas: h.NegativeDeltas,
+		NegativeCounts: h.NegativeCounts,
+		PositiveSpans:  convertBucketSpansV2(h.PositiveSpans),
+		PositiveDeltas: h.PositiveDeltas,
+		PositiveCounts: h.PositiveCounts,
+		// the 1.0 and 2.0 reset hint enums share their values
+		ResetHint:    prompb.Histogram_ResetHint(h.ResetHint),
+		Timestamp:    h.Timestamp,
+		CustomValues: h.CustomValues,
+	}
+
+	switch c := h.GetCount().(type) {
+	case *writev2.Histogram_CountInt:
+		converted.Count = &prompb.Histogram_CountInt{CountInt: c.CountInt}
+	case *writev2.Histogram_CountFloat:
+		converted.Count = &prompb.Histogram_CountFloat{CountFloat: c.CountFloat}
+	}
+
+	switch z := h.GetZeroCount().(type) {
+	case *writev2.Histogram_ZeroCountInt:
+		converted.ZeroCount = &prompb.Histogram_ZeroCountInt{ZeroCountInt: z.ZeroCountInt}
+	case *writev2.Histogram_ZeroCountFloat:
+		converted.ZeroCount = &prompb.Histogram_ZeroCountFloat{ZeroCountFloat: z.ZeroCountFloat}
+	}
+
+	return converted
+}
@@ -0,0 +1,120 @@
+package receiver
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const metricNameLabel = "__name__"
+
+var (
+	metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRE  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// Validate checks wr against the rules the remote write specification places on senders: every series must have a
+// valid metric name, label names must be valid, unique and sorted, no label set may appear twice, and the samples,
+// histograms and exemplars of a series must be in increasing timestamp order. Every problem found is returned, joined
+// with errors.Join, and each one wraps the matching sentinel error so callers can use errors.Is
+func Validate(wr *prompb.WriteRequest) error {
+	if wr == nil {
+		return nil
+	}
+
+	var errs []error
+	seen := make(map[string]int, len(wr.Timeseries))
+	for i, series := range wr.Timeseries {
+		for _, err := range validateLabels(series.Labels) {
+			errs = append(errs, fmt.Errorf("series %d: %w", i, err))
+		}
+
+		key := labelsKey(series.Labels)
+		if first, ok := seen[key]; ok {
+			errs = append(errs, fmt.Errorf("series %d: %w (first seen as series %d)", i, ErrDuplicateSeries, first))
+		} else {
+			seen[key] = i
+		}
+
+		for _, err := range validateTimestamps(series) {
+			errs = append(errs, fmt.Errorf("series %d: %w", i, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateLabels(labels []prompb.Label) []error {
+	if len(labels) == 0 {
+		return []error{ErrEmptyLabelSet}
+	}
+
+	var errs []error
+	hasName := false
+	for i, l := range labels {
+		if l.Name == metricNameLabel {
+			hasName = true
+			if !metricNameRE.MatchString(l.Value) {
+				errs = append(errs, fmt.Errorf("%w %q", ErrInvalidMetricName, l.Value))
+			}
+		} else if !labelNameRE.MatchString(l.Name) {
+			errs = append(errs, fmt.Errorf("%w %q", ErrInvalidLabelName, l.Name))
+		}
+
+		if i == 0 {
+			continue
+		}
+
+		switch prev := labels[i-1].Name; {
+		case prev == l.Name:
+			errs = append(errs, fmt.Errorf("%w %q", ErrDuplicateLabelName, l.Name))
+		case prev > l.Name:
+			errs = append(errs, fmt.Errorf("%w: %q comes after %q", ErrUnsortedLabels, l.Name, prev))
+		}
+	}
+
+	if !hasName {
+		errs = append(errs, ErrMissingMetricName)
+	}
+
+	return errs
+}
+
+func validateTimestamps(series prompb.TimeSeries) []error {
+	var errs []error
+
+	for i := 1; i < len(series.Samples); i++ {
+		if series.Samples[i].Timestamp <= series.Samples[i-1].Timestamp {
+			errs = append(errs, fmt.Errorf("sample %d: %w", i, ErrOutOfOrderTimestamps))
+		}
+	}
+
+	for i := 1; i < len(series.Histograms); i++ {
+		if series.Histograms[i].Timestamp <= series.Histograms[i-1].Timestamp {
+			errs = append(errs, fmt.Errorf("histogram %d: %w", i, ErrOutOfOrderTimestamps))
+		}
+	}
+
+	for i := 1; i < len(series.Exemplars); i++ {
+		if series.Exemplars[i].Timestamp < series.Exemplars[i-1].Timestamp {
+			errs = append(errs, fmt.Errorf("exemplar %d: %w", i, ErrOutOfOrderTimestamps))
+		}
+	}
+
+	return errs
+}
+
+func labelsKey(labels []prompb.Label) string {
+	var sb strings.Builder
+	for _, l := range labels {
+		sb.WriteString(l.Name)
+		sb.WriteByte(0xff)
+		sb.WriteString(l.Value)
+		sb.WriteByte(0xff)
+	}
+
+	return sb.String()
+}
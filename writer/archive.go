@@ -0,0 +1,201 @@
+package writer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// BlobStore stores archived payloads. Keys are slash separated paths
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+}
+
+// ArchiveMode decides which payloads a writer with an Archive copies to it
+type ArchiveMode int
+
+const (
+	// ArchiveAll archives every payload, whether or not it was sent. It is the default
+	ArchiveAll ArchiveMode = iota
+	// ArchiveSent archives only the payloads the target accepted
+	ArchiveSent
+	// ArchiveFailed archives only the payloads that could not be sent
+	ArchiveFailed
+)
+
+// String returns the name of the mode
+func (m ArchiveMode) String() string {
+	switch m {
+	case ArchiveAll:
+		return "all"
+	case ArchiveSent:
+		return "sent"
+	case ArchiveFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("ArchiveMode(%d)", int(m))
+	}
+}
+
+// archiver copies payloads to a BlobStore once their push is over. Sent payloads are stored under sent/ and failed
+// ones under failed/, in the file format a FileSender writes, so either can be read with ReadPayloadFile and, from a
+// DirBlobStore, replayed with Replay
+type archiver struct {
+	store BlobStore
+	mode  ArchiveMode
+	seq   atomic.Uint64
+}
+
+func newArchiver(store BlobStore, mode ArchiveMode) *archiver {
+	if store == nil {
+		return nil
+	}
+
+	return &archiver{store: store, mode: mode}
+}
+
+// archive stores payload if the mode calls for it, logging rather than returning any error so that archiving never
+// changes the outcome of a push
+func (w *writerImpl) archive(ctx context.Context, payload Payload, sent bool) {
+	a := w.archiver
+	if a == nil || (sent && a.mode == ArchiveFailed) || (!sent && a.mode == ArchiveSent) {
+		return
+	}
+
+	dir := "sent"
+	if !sent {
+		dir = "failed"
+	}
+	key := dir + "/" + payloadFileName(payload.CreatedAt, a.seq.Add(1))
+
+	data, err := encodePayloadFile(payload)
+	if err == nil {
+		// a push abandoned by its caller is still worth a record of what it was sending
+		err = a.store.Put(context.WithoutCancel(ctx), key, data)
+	}
+	if err != nil {
+		w.log().WarnContext(ctx, "archiving remote write payload failed",
+			"url", payload.TargetURL, "key", key, "error", err)
+	}
+}
+
+// DirBlobStore is a BlobStore that writes each blob to a file under Dir, creating directories as needed
+type DirBlobStore struct {
+	Dir string
+}
+
+// Put writes data to the file named by key. The file is written under a temporary name and renamed once complete,
+// so a reader never sees a partial blob
+func (d DirBlobStore) Put(ctx context.Context, key string, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	name := filepath.FromSlash(key)
+	if !filepath.IsLocal(name) {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	name = filepath.Join(d.Dir, name)
+
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".partial-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
+
+// S3BlobStore is a BlobStore that uploads each blob as an object to an S3 compatible API (AWS S3, MinIO, Ceph,
+// Cloudflare R2 and the like), addressing the bucket by path.
+//
+//	Endpoint is the base URL of the API, such as https://s3.us-east-1.amazonaws.com or http://minio:9000
+//	Bucket is required
+//	Prefix is prepended to every key
+//	If AccessKeyID is set, requests are signed with SigV4 for Region, which defaults to us-east-1
+//	If HTTPClient is not set, it defaults to http.DefaultClient
+type S3BlobStore struct {
+	Endpoint        string
+	Bucket          string
+	Prefix          string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	HTTPClient      *http.Client
+}
+
+// Put uploads data as the object named by Prefix and key
+func (s S3BlobStore) Put(ctx context.Context, key string, data []byte) error {
+	if strings.TrimSpace(s.Bucket) == "" {
+		return errors.New("S3BlobStore.Bucket must be set")
+	}
+
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return err
+	}
+	u.Path = path.Join("/", u.Path, s.Bucket, s.Prefix, key)
+	u.RawPath = sigV4EscapePath(u.Path)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	if s.AccessKeyID != "" {
+		region := s.Region
+		if strings.TrimSpace(region) == "" {
+			region = "us-east-1"
+		}
+
+		signer := SigV4Signer{
+			Region:          region,
+			Service:         "s3",
+			AccessKeyID:     s.AccessKeyID,
+			SecretAccessKey: s.SecretAccessKey,
+			SessionToken:    s.SessionToken,
+		}
+		if err = signer.Sign(req, data); err != nil {
+			return err
+		}
+	}
+
+	hc := s.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	return nil
+}
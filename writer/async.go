@@ -0,0 +1,29 @@
+package writer
+
+import "context"
+
+// WriteResult is the outcome of an asynchronous push: the number of series sent and the error the push failed with,
+// if it did
+type WriteResult struct {
+	Series int
+	Err    error
+}
+
+// WriteMetricsAsync starts a WriteMetrics call on w and returns straight away, for callers on latency sensitive paths
+// that still want to observe the outcome. The returned channel receives the result once the push is over, and is then
+// closed, so it can be read from or ignored. Since it takes a writer rather than being one of its methods, it works
+// on writers wrapped in Middleware too.
+//
+// ctx bounds the push as it would a WriteMetrics call, so a caller that may return before the push is over (an HTTP
+// handler, say) should pass a context that outlives it, such as one from context.WithoutCancel
+func WriteMetricsAsync(ctx context.Context, w RemoteMetricsWriter) <-chan WriteResult {
+	result := make(chan WriteResult, 1)
+	go func() {
+		defer close(result)
+
+		n, err := w.WriteMetrics(ctx)
+		result <- WriteResult{Series: n, Err: err}
+	}()
+
+	return result
+}
@@ -0,0 +1,49 @@
+package writer_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ = Describe("WriteMetricsAsync", func() {
+	It("Returns before the push is over and delivers its result", func() {
+		release := make(chan struct{})
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			<-release
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer s.Close()
+
+		registry := prometheus.NewRegistry()
+		gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "queue_depth"})
+		registry.MustRegister(gauge)
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{HTTPClient: s.Client()}, registry)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		result := writer.WriteMetricsAsync(context.Background(), w)
+		Consistently(result, "50ms").ShouldNot(Receive())
+
+		close(release)
+		Eventually(result).Should(Receive(Equal(writer.WriteResult{Series: 1})))
+		Eventually(result).Should(BeClosed())
+	})
+
+	It("Delivers the error of a failed push", func() {
+		w, err := writer.NewRemoteMetricsWriter("http://example.invalid/push", writer.RemoteMetricsWriterOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		var result writer.WriteResult
+		Eventually(writer.WriteMetricsAsync(ctx, w)).Should(Receive(&result))
+		Expect(result.Err).Should(MatchError(context.Canceled))
+	})
+})
@@ -0,0 +1,87 @@
+package writer
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ThrottleSignal describes a target asking the writer to slow down, with a 429 Too Many Requests response or a 503
+// Service Unavailable response carrying a Retry-After header. Applications can use it to slow their own production of
+// metrics instead of building up a backlog the target will not accept.
+//
+//	RetryAfter is the delay the target suggested in its Retry-After header, or zero if it did not suggest one
+//	Limit, Remaining and Reset come from the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers (or
+//	their X-RateLimit- forms). Limit and Remaining are -1, and Reset zero, when the target did not send them
+type ThrottleSignal struct {
+	TargetURL  string
+	Tenant     string
+	StatusCode int
+	RetryAfter time.Duration
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+}
+
+// throttleSignal returns the signal carried by resp, or nil if resp is not asking the writer to slow down
+func throttleSignal(resp *http.Response, payload Payload, now time.Time) *ThrottleSignal {
+	retryAfter, hasRetryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), now)
+	if resp.StatusCode != http.StatusTooManyRequests && !(resp.StatusCode == http.StatusServiceUnavailable && hasRetryAfter) {
+		return nil
+	}
+
+	reset, _ := rateLimitHeader(resp.Header, "Reset")
+	signal := &ThrottleSignal{
+		TargetURL:  payload.TargetURL,
+		Tenant:     payload.Tenant,
+		StatusCode: resp.StatusCode,
+		RetryAfter: retryAfter,
+		Limit:      -1,
+		Remaining:  -1,
+		Reset:      time.Duration(reset) * time.Second,
+	}
+	if limit, ok := rateLimitHeader(resp.Header, "Limit"); ok {
+		signal.Limit = limit
+	}
+	if remaining, ok := rateLimitHeader(resp.Header, "Remaining"); ok {
+		signal.Remaining = remaining
+	}
+
+	return signal
+}
+
+// parseRetryAfter reads a Retry-After header, which is either a number of seconds or an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0), true
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+
+	return 0, false
+}
+
+// rateLimitHeader reads the RateLimit-<name> header, falling back to X-RateLimit-<name>. Some targets send a policy
+// after the number (such as "100;w=60"), which is ignored
+func rateLimitHeader(header http.Header, name string) (int, bool) {
+	value := header.Get("RateLimit-" + name)
+	if value == "" {
+		value = header.Get("X-RateLimit-" + name)
+	}
+
+	value, _, _ = strings.Cut(value, ";")
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || n < 0 {
+		return 0, false
+	}
+
+	return n, true
+}
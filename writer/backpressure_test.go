@@ -0,0 +1,107 @@
+package writer_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("Throttle signals", func() {
+	series := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+	}}
+
+	serve := func(status int, headers map[string]string) *httptest.Server {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for name, value := range headers {
+				w.Header().Set(name, value)
+			}
+			w.WriteHeader(status)
+		}))
+		DeferCleanup(s.Close)
+		return s
+	}
+
+	It("reports 429 responses to OnThrottle and on the error", func() {
+		s := serve(http.StatusTooManyRequests, map[string]string{
+			"Retry-After":         "7",
+			"RateLimit-Limit":     "100;w=60",
+			"RateLimit-Remaining": "0",
+			"X-RateLimit-Reset":   "30",
+		})
+
+		var mu sync.Mutex
+		var signals []writer.ThrottleSignal
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient: s.Client(),
+			Tenant:     "team-a",
+			MaxRetries: 1,
+			MinBackoff: time.Millisecond,
+			OnThrottle: func(signal writer.ThrottleSignal) {
+				mu.Lock()
+				defer mu.Unlock()
+				signals = append(signals, signal)
+			},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		var se *writer.StatusError
+		Expect(errors.As(err, &se)).Should(BeTrue())
+		Expect(se.Throttle).ShouldNot(BeNil())
+		Expect(*se.Throttle).Should(Equal(writer.ThrottleSignal{
+			TargetURL:  s.URL,
+			Tenant:     "team-a",
+			StatusCode: http.StatusTooManyRequests,
+			RetryAfter: 7 * time.Second,
+			Limit:      100,
+			Remaining:  0,
+			Reset:      30 * time.Second,
+		}))
+
+		mu.Lock()
+		defer mu.Unlock()
+		Expect(signals).Should(HaveLen(2))
+	})
+
+	It("reads Retry-After dates on 503 responses", func() {
+		retryAt := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+		s := serve(http.StatusServiceUnavailable, map[string]string{"Retry-After": retryAt})
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{HTTPClient: s.Client()})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		var se *writer.StatusError
+		Expect(errors.As(err, &se)).Should(BeTrue())
+		Expect(se.Throttle).ShouldNot(BeNil())
+		Expect(se.Throttle.RetryAfter).Should(BeNumerically("~", time.Minute, 2*time.Second))
+		Expect(se.Throttle.Limit).Should(Equal(-1))
+	})
+
+	It("does not report other failures", func() {
+		s := serve(http.StatusServiceUnavailable, nil)
+
+		called := false
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient: s.Client(),
+			OnThrottle: func(writer.ThrottleSignal) { called = true },
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		var se *writer.StatusError
+		Expect(errors.As(err, &se)).Should(BeTrue())
+		Expect(se.Throttle).Should(BeNil())
+		Expect(called).Should(BeFalse())
+	})
+})
@@ -0,0 +1,48 @@
+package writer
+
+import (
+	"sync"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// maxPooledBuffer is the largest marshal buffer kept for reuse, so one huge push does not pin its memory forever
+const maxPooledBuffer = 16 << 20
+
+var marshalBuffers = sync.Pool{
+	New: func() any { return new([]byte) },
+}
+
+// marshalPooled is Format.Marshal, except that Protobuf requests are marshalled into a pooled buffer sized from
+// wr.Size() instead of a freshly allocated one. The returned bytes belong to the pool once release is called, so
+// release must only be called when nothing refers to them anymore
+func (f Format) marshalPooled(wr prompb.WriteRequest) (data []byte, release func(), err error) {
+	if f != Protobuf {
+		data, err = f.Marshal(wr)
+		return data, func() {}, err
+	}
+
+	size := wr.Size()
+	buf := marshalBuffers.Get().(*[]byte)
+	if cap(*buf) < size {
+		*buf = make([]byte, size)
+	}
+
+	// MarshalToSizedBuffer fills the buffer from the end
+	sized := (*buf)[:size]
+	n, err := wr.MarshalToSizedBuffer(sized)
+	if err != nil {
+		releaseBuffer(buf)
+		return nil, nil, err
+	}
+
+	return sized[size-n:], func() { releaseBuffer(buf) }, nil
+}
+
+func releaseBuffer(buf *[]byte) {
+	if cap(*buf) > maxPooledBuffer {
+		return
+	}
+
+	marshalBuffers.Put(buf)
+}
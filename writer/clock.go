@@ -0,0 +1,24 @@
+package writer
+
+import "time"
+
+// Clock tells a writer the time and waits for time to pass. The writer uses it to timestamp metrics and payloads, to
+// back off between retries, and to schedule throttled pushes, metadata sends, target resolution and hedged requests,
+// so tests can control all of these without sleeping. Pushers and queue managers take one for their schedules too.
+// How long pushes and requests take is always measured with the system clock
+type Clock interface {
+	Now() time.Time
+	// After returns a channel that receives the time once d has passed, like time.After
+	After(d time.Duration) <-chan time.Time
+}
+
+// systemClock is the Clock of a writer that has none
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
@@ -0,0 +1,259 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/relabel"
+	"go.yaml.in/yaml/v3"
+)
+
+// DefaultConfigCheckInterval is how often WatchConfigFile checks its file for changes when the Pusher has no
+// ConfigCheckInterval
+const DefaultConfigCheckInterval = 10 * time.Second
+
+// ConfigFile is a writer configuration kept in a YAML file, for programs that want to change where and how their
+// metrics are sent without restarting. The settings match those of OptionsFromEnv, plus Targets, RelabelConfigs and
+// the push interval:
+//
+//	url: https://mimir.example.com/api/v1/push
+//	format: protobuf
+//	compression: snappy
+//	target_flavor: mimir
+//	bearer_token_file: /var/run/secrets/token
+//	tenant: team-a
+//	headers:
+//	  X-Scope-Source: agent
+//	max_retries: 3
+//	min_backoff: 500ms
+//	max_backoff: 30s
+//	targets:
+//	  - url: https://backup.example.com/api/v1/push
+//	relabel_configs:
+//	  - source_labels: [__name__]
+//	    regex: go_.*
+//	    action: drop
+//	push_interval: 30s
+//
+// Settings left out keep the value of the options the file is applied to
+type ConfigFile struct {
+	URL             string            `yaml:"url"`
+	Format          string            `yaml:"format"`
+	Compression     string            `yaml:"compression"`
+	TargetFlavor    string            `yaml:"target_flavor"`
+	BearerToken     string            `yaml:"bearer_token"`
+	BearerTokenFile string            `yaml:"bearer_token_file"`
+	Username        string            `yaml:"username"`
+	Password        string            `yaml:"password"`
+	PasswordFile    string            `yaml:"password_file"`
+	Tenant          string            `yaml:"tenant"`
+	Headers         map[string]string `yaml:"headers"`
+	MaxRetries      *int              `yaml:"max_retries"`
+	MinBackoff      time.Duration     `yaml:"min_backoff"`
+	MaxBackoff      time.Duration     `yaml:"max_backoff"`
+	HACluster       string            `yaml:"ha_cluster"`
+	HAReplica       string            `yaml:"ha_replica"`
+	Targets         []ConfigTarget    `yaml:"targets"`
+	RelabelConfigs  []*relabel.Config `yaml:"relabel_configs"`
+	PushInterval    time.Duration     `yaml:"push_interval"`
+}
+
+// ConfigTarget is one of the Targets of a ConfigFile
+type ConfigTarget struct {
+	URL         string `yaml:"url"`
+	Format      string `yaml:"format"`
+	Compression string `yaml:"compression"`
+}
+
+// LoadConfigFile reads the ConfigFile at path. Unknown settings are an error, so a misspelt one is not silently ignored
+func LoadConfigFile(path string) (ConfigFile, error) {
+	var config ConfigFile
+
+	f, err := os.Open(path)
+	if err != nil {
+		return config, err
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil {
+		return config, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+// Options applies the file to base, which holds the settings that can only be made in code such as HTTPClient and
+// Logger, and returns the target URL and options of a writer
+func (c ConfigFile) Options(base RemoteMetricsWriterOptions) (string, RemoteMetricsWriterOptions, error) {
+	options := base
+	if c.URL == "" {
+		return "", options, errors.New("url must be set")
+	}
+
+	var errs []error
+	parse := func(name, value string, fn func(string) error) {
+		if value != "" {
+			if err := fn(value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}
+	}
+
+	parse("format", c.Format, func(v string) (err error) {
+		options.Format, err = ParseFormat(v)
+		return err
+	})
+	parse("compression", c.Compression, func(v string) (err error) {
+		options.Compression, err = ParseCompression(v)
+		return err
+	})
+	parse("target_flavor", c.TargetFlavor, func(v string) (err error) {
+		options.TargetFlavor, err = ParseTargetFlavor(v)
+		return err
+	})
+
+	if c.MaxRetries != nil {
+		options.MaxRetries = *c.MaxRetries
+	}
+	if c.MinBackoff > 0 {
+		options.MinBackoff = c.MinBackoff
+	}
+	if c.MaxBackoff > 0 {
+		options.MaxBackoff = c.MaxBackoff
+	}
+
+	if len(c.Headers) > 0 {
+		options.Headers = options.Headers.Clone()
+		if options.Headers == nil {
+			options.Headers = http.Header{}
+		}
+		for name, value := range c.Headers {
+			options.Headers.Set(name, value)
+		}
+	}
+
+	if c.BearerToken != "" || c.BearerTokenFile != "" {
+		options.BearerToken, options.BearerTokenFile = c.BearerToken, c.BearerTokenFile
+	}
+	if c.Username != "" {
+		options.BasicAuth = &BasicAuth{Username: c.Username, Password: c.Password, PasswordFile: c.PasswordFile}
+	} else if c.Password != "" || c.PasswordFile != "" {
+		errs = append(errs, errors.New("username must be set to use password or password_file"))
+	}
+
+	if c.Tenant != "" {
+		options.Tenant = c.Tenant
+	}
+	if c.HACluster != "" {
+		options.HACluster = c.HACluster
+	}
+	if c.HAReplica != "" {
+		options.HAReplica = c.HAReplica
+	}
+
+	if len(c.RelabelConfigs) > 0 {
+		options.RelabelConfigs = c.RelabelConfigs
+	}
+
+	if len(c.Targets) > 0 {
+		options.Targets = nil
+		for i, t := range c.Targets {
+			target := Target{URL: t.URL}
+			if t.URL == "" {
+				errs = append(errs, fmt.Errorf("targets[%d]: url must be set", i))
+			}
+			parse(fmt.Sprintf("targets[%d].format", i), t.Format, func(v string) (err error) {
+				target.Format, err = ParseFormat(v)
+				return err
+			})
+			parse(fmt.Sprintf("targets[%d].compression", i), t.Compression, func(v string) error {
+				compression, err := ParseCompression(v)
+				target.Compression = &compression
+				return err
+			})
+			options.Targets = append(options.Targets, target)
+		}
+	}
+
+	return c.URL, options, errors.Join(errs...)
+}
+
+// WatchConfigFile keeps the Pusher's writer in line with the ConfigFile at path until ctx is done. The file is checked
+// every ConfigCheckInterval, and straight away when the process receives SIGHUP; whenever it has changed (or on every
+// SIGHUP), a writer is created from it, with base and gatherers, and handed to Reload, and the Pusher's interval is
+// set to its push_interval if it has one. Pushes carry on throughout: one in progress finishes with the old writer.
+//
+// A file that cannot be read, or does not make a valid writer, is reported to OnError and the Pusher keeps the writer
+// it has, so a half-written file does not stop the pushes. The file is not loaded when WatchConfigFile starts; the
+// Pusher is expected to have been created from it
+func (p *Pusher) WatchConfigFile(ctx context.Context, path string, base RemoteMetricsWriterOptions, gatherers ...prometheus.Gatherer) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	modTime, size := info.ModTime(), info.Size()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		force := false
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hup:
+			force = true
+		case <-p.options.Clock.After(p.options.ConfigCheckInterval):
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			p.reportError(fmt.Errorf("reloading %s: %w", path, err))
+			continue
+		}
+		if !force && info.ModTime().Equal(modTime) && info.Size() == size {
+			continue
+		}
+		modTime, size = info.ModTime(), info.Size()
+
+		if err := p.reloadConfigFile(path, base, gatherers); err != nil {
+			p.reportError(fmt.Errorf("reloading %s: %w", path, err))
+		}
+	}
+}
+
+func (p *Pusher) reloadConfigFile(path string, base RemoteMetricsWriterOptions, gatherers []prometheus.Gatherer) error {
+	config, err := LoadConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	targetURL, options, err := config.Options(base)
+	if err != nil {
+		return err
+	}
+
+	w, err := NewRemoteMetricsWriter(targetURL, options, gatherers...)
+	if err != nil {
+		return err
+	}
+
+	if config.PushInterval > 0 {
+		p.mu.Lock()
+		p.interval = config.PushInterval
+		p.mu.Unlock()
+	}
+	p.Reload(w)
+
+	return nil
+}
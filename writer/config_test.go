@@ -0,0 +1,181 @@
+package writer_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	"github.com/jghiloni/prometheus-remote-write/writertest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/relabel"
+)
+
+var _ = Describe("Config files", func() {
+	write := func(path, contents string) {
+		Expect(os.WriteFile(path, []byte(contents), 0o600)).Should(Succeed())
+	}
+
+	It("Reads writer options", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "writer.yaml")
+		write(path, `
+url: https://mimir.example.com/api/v1/push
+format: protobuf
+compression: gzip
+tenant: team-a
+headers:
+  X-Source: agent
+max_retries: 0
+min_backoff: 500ms
+targets:
+  - url: https://backup.example.com/api/v1/push
+    compression: snappy
+relabel_configs:
+  - source_labels: [__name__]
+    regex: go_.*
+    action: drop
+push_interval: 30s
+`)
+
+		config, err := writer.LoadConfigFile(path)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(config.PushInterval).Should(Equal(30 * time.Second))
+
+		targetURL, options, err := config.Options(writer.RemoteMetricsWriterOptions{MaxRetries: 3, Tenant: "default"})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(targetURL).Should(Equal("https://mimir.example.com/api/v1/push"))
+		Expect(options.Compression).Should(Equal(writer.Gzip))
+		Expect(options.Tenant).Should(Equal("team-a"))
+		Expect(options.Headers.Get("X-Source")).Should(Equal("agent"))
+		Expect(options.MaxRetries).Should(BeZero())
+		Expect(options.MinBackoff).Should(Equal(500 * time.Millisecond))
+		Expect(options.Targets).Should(HaveLen(1))
+		Expect(*options.Targets[0].Compression).Should(Equal(writer.Snappy))
+		Expect(options.RelabelConfigs).Should(HaveLen(1))
+		Expect(options.RelabelConfigs[0].Action).Should(Equal(relabel.Drop))
+		Expect(options.RelabelConfigs[0].Regex.MatchString("go_goroutines")).Should(BeTrue())
+		Expect(options.RelabelConfigs[0].Separator).Should(Equal(relabel.DefaultRelabelConfig.Separator))
+	})
+
+	It("Rejects unknown settings and bad values", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "writer.yaml")
+		write(path, "url: http://localhost\ninterval: 10s\n")
+		_, err := writer.LoadConfigFile(path)
+		Expect(err).Should(HaveOccurred())
+
+		write(path, "url: http://localhost\ncompression: lz4\n")
+		config, err := writer.LoadConfigFile(path)
+		Expect(err).ShouldNot(HaveOccurred())
+		_, _, err = config.Options(writer.RemoteMetricsWriterOptions{})
+		Expect(err).Should(MatchError(ContainSubstring("compression")))
+
+		write(path, "url: http://localhost\nrelabel_configs:\n  - action: shuffle\n")
+		_, err = writer.LoadConfigFile(path)
+		Expect(err).Should(MatchError(ContainSubstring("shuffle")))
+	})
+
+	Describe("Watching", func() {
+		var (
+			path      string
+			old, next *httptest.Server
+			oldHits   atomic.Int64
+			nextHits  atomic.Int64
+			clock     *writertest.FakeClock
+			reloads   chan struct{}
+			errs      chan error
+			pusher    *writer.Pusher
+			registry  *prometheus.Registry
+		)
+
+		server := func(hits *atomic.Int64) *httptest.Server {
+			hits.Store(0)
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				hits.Add(1)
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			DeferCleanup(s.Close)
+			return s
+		}
+
+		// touch moves the file's modification time on, so the change is seen however quickly it follows the last
+		touch := func() {
+			later := time.Now().Add(time.Minute)
+			Expect(os.Chtimes(path, later, later)).Should(Succeed())
+		}
+
+		watch := func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() {
+				done <- pusher.WatchConfigFile(ctx, path, writer.RemoteMetricsWriterOptions{}, registry)
+			}()
+			DeferCleanup(func() {
+				cancel()
+				Eventually(done).Should(Receive(BeNil()))
+			})
+			Eventually(clock.Waiters).Should(BeNumerically(">=", 1))
+		}
+
+		pushOnce := func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			pusher.Run(ctx)
+		}
+
+		BeforeEach(func() {
+			old, next = server(&oldHits), server(&nextHits)
+			path = filepath.Join(GinkgoT().TempDir(), "writer.yaml")
+			write(path, "url: "+old.URL+"\n")
+
+			registry = prometheus.NewRegistry()
+			registry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "up"}))
+
+			w, err := writer.NewRemoteMetricsWriter(old.URL, writer.RemoteMetricsWriterOptions{}, registry)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			clock = writertest.NewFakeClock(time.Unix(1700000000, 0))
+			reloads, errs = make(chan struct{}, 10), make(chan error, 10)
+			pusher = writer.NewPusher(w, writer.PusherOptions{
+				Clock:          clock,
+				OnConfigReload: func() { reloads <- struct{}{} },
+				OnError:        func(err error) { errs <- err },
+			})
+		})
+
+		It("Switches to the writer the file describes once it changes", func() {
+			watch()
+
+			clock.Advance(writer.DefaultConfigCheckInterval)
+			Consistently(reloads, 50*time.Millisecond).ShouldNot(Receive())
+
+			write(path, "url: "+next.URL+"\n")
+			touch()
+			Eventually(clock.Waiters).Should(BeNumerically(">=", 1))
+			clock.Advance(writer.DefaultConfigCheckInterval)
+			Eventually(reloads).Should(Receive())
+
+			pushOnce()
+			Expect(nextHits.Load()).Should(BeEquivalentTo(1))
+			Expect(oldHits.Load()).Should(BeZero())
+		})
+
+		It("Keeps the current writer when the file is broken", func() {
+			watch()
+
+			write(path, "url: [\n")
+			touch()
+			clock.Advance(writer.DefaultConfigCheckInterval)
+			Eventually(errs).Should(Receive(MatchError(ContainSubstring("reloading"))))
+			Expect(reloads).ShouldNot(Receive())
+
+			pushOnce()
+			Expect(oldHits.Load()).Should(BeEquivalentTo(1))
+		})
+	})
+})
@@ -7,4 +7,39 @@ import (
 var (
 	ErrNilContext         = errors.New("nil context passed")
 	ErrNoGatherersDefined = errors.New("no gatherers were defined")
+	ErrQueueClosed        = errors.New("queue manager is stopped")
+	ErrThrottled          = errors.New("push throttled locally")
+	// ErrSampleLimitExceeded is wrapped by the *SampleLimitExceededError returned for pushes with more samples than
+	// SampleLimit
+	ErrSampleLimitExceeded = errors.New("sample limit exceeded")
+	// ErrSeriesLimitExceeded is wrapped by the *SeriesLimitExceededError returned for pushes with more series than
+	// MaxSeriesPerPush
+	ErrSeriesLimitExceeded = errors.New("series limit exceeded")
+	// ErrDuplicateLabel is returned for pushes with a series that has the same label name twice, when the writer's
+	// DuplicateLabelPolicy is DuplicateLabelsError
+	ErrDuplicateLabel = errors.New("duplicate label name")
+	// ErrMemoryBudgetExceeded is wrapped by the *MemoryBudgetExceededError returned for requests over MemoryBudget, when
+	// the writer's MemoryBudgetAction is MemoryBudgetError
+	ErrMemoryBudgetExceeded = errors.New("memory budget exceeded")
+	// ErrNoDefaultWriter is returned by Write when no writer has been set with SetDefault
+	ErrNoDefaultWriter = errors.New("no default writer set")
+
+	// The errors below are wrapped together with the error that caused them, so both errors.Is on these and
+	// errors.As on the cause (a *url.Error, a prometheus.MultiError, and so on) work on what a push returns
+	ErrGather           = errors.New("gathering metrics failed")
+	ErrMarshal          = errors.New("marshalling request failed")
+	ErrCompress         = errors.New("compressing request failed")
+	ErrSend             = errors.New("sending request failed")
+	ErrUnexpectedStatus = errors.New("unexpected HTTP status")
+
+	// ErrGatherTimeout and ErrSendTimeout are wrapped, along with ErrGather and ErrSend, by the errors of gathers and
+	// requests that took longer than GatherTimeout and SendTimeout
+	ErrGatherTimeout = errors.New("gather timed out")
+	ErrSendTimeout   = errors.New("request timed out")
+
+	// ErrInvalidMetric is wrapped by the problems Validate finds in converted series and encoded requests
+	ErrInvalidMetric = errors.New("invalid metric")
+
+	// ErrUnsupportedRemoteWriteVersion is returned for a RemoteWriteVersion the writer cannot encode requests for
+	ErrUnsupportedRemoteWriteVersion = errors.New("unsupported remote write version")
 )
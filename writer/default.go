@@ -0,0 +1,38 @@
+package writer
+
+import (
+	"context"
+	"sync"
+)
+
+var (
+	defaultMu     sync.RWMutex
+	defaultWriter RemoteMetricsWriter
+)
+
+// SetDefault makes w the writer Write pushes with, so a small program can set up one push path and use it from
+// anywhere, much as prometheus.DefaultRegisterer is used for registration. Passing nil unsets it
+func SetDefault(w RemoteMetricsWriter) {
+	defaultMu.Lock()
+	defaultWriter = w
+	defaultMu.Unlock()
+}
+
+// Default returns the writer set with SetDefault, or nil if none has been
+func Default() RemoteMetricsWriter {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+
+	return defaultWriter
+}
+
+// Write gathers and pushes metrics with the default writer, as its WriteMetrics does. It returns ErrNoDefaultWriter if
+// SetDefault has not been called
+func Write(ctx context.Context) (int, error) {
+	w := Default()
+	if w == nil {
+		return 0, ErrNoDefaultWriter
+	}
+
+	return w.WriteMetrics(ctx)
+}
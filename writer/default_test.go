@@ -0,0 +1,49 @@
+package writer_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ = Describe("Default writer", func() {
+	BeforeEach(func() {
+		previous := writer.Default()
+		DeferCleanup(func() { writer.SetDefault(previous) })
+		writer.SetDefault(nil)
+	})
+
+	It("returns ErrNoDefaultWriter until one is set", func() {
+		_, err := writer.Write(context.Background())
+		Expect(err).Should(MatchError(writer.ErrNoDefaultWriter))
+	})
+
+	It("pushes with the writer set as the default", func() {
+		var requests atomic.Int32
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		DeferCleanup(s.Close)
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "up"}))
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{}, registry)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		writer.SetDefault(w)
+		Expect(writer.Default()).Should(BeIdenticalTo(w))
+
+		n, err := writer.Write(context.Background())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).Should(Equal(1))
+		Expect(requests.Load()).Should(BeEquivalentTo(1))
+	})
+})
@@ -0,0 +1,226 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultDialTimeout and DefaultKeepAlive match the dialer of http.DefaultTransport
+const (
+	DefaultDialTimeout = 30 * time.Second
+	DefaultKeepAlive   = 30 * time.Second
+)
+
+// AddressFamily decides which of the addresses of a dual-stack target the writer connects to
+type AddressFamily int
+
+const (
+	// AnyAddressFamily connects to IPv4 and IPv6 addresses alike, racing them as the standard library does
+	AnyAddressFamily AddressFamily = iota
+	// PreferIPv4 tries the target's IPv4 addresses before its IPv6 addresses
+	PreferIPv4
+	// PreferIPv6 tries the target's IPv6 addresses before its IPv4 addresses
+	PreferIPv6
+	// IPv4Only only connects to IPv4 addresses
+	IPv4Only
+	// IPv6Only only connects to IPv6 addresses
+	IPv6Only
+)
+
+// String returns the name of the AddressFamily
+func (f AddressFamily) String() string {
+	switch f {
+	case AnyAddressFamily:
+		return "any"
+	case PreferIPv4:
+		return "prefer-ipv4"
+	case PreferIPv6:
+		return "prefer-ipv6"
+	case IPv4Only:
+		return "ipv4-only"
+	case IPv6Only:
+		return "ipv6-only"
+	default:
+		return fmt.Sprintf("%%INVALID!(%d)", f)
+	}
+}
+
+// dialFunc dials a connection, as http.Transport's DialContext does
+type dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// dialer dials the writer's connections with its dialer options. When it has an address family preference or a DNS
+// cache, it resolves host names itself and dials their addresses one at a time in the order it prefers; otherwise it
+// leaves both to net.Dialer
+type dialer struct {
+	dialer   *net.Dialer
+	resolver *net.Resolver
+	ttl      time.Duration
+	family   AddressFamily
+	clock    Clock
+
+	mu    sync.Mutex
+	hosts map[string]resolved
+}
+
+type resolved struct {
+	addrs   []string
+	expires time.Time
+}
+
+// usesDialer reports whether options has any of the settings that need the writer to dial its own connections
+func usesDialer(options RemoteMetricsWriterOptions) bool {
+	return options.Resolver != nil || options.DNSCacheTTL > 0 || options.DialTimeout > 0 || options.KeepAlive != 0 ||
+		options.AddressFamily != AnyAddressFamily
+}
+
+// newDialer returns a dialer with the dialer settings of options. Its resolver is net.DefaultResolver if Resolver is
+// not set, and its timeout and keep-alive period default to DefaultDialTimeout and DefaultKeepAlive
+func newDialer(options RemoteMetricsWriterOptions) *dialer {
+	d := &dialer{
+		dialer: &net.Dialer{
+			Timeout:   options.DialTimeout,
+			KeepAlive: options.KeepAlive,
+			Resolver:  options.Resolver,
+		},
+		resolver: options.Resolver,
+		ttl:      options.DNSCacheTTL,
+		family:   options.AddressFamily,
+		clock:    options.Clock,
+		hosts:    map[string]resolved{},
+	}
+
+	if d.dialer.Timeout <= 0 {
+		d.dialer.Timeout = DefaultDialTimeout
+	}
+
+	if d.dialer.KeepAlive == 0 {
+		d.dialer.KeepAlive = DefaultKeepAlive
+	}
+
+	if d.resolver == nil {
+		d.resolver = net.DefaultResolver
+	}
+
+	if d.clock == nil {
+		d.clock = systemClock{}
+	}
+
+	return d
+}
+
+// DialContext dials address. A host name is resolved (or its addresses taken from the cache) and its addresses of the
+// allowed families dialed in turn, preferred family first. If none of them can be dialed, the host is forgotten, so
+// the next connection resolves it again rather than keep trying addresses that may have moved
+func (d *dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	network = d.network(network)
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) != nil || (d.ttl <= 0 && d.family == AnyAddressFamily) {
+		return d.dialer.DialContext(ctx, network, address)
+	}
+
+	addrs, err := d.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs = d.order(addrs)
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("dial %s: no addresses allowed by address family %s", host, d.family)
+	}
+
+	var errs []error
+	for _, addr := range addrs {
+		conn, err := d.dialer.DialContext(ctx, network, net.JoinHostPort(addr, port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	d.mu.Lock()
+	delete(d.hosts, host)
+	d.mu.Unlock()
+
+	return nil, errors.Join(errs...)
+}
+
+// network narrows tcp to tcp4 or tcp6 when only one address family is allowed
+func (d *dialer) network(network string) string {
+	if network != "tcp" {
+		return network
+	}
+
+	switch d.family {
+	case IPv4Only:
+		return "tcp4"
+	case IPv6Only:
+		return "tcp6"
+	default:
+		return network
+	}
+}
+
+// order returns the addresses of the allowed families, those of the preferred family first
+func (d *dialer) order(addrs []string) []string {
+	isIPv4 := func(addr string) bool {
+		ip := net.ParseIP(addr)
+		return ip != nil && ip.To4() != nil
+	}
+
+	switch d.family {
+	case IPv4Only:
+		return slices.DeleteFunc(slices.Clone(addrs), func(a string) bool { return !isIPv4(a) })
+	case IPv6Only:
+		return slices.DeleteFunc(slices.Clone(addrs), isIPv4)
+	case PreferIPv4, PreferIPv6:
+		ordered := slices.Clone(addrs)
+		slices.SortStableFunc(ordered, func(a, b string) int {
+			if isIPv4(a) == isIPv4(b) {
+				return 0
+			}
+			if isIPv4(a) == (d.family == PreferIPv4) {
+				return -1
+			}
+			return 1
+		})
+		return ordered
+	default:
+		return addrs
+	}
+}
+
+// lookup returns the addresses of host, from the cache if they were resolved less than DNSCacheTTL ago
+func (d *dialer) lookup(ctx context.Context, host string) ([]string, error) {
+	if d.ttl <= 0 {
+		return d.resolver.LookupHost(ctx, host)
+	}
+
+	d.mu.Lock()
+	entry, ok := d.hosts[host]
+	d.mu.Unlock()
+
+	if ok && d.clock.Now().Before(entry.expires) {
+		return entry.addrs, nil
+	}
+
+	addrs, err := d.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	d.hosts[host] = resolved{addrs: addrs, expires: d.clock.Now().Add(d.ttl)}
+	d.mu.Unlock()
+
+	return addrs, nil
+}
@@ -0,0 +1,155 @@
+package writer_test
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// answerLoopback answers the DNS query on conn, framed as over TCP, with 127.0.0.1 for A queries and ::1 for AAAA
+// queries
+func answerLoopback(conn net.Conn) {
+	defer conn.Close()
+
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return
+	}
+	query := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, query); err != nil {
+		return
+	}
+
+	// the question starts after the 12 byte header, with the name's labels followed by its type and class
+	end := 12
+	for query[end] != 0 {
+		end += int(query[end]) + 1
+	}
+	end += 5
+	qtype := binary.BigEndian.Uint16(query[end-4:])
+
+	response := append([]byte{}, query[:2]...)
+	response = append(response, 0x81, 0x80, 0, 1, 0, 1, 0, 0, 0, 0)
+	response = append(response, query[12:end]...)
+	// the answer points back at the question's name, with a TTL of 60 seconds
+	response = append(response, 0xc0, 12)
+	response = binary.BigEndian.AppendUint16(response, qtype)
+	response = append(response, 0, 1, 0, 0, 0, 60)
+	if qtype == 1 {
+		response = append(response, 0, 4, 127, 0, 0, 1)
+	} else {
+		response = append(response, 0, 16)
+		response = append(response, net.IPv6loopback...)
+	}
+
+	framed := binary.BigEndian.AppendUint16(nil, uint16(len(response)))
+	_, _ = conn.Write(append(framed, response...))
+}
+
+var _ = Describe("Dialer options", func() {
+	series := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+	}}
+
+	var (
+		lookups  atomic.Int32
+		resolver *net.Resolver
+		target   string
+	)
+
+	BeforeEach(func() {
+		lookups.Store(0)
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(context.Context, string, string) (net.Conn, error) {
+				lookups.Add(1)
+				client, server := net.Pipe()
+				go answerLoopback(server)
+				return client, nil
+			},
+		}
+
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			// a new connection, and so a new dial, for every request
+			w.Header().Set("Connection", "close")
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		DeferCleanup(s.Close)
+		target = strings.Replace(s.URL, "127.0.0.1", "metrics.test", 1)
+	})
+
+	It("Resolves the target with the Resolver for every connection", func() {
+		w, err := writer.NewRemoteMetricsWriter(target, writer.RemoteMetricsWriterOptions{Resolver: resolver})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		for range 2 {
+			_, err = w.WriteTimeSeries(context.Background(), series, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+
+		Expect(lookups.Load()).Should(BeNumerically(">=", 2))
+	})
+
+	It("Reuses resolved addresses for DNSCacheTTL", func() {
+		w, err := writer.NewRemoteMetricsWriter(target, writer.RemoteMetricsWriterOptions{
+			Resolver:    resolver,
+			DNSCacheTTL: time.Hour,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		first := lookups.Load()
+		Expect(first).Should(BeNumerically(">", 0))
+
+		for range 3 {
+			_, err = w.WriteTimeSeries(context.Background(), series, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+		Expect(lookups.Load()).Should(Equal(first))
+	})
+
+	It("Connects to the address families allowed, preferred family first", func() {
+		// the target only listens on 127.0.0.1, so its ::1 address refuses connections
+		for family, ok := range map[writer.AddressFamily]bool{
+			writer.IPv4Only:   true,
+			writer.PreferIPv4: true,
+			writer.PreferIPv6: true,
+			writer.IPv6Only:   false,
+		} {
+			w, err := writer.NewRemoteMetricsWriter(target, writer.RemoteMetricsWriterOptions{
+				Resolver:      resolver,
+				AddressFamily: family,
+				DialTimeout:   time.Second,
+			})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			_, err = w.WriteTimeSeries(context.Background(), series, nil)
+			if ok {
+				Expect(err).ShouldNot(HaveOccurred(), family.String())
+			} else {
+				Expect(err).Should(MatchError(writer.ErrSend), family.String())
+			}
+		}
+	})
+
+	It("Cannot be combined with an HTTPClient", func() {
+		_, err := writer.NewRemoteMetricsWriter(target, writer.RemoteMetricsWriterOptions{
+			HTTPClient:  http.DefaultClient,
+			DNSCacheTTL: time.Minute,
+		})
+		Expect(err).Should(HaveOccurred())
+	})
+})
@@ -0,0 +1,135 @@
+package writer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The environment variables NewWriterFromEnv and OptionsFromEnv read
+const (
+	EnvURL             = "PROM_RW_URL"
+	EnvFormat          = "PROM_RW_FORMAT"
+	EnvCompression     = "PROM_RW_COMPRESSION"
+	EnvTargetFlavor    = "PROM_RW_TARGET_FLAVOR"
+	EnvBearerToken     = "PROM_RW_BEARER_TOKEN"
+	EnvBearerTokenFile = "PROM_RW_BEARER_TOKEN_FILE"
+	EnvUsername        = "PROM_RW_USERNAME"
+	EnvPassword        = "PROM_RW_PASSWORD"
+	EnvPasswordFile    = "PROM_RW_PASSWORD_FILE"
+	EnvTenant          = "PROM_RW_TENANT"
+	EnvHeaders         = "PROM_RW_HEADERS"
+	EnvMaxRetries      = "PROM_RW_MAX_RETRIES"
+	EnvMinBackoff      = "PROM_RW_MIN_BACKOFF"
+	EnvMaxBackoff      = "PROM_RW_MAX_BACKOFF"
+	EnvHACluster       = "PROM_RW_HA_CLUSTER"
+	EnvHAReplica       = "PROM_RW_HA_REPLICA"
+)
+
+// NewWriterFromEnv creates a RemoteMetricsWriter configured entirely by environment variables (see OptionsFromEnv), so
+// that applications run in containers can be pointed at a remote write target without code changes. If no gatherers
+// are specified, prometheus.DefaultGatherer is used.
+func NewWriterFromEnv(gatherers ...prometheus.Gatherer) (RemoteMetricsWriter, error) {
+	targetURL, options, err := OptionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRemoteMetricsWriter(targetURL, options, gatherers...)
+}
+
+// OptionsFromEnv returns the target URL and options described by the environment, for callers that want to adjust
+// them before creating a writer.
+//
+//	PROM_RW_URL is the target URL, and must be set
+//	PROM_RW_FORMAT, PROM_RW_COMPRESSION and PROM_RW_TARGET_FLAVOR are the names of a Format, Compression and
+//	TargetFlavor, as returned by their String methods (protobuf, snappy, thanos-receive and so on)
+//	PROM_RW_BEARER_TOKEN or PROM_RW_BEARER_TOKEN_FILE set the bearer token or the file holding it
+//	PROM_RW_USERNAME, with PROM_RW_PASSWORD or PROM_RW_PASSWORD_FILE, sets basic auth credentials
+//	PROM_RW_TENANT sets the tenant
+//	PROM_RW_HEADERS holds extra headers as comma separated name=value pairs
+//	PROM_RW_MAX_RETRIES is a number, and PROM_RW_MIN_BACKOFF and PROM_RW_MAX_BACKOFF are durations such as 500ms
+//	PROM_RW_HA_CLUSTER and PROM_RW_HA_REPLICA set the HA cluster and replica labels
+//
+// Unset and empty variables leave their options at their defaults
+func OptionsFromEnv() (string, RemoteMetricsWriterOptions, error) {
+	var options RemoteMetricsWriterOptions
+
+	targetURL := env(EnvURL)
+	if targetURL == "" {
+		return "", options, fmt.Errorf("%s must be set", EnvURL)
+	}
+
+	var errs []error
+	parse := func(name string, fn func(string) error) {
+		if value := env(name); value != "" {
+			if err := fn(value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}
+	}
+
+	parse(EnvFormat, func(v string) (err error) {
+		options.Format, err = ParseFormat(v)
+		return err
+	})
+	parse(EnvCompression, func(v string) (err error) {
+		options.Compression, err = ParseCompression(v)
+		return err
+	})
+	parse(EnvTargetFlavor, func(v string) (err error) {
+		options.TargetFlavor, err = ParseTargetFlavor(v)
+		return err
+	})
+	parse(EnvMaxRetries, func(v string) (err error) {
+		options.MaxRetries, err = strconv.Atoi(v)
+		return err
+	})
+	parse(EnvMinBackoff, func(v string) (err error) {
+		options.MinBackoff, err = time.ParseDuration(v)
+		return err
+	})
+	parse(EnvMaxBackoff, func(v string) (err error) {
+		options.MaxBackoff, err = time.ParseDuration(v)
+		return err
+	})
+	parse(EnvHeaders, func(v string) error {
+		options.Headers = http.Header{}
+		for _, pair := range strings.Split(v, ",") {
+			name, value, ok := strings.Cut(pair, "=")
+			if !ok || strings.TrimSpace(name) == "" {
+				return fmt.Errorf("%q is not a name=value pair", pair)
+			}
+			options.Headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+		return nil
+	})
+
+	options.BearerToken = env(EnvBearerToken)
+	options.BearerTokenFile = env(EnvBearerTokenFile)
+	options.Tenant = env(EnvTenant)
+	options.HACluster = env(EnvHACluster)
+	options.HAReplica = env(EnvHAReplica)
+
+	if username := env(EnvUsername); username != "" {
+		options.BasicAuth = &BasicAuth{
+			Username:     username,
+			Password:     env(EnvPassword),
+			PasswordFile: env(EnvPasswordFile),
+		}
+	} else if env(EnvPassword) != "" || env(EnvPasswordFile) != "" {
+		errs = append(errs, fmt.Errorf("%s must be set to use %s or %s", EnvUsername, EnvPassword, EnvPasswordFile))
+	}
+
+	return targetURL, options, errors.Join(errs...)
+}
+
+func env(name string) string {
+	return strings.TrimSpace(os.Getenv(name))
+}
@@ -0,0 +1,86 @@
+package writer_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ = Describe("Configuration from the environment", func() {
+	setenv := func(vars map[string]string) {
+		for name, value := range vars {
+			GinkgoT().Setenv(name, value)
+		}
+	}
+
+	It("reads the options from the environment", func() {
+		setenv(map[string]string{
+			writer.EnvURL:         "http://localhost:9090/api/v1/write",
+			writer.EnvCompression: "snappy",
+			writer.EnvFormat:      "protobuf",
+			writer.EnvBearerToken: "secret",
+			writer.EnvTenant:      "team-a",
+			writer.EnvHeaders:     "X-One=1, X-Two=2",
+			writer.EnvMaxRetries:  "4",
+			writer.EnvMinBackoff:  "250ms",
+		})
+
+		targetURL, options, err := writer.OptionsFromEnv()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(targetURL).Should(Equal("http://localhost:9090/api/v1/write"))
+		Expect(options.Compression).Should(Equal(writer.Snappy))
+		Expect(options.Format).Should(Equal(writer.Protobuf))
+		Expect(options.BearerToken).Should(Equal("secret"))
+		Expect(options.Tenant).Should(Equal("team-a"))
+		Expect(options.Headers.Get("X-One")).Should(Equal("1"))
+		Expect(options.Headers.Get("X-Two")).Should(Equal("2"))
+		Expect(options.MaxRetries).Should(Equal(4))
+		Expect(options.MinBackoff).Should(Equal(250 * time.Millisecond))
+	})
+
+	It("requires a URL", func() {
+		setenv(map[string]string{writer.EnvURL: ""})
+
+		_, err := writer.NewWriterFromEnv()
+		Expect(err).Should(MatchError(ContainSubstring(writer.EnvURL)))
+	})
+
+	It("reports every invalid variable", func() {
+		setenv(map[string]string{
+			writer.EnvURL:         "http://localhost:9090",
+			writer.EnvCompression: "lz4",
+			writer.EnvMaxRetries:  "many",
+		})
+
+		_, _, err := writer.OptionsFromEnv()
+		Expect(err).Should(MatchError(ContainSubstring(writer.EnvCompression)))
+		Expect(err).Should(MatchError(ContainSubstring(writer.EnvMaxRetries)))
+	})
+
+	It("creates a working writer", func() {
+		var auth string
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth = r.Header.Get("Authorization")
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		DeferCleanup(s.Close)
+
+		setenv(map[string]string{writer.EnvURL: s.URL, writer.EnvBearerToken: "secret"})
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "up"}))
+
+		w, err := writer.NewWriterFromEnv(registry)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteMetrics(context.Background())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(auth).Should(Equal("Bearer secret"))
+	})
+})
@@ -0,0 +1,96 @@
+package writer_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("Errors", func() {
+	series := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+	}}
+
+	It("Wraps gather errors", func() {
+		collectorErr := errors.New("collector failed")
+		failing := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			return nil, prometheus.MultiError{collectorErr}
+		})
+		w, err := writer.NewRemoteMetricsWriter("http://example.invalid/push", writer.RemoteMetricsWriterOptions{}, failing)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteMetrics(context.Background())
+		Expect(err).Should(MatchError(writer.ErrGather))
+		Expect(err).Should(MatchError(collectorErr))
+	})
+
+	It("Attributes gather errors and counts to their gatherer", func() {
+		healthy := prometheus.NewRegistry()
+		healthy.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "queue_depth"}))
+		failing := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			return nil, errors.New("collector failed")
+		})
+
+		var results []writer.GatherResult
+		w, err := writer.NewRemoteMetricsWriter("http://example.invalid/push", writer.RemoteMetricsWriterOptions{
+			OnGather: func(r []writer.GatherResult) { results = r },
+			Logger:   slog.New(slog.DiscardHandler),
+		}, healthy, writer.NamedGatherer("plugins", failing))
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteMetrics(context.Background())
+		Expect(err).Should(MatchError(writer.ErrGather))
+		Expect(err).Should(MatchError(ContainSubstring(`gatherer "plugins": collector failed`)))
+
+		var gatherErr *writer.GatherError
+		Expect(errors.As(err, &gatherErr)).Should(BeTrue())
+		Expect(gatherErr.Index).Should(Equal(1))
+
+		Expect(results).Should(HaveLen(2))
+		Expect(results[0]).Should(Equal(writer.GatherResult{Index: 0, Families: 1, Metrics: 1}))
+		Expect(results[1].Name).Should(Equal("plugins"))
+		Expect(results[1].Err).Should(MatchError("collector failed"))
+	})
+
+	It("Wraps errors sending the request", func() {
+		s := httptest.NewServer(http.NotFoundHandler())
+		s.Close()
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).Should(MatchError(writer.ErrSend))
+
+		var urlErr *url.Error
+		Expect(errors.As(err, &urlErr)).Should(BeTrue())
+	})
+
+	It("Reports unexpected statuses", func() {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "bad labels", http.StatusBadRequest)
+		}))
+		defer s.Close()
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{HTTPClient: s.Client()})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).Should(MatchError(writer.ErrUnexpectedStatus))
+
+		var se *writer.StatusError
+		Expect(errors.As(err, &se)).Should(BeTrue())
+		Expect(se.StatusCode).Should(Equal(http.StatusBadRequest))
+	})
+})
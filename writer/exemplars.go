@@ -0,0 +1,183 @@
+package writer
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// MaxExemplarLabelLength is the most UTF-8 characters the names and values of an exemplar's labels may have combined.
+// Receivers reject exemplars over it
+const MaxExemplarLabelLength = 128
+
+// ExemplarLabelPolicy decides what happens to an exemplar whose labels are longer than MaxExemplarLabelLength
+type ExemplarLabelPolicy int
+
+const (
+	// ExemplarLabelsDrop leaves the exemplar out of the push, keeping the rest of its series
+	ExemplarLabelsDrop ExemplarLabelPolicy = iota
+	// ExemplarLabelsTruncate keeps labels in order until the limit is reached, shortening the value of the label that
+	// reaches it and removing the labels after it
+	ExemplarLabelsTruncate
+)
+
+// String returns the name of the ExemplarLabelPolicy
+func (p ExemplarLabelPolicy) String() string {
+	switch p {
+	case ExemplarLabelsDrop:
+		return "drop"
+	case ExemplarLabelsTruncate:
+		return "truncate"
+	default:
+		return fmt.Sprintf("%%INVALID!(%d)", p)
+	}
+}
+
+// ExemplarDrops counts the exemplars one push left out, and why
+type ExemplarDrops struct {
+	// Total is the number of exemplars the push was given
+	Total int
+	// LabelsTooLong were dropped under ExemplarLabelsDrop
+	LabelsTooLong int
+	// Sampled were left out by ExemplarSampleRate
+	Sampled int
+	// OverLimit were left out by MaxExemplarsPerSend
+	OverLimit int
+}
+
+// Dropped returns the number of exemplars left out for any reason
+func (d ExemplarDrops) Dropped() int {
+	return d.LabelsTooLong + d.Sampled + d.OverLimit
+}
+
+// checkExemplars applies the writer's ExemplarLabelPolicy, ExemplarSampleRate and MaxExemplarsPerSend to the
+// exemplars of series, in that order. Which exemplars are sampled depends only on the exemplar and its series, and the
+// limit keeps the first exemplars in the order of series, so the same input always loses the same exemplars. Series
+// are only copied when one of them has to change, so the caller's slices are never modified
+func (w *writerImpl) checkExemplars(series []prompb.TimeSeries) ([]prompb.TimeSeries, ExemplarDrops) {
+	var (
+		drops   ExemplarDrops
+		checked []prompb.TimeSeries
+		sent    int
+	)
+	for i, ts := range series {
+		drops.Total += len(ts.Exemplars)
+
+		unchanged := w.exemplarSampleRate <= 0 && !hasLongExemplarLabels(ts.Exemplars) &&
+			(w.maxExemplarsPerSend <= 0 || sent+len(ts.Exemplars) <= w.maxExemplarsPerSend)
+		if unchanged {
+			sent += len(ts.Exemplars)
+			if checked != nil {
+				checked = append(checked, ts)
+			}
+			continue
+		}
+
+		var kept []prompb.Exemplar
+		for _, e := range ts.Exemplars {
+			if exemplarLabelLength(e.Labels) > MaxExemplarLabelLength {
+				if w.exemplarLabelPolicy != ExemplarLabelsTruncate {
+					drops.LabelsTooLong++
+					continue
+				}
+				e.Labels = truncateExemplarLabels(e.Labels)
+			}
+
+			if w.exemplarSampleRate > 0 && !sampleExemplar(ts.Labels, e, w.exemplarSampleRate) {
+				drops.Sampled++
+				continue
+			}
+
+			if w.maxExemplarsPerSend > 0 && sent >= w.maxExemplarsPerSend {
+				drops.OverLimit++
+				continue
+			}
+
+			sent++
+			kept = append(kept, e)
+		}
+
+		if checked == nil {
+			checked = make([]prompb.TimeSeries, i, len(series))
+			copy(checked, series[:i])
+		}
+		ts.Exemplars = kept
+		checked = append(checked, ts)
+	}
+
+	if checked == nil {
+		return series, drops
+	}
+
+	return checked, drops
+}
+
+// sampleExemplar decides whether an exemplar of the series with labels is kept at rate, by hashing the series, the
+// exemplar's labels and its timestamp
+func sampleExemplar(labels []prompb.Label, e prompb.Exemplar, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+
+	h := fingerprint(labels)*31 + fingerprint(e.Labels)
+	// mix the bits so nearby timestamps do not hash to nearby values
+	h = mix(h*31 + uint64(e.Timestamp))
+
+	return float64(h>>11)/(1<<53) < rate
+}
+
+func hasLongExemplarLabels(exemplars []prompb.Exemplar) bool {
+	for _, e := range exemplars {
+		if exemplarLabelLength(e.Labels) > MaxExemplarLabelLength {
+			return true
+		}
+	}
+
+	return false
+}
+
+func exemplarLabelLength(labels []prompb.Label) int {
+	n := 0
+	for _, l := range labels {
+		n += utf8.RuneCountInString(l.Name) + utf8.RuneCountInString(l.Value)
+	}
+
+	return n
+}
+
+// truncateExemplarLabels returns a copy of labels cut down to MaxExemplarLabelLength characters. A label whose name
+// does not fit is removed along with the labels after it, since a label name cannot be shortened
+func truncateExemplarLabels(labels []prompb.Label) []prompb.Label {
+	truncated := make([]prompb.Label, 0, len(labels))
+	remaining := MaxExemplarLabelLength
+	for _, l := range labels {
+		remaining -= utf8.RuneCountInString(l.Name)
+		if remaining < 0 {
+			break
+		}
+
+		if n := utf8.RuneCountInString(l.Value); n > remaining {
+			l.Value = truncateRunes(l.Value, remaining)
+			truncated = append(truncated, l)
+			break
+		}
+
+		remaining -= utf8.RuneCountInString(l.Value)
+		truncated = append(truncated, l)
+	}
+
+	return truncated
+}
+
+// truncateRunes returns the first n characters of s
+func truncateRunes(s string, n int) string {
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+
+	return s
+}
@@ -0,0 +1,162 @@
+package writer_test
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("ExemplarLabelPolicy", func() {
+	var requests []prompb.WriteRequest
+
+	// the trace ID takes the labels 2 characters over the limit
+	long := strings.Repeat("é", writer.MaxExemplarLabelLength-len("span")-len("def")-len("trace_id")+2)
+
+	series := func() []prompb.TimeSeries {
+		return []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "requests_total"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+			Exemplars: []prompb.Exemplar{
+				{Labels: []prompb.Label{{Name: "trace_id", Value: "abc"}}, Value: 1, Timestamp: 1000},
+				{
+					Labels:    []prompb.Label{{Name: "span", Value: "def"}, {Name: "trace_id", Value: long}},
+					Value:     2,
+					Timestamp: 1000,
+				},
+			},
+		}}
+	}
+
+	newWriter := func(policy writer.ExemplarLabelPolicy) writer.RemoteMetricsWriter {
+		requests = nil
+		w, err := writer.NewRemoteMetricsWriter("http://example.invalid/push", writer.RemoteMetricsWriterOptions{
+			ExemplarLabelPolicy: policy,
+			Sender: senderFunc(func(_ context.Context, p writer.Payload) error {
+				var wr prompb.WriteRequest
+				if err := wr.Unmarshal(p.Body); err != nil {
+					return err
+				}
+				requests = append(requests, wr)
+				return nil
+			}),
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		return w
+	}
+
+	It("Drops exemplars with labels over the limit by default", func() {
+		input := series()
+		_, err := newWriter(writer.ExemplarLabelsDrop).WriteTimeSeries(context.Background(), input, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		exemplars := requests[0].Timeseries[0].Exemplars
+		Expect(exemplars).Should(HaveLen(1))
+		Expect(exemplars[0].Value).Should(Equal(1.0))
+		Expect(requests[0].Timeseries[0].Samples).Should(HaveLen(1))
+		Expect(input[0].Exemplars).Should(HaveLen(2))
+	})
+
+	It("Truncates labels to the limit", func() {
+		input := series()
+		_, err := newWriter(writer.ExemplarLabelsTruncate).WriteTimeSeries(context.Background(), input, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		exemplars := requests[0].Timeseries[0].Exemplars
+		Expect(exemplars).Should(HaveLen(2))
+		Expect(exemplars[1].Labels[0]).Should(Equal(prompb.Label{Name: "span", Value: "def"}))
+		Expect(exemplars[1].Labels[1].Value).Should(Equal(strings.Repeat("é", len([]rune(long))-2)))
+		Expect(input[0].Exemplars[1].Labels[1].Value).Should(Equal(long))
+	})
+})
+
+var _ = Describe("Exemplar limiting and sampling", func() {
+	var (
+		requests []prompb.WriteRequest
+		drops    []writer.ExemplarDrops
+	)
+
+	series := func() []prompb.TimeSeries {
+		var series []prompb.TimeSeries
+		for i := range 10 {
+			ts := prompb.TimeSeries{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "requests_total"}, {Name: "pod", Value: strconv.Itoa(i)}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+			}
+			for j := range 10 {
+				ts.Exemplars = append(ts.Exemplars, prompb.Exemplar{
+					Labels:    []prompb.Label{{Name: "trace_id", Value: fmt.Sprintf("%d-%d", i, j)}},
+					Value:     1,
+					Timestamp: int64(1000 + j),
+				})
+			}
+			series = append(series, ts)
+		}
+		return series
+	}
+
+	newWriter := func(options writer.RemoteMetricsWriterOptions) writer.RemoteMetricsWriter {
+		requests, drops = nil, nil
+		options.OnExemplarsDropped = func(d writer.ExemplarDrops) { drops = append(drops, d) }
+		options.Sender = senderFunc(func(_ context.Context, p writer.Payload) error {
+			var wr prompb.WriteRequest
+			if err := wr.Unmarshal(p.Body); err != nil {
+				return err
+			}
+			requests = append(requests, wr)
+			return nil
+		})
+		w, err := writer.NewRemoteMetricsWriter("http://example.invalid/push", options)
+		Expect(err).ShouldNot(HaveOccurred())
+		return w
+	}
+
+	exemplarCount := func(wr prompb.WriteRequest) int {
+		n := 0
+		for _, ts := range wr.Timeseries {
+			n += len(ts.Exemplars)
+		}
+		return n
+	}
+
+	It("Sends at most MaxExemplarsPerSend exemplars, keeping the first", func() {
+		input := series()
+		_, err := newWriter(writer.RemoteMetricsWriterOptions{MaxExemplarsPerSend: 25}).
+			WriteTimeSeries(context.Background(), input, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(exemplarCount(requests[0])).Should(Equal(25))
+		Expect(requests[0].Timeseries).Should(HaveLen(10))
+		Expect(requests[0].Timeseries[2].Exemplars).Should(HaveLen(5))
+		Expect(requests[0].Timeseries[3].Exemplars).Should(BeEmpty())
+		Expect(drops).Should(Equal([]writer.ExemplarDrops{{Total: 100, OverLimit: 75}}))
+		Expect(input[9].Exemplars).Should(HaveLen(10))
+	})
+
+	It("Samples the same exemplars on every push", func() {
+		w := newWriter(writer.RemoteMetricsWriterOptions{ExemplarSampleRate: 0.25})
+		for range 2 {
+			_, err := w.WriteTimeSeries(context.Background(), series(), nil)
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+
+		Expect(requests).Should(HaveLen(2))
+		Expect(requests[0]).Should(Equal(requests[1]))
+		Expect(exemplarCount(requests[0])).Should(BeNumerically("~", 25, 15))
+		Expect(drops[0].Sampled).Should(Equal(100 - exemplarCount(requests[0])))
+	})
+
+	It("Reports nothing when no exemplars are dropped", func() {
+		_, err := newWriter(writer.RemoteMetricsWriterOptions{MaxExemplarsPerSend: 100}).
+			WriteTimeSeries(context.Background(), series(), nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(exemplarCount(requests[0])).Should(Equal(100))
+		Expect(drops).Should(BeEmpty())
+	})
+})
@@ -0,0 +1,144 @@
+package writer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// PayloadFileExtension is the extension of the files a FileSender writes
+const PayloadFileExtension = ".prw"
+
+// payloadHeader is written as a single line of JSON at the start of every payload file, before the body
+type payloadHeader struct {
+	Format             string    `json:"format"`
+	Compression        string    `json:"compression"`
+	RemoteWriteVersion string    `json:"remoteWriteVersion,omitempty"`
+	CreatedAt          time.Time `json:"createdAt"`
+	TargetURL          string    `json:"targetURL,omitempty"`
+	Tenant             string    `json:"tenant,omitempty"`
+}
+
+// FileSender is a Sender that writes every payload to its own file in a directory, so pushes can be captured while
+// the target is unavailable and replayed later. Each file starts with one line of JSON recording the payload's format,
+// compression, creation time, target and tenant, followed by the body exactly as it would have been sent. File names
+// sort in the order the payloads were written
+type FileSender struct {
+	dir string
+	seq atomic.Uint64
+}
+
+// NewFileSender returns a FileSender that writes to dir, creating it if needed
+func NewFileSender(dir string) (*FileSender, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &FileSender{dir: dir}, nil
+}
+
+// Send writes payload to a new file. The file is written under a temporary name and renamed once complete, so a
+// reader never sees a partial payload
+func (f *FileSender) Send(ctx context.Context, payload Payload) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if payload.CreatedAt.IsZero() {
+		payload.CreatedAt = time.Now()
+	}
+
+	data, err := encodePayloadFile(payload)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(f.dir, ".partial-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(f.dir, payloadFileName(payload.CreatedAt, f.seq.Add(1))))
+}
+
+// encodePayloadFile returns the contents of the file a FileSender writes for payload
+func encodePayloadFile(payload Payload) ([]byte, error) {
+	header, err := json.Marshal(payloadHeader{
+		Format:             payload.Format.String(),
+		Compression:        payload.Compression.String(),
+		RemoteWriteVersion: payload.RemoteWriteVersion,
+		CreatedAt:          payload.CreatedAt.UTC(),
+		TargetURL:          payload.TargetURL,
+		Tenant:             payload.Tenant,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append(append(header, '\n'), payload.Body...), nil
+}
+
+// payloadFileName names payload files so they sort in the order they were written
+func payloadFileName(createdAt time.Time, seq uint64) string {
+	return fmt.Sprintf("%020d-%06d%s", createdAt.UnixNano(), seq%1e6, PayloadFileExtension)
+}
+
+// ReadPayloadFile reads a payload written by a FileSender
+func ReadPayloadFile(name string) (Payload, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return Payload{}, err
+	}
+
+	r := bufio.NewReader(bytes.NewReader(data))
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return Payload{}, fmt.Errorf("%s: missing payload header: %w", name, err)
+	}
+
+	var header payloadHeader
+	if err = json.Unmarshal(line, &header); err != nil {
+		return Payload{}, fmt.Errorf("%s: invalid payload header: %w", name, err)
+	}
+
+	format, err := ParseFormat(header.Format)
+	if err != nil {
+		return Payload{}, fmt.Errorf("%s: %w", name, err)
+	}
+
+	compression, err := ParseCompression(header.Compression)
+	if err != nil {
+		return Payload{}, fmt.Errorf("%s: %w", name, err)
+	}
+
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return Payload{}, err
+	}
+
+	return Payload{
+		Body:               body,
+		Format:             format,
+		Compression:        compression,
+		RemoteWriteVersion: header.RemoteWriteVersion,
+		TargetURL:          header.TargetURL,
+		Tenant:             header.Tenant,
+		CreatedAt:          header.CreatedAt,
+	}, nil
+}
@@ -0,0 +1,106 @@
+package writer_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/snappy"
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("FileSender", func() {
+	series := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+	}}
+
+	readPayloadFiles := func(dir string) []map[string]any {
+		files, err := filepath.Glob(filepath.Join(dir, "*"+writer.PayloadFileExtension))
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var headers []map[string]any
+		for _, name := range files {
+			f, err := os.Open(name)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			r := bufio.NewReader(f)
+			line, err := r.ReadBytes('\n')
+			Expect(err).ShouldNot(HaveOccurred())
+
+			var header map[string]any
+			Expect(json.Unmarshal(line, &header)).Should(Succeed())
+
+			body, err := io.ReadAll(r)
+			Expect(err).ShouldNot(HaveOccurred())
+			decoded, err := snappy.Decode(nil, body)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			var wr prompb.WriteRequest
+			Expect(wr.Unmarshal(decoded)).Should(Succeed())
+			Expect(wr.Timeseries).Should(HaveLen(1))
+
+			f.Close()
+			headers = append(headers, header)
+		}
+
+		return headers
+	}
+
+	It("Writes payloads to files with a header", func() {
+		dir := GinkgoT().TempDir()
+		sender, err := writer.NewFileSender(dir)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		w, err := writer.NewRemoteMetricsWriter("http://example.invalid/push", writer.RemoteMetricsWriterOptions{
+			Compression: writer.Snappy,
+			Tenant:      "team-a",
+			Sender:      sender,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		for range 2 {
+			_, err = w.WriteTimeSeries(context.Background(), series, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+
+		headers := readPayloadFiles(dir)
+		Expect(headers).Should(HaveLen(2))
+		Expect(headers[0]).Should(HaveKeyWithValue("format", "protobuf"))
+		Expect(headers[0]).Should(HaveKeyWithValue("compression", "snappy"))
+		Expect(headers[0]).Should(HaveKeyWithValue("targetURL", "http://example.invalid/push"))
+		Expect(headers[0]).Should(HaveKeyWithValue("tenant", "team-a"))
+		Expect(headers[0]).Should(HaveKey("createdAt"))
+	})
+
+	It("Captures payloads the target rejects when used as the fallback", func() {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+		}))
+		defer s.Close()
+
+		dir := GinkgoT().TempDir()
+		sender, err := writer.NewFileSender(dir)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:     s.Client(),
+			Compression:    writer.Snappy,
+			FallbackSender: sender,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		n, err := w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).Should(Equal(1))
+		Expect(readPayloadFiles(dir)).Should(HaveLen(1))
+	})
+})
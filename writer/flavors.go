@@ -0,0 +1,133 @@
+package writer
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+)
+
+const (
+	victoriaMetricsWritePath = "/api/v1/write"
+	thanosReceivePath        = "/api/v1/receive"
+	thanosTenantHeader       = "THANOS-TENANT"
+	influxDBWritePath        = "/api/v1/prom/write"
+)
+
+// String returns the name of the TargetFlavor
+func (f TargetFlavor) String() string {
+	switch f {
+	case Generic:
+		return "generic"
+	case VictoriaMetrics:
+		return "victoriametrics"
+	case ThanosReceive:
+		return "thanos-receive"
+	case InfluxDB:
+		return "influxdb"
+	default:
+		return fmt.Sprintf("%%INVALID!(%d)", f)
+	}
+}
+
+// ParseTargetFlavor returns the TargetFlavor whose String() is name
+func ParseTargetFlavor(name string) (TargetFlavor, error) {
+	for _, f := range []TargetFlavor{Generic, VictoriaMetrics, ThanosReceive, InfluxDB} {
+		if f.String() == name {
+			return f, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unrecognized target flavor %q", name)
+}
+
+// apply validates options against what the flavor's backend accepts, fills in the flavor's defaults, and returns the
+// URL requests should actually be sent to
+func (f TargetFlavor) apply(targetURL string, options *RemoteMetricsWriterOptions) (string, error) {
+	switch f {
+	case Generic:
+		return targetURL, nil
+	case VictoriaMetrics:
+		if err := f.requireSnappyProtobuf(options); err != nil {
+			return "", err
+		}
+
+		u, err := withDefaultPath(targetURL, victoriaMetricsWritePath)
+		if err != nil {
+			return "", err
+		}
+
+		if len(options.ExtraLabels) > 0 {
+			names := make([]string, 0, len(options.ExtraLabels))
+			for name := range options.ExtraLabels {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			q := u.Query()
+			for _, name := range names {
+				q.Add("extra_label", name+"="+options.ExtraLabels[name])
+			}
+			u.RawQuery = q.Encode()
+		}
+
+		return u.String(), nil
+	case ThanosReceive:
+		if options.TenantHeader == "" {
+			options.TenantHeader = thanosTenantHeader
+		}
+		options.RetryOnConflict = true
+
+		u, err := withDefaultPath(targetURL, thanosReceivePath)
+		if err != nil {
+			return "", err
+		}
+
+		return u.String(), nil
+	case InfluxDB:
+		if err := f.requireSnappyProtobuf(options); err != nil {
+			return "", err
+		}
+
+		u, err := withDefaultPath(targetURL, influxDBWritePath)
+		if err != nil {
+			return "", err
+		}
+
+		return u.String(), nil
+	default:
+		return "", fmt.Errorf("unrecognized target flavor %s", f)
+	}
+}
+
+func (f TargetFlavor) requireSnappyProtobuf(options *RemoteMetricsWriterOptions) error {
+	if options.Format != Protobuf && options.Format != GoProtobuf {
+		return fmt.Errorf("%s does not accept the %s format", f, options.Format)
+	}
+
+	if options.Compression == None {
+		options.Compression = Snappy
+	}
+
+	if options.Compression != Snappy {
+		return fmt.Errorf("%s does not accept %s compression", f, options.Compression)
+	}
+
+	if options.CompressionMinBytes > 0 {
+		return fmt.Errorf("%s does not accept uncompressed requests", f)
+	}
+
+	return nil
+}
+
+func withDefaultPath(targetURL string, path string) (*url.URL, error) {
+	u, err := url.Parse(targetURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Path == "" || u.Path == "/" {
+		u.Path = path
+	}
+
+	return u, nil
+}
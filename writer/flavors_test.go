@@ -0,0 +1,177 @@
+package writer_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ = Describe("Target flavors", func() {
+	var s *httptest.Server
+	var lastRequest *http.Request
+	var failWith []int
+	var attempts int
+	var mu sync.Mutex
+	var r *prometheus.Registry
+
+	BeforeEach(func() {
+		lastRequest = nil
+		failWith = nil
+		attempts = 0
+		s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			mu.Lock()
+			lastRequest = req
+			attempts++
+			var code int
+			if len(failWith) > 0 {
+				code, failWith = failWith[0], failWith[1:]
+			}
+			mu.Unlock()
+
+			if code != 0 {
+				http.Error(w, http.StatusText(code), code)
+				return
+			}
+			receiveMetrics(w, req)
+		}))
+
+		r = prometheus.NewRegistry()
+		c := prometheus.NewCounter(prometheus.CounterOpts{Name: "flavored_total", Help: "flavored"})
+		Expect(r.Register(c)).Should(Succeed())
+		c.Inc()
+	})
+
+	AfterEach(func() {
+		s.Close()
+	})
+
+	Describe("VictoriaMetrics", func() {
+		It("Uses the VictoriaMetrics path, extra labels and snappy", func() {
+			w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+				HTTPClient:   s.Client(),
+				TargetFlavor: writer.VictoriaMetrics,
+				ExtraLabels:  map[string]string{"env": "prod", "dc": "east"},
+			}, r)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			_, err = w.WriteMetrics(context.Background())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(lastRequest).ShouldNot(BeNil())
+			Expect(lastRequest.URL.Path).Should(Equal("/api/v1/write"))
+			Expect(lastRequest.URL.Query()["extra_label"]).Should(Equal([]string{"dc=east", "env=prod"}))
+			Expect(lastRequest.Header.Get("Content-Encoding")).Should(Equal("snappy"))
+		})
+
+		It("Keeps an explicit path", func() {
+			w, err := writer.NewRemoteMetricsWriter(s.URL+"/insert/0/prometheus/api/v1/write", writer.RemoteMetricsWriterOptions{
+				HTTPClient:   s.Client(),
+				TargetFlavor: writer.VictoriaMetrics,
+			}, r)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			_, err = w.WriteMetrics(context.Background())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(lastRequest.URL.Path).Should(Equal("/insert/0/prometheus/api/v1/write"))
+		})
+
+		It("Rejects encodings VictoriaMetrics does not accept", func() {
+			_, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+				TargetFlavor: writer.VictoriaMetrics,
+				Compression:  writer.Gzip,
+			}, r)
+			Expect(err).Should(HaveOccurred())
+
+			_, err = writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+				TargetFlavor: writer.VictoriaMetrics,
+				Format:       writer.JSON,
+			}, r)
+			Expect(err).Should(HaveOccurred())
+
+			_, err = writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+				TargetFlavor:        writer.VictoriaMetrics,
+				CompressionMinBytes: 1024,
+			}, r)
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+
+	Describe("Thanos Receive", func() {
+		It("Sends the tenant header and uses the receive path", func() {
+			w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+				HTTPClient:   s.Client(),
+				TargetFlavor: writer.ThanosReceive,
+				Tenant:       "team-a",
+			}, r)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			_, err = w.WriteMetrics(context.Background())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(lastRequest.URL.Path).Should(Equal("/api/v1/receive"))
+			Expect(lastRequest.Header.Get("THANOS-TENANT")).Should(Equal("team-a"))
+			Expect(lastRequest.Header.Get("X-Scope-OrgID")).Should(BeEmpty())
+		})
+
+		It("Retries conflicts", func() {
+			failWith = []int{http.StatusConflict}
+			w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+				HTTPClient:   s.Client(),
+				TargetFlavor: writer.ThanosReceive,
+				MaxRetries:   1,
+				MinBackoff:   1,
+			}, r)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			_, err = w.WriteMetrics(context.Background())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(attempts).Should(Equal(2))
+		})
+	})
+
+	Describe("Generic", func() {
+		It("Sends the tenant as X-Scope-OrgID and does not retry conflicts", func() {
+			failWith = []int{http.StatusConflict}
+			w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+				HTTPClient: s.Client(),
+				Tenant:     "team-b",
+				MaxRetries: 1,
+				MinBackoff: 1,
+			}, r)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			_, err = w.WriteMetrics(context.Background())
+			Expect(err).Should(HaveOccurred())
+			Expect(attempts).Should(Equal(1))
+			Expect(lastRequest.Header.Get("X-Scope-OrgID")).Should(Equal("team-b"))
+		})
+	})
+
+	Describe("InfluxDB", func() {
+		It("Builds the compatibility endpoint URL and token header", func() {
+			w, err := writer.NewInfluxDBWriter(s.URL, "telegraf", "autogen", "s3cr3t", writer.RemoteMetricsWriterOptions{
+				HTTPClient: s.Client(),
+				Headers:    http.Header{"X-Extra": []string{"yes"}},
+			}, r)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			_, err = w.WriteMetrics(context.Background())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(lastRequest.URL.Path).Should(Equal("/api/v1/prom/write"))
+			Expect(lastRequest.URL.Query().Get("db")).Should(Equal("telegraf"))
+			Expect(lastRequest.URL.Query().Get("rp")).Should(Equal("autogen"))
+			Expect(lastRequest.Header.Get("Authorization")).Should(Equal("Token s3cr3t"))
+			Expect(lastRequest.Header.Get("X-Extra")).Should(Equal("yes"))
+			Expect(lastRequest.Header.Get("Content-Encoding")).Should(Equal("snappy"))
+		})
+
+		It("Requires a database", func() {
+			_, err := writer.NewInfluxDBWriter(s.URL, "", "", "", writer.RemoteMetricsWriterOptions{}, r)
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,129 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// NamedGatherer gives g a name, which the writer uses instead of its index to attribute gather errors and counts when
+// it has several gatherers
+func NamedGatherer(name string, g prometheus.Gatherer) prometheus.Gatherer {
+	return namedGatherer{Gatherer: g, name: name}
+}
+
+type namedGatherer struct {
+	prometheus.Gatherer
+	name string
+}
+
+// GatherResult describes what one of the writer's gatherers returned during a push. Index is the gatherer's position
+// among those the writer was created with, and Name the name it was given with NamedGatherer, if any. Metrics counts
+// the metrics of all the families gathered, which are series before histograms and summaries are expanded into their
+// buckets and quantiles
+type GatherResult struct {
+	Index    int
+	Name     string
+	Families int
+	Metrics  int
+	Err      error
+}
+
+// gatherer returns Name, or the index of a gatherer without one
+func (r GatherResult) gatherer() string {
+	if r.Name != "" {
+		return strconv.Quote(r.Name)
+	}
+
+	return strconv.Itoa(r.Index)
+}
+
+// GatherError is the error one of the writer's gatherers failed with
+type GatherError struct {
+	Index int
+	Name  string
+	Err   error
+}
+
+// Error returns the gatherer's error, prefixed by its name or index
+func (e *GatherError) Error() string {
+	return fmt.Sprintf("gatherer %s: %v", GatherResult{Index: e.Index, Name: e.Name}.gatherer(), e.Err)
+}
+
+// Unwrap returns the gatherer's error
+func (e *GatherError) Unwrap() error {
+	return e.Err
+}
+
+// gather gathers from each of the writer's gatherers on its own, so that errors and counts can be attributed to the
+// gatherer they came from, then merges their families the way prometheus.Gatherers does. Like prometheus.Gatherers,
+// it returns whatever could be gathered along with any error, which joins a GatherError for every gatherer that failed
+func (w *writerImpl) gather() ([]*dto.MetricFamily, []GatherResult, error) {
+	results := make([]GatherResult, len(w.gatherers))
+	gathered := make(prometheus.Gatherers, len(w.gatherers))
+	var errs []error
+	for i, g := range w.gatherers {
+		results[i].Index = i
+		if named, ok := g.(namedGatherer); ok {
+			results[i].Name = named.name
+		}
+
+		families, err := g.Gather()
+		results[i].Families = len(families)
+		for _, mf := range families {
+			results[i].Metrics += len(mf.GetMetric())
+		}
+
+		if err != nil {
+			// so errors.Is and errors.As see the collectors' errors
+			if multi, ok := err.(prometheus.MultiError); ok {
+				err = errors.Join(multi...)
+			}
+			results[i].Err = err
+			errs = append(errs, &GatherError{Index: i, Name: results[i].Name, Err: err})
+		}
+
+		gathered[i] = prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return families, nil })
+	}
+
+	// what is left are inconsistencies between gatherers, such as the same family with different types
+	families, err := gathered.Gather()
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return families, results, errors.Join(errs...)
+}
+
+// gatherWithin is gather bounded by GatherTimeout. A gather that runs over is left running in the background, because
+// Gather cannot be cancelled, and its results are discarded when it finishes
+func (w *writerImpl) gatherWithin(ctx context.Context) ([]*dto.MetricFamily, []GatherResult, error) {
+	if w.gatherTimeout <= 0 {
+		return w.gather()
+	}
+
+	type gathered struct {
+		families []*dto.MetricFamily
+		results  []GatherResult
+		err      error
+	}
+
+	done := make(chan gathered, 1)
+	go func() {
+		families, results, err := w.gather()
+		done <- gathered{families: families, results: results, err: err}
+	}()
+
+	select {
+	case g := <-done:
+		return g.families, g.results, g.err
+	case <-w.clock.After(w.gatherTimeout):
+		return nil, nil, fmt.Errorf("%w after %s", ErrGatherTimeout, w.gatherTimeout)
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+}
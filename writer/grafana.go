@@ -0,0 +1,61 @@
+package writer
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	grafanaCloudPushPath          = "/api/prom/push"
+	grafanaCloudDefaultMaxRetries = 3
+)
+
+// NewGrafanaCloudWriter creates a RemoteMetricsWriter for a Grafana Cloud hosted Prometheus (Mimir) stack. stackURL
+// is the stack's Prometheus URL as shown in the Grafana Cloud portal; /api/prom/push is added when it has no path.
+// instanceID and apiKey (an access policy token) are sent using basic authentication. Unless options say otherwise,
+// metrics are sent as snappy compressed protobuf, and failed pushes are retried up to 3 times. As a MaxRetries of zero
+// stands for that default here, set it to a negative number to turn retries off.
+func NewGrafanaCloudWriter(stackURL string, instanceID string, apiKey string, options RemoteMetricsWriterOptions, gatherers ...prometheus.Gatherer) (RemoteMetricsWriter, error) {
+	if strings.TrimSpace(instanceID) == "" {
+		return nil, errors.New("instanceID must be set")
+	}
+
+	if strings.TrimSpace(apiKey) == "" {
+		return nil, errors.New("apiKey must be set")
+	}
+
+	u, err := url.Parse(strings.TrimSpace(stackURL))
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Host == "" {
+		return nil, errors.New("stackURL must be an absolute URL")
+	}
+
+	if u.Path == "" || u.Path == "/" {
+		u.Path = grafanaCloudPushPath
+	}
+
+	if options.Format == 0 {
+		options.Format = Protobuf
+	}
+
+	if options.Compression == None {
+		options.Compression = Snappy
+	}
+
+	if options.MaxRetries == 0 {
+		options.MaxRetries = grafanaCloudDefaultMaxRetries
+	}
+
+	options.BasicAuth = &BasicAuth{
+		Username: strings.TrimSpace(instanceID),
+		Password: strings.TrimSpace(apiKey),
+	}
+
+	return NewRemoteMetricsWriter(u.String(), options, gatherers...)
+}
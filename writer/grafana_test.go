@@ -0,0 +1,97 @@
+package writer_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ = Describe("Grafana Cloud", func() {
+	var s *httptest.Server
+	var lastRequest *http.Request
+	var failures atomic.Int32
+	var attempts atomic.Int32
+	var r *prometheus.Registry
+
+	BeforeEach(func() {
+		lastRequest = nil
+		failures.Store(0)
+		attempts.Store(0)
+		s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			attempts.Add(1)
+			lastRequest = req
+			if failures.Add(-1) >= 0 {
+				http.Error(w, "try again", http.StatusServiceUnavailable)
+				return
+			}
+			receiveMetrics(w, req)
+		}))
+
+		r = prometheus.NewRegistry()
+		g := prometheus.NewGauge(prometheus.GaugeOpts{Name: "grafana_cloud_gauge", Help: "gauge"})
+		Expect(r.Register(g)).Should(Succeed())
+	})
+
+	AfterEach(func() {
+		s.Close()
+	})
+
+	It("Sets the push path, credentials and recommended encodings", func() {
+		w, err := writer.NewGrafanaCloudWriter(s.URL, "123456", "glc_secret", writer.RemoteMetricsWriterOptions{
+			HTTPClient: s.Client(),
+		}, r)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteMetrics(context.Background())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(lastRequest.URL.Path).Should(Equal("/api/prom/push"))
+		Expect(lastRequest.Header.Get("Content-Encoding")).Should(Equal("snappy"))
+		Expect(lastRequest.Header.Get("Content-Type")).Should(Equal("application/x-protobuf"))
+
+		user, pass, ok := lastRequest.BasicAuth()
+		Expect(ok).Should(BeTrue())
+		Expect(user).Should(Equal("123456"))
+		Expect(pass).Should(Equal("glc_secret"))
+	})
+
+	It("Retries transient failures", func() {
+		failures.Store(2)
+		w, err := writer.NewGrafanaCloudWriter(s.URL, "123456", "glc_secret", writer.RemoteMetricsWriterOptions{
+			HTTPClient: s.Client(),
+			MinBackoff: 1,
+		}, r)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteMetrics(context.Background())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(attempts.Load()).Should(BeNumerically("==", 3))
+	})
+
+	It("Does not retry when MaxRetries is negative", func() {
+		failures.Store(2)
+		w, err := writer.NewGrafanaCloudWriter(s.URL, "123456", "glc_secret", writer.RemoteMetricsWriterOptions{
+			HTTPClient: s.Client(),
+			MinBackoff: 1,
+			MaxRetries: -1,
+		}, r)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteMetrics(context.Background())
+		Expect(err).Should(HaveOccurred())
+		Expect(attempts.Load()).Should(BeNumerically("==", 1))
+	})
+
+	It("Requires credentials", func() {
+		_, err := writer.NewGrafanaCloudWriter(s.URL, "", "glc_secret", writer.RemoteMetricsWriterOptions{}, r)
+		Expect(err).Should(HaveOccurred())
+
+		_, err = writer.NewGrafanaCloudWriter(s.URL, "123456", " ", writer.RemoteMetricsWriterOptions{}, r)
+		Expect(err).Should(HaveOccurred())
+	})
+})
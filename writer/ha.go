@@ -0,0 +1,67 @@
+package writer
+
+import (
+	"sort"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const (
+	// DefaultHAClusterLabel and DefaultHAReplicaLabel are the label names the Mimir and Cortex HA trackers expect
+	DefaultHAClusterLabel = "cluster"
+	DefaultHAReplicaLabel = "__replica__"
+)
+
+// haLabels returns the cluster and replica labels the writer stamps on every series, sorted by name
+func haLabels(options RemoteMetricsWriterOptions) []prompb.Label {
+	var labels []prompb.Label
+	if options.HACluster != "" {
+		labels = append(labels, prompb.Label{Name: options.HAClusterLabel, Value: options.HACluster})
+	}
+	if options.HAReplica != "" {
+		labels = append(labels, prompb.Label{Name: options.HAReplicaLabel, Value: options.HAReplica})
+	}
+
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].Name < labels[j].Name
+	})
+
+	return labels
+}
+
+// stampLabels returns a copy of series with extra added to every series' labels, replacing labels with the same name
+// and keeping them sorted. The series passed in are left untouched
+func stampLabels(series []prompb.TimeSeries, extra []prompb.Label) []prompb.TimeSeries {
+	if len(extra) == 0 {
+		return series
+	}
+
+	stamped := make([]prompb.TimeSeries, len(series))
+	for i, ts := range series {
+		labels := make([]prompb.Label, 0, len(ts.Labels)+len(extra))
+		for _, l := range ts.Labels {
+			if !hasLabel(extra, l.Name) {
+				labels = append(labels, l)
+			}
+		}
+		labels = append(labels, extra...)
+		sort.Slice(labels, func(i, j int) bool {
+			return labels[i].Name < labels[j].Name
+		})
+
+		ts.Labels = labels
+		stamped[i] = ts
+	}
+
+	return stamped
+}
+
+func hasLabel(labels []prompb.Label, name string) bool {
+	for _, l := range labels {
+		if l.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,81 @@
+package writer_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("HA labels", func() {
+	var s *httptest.Server
+	var received prompb.WriteRequest
+
+	BeforeEach(func() {
+		received = prompb.WriteRequest{}
+		s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			defer req.Body.Close()
+			body, err := io.ReadAll(req.Body)
+			if err == nil {
+				err = received.Unmarshal(body)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	})
+
+	AfterEach(func() {
+		s.Close()
+	})
+
+	It("Stamps the cluster and replica labels on every series", func() {
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient: s.Client(),
+			HACluster:  "prod",
+			HAReplica:  "agent-1",
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		series := []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "cluster", Value: "old"}, {Name: "job", Value: "x"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+		}}
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(received.Timeseries[0].Labels).Should(Equal([]prompb.Label{
+			{Name: "__name__", Value: "up"},
+			{Name: "__replica__", Value: "agent-1"},
+			{Name: "cluster", Value: "prod"},
+			{Name: "job", Value: "x"},
+		}))
+		Expect(series[0].Labels[1].Value).Should(Equal("old"))
+	})
+
+	It("Uses custom label names", func() {
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:     s.Client(),
+			HAReplica:      "b",
+			HAReplicaLabel: "replica",
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+		}}, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(received.Timeseries[0].Labels).Should(Equal([]prompb.Label{
+			{Name: "__name__", Value: "up"},
+			{Name: "replica", Value: "b"},
+		}))
+	})
+})
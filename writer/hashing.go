@@ -0,0 +1,61 @@
+package writer
+
+import (
+	"hash/fnv"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// fingerprint hashes a series' labels, which are expected to be sorted
+func fingerprint(labels []prompb.Label) uint64 {
+	h := fnv.New64a()
+	for _, l := range labels {
+		h.Write([]byte(l.Name))
+		h.Write([]byte{0xff})
+		h.Write([]byte(l.Value))
+		h.Write([]byte{0xff})
+	}
+
+	return h.Sum64()
+}
+
+// jumpHash maps key onto one of n buckets with Lamping and Veach's jump consistent hash: when n changes, only the keys
+// that have to move to keep the buckets balanced change bucket
+func jumpHash(key uint64, n int) int {
+	b, j := int64(-1), int64(0)
+	for j < int64(n) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+
+	return int(b)
+}
+
+// rendezvous picks the target with the highest hash of key and the target's URL. Unlike jumpHash it does not depend
+// on the order of the targets, and when one is added or removed only the series that hash to it move
+func rendezvous(key uint64, targets []*targetState) *targetState {
+	var best *targetState
+	var bestScore uint64
+	for _, t := range targets {
+		h := fnv.New64a()
+		h.Write([]byte(t.url))
+		score := mix(h.Sum64() ^ key)
+		if best == nil || score > bestScore {
+			best, bestScore = t, score
+		}
+	}
+
+	return best
+}
+
+// mix is the 64 bit finalizer of MurmurHash3, which spreads the bits of the combined hashes
+func mix(k uint64) uint64 {
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	k ^= k >> 33
+
+	return k
+}
@@ -0,0 +1,35 @@
+package writer
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// HeartbeatName is the name of the series a writer with Heartbeat set sends with every push
+const HeartbeatName = "remote_write_client_up"
+
+// heartbeat builds the heartbeat series, with a sample of 1 at now, and its metadata
+func heartbeat(labels map[string]string, now time.Time) (prompb.TimeSeries, prompb.MetricMetadata) {
+	ls := make([]prompb.Label, 0, len(labels)+1)
+	ls = append(ls, prompb.Label{Name: "__name__", Value: HeartbeatName})
+	for name, value := range labels {
+		if name != "__name__" {
+			ls = append(ls, prompb.Label{Name: name, Value: value})
+		}
+	}
+	slices.SortFunc(ls, func(a, b prompb.Label) int { return strings.Compare(a.Name, b.Name) })
+
+	series := prompb.TimeSeries{
+		Labels:  ls,
+		Samples: []prompb.Sample{{Value: 1, Timestamp: now.UnixMilli()}},
+	}
+
+	return series, prompb.MetricMetadata{
+		Type:             prompb.MetricMetadata_GAUGE,
+		MetricFamilyName: HeartbeatName,
+		Help:             "Always 1 while the remote write client is pushing",
+	}
+}
@@ -0,0 +1,104 @@
+package writer
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// RequestTimings breaks the time a request took down into its network phases, so that a slow network can be told
+// apart from a slow receiver. DNS, Connect and TLS are zero when the request reused a connection.
+//
+//	TimeToFirstByte runs from the request being fully written to the first byte of the response, which is mostly the
+//	time the receiver spent handling it
+//	Total runs from the start of the request to the first byte of the response
+type RequestTimings struct {
+	DNS             time.Duration
+	Connect         time.Duration
+	TLS             time.Duration
+	TimeToFirstByte time.Duration
+	Total           time.Duration
+	ReusedConn      bool
+}
+
+// requestTracer collects RequestTimings through an httptrace.ClientTrace. The trace's hooks can be called from other
+// goroutines, so they take mu
+type requestTracer struct {
+	mu       sync.Mutex
+	start    time.Time
+	dnsStart time.Time
+	dialed   time.Time
+	tlsStart time.Time
+	wrote    time.Time
+	timings  RequestTimings
+}
+
+// trace returns ctx with t's hooks attached
+func (t *requestTracer) trace(ctx context.Context) context.Context {
+	t.start = time.Now()
+
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.timings.DNS = time.Since(t.dnsStart)
+		},
+		ConnectStart: func(string, string) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			// with several addresses to try, the connect phase runs from the first attempt to the one that succeeds
+			if t.dialed.IsZero() {
+				t.dialed = time.Now()
+			}
+		},
+		ConnectDone: func(_, _ string, err error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if err == nil {
+				t.timings.Connect = time.Since(t.dialed)
+			}
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.timings.TLS = time.Since(t.tlsStart)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.timings.ReusedConn = info.Reused
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.wrote = time.Now()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if !t.wrote.IsZero() {
+				t.timings.TimeToFirstByte = time.Since(t.wrote)
+			}
+			t.timings.Total = time.Since(t.start)
+		},
+	})
+}
+
+func (t *requestTracer) result() RequestTimings {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.timings
+}
@@ -2,27 +2,27 @@ package writer
 
 import (
 	"bytes"
+	"cmp"
 	"context"
+	"crypto/rand"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"slices"
+	"time"
 
-	"github.com/jghiloni/go-commonutils/v2/slices"
+	"github.com/jghiloni/prometheus-remote-write/convert"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/prometheus/prompb"
 )
 
-type valued interface {
-	GetValue() float64
-}
-
-type hasExemplar interface {
-	GetExemplar() *dto.Exemplar
-}
-
 // WriteMetrics takes all the metrics from the gatherers specified when the RemoteMetricsWriter was created,
 // converts them into a list of Timeseries and Metadata, then serializes and compresses it before sending to
-// the target endpoint. If sent successfully, it will return the number of timeseries actually sent to the
-// server. If an error occurs, no partial data will be sent, and the number returned will always be 0.
+// the target endpoint. It returns the number of timeseries actually sent to the server. A push that is split
+// between tenants, routes, targets or requests can partly succeed, and a truncating limit sends only part of it, so a
+// non-zero number may come back along with an error describing what was not sent. With CollapseConcurrentWrites, a
+// call made while another is in progress returns that call's result instead.
 func (w *writerImpl) WriteMetrics(ctx context.Context) (int, error) {
 	if ctx == nil {
 		return 0, ErrNilContext
@@ -32,159 +32,525 @@ func (w *writerImpl) WriteMetrics(ctx context.Context) (int, error) {
 		return 0, ErrNoGatherersDefined
 	}
 
-	metricFamilies, err := w.gatherers.Gather()
+	return w.gathers.do(ctx, func() (int, error) {
+		return w.timed(ctx, func(ctx context.Context) (int, error) {
+			start := time.Now()
+			metricFamilies, results, err := w.gatherWithin(ctx)
+			statsFrom(ctx).record(gatherPhase, start)
+			if errors.Is(err, ErrGatherTimeout) {
+				w.log().WarnContext(ctx, "gathering metrics timed out", slog.String("url", w.targetURL),
+					slog.Duration("timeout", w.gatherTimeout))
+			}
+			for _, r := range results {
+				if r.Err != nil {
+					w.log().WarnContext(ctx, "gathering metrics failed", slog.String("url", w.targetURL),
+						slog.String("gatherer", r.gatherer()), slog.Int("metrics", r.Metrics), slog.Any("error", r.Err))
+				}
+			}
+			if w.onGather != nil && results != nil {
+				w.onGather(results)
+			}
+			if err != nil {
+				if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+					return 0, err
+				}
+				return 0, fmt.Errorf("%w: %w", ErrGather, err)
+			}
+
+			if err = ctx.Err(); err != nil {
+				return 0, err
+			}
+
+			return w.WriteMetricFamilies(ctx, metricFamilies)
+		})
+	})
+}
+
+// WriteMetricFamilies converts and sends metric families that did not come from the writer's gatherers, such as the
+// result of convert.ParseText. It behaves exactly like WriteMetrics once the metrics have been gathered.
+func (w *writerImpl) WriteMetricFamilies(ctx context.Context, metricFamilies []*dto.MetricFamily) (int, error) {
+	if ctx == nil {
+		return 0, ErrNilContext
+	}
+
+	if len(metricFamilies) == 0 && !w.heartbeat {
+		return 0, nil
+	}
+
+	return w.timed(ctx, func(ctx context.Context) (int, error) {
+		return w.writeMetricFamilies(ctx, metricFamilies)
+	})
+}
+
+func (w *writerImpl) writeMetricFamilies(ctx context.Context, metricFamilies []*dto.MetricFamily) (int, error) {
+	start := time.Now()
+	wr, err := w.convertFamilies(ctx, metricFamilies, w.tracker)
 	if err != nil {
 		return 0, err
 	}
+	statsFrom(ctx).record(convertPhase, start)
 
-	ts := make([]prompb.TimeSeries, 0, len(metricFamilies))
-	metadata := make([]prompb.MetricMetadata, 0, len(metricFamilies))
+	return w.write(ctx, wr)
+}
 
-	if len(metricFamilies) == 0 {
+// convertFamilies converts families into the request a push sends, with the writer's target_info and heartbeat series.
+// Validate and EstimateSize convert without the tracker, so that they cannot change the reset hints, zero samples or
+// metadata of the next push
+func (w *writerImpl) convertFamilies(ctx context.Context, families []*dto.MetricFamily, tracker *convert.SeriesTracker) (prompb.WriteRequest, error) {
+	ts, metadata, err := convert.FromMetricFamiliesContext(ctx, families, convert.MetricFamilyOptions{
+		Timestamp:                w.clock.Now(),
+		Tracker:                  tracker,
+		CreatedTimestampZeros:    w.createdTimestampZeros,
+		MissingExemplarTimestamp: w.missingExemplarTS,
+		TimestampFunc:            w.timestampFunc,
+		DropZeroCounters:         w.dropZeroCounters,
+		CounterResetZeros:        w.counterResetZeros,
+		Interner:                 w.interner,
+		MergeDuplicateSeries:     w.mergeDuplicateSeries,
+		NameLabelPolicy:          w.nameLabelPolicy,
+		ValueTransforms:          w.valueTransforms,
+		NormalizeUnits:           w.normalizeUnits,
+		UntypedAsGauge:           w.untypedAsGauge,
+		UntypedGaugeSuffix:       w.untypedGaugeSuffix,
+		NewSeriesMetadataOnly:    w.newSeriesMetadataOnly,
+	})
+	if err != nil {
+		return prompb.WriteRequest{}, err
+	}
+
+	if len(w.resourceAttributes) > 0 {
+		info, infoMetadata := convert.TargetInfo(w.resourceAttributes, w.clock.Now())
+		ts = append(ts, info)
+		metadata = append(metadata, infoMetadata)
+	}
+
+	if w.heartbeat {
+		beat, beatMetadata := heartbeat(w.heartbeatLabels, w.clock.Now())
+		ts = append(ts, beat)
+		metadata = append(metadata, beatMetadata)
+	}
+
+	return prompb.WriteRequest{Timeseries: ts, Metadata: metadata}, nil
+}
+
+// WriteTimeSeries sends series and metadata that were built elsewhere (for example by the convert package) to the
+// target endpoint, serialized and compressed the same way WriteMetrics does. It returns the number of series sent.
+func (w *writerImpl) WriteTimeSeries(ctx context.Context, series []prompb.TimeSeries, metadata []prompb.MetricMetadata) (int, error) {
+	if ctx == nil {
+		return 0, ErrNilContext
+	}
+
+	if len(series) == 0 && len(metadata) == 0 {
 		return 0, nil
 	}
 
-	for _, metricsFamily := range metricFamilies {
-		metadata = append(metadata, prompb.MetricMetadata{
-			Type:             prompb.MetricMetadata_MetricType(metricsFamily.GetType()),
-			MetricFamilyName: metricsFamily.GetName(),
-			Help:             metricsFamily.GetHelp(),
-			Unit:             metricsFamily.GetUnit(),
+	return w.timed(ctx, func(ctx context.Context) (int, error) {
+		return w.write(ctx, prompb.WriteRequest{
+			Timeseries: series,
+			Metadata:   metadata,
 		})
+	})
+}
+
+// write sends wr, rejecting or coalescing it if the writer has a MinPushInterval that has not passed since the last
+// push
+func (w *writerImpl) write(ctx context.Context, wr prompb.WriteRequest) (int, error) {
+	return w.throttle.do(ctx, wr, w.push)
+}
+
+// push sends wr, split into one request per route and tenant if the writer has Routes or a TenantLabel, with its
+// metadata dropped if the writer does not send metadata, or sent separately, to the destination of the series it
+// describes, if the writer has a MetadataSendInterval or wr carries more than MaxMetadataPerSend entries.
+// Every request is attempted even if an earlier one fails; the number of series sent successfully is returned with any
+// errors joined
+func (w *writerImpl) push(ctx context.Context, wr prompb.WriteRequest) (int, error) {
+	p, err := w.prepare(wr)
+	if err != nil {
+		return 0, err
+	}
+	if p.drops.Dropped() > 0 && w.onExemplarsDropped != nil {
+		w.onExemplarsDropped(p.drops)
+	}
+
+	errs := p.errs
+	if p.metadata != nil {
+		if err := w.writeMetadata(ctx, p.metadata); err != nil {
+			errs = append(errs, fmt.Errorf("metadata: %w", err))
+		}
+	}
+
+	if len(p.batches) == 0 {
+		return 0, errors.Join(errs...)
+	}
 
-		ts = append(ts, getTimeseries(metricsFamily)...)
+	if len(p.batches) == 1 && len(errs) == 0 {
+		return w.deliver(ctx, p.batches[0].request, p.batches[0].destination)
 	}
 
-	wr := prompb.WriteRequest{
-		Timeseries: ts,
-		Metadata:   metadata,
+	sent := 0
+	for _, b := range p.batches {
+		n, err := w.deliver(ctx, b.request, b.destination)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", b.destination, err))
+			continue
+		}
+		sent += n
 	}
 
-	uncompressed, err := w.format.Marshal(wr)
+	return sent, errors.Join(errs...)
+}
+
+// preparedPush is a push that has been through the writer's limits and checks and divided between its destinations,
+// ready to be delivered
+type preparedPush struct {
+	// batches are the requests to deliver, at most one per destination
+	batches []batch
+	// metadata is the metadata due to be sent in metadata-only requests, by destination
+	metadata []batch
+	drops    ExemplarDrops
+	// errs are the problems that did not stop the push, such as the series a limit left out
+	errs []error
+}
+
+// prepare takes wr through everything a push does before delivering it, without sending anything or calling any
+// callbacks, so that Validate and EstimateSize see the requests a push would make
+func (w *writerImpl) prepare(wr prompb.WriteRequest) (preparedPush, error) {
+	var p preparedPush
+	// duplicate labels are dealt with first, as relabeling sorts the labels and would lose which of them came first
+	series, err := w.checkDuplicateLabels(wr.Timeseries)
 	if err != nil {
-		return 0, err
+		return preparedPush{}, err
 	}
 
-	compressed, err := w.encoding.Compress(uncompressed)
+	series, err = w.applySeriesLimit(w.relabelSeries(series))
+	if err != nil {
+		p.errs = append(p.errs, err)
+	}
+
+	series, err = w.applySampleLimit(series)
+	if err != nil {
+		if w.sampleLimitAction != SampleLimitTruncate {
+			return preparedPush{}, err
+		}
+		p.errs = append(p.errs, err)
+	}
+
+	series, p.drops = w.checkExemplars(series)
+	wr.Timeseries = stampLabels(sortSeries(series), w.haLabels)
+
+	if !w.sendMetadata {
+		wr.Metadata = nil
+	}
+	wr.Metadata = w.trimHelp(wr.Metadata)
+
+	if len(wr.Timeseries) == 0 && len(wr.Metadata) == 0 {
+		return p, nil
+	}
+
+	p.batches = w.split(wr)
+	p.metadata = w.separateMetadata(p.batches)
+	p.batches = slices.DeleteFunc(p.batches, func(b batch) bool {
+		return len(b.request.Timeseries) == 0 && len(b.request.Metadata) == 0
+	})
+
+	return p, nil
+}
+
+// writeTo sends wr to dest, in several requests if the target has rejected requests as large as wr before. A request
+// the target rejects with 413 Payload Too Large is split in half by samples and each half sent on its own, down to
+// MinSplitSamples samples
+func (w *writerImpl) writeTo(ctx context.Context, wr prompb.WriteRequest, dest destination) (int, error) {
+	sampleLimit := w.sampleLimitFor(dest.targetURL)
+	limit := sampleLimit.get()
+	budgetLimit, err := w.memoryBudgetLimit(wr)
 	if err != nil {
 		return 0, err
 	}
+	if budgetLimit > 0 && (limit <= 0 || budgetLimit < limit) {
+		limit = budgetLimit
+	}
+
+	chunks := chunk(wr, limit)
+	if len(chunks) == 1 {
+		return w.writeRequest(ctx, wr, dest)
+	}
+
+	var errs []error
+	sent := 0
+	for _, c := range chunks {
+		if _, err := w.writeRequest(ctx, c.request, dest); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		sent += c.series
+	}
+
+	if len(errs) == 0 && limit == sampleLimit.get() {
+		sampleLimit.accepted()
+	}
+
+	return sent, errors.Join(errs...)
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.targetURL, bytes.NewBuffer(compressed))
+func (w *writerImpl) writeRequest(ctx context.Context, wr prompb.WriteRequest, dest destination) (int, error) {
+	// marshalling and compressing a large request takes a while, so a push cancelled before then is abandoned
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	format, encoding, version, target := w.encodingFor(dest.targetURL)
+
+	// a resolver can hand out targets with any version, so the version is checked on every request too
+	if err := checkRemoteWriteVersion(cmp.Or(version, w.version), format); err != nil {
+		return 0, err
+	}
+	// 2.0 carries metadata on the series it describes, so a 2.x receiver has no use for a metadata-only request
+	if isRemoteWriteV2(cmp.Or(version, w.version)) && len(wr.Timeseries) == 0 {
+		return 0, nil
+	}
+
+	stats := statsFrom(ctx)
+	start := time.Now()
+	uncompressed, release, err := marshalFor(format, cmp.Or(version, w.version), wr)
+	stats.record(marshalPhase, start)
 	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrMarshal, err)
+	}
+
+	if err = ctx.Err(); err != nil {
+		release()
 		return 0, err
 	}
 
-	req.Header.Add("X-Prometheus-Remote-Write-Version", w.version)
-	w.format.UpdateRequest(req)
-	w.encoding.UpdateRequest(req)
+	if len(uncompressed) < w.compressionMinBytes {
+		encoding = None
+	}
 
-	resp, err := w.hc.Do(req)
+	start = time.Now()
+	compressed, err := encoding.Compress(uncompressed)
+	stats.record(compressPhase, start)
+	// compressing copies the payload out of the marshal buffer, but an uncompressed body is the buffer itself, so it
+	// only goes back to the pool once the request is done with it. A Sender, fallback or mirror may keep the payload
+	// for longer, so they are given a copy
+	switch {
+	case encoding != None:
+		release()
+	case w.sender != nil || w.fallback != nil || w.mirrors != nil:
+		compressed = bytes.Clone(compressed)
+		release()
+	default:
+		defer release()
+	}
 	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrCompress, err)
+	}
+
+	// the body of a 2.0 request cannot be read back without its version, so the payload always records it
+	if version == "" && isRemoteWriteV2(w.version) {
+		version = w.version
+	}
+
+	payload := Payload{
+		Body:               compressed,
+		Format:             format,
+		Compression:        encoding,
+		RemoteWriteVersion: version,
+		TargetURL:          dest.targetURL,
+		Tenant:             dest.tenant,
+		CreatedAt:          w.clock.Now(),
+	}
+	if w.idempotencyKeyHeader != "" {
+		payload.IdempotencyKey = rand.Text()
+	}
+
+	w.mirror(ctx, payload)
+
+	start = time.Now()
+	network, err := w.send(ctx, payload)
+	stats.record(sendPhase, start)
+	if err == nil {
+		samples := batchSamples(wr.Timeseries)
+		stats.sent(samples, len(payload.Body))
+		if target != nil {
+			target.addBytes(len(payload.Body), len(uncompressed))
+		}
+
+		if w.onPayload != nil {
+			w.onPayload(PayloadStats{
+				TargetURL:         dest.targetURL,
+				Tenant:            dest.tenant,
+				Format:            format,
+				Compression:       encoding,
+				Series:            len(wr.Timeseries),
+				Samples:           samples,
+				UncompressedBytes: len(uncompressed),
+				CompressedBytes:   len(payload.Body),
+				Network:           network,
+			})
+		}
+
+		w.archive(ctx, payload, true)
+		return len(wr.Timeseries), nil
+	}
+
+	if isTooLarge(err) {
+		if halves, ok := splitMetadata(wr); ok {
+			return 0, w.writeHalves(ctx, halves, dest)
+		}
+		if samples := batchSamples(wr.Timeseries); len(wr.Timeseries) > 0 && samples >= w.minSplitSamples {
+			w.sampleLimitFor(dest.targetURL).tooLarge(samples)
+			return w.writeTo(ctx, wr, dest)
+		}
+	}
+
+	// a hedged push that lost the race was not a failure, just unnecessary
+	if errors.Is(context.Cause(ctx), errHedgeLost) {
 		return 0, err
 	}
-	resp.Body.Close()
 
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return 0, fmt.Errorf("expected 2xx HTTP code, but got %s", resp.Status)
+	w.archive(ctx, payload, false)
+	if w.fallback == nil {
+		return 0, err
+	}
+
+	// the payload is safe once the fallback has it, so the push counts as a success
+	if fallbackErr := w.fallback.Send(ctx, payload); fallbackErr != nil {
+		return 0, errors.Join(err, fmt.Errorf("fallback: %w", fallbackErr))
 	}
 
-	return len(ts), nil
+	return len(wr.Timeseries), nil
 }
 
-func convertLabels(dtoLabels []*dto.LabelPair) []prompb.Label {
-	return slices.Map(dtoLabels, func(lp *dto.LabelPair) prompb.Label {
-		return prompb.Label{
-			Name:  lp.GetName(),
-			Value: lp.GetValue(),
+// send delivers the payload, retrying according to the writer's retry settings. It returns the network timings of the
+// last attempt, which are zero if the payload was handed to a Sender
+func (w *writerImpl) send(ctx context.Context, payload Payload) (RequestTimings, error) {
+	if w.retryBudget != nil {
+		w.retryBudget.request(w.clock.Now())
+	}
+
+	start := w.clock.Now()
+	backoff := w.minBackoff
+	for attempt := 0; ; attempt++ {
+		timings, err := w.attempt(ctx, payload)
+		if err == nil || attempt >= w.maxRetries || !w.isRetryable(err) {
+			return timings, err
 		}
-	})
+
+		if w.maxRetryElapsed > 0 && w.clock.Now().Sub(start)+backoff > w.maxRetryElapsed {
+			return timings, fmt.Errorf("retry deadline exceeded after %d attempts: %w", attempt+1, err)
+		}
+
+		if w.retryBudget != nil && !w.retryBudget.allowRetry(w.clock.Now()) {
+			return timings, fmt.Errorf("retry budget exhausted: %w", err)
+		}
+
+		if err = sleep(ctx, w.clock, backoff); err != nil {
+			return timings, err
+		}
+		statsFrom(ctx).retried()
+		backoff = min(backoff*2, w.maxBackoff)
+	}
 }
 
-func convertExemplar(e *dto.Exemplar) prompb.Exemplar {
-	return prompb.Exemplar{
-		Labels:    convertLabels(e.GetLabel()),
-		Value:     e.GetValue(),
-		Timestamp: e.GetTimestamp().AsTime().UnixMilli(),
+// describe sets the headers that tell the receiver how payload is encoded. A payload without a format or remote write
+// version of its own is described with the writer's
+func (w *writerImpl) describe(req *http.Request, payload Payload) {
+	version := payload.RemoteWriteVersion
+	if version == "" {
+		version = w.version
 	}
+
+	format := payload.Format
+	if format == 0 {
+		format = w.format
+	}
+
+	req.Header.Add("X-Prometheus-Remote-Write-Version", version)
+	format.UpdateRequest(req)
+	if isRemoteWriteV2(version) {
+		describeV2(req)
+	}
+	payload.Compression.UpdateRequest(req)
 }
 
-func convertBucketSpans(spans []*dto.BucketSpan) []prompb.BucketSpan {
-	return slices.Map(spans, func(s *dto.BucketSpan) prompb.BucketSpan {
-		return prompb.BucketSpan{
-			Offset: s.GetOffset(),
-			Length: s.GetLength(),
-		}
-	})
+func (w *writerImpl) attempt(ctx context.Context, payload Payload) (RequestTimings, error) {
+	if w.sendTimeout <= 0 {
+		return w.request(ctx, payload)
+	}
+
+	attemptCtx, cancel := context.WithTimeoutCause(ctx, w.sendTimeout, ErrSendTimeout)
+	defer cancel()
+
+	timings, err := w.request(attemptCtx, payload)
+	// the push's own deadline or cancellation is reported as it is; only the request's deadline is a send timeout
+	timedOut := ctx.Err() == nil && errors.Is(context.Cause(attemptCtx), ErrSendTimeout)
+	if timedOut && errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("%w: %w after %s", ErrSend, ErrSendTimeout, w.sendTimeout)
+	}
+
+	return timings, err
 }
 
-func getTimeseries(family *dto.MetricFamily) []prompb.TimeSeries {
-	if family.GetMetric() == nil {
-		return nil
-	}
-
-	ts := make([]prompb.TimeSeries, len(family.GetMetric()))
-	for i, metric := range family.GetMetric() {
-		prompbLabels := convertLabels(metric.Label)
-		prompbLabels = append(prompbLabels, prompb.Label{Name: "__name__", Value: family.GetName()})
-
-		var samplerMetric any
-		var histogram *dto.Histogram
-		switch {
-		case metric.GetGauge() != nil:
-			samplerMetric = metric.GetGauge()
-		case metric.GetCounter() != nil:
-			samplerMetric = metric.GetCounter()
-		case metric.GetUntyped() != nil:
-			samplerMetric = metric.GetUntyped()
-		case metric.GetHistogram() != nil:
-			samplerMetric = nil
-			histogram = metric.GetHistogram()
-		}
+// request makes one attempt to deliver payload, through the writer's Sender if it has one
+func (w *writerImpl) request(ctx context.Context, payload Payload) (RequestTimings, error) {
+	if w.sender != nil {
+		return RequestTimings{}, w.sender.Send(ctx, payload)
+	}
 
-		samples := make([]prompb.Sample, 0, 1)
-		exemplars := make([]prompb.Exemplar, 0, 1)
-		histograms := make([]prompb.Histogram, 0, 1)
-
-		if samplerMetric != nil {
-			v, ok := samplerMetric.(valued)
-			if ok {
-				samples = append(samples, prompb.Sample{
-					Value:     v.GetValue(),
-					Timestamp: metric.GetTimestampMs(),
-				})
-			}
+	tracer := &requestTracer{}
+	req, err := http.NewRequestWithContext(tracer.trace(ctx), http.MethodPost, payload.TargetURL, bytes.NewReader(payload.Body))
+	if err != nil {
+		return RequestTimings{}, fmt.Errorf("%w: %w", ErrSend, err)
+	}
 
-			e, ok := samplerMetric.(hasExemplar)
-			if ok {
-				exemplars = append(exemplars, convertExemplar(e.GetExemplar()))
-			}
+	w.describe(req, payload)
+	if payload.Compression == None && w.identityContentEncoding {
+		req.Header.Set("Content-Encoding", "identity")
+	}
+
+	if payload.Tenant != "" {
+		req.Header.Set(w.tenantHeader, payload.Tenant)
+	}
+
+	if payload.IdempotencyKey != "" {
+		req.Header.Set(w.idempotencyKeyHeader, payload.IdempotencyKey)
+	}
+
+	if err = w.credentials.apply(req); err != nil {
+		return RequestTimings{}, err
+	}
+
+	for name, values := range w.headers {
+		req.Header[http.CanonicalHeaderKey(name)] = values
+	}
+
+	if w.ctxHeaders != nil {
+		for name, values := range w.ctxHeaders(ctx) {
+			req.Header[http.CanonicalHeaderKey(name)] = values
 		}
+	}
 
-		if histogram != nil {
-			samples = nil
-			exemplars = slices.Map(histogram.GetExemplars(), convertExemplar)
-			histograms = append(histograms, prompb.Histogram{
-				Sum:            histogram.GetSampleSum(),
-				Schema:         histogram.GetSchema(),
-				ZeroThreshold:  histogram.GetZeroThreshold(),
-				NegativeSpans:  convertBucketSpans(histogram.GetNegativeSpan()),
-				NegativeDeltas: histogram.GetNegativeDelta(),
-				NegativeCounts: histogram.GetNegativeCount(),
-				PositiveSpans:  convertBucketSpans(histogram.GetPositiveSpan()),
-				PositiveDeltas: histogram.GetPositiveDelta(),
-				PositiveCounts: histogram.GetPositiveCount(),
-				Timestamp:      histogram.GetCreatedTimestamp().AsTime().UnixMilli(),
-			})
+	if w.signer != nil {
+		if err = w.signer.Sign(req, payload.Body); err != nil {
+			return RequestTimings{}, fmt.Errorf("signing request: %w", err)
 		}
+	}
+
+	resp, err := w.hc.Do(req)
+	if err != nil {
+		return tracer.result(), fmt.Errorf("%w: %w", ErrSend, err)
+	}
+	resp.Body.Close()
+	statsFrom(ctx).responded(resp.StatusCode)
 
-		ts[i] = prompb.TimeSeries{
-			Labels:     prompbLabels,
-			Samples:    samples,
-			Exemplars:  exemplars,
-			Histograms: histograms,
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		signal := throttleSignal(resp, payload, w.clock.Now())
+		if signal != nil && w.onThrottle != nil {
+			w.onThrottle(*signal)
 		}
+
+		return tracer.result(), &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, Throttle: signal}
 	}
 
-	return ts
+	return tracer.result(), nil
 }
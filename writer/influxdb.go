@@ -0,0 +1,50 @@
+package writer
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NewInfluxDBWriter creates a RemoteMetricsWriter for InfluxDB's Prometheus remote write compatibility endpoint.
+// influxURL is the address of the InfluxDB server; /api/v1/prom/write is added when it has no path. database is
+// required and retentionPolicy is optional; both are sent as the db and rp query parameters. If token is set, it is
+// sent as "Authorization: Token <token>", which InfluxDB 2.x expects (on 1.x, use a "username:password" token).
+func NewInfluxDBWriter(influxURL string, database string, retentionPolicy string, token string, options RemoteMetricsWriterOptions, gatherers ...prometheus.Gatherer) (RemoteMetricsWriter, error) {
+	if strings.TrimSpace(database) == "" {
+		return nil, errors.New("database must be set")
+	}
+
+	u, err := withDefaultPath(strings.TrimSpace(influxURL), influxDBWritePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Host == "" {
+		return nil, errors.New("influxURL must be an absolute URL")
+	}
+
+	q := u.Query()
+	q.Set("db", strings.TrimSpace(database))
+	if strings.TrimSpace(retentionPolicy) != "" {
+		q.Set("rp", strings.TrimSpace(retentionPolicy))
+	}
+	u.RawQuery = q.Encode()
+
+	if options.Format == 0 {
+		options.Format = Protobuf
+	}
+	options.TargetFlavor = InfluxDB
+
+	if strings.TrimSpace(token) != "" {
+		options.Headers = options.Headers.Clone()
+		if options.Headers == nil {
+			options.Headers = http.Header{}
+		}
+		options.Headers.Set("Authorization", "Token "+strings.TrimSpace(token))
+	}
+
+	return NewRemoteMetricsWriter(u.String(), options, gatherers...)
+}
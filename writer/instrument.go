@@ -0,0 +1,75 @@
+package writer
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// InstrumentRoundTripper wraps next (http.DefaultTransport if it is nil) with the promhttp client instrumentation,
+// registering its collectors with reg:
+//
+//	prometheus_remote_write_http_requests_in_flight is the number of requests in progress
+//	prometheus_remote_write_http_requests_total counts requests by status code and method
+//	prometheus_remote_write_http_request_duration_seconds is a histogram of request durations by status code
+//
+// constLabels are added to all three, so transports of several writers (for different targets, say) can share a
+// registry. Wrapping another transport with the same constLabels and registry reuses the collectors already there
+func InstrumentRoundTripper(next http.RoundTripper, reg prometheus.Registerer, constLabels prometheus.Labels) (http.RoundTripper, error) {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	inFlight := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "prometheus_remote_write_http_requests_in_flight",
+		Help:        "Number of remote write HTTP requests in progress.",
+		ConstLabels: constLabels,
+	})
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "prometheus_remote_write_http_requests_total",
+		Help:        "Number of remote write HTTP requests sent, by status code and method.",
+		ConstLabels: constLabels,
+	}, []string{"code", "method"})
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:        "prometheus_remote_write_http_request_duration_seconds",
+		Help:        "Duration of remote write HTTP requests, by status code.",
+		Buckets:     prometheus.DefBuckets,
+		ConstLabels: constLabels,
+	}, []string{"code"})
+
+	var err error
+	if inFlight, err = register(reg, inFlight); err != nil {
+		return nil, err
+	}
+	if requests, err = register(reg, requests); err != nil {
+		return nil, err
+	}
+	if duration, err = register(reg, duration); err != nil {
+		return nil, err
+	}
+
+	return promhttp.InstrumentRoundTripperInFlight(inFlight,
+		promhttp.InstrumentRoundTripperCounter(requests,
+			promhttp.InstrumentRoundTripperDuration(duration, next),
+		),
+	), nil
+}
+
+// register registers c with reg, returning the collector registered before it if there is one
+func register[C prometheus.Collector](reg prometheus.Registerer, c C) (C, error) {
+	err := reg.Register(c)
+	if err == nil {
+		return c, nil
+	}
+
+	var already prometheus.AlreadyRegisteredError
+	if errors.As(err, &already) {
+		if existing, ok := already.ExistingCollector.(C); ok {
+			return existing, nil
+		}
+	}
+
+	return c, err
+}
@@ -0,0 +1,82 @@
+package writer_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("Instrumented transport", func() {
+	series := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+	}}
+
+	find := func(families []*dto.MetricFamily, name string) *dto.MetricFamily {
+		for _, family := range families {
+			if family.GetName() == name {
+				return family
+			}
+		}
+		return nil
+	}
+
+	It("counts and times the writer's requests", func() {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		DeferCleanup(s.Close)
+
+		registry := prometheus.NewRegistry()
+		client := s.Client()
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:          client,
+			InstrumentTransport: registry,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(client.Transport).Should(Equal(s.Client().Transport))
+
+		for range 3 {
+			_, err = w.WriteTimeSeries(context.Background(), series, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+
+		families, err := registry.Gather()
+		Expect(err).ShouldNot(HaveOccurred())
+
+		requests := find(families, "prometheus_remote_write_http_requests_total")
+		Expect(requests).ShouldNot(BeNil())
+		Expect(requests.GetMetric()).Should(HaveLen(1))
+		Expect(requests.GetMetric()[0].GetCounter().GetValue()).Should(BeEquivalentTo(3))
+		Expect(requests.GetMetric()[0].GetLabel()).Should(ContainElement(SatisfyAll(
+			HaveField("GetName()", "code"), HaveField("GetValue()", "204"),
+		)))
+		Expect(requests.GetMetric()[0].GetLabel()).Should(ContainElement(SatisfyAll(
+			HaveField("GetName()", "url"), HaveField("GetValue()", s.URL),
+		)))
+
+		duration := find(families, "prometheus_remote_write_http_request_duration_seconds")
+		Expect(duration).ShouldNot(BeNil())
+		Expect(duration.GetMetric()[0].GetHistogram().GetSampleCount()).Should(BeEquivalentTo(3))
+		Expect(find(families, "prometheus_remote_write_http_requests_in_flight")).ShouldNot(BeNil())
+	})
+
+	It("shares collectors between transports with the same labels", func() {
+		registry := prometheus.NewRegistry()
+		labels := prometheus.Labels{"url": "http://localhost:9090"}
+
+		_, err := writer.InstrumentRoundTripper(nil, registry, labels)
+		Expect(err).ShouldNot(HaveOccurred())
+		_, err = writer.InstrumentRoundTripper(nil, registry, labels)
+		Expect(err).ShouldNot(HaveOccurred())
+		_, err = writer.InstrumentRoundTripper(nil, registry, prometheus.Labels{"url": "http://localhost:9091"})
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+})
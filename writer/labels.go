@@ -0,0 +1,114 @@
+package writer
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// DuplicateLabelPolicy decides what happens to a series in which the same label name appears more than once, which
+// receivers reject along with the rest of the request
+type DuplicateLabelPolicy int
+
+const (
+	// DuplicateLabelsFix keeps the first of the labels with the same name and removes the others
+	DuplicateLabelsFix DuplicateLabelPolicy = iota
+	// DuplicateLabelsDrop leaves the series out of the push
+	DuplicateLabelsDrop
+	// DuplicateLabelsError fails the push with ErrDuplicateLabel
+	DuplicateLabelsError
+)
+
+// String returns the name of the DuplicateLabelPolicy
+func (p DuplicateLabelPolicy) String() string {
+	switch p {
+	case DuplicateLabelsFix:
+		return "fix"
+	case DuplicateLabelsDrop:
+		return "drop"
+	case DuplicateLabelsError:
+		return "error"
+	default:
+		return fmt.Sprintf("%%INVALID!(%d)", p)
+	}
+}
+
+// checkDuplicateLabels applies the writer's DuplicateLabelPolicy to series. Series are only copied when one of them
+// has to change, so the caller's slices are never modified
+func (w *writerImpl) checkDuplicateLabels(series []prompb.TimeSeries) ([]prompb.TimeSeries, error) {
+	var checked []prompb.TimeSeries
+	for i, ts := range series {
+		if !hasDuplicateLabels(ts.Labels) {
+			if checked != nil {
+				checked = append(checked, ts)
+			}
+			continue
+		}
+
+		if w.duplicateLabelPolicy == DuplicateLabelsError {
+			return nil, fmt.Errorf("%w in series {%s}", ErrDuplicateLabel, labelsString(ts.Labels))
+		}
+
+		if checked == nil {
+			checked = make([]prompb.TimeSeries, i, len(series))
+			copy(checked, series[:i])
+		}
+
+		if w.duplicateLabelPolicy == DuplicateLabelsFix {
+			ts.Labels = dedupeLabels(ts.Labels)
+			checked = append(checked, ts)
+		}
+	}
+
+	if checked == nil {
+		return series, nil
+	}
+
+	return checked, nil
+}
+
+func hasDuplicateLabels(labels []prompb.Label) bool {
+	// labels are normally sorted, which puts duplicates next to each other
+	if slices.IsSortedFunc(labels, func(a, b prompb.Label) int { return strings.Compare(a.Name, b.Name) }) {
+		for i := 1; i < len(labels); i++ {
+			if labels[i].Name == labels[i-1].Name {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	for i := range labels {
+		for j := i + 1; j < len(labels); j++ {
+			if labels[i].Name == labels[j].Name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// dedupeLabels returns a copy of labels with only the first label of each name
+func dedupeLabels(labels []prompb.Label) []prompb.Label {
+	deduped := make([]prompb.Label, 0, len(labels))
+	for _, l := range labels {
+		if !slices.ContainsFunc(deduped, func(d prompb.Label) bool { return d.Name == l.Name }) {
+			deduped = append(deduped, l)
+		}
+	}
+
+	return deduped
+}
+
+func labelsString(labels []prompb.Label) string {
+	pairs := make([]string, len(labels))
+	for i, l := range labels {
+		pairs[i] = fmt.Sprintf("%s=%q", l.Name, l.Value)
+	}
+
+	return strings.Join(pairs, ", ")
+}
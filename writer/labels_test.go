@@ -0,0 +1,75 @@
+package writer_test
+
+import (
+	"context"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("DuplicateLabelPolicy", func() {
+	var requests []prompb.WriteRequest
+
+	series := func() []prompb.TimeSeries {
+		return []prompb.TimeSeries{
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "ok"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+			},
+			{
+				Labels: []prompb.Label{
+					{Name: "__name__", Value: "clash"},
+					{Name: "env", Value: "const"},
+					{Name: "env", Value: "dynamic"},
+				},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+			},
+		}
+	}
+
+	newWriter := func(policy writer.DuplicateLabelPolicy) writer.RemoteMetricsWriter {
+		requests = nil
+		w, err := writer.NewRemoteMetricsWriter("http://example.invalid/push", writer.RemoteMetricsWriterOptions{
+			DuplicateLabelPolicy: policy,
+			Sender: senderFunc(func(_ context.Context, p writer.Payload) error {
+				var wr prompb.WriteRequest
+				if err := wr.Unmarshal(p.Body); err != nil {
+					return err
+				}
+				requests = append(requests, wr)
+				return nil
+			}),
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		return w
+	}
+
+	It("Keeps the first label by default", func() {
+		input := series()
+		n, err := newWriter(writer.DuplicateLabelsFix).WriteTimeSeries(context.Background(), input, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).Should(Equal(2))
+
+		Expect(requests[0].Timeseries[1].Labels).Should(Equal([]prompb.Label{
+			{Name: "__name__", Value: "clash"},
+			{Name: "env", Value: "const"},
+		}))
+		Expect(input[1].Labels).Should(HaveLen(3))
+	})
+
+	It("Drops the series", func() {
+		n, err := newWriter(writer.DuplicateLabelsDrop).WriteTimeSeries(context.Background(), series(), nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).Should(Equal(1))
+		Expect(requests[0].Timeseries).Should(HaveLen(1))
+	})
+
+	It("Fails the push", func() {
+		_, err := newWriter(writer.DuplicateLabelsError).WriteTimeSeries(context.Background(), series(), nil)
+		Expect(err).Should(MatchError(writer.ErrDuplicateLabel))
+		Expect(err.Error()).Should(ContainSubstring(`env="dynamic"`))
+		Expect(requests).Should(BeEmpty())
+	})
+})
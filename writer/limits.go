@@ -0,0 +1,239 @@
+package writer
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// SampleLimitAction decides what happens to a push with more samples than SampleLimit
+type SampleLimitAction int
+
+const (
+	// SampleLimitReject sends nothing and fails the push with a *SampleLimitExceededError
+	SampleLimitReject SampleLimitAction = iota
+	// SampleLimitTruncate sends the families that fit within the limit, highest priority first and otherwise in order,
+	// and reports the rest in a *SampleLimitExceededError returned along with the number of series sent
+	SampleLimitTruncate
+)
+
+// String returns the name of the SampleLimitAction
+func (a SampleLimitAction) String() string {
+	switch a {
+	case SampleLimitReject:
+		return "reject"
+	case SampleLimitTruncate:
+		return "truncate"
+	default:
+		return fmt.Sprintf("%%INVALID!(%d)", a)
+	}
+}
+
+// SampleLimitExceededError is returned for pushes with more samples than SampleLimit: on its own under
+// SampleLimitReject, or along with the number of series sent under SampleLimitTruncate. It wraps
+// ErrSampleLimitExceeded
+type SampleLimitExceededError struct {
+	Limit int
+	Total int
+	// DroppedSamples is the number of samples in the series that were left out
+	DroppedSamples int
+	// Dropped holds the labels of the series that were left out when truncating, in the order they were pushed
+	Dropped [][]prompb.Label
+}
+
+func (e *SampleLimitExceededError) Error() string {
+	msg := fmt.Sprintf("push has %d samples, more than the %d allowed", e.Total, e.Limit)
+	if e.Dropped == nil {
+		return msg
+	}
+
+	return fmt.Sprintf("%s: dropped %d of %d samples in %d series", msg, e.DroppedSamples, e.Total, len(e.Dropped))
+}
+
+func (e *SampleLimitExceededError) Unwrap() error {
+	return ErrSampleLimitExceeded
+}
+
+// applySampleLimit enforces the writer's SampleLimit on series. It returns the series to send and, if the limit was
+// exceeded, a *SampleLimitExceededError to report. When truncating, whole families are kept by priority as
+// keepFamilies does, and the error lists the series dropped
+func (w *writerImpl) applySampleLimit(series []prompb.TimeSeries) ([]prompb.TimeSeries, error) {
+	if w.sampleLimit <= 0 {
+		return series, nil
+	}
+
+	total := 0
+	for _, ts := range series {
+		total += len(ts.Samples) + len(ts.Histograms)
+	}
+
+	if total <= w.sampleLimit {
+		return series, nil
+	}
+
+	if w.sampleLimitAction != SampleLimitTruncate {
+		return nil, &SampleLimitExceededError{Limit: w.sampleLimit, Total: total, DroppedSamples: total}
+	}
+
+	kept, dropped, samples := w.keepFamilies(series, w.sampleLimit, func(ts prompb.TimeSeries) int {
+		return len(ts.Samples) + len(ts.Histograms)
+	})
+
+	return kept, &SampleLimitExceededError{
+		Limit:          w.sampleLimit,
+		Total:          total,
+		DroppedSamples: total - samples,
+		Dropped:        dropped,
+	}
+}
+
+// SeriesLimitExceededError is returned, along with the number of series sent, for pushes with more series than
+// MaxSeriesPerPush. It wraps ErrSeriesLimitExceeded
+type SeriesLimitExceededError struct {
+	Limit int
+	Total int
+	// Dropped holds the labels of the series that were left out, in the order they were pushed
+	Dropped [][]prompb.Label
+}
+
+func (e *SeriesLimitExceededError) Error() string {
+	return fmt.Sprintf("push has %d series, more than the %d allowed: dropped %d", e.Total, e.Limit, len(e.Dropped))
+}
+
+func (e *SeriesLimitExceededError) Unwrap() error {
+	return ErrSeriesLimitExceeded
+}
+
+// applySeriesLimit enforces the writer's MaxSeriesPerPush on series, keeping whole families by priority as
+// keepFamilies does. If the limit was exceeded, it returns a *SeriesLimitExceededError with the series it dropped
+func (w *writerImpl) applySeriesLimit(series []prompb.TimeSeries) ([]prompb.TimeSeries, error) {
+	if w.maxSeriesPerPush <= 0 || len(series) <= w.maxSeriesPerPush {
+		return series, nil
+	}
+
+	kept, dropped, _ := w.keepFamilies(series, w.maxSeriesPerPush, func(prompb.TimeSeries) int { return 1 })
+
+	return kept, &SeriesLimitExceededError{Limit: w.maxSeriesPerPush, Total: len(series), Dropped: dropped}
+}
+
+// keepFamilies keeps the families of series that fit within limit, as measured by size, from the highest priority to
+// the lowest, and leaves out the others whole, so that a histogram or summary is never cut part way. A family is
+// ranked by its highest priority series, and families of equal priority are taken in the order they were pushed. The
+// series kept stay in the order they were pushed, and the labels of those dropped are returned in the same order,
+// along with the total size of the series kept
+func (w *writerImpl) keepFamilies(series []prompb.TimeSeries, limit int, size func(prompb.TimeSeries) int) ([]prompb.TimeSeries, [][]prompb.Label, int) {
+	var families [][]int
+	index := map[string]int{}
+	priorities := []int{}
+	for i, ts := range series {
+		name := familyName(ts.Labels)
+		f, ok := index[name]
+		if !ok {
+			f = len(families)
+			index[name] = f
+			families = append(families, nil)
+			priorities = append(priorities, w.priority(ts.Labels))
+		}
+		families[f] = append(families[f], i)
+		priorities[f] = max(priorities[f], w.priority(ts.Labels))
+	}
+
+	order := make([]int, len(families))
+	for f := range order {
+		order[f] = f
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return cmp.Compare(priorities[b], priorities[a])
+	})
+
+	keep := make([]bool, len(series))
+	used := 0
+	for _, f := range order {
+		n := 0
+		for _, i := range families[f] {
+			n += size(series[i])
+		}
+		if used+n > limit {
+			continue
+		}
+		used += n
+		for _, i := range families[f] {
+			keep[i] = true
+		}
+	}
+
+	kept := make([]prompb.TimeSeries, 0, len(series))
+	var dropped [][]prompb.Label
+	for i, ts := range series {
+		if keep[i] {
+			kept = append(kept, ts)
+		} else {
+			dropped = append(dropped, ts.Labels)
+		}
+	}
+
+	return kept, dropped, used
+}
+
+// familyName returns the name of the family the series with labels belongs to: its metric name, without any of the
+// suffixes (_bucket, _sum, _count and so on) the conversion adds
+func familyName(labels []prompb.Label) string {
+	var name string
+	for _, l := range labels {
+		if l.Name == "__name__" {
+			name = l.Value
+			break
+		}
+	}
+
+	for _, suffix := range familySuffixes {
+		if family, ok := strings.CutSuffix(name, suffix); ok {
+			return family
+		}
+	}
+
+	return name
+}
+
+// priority returns the priority of the series with labels: what SeriesPriority says if it is set, or else its
+// family's priority in FamilyPriorities
+func (w *writerImpl) priority(labels []prompb.Label) int {
+	if w.seriesPriority != nil {
+		return w.seriesPriority(labels)
+	}
+
+	return familyPriority(w.familyPriorities, labels)
+}
+
+// familyPriority returns the entry of priorities for the name of the series with labels, or for its family's name if
+// it has one of the suffixes (_bucket, _sum, _count and so on) the conversion adds. Series without one have priority 0
+func familyPriority(priorities map[string]int, labels []prompb.Label) int {
+	if len(priorities) == 0 {
+		return 0
+	}
+
+	var name string
+	for _, l := range labels {
+		if l.Name == "__name__" {
+			name = l.Value
+			break
+		}
+	}
+
+	if p, ok := priorities[name]; ok {
+		return p
+	}
+
+	for _, suffix := range familySuffixes {
+		if family, ok := strings.CutSuffix(name, suffix); ok {
+			if p, ok := priorities[family]; ok {
+				return p
+			}
+		}
+	}
+
+	return 0
+}
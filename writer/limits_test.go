@@ -0,0 +1,227 @@
+package writer_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("SampleLimit", func() {
+	var s *httptest.Server
+	var requests []prompb.WriteRequest
+
+	// three series of up and two of down, two samples each
+	series := make([]prompb.TimeSeries, 5)
+	for i := range series {
+		name := "up"
+		if i >= 3 {
+			name = "down"
+		}
+		series[i] = prompb.TimeSeries{
+			Labels:  []prompb.Label{{Name: "__name__", Value: name}, {Name: "i", Value: strconv.Itoa(i)}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}, {Value: 1, Timestamp: 2000}},
+		}
+	}
+
+	BeforeEach(func() {
+		requests = nil
+		s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+
+			var wr prompb.WriteRequest
+			if err := wr.Unmarshal(body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			requests = append(requests, wr)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	})
+
+	AfterEach(func() {
+		s.Close()
+	})
+
+	It("Rejects pushes over the limit", func() {
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:  s.Client(),
+			SampleLimit: 9,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		n, err := w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).Should(MatchError(writer.ErrSampleLimitExceeded))
+		Expect(n).Should(BeZero())
+		Expect(requests).Should(BeEmpty())
+
+		n, err = w.WriteTimeSeries(context.Background(), series[:4], nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).Should(Equal(4))
+	})
+
+	It("Truncates pushes over the limit when asked", func() {
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:        s.Client(),
+			SampleLimit:       7,
+			SampleLimitAction: writer.SampleLimitTruncate,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		n, err := w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).Should(MatchError(writer.ErrSampleLimitExceeded))
+		Expect(err.Error()).Should(ContainSubstring("dropped 4 of 10 samples in 2 series"))
+		Expect(n).Should(Equal(3))
+
+		var limitErr *writer.SampleLimitExceededError
+		Expect(errors.As(err, &limitErr)).Should(BeTrue())
+		Expect(limitErr.Limit).Should(Equal(7))
+		Expect(limitErr.Total).Should(Equal(10))
+		Expect(limitErr.DroppedSamples).Should(Equal(4))
+		Expect(limitErr.Dropped).Should(Equal([][]prompb.Label{series[3].Labels, series[4].Labels}))
+
+		Expect(requests).Should(HaveLen(1))
+		Expect(requests[0].Timeseries).Should(HaveLen(3))
+	})
+
+	It("Truncates low priority series first", func() {
+		prioritized := append([]prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "slo_errors_total"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}, {Value: 1, Timestamp: 2000}},
+		}}, series...)
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:        s.Client(),
+			SampleLimit:       7,
+			SampleLimitAction: writer.SampleLimitTruncate,
+			FamilyPriorities:  map[string]int{"slo_errors": 100, "up": -1},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		n, err := w.WriteTimeSeries(context.Background(), prioritized, nil)
+		Expect(err).Should(MatchError(writer.ErrSampleLimitExceeded))
+		Expect(err.Error()).Should(ContainSubstring("dropped 6 of 12 samples in 3 series"))
+		Expect(n).Should(Equal(3))
+
+		Expect(requests).Should(HaveLen(1))
+		Expect(requests[0].Timeseries).Should(HaveLen(3))
+		Expect(requests[0].Timeseries[0].Labels[0].Value).Should(Equal("slo_errors_total"))
+	})
+})
+
+var _ = Describe("MaxSeriesPerPush", func() {
+	var s *httptest.Server
+	var requests []prompb.WriteRequest
+
+	named := func(name, i string) prompb.TimeSeries {
+		return prompb.TimeSeries{
+			Labels:  []prompb.Label{{Name: "__name__", Value: name}, {Name: "i", Value: i}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+		}
+	}
+	series := []prompb.TimeSeries{
+		named("debug_info", "0"),
+		named("http_requests_total", "1"),
+		named("latency_seconds_bucket", "2"),
+		named("debug_info", "3"),
+		named("latency_seconds_sum", "4"),
+	}
+
+	BeforeEach(func() {
+		requests = nil
+		s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+
+			var wr prompb.WriteRequest
+			if err := wr.Unmarshal(body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			requests = append(requests, wr)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		DeferCleanup(s.Close)
+	})
+
+	It("Keeps the series with the highest family priority and reports the rest", func() {
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:       s.Client(),
+			MaxSeriesPerPush: 3,
+			FamilyPriorities: map[string]int{"latency_seconds": 10, "debug_info": -1},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		n, err := w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(n).Should(Equal(3))
+		Expect(err).Should(MatchError(writer.ErrSeriesLimitExceeded))
+
+		var limitErr *writer.SeriesLimitExceededError
+		Expect(errors.As(err, &limitErr)).Should(BeTrue())
+		Expect(limitErr.Limit).Should(Equal(3))
+		Expect(limitErr.Total).Should(Equal(5))
+		Expect(limitErr.Dropped).Should(Equal([][]prompb.Label{series[0].Labels, series[3].Labels}))
+
+		Expect(requests).Should(HaveLen(1))
+		Expect(requests[0].Timeseries).Should(HaveLen(3))
+	})
+
+	It("Ranks series with SeriesPriority when it is set", func() {
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:       s.Client(),
+			MaxSeriesPerPush: 2,
+			SeriesPriority: func(labels []prompb.Label) int {
+				i, _ := strconv.Atoi(labels[1].Value)
+				return i
+			},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		n, err := w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(n).Should(Equal(2))
+		Expect(err).Should(MatchError(ContainSubstring("push has 5 series, more than the 2 allowed: dropped 3")))
+
+		Expect(requests).Should(HaveLen(1))
+		// latency_seconds ranks as its series with priority 4, and debug_info, with 3, no longer fits beside it
+		Expect(requests[0].Timeseries).Should(HaveLen(2))
+		Expect(requests[0].Timeseries[0].Labels).Should(ContainElement(prompb.Label{Name: "i", Value: "2"}))
+		Expect(requests[0].Timeseries[1].Labels).Should(ContainElement(prompb.Label{Name: "i", Value: "4"}))
+	})
+
+	It("Never sends part of a histogram", func() {
+		histogram := []prompb.TimeSeries{
+			named("latency_seconds_bucket", "0"),
+			named("latency_seconds_bucket", "1"),
+			named("latency_seconds_sum", "2"),
+			named("latency_seconds_count", "3"),
+			named("up", "4"),
+			named("queue_length", "5"),
+		}
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:       s.Client(),
+			MaxSeriesPerPush: 3,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		n, err := w.WriteTimeSeries(context.Background(), histogram, nil)
+		Expect(n).Should(Equal(2))
+
+		var limitErr *writer.SeriesLimitExceededError
+		Expect(errors.As(err, &limitErr)).Should(BeTrue())
+		Expect(limitErr.Dropped).Should(HaveLen(4))
+
+		Expect(requests).Should(HaveLen(1))
+		Expect(requests[0].Timeseries).Should(HaveLen(2))
+		Expect(requests[0].Timeseries[0].Labels[0].Value).Should(Equal("up"))
+		Expect(requests[0].Timeseries[1].Labels[0].Value).Should(Equal("queue_length"))
+	})
+})
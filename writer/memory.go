@@ -0,0 +1,68 @@
+package writer
+
+import (
+	"fmt"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// MemoryBudgetAction decides what happens to a request larger than the writer's MemoryBudget
+type MemoryBudgetAction int
+
+const (
+	// MemoryBudgetSplit sends the request in as many smaller requests as it takes for each to fit the budget, down to
+	// a single sample per request
+	MemoryBudgetSplit MemoryBudgetAction = iota
+	// MemoryBudgetError sends nothing and fails the push with a *MemoryBudgetExceededError
+	MemoryBudgetError
+)
+
+// String returns the name of the MemoryBudgetAction
+func (a MemoryBudgetAction) String() string {
+	switch a {
+	case MemoryBudgetSplit:
+		return "split"
+	case MemoryBudgetError:
+		return "error"
+	default:
+		return fmt.Sprintf("%%INVALID!(%d)", a)
+	}
+}
+
+// MemoryBudgetExceededError is returned for requests that would need more than the writer's MemoryBudget to encode,
+// when its MemoryBudgetAction is MemoryBudgetError. It wraps ErrMemoryBudgetExceeded
+type MemoryBudgetExceededError struct {
+	// Estimated is the number of bytes the request was estimated to need
+	Estimated int
+	Budget    int
+}
+
+func (e *MemoryBudgetExceededError) Error() string {
+	return fmt.Sprintf("request needs an estimated %d bytes, over the memory budget of %d", e.Estimated, e.Budget)
+}
+
+func (e *MemoryBudgetExceededError) Unwrap() error {
+	return ErrMemoryBudgetExceeded
+}
+
+// memoryBudgetLimit returns the most samples a request like wr can carry and stay within the writer's MemoryBudget,
+// or 0 if wr fits as it is. A request is estimated to need its marshalled size, which encoding allocates in one piece
+// before compressing it, and the samples are assumed to share that size evenly
+func (w *writerImpl) memoryBudgetLimit(wr prompb.WriteRequest) (int, error) {
+	if w.memoryBudget <= 0 {
+		return 0, nil
+	}
+
+	size := wr.Size()
+	if size <= w.memoryBudget {
+		return 0, nil
+	}
+
+	if w.memoryBudgetAction == MemoryBudgetError {
+		return 0, &MemoryBudgetExceededError{Estimated: size, Budget: w.memoryBudget}
+	}
+
+	samples := max(batchSamples(wr.Timeseries), 1)
+	perSample := (size + samples - 1) / samples
+	return max(w.memoryBudget/perSample, 1), nil
+}
@@ -0,0 +1,80 @@
+package writer_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("MemoryBudget", func() {
+	var requests []prompb.WriteRequest
+
+	series := func(n int) []prompb.TimeSeries {
+		series := make([]prompb.TimeSeries, n)
+		for i := range series {
+			series[i] = prompb.TimeSeries{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "requests_total"}, {Name: "id", Value: strconv.Itoa(i)}},
+				Samples: []prompb.Sample{{Value: float64(i), Timestamp: 1000}},
+			}
+		}
+		return series
+	}
+
+	newWriter := func(budget int, action writer.MemoryBudgetAction) writer.RemoteMetricsWriter {
+		requests = nil
+		w, err := writer.NewRemoteMetricsWriter("http://example.invalid/push", writer.RemoteMetricsWriterOptions{
+			MemoryBudget:       budget,
+			MemoryBudgetAction: action,
+			Sender: senderFunc(func(_ context.Context, p writer.Payload) error {
+				var wr prompb.WriteRequest
+				if err := wr.Unmarshal(p.Body); err != nil {
+					return err
+				}
+				requests = append(requests, wr)
+				return nil
+			}),
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		return w
+	}
+
+	It("Splits requests over the budget into requests within it", func() {
+		input := series(100)
+		full := (&prompb.WriteRequest{Timeseries: input}).Size()
+
+		n, err := newWriter(full/4, writer.MemoryBudgetSplit).WriteTimeSeries(context.Background(), input, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).Should(Equal(100))
+
+		Expect(len(requests)).Should(BeNumerically(">=", 4))
+		sent := 0
+		for _, wr := range requests {
+			Expect(wr.Size()).Should(BeNumerically("<=", full/4))
+			sent += len(wr.Timeseries)
+		}
+		Expect(sent).Should(Equal(100))
+	})
+
+	It("Sends requests within the budget as they are", func() {
+		input := series(10)
+		_, err := newWriter(1<<20, writer.MemoryBudgetSplit).WriteTimeSeries(context.Background(), input, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(requests).Should(HaveLen(1))
+	})
+
+	It("Fails pushes over the budget with a typed error", func() {
+		_, err := newWriter(64, writer.MemoryBudgetError).WriteTimeSeries(context.Background(), series(10), nil)
+		Expect(err).Should(MatchError(writer.ErrMemoryBudgetExceeded))
+
+		var budgetErr *writer.MemoryBudgetExceededError
+		Expect(errors.As(err, &budgetErr)).Should(BeTrue())
+		Expect(budgetErr.Budget).Should(Equal(64))
+		Expect(budgetErr.Estimated).Should(BeNumerically(">", 64))
+		Expect(requests).Should(BeEmpty())
+	})
+})
@@ -0,0 +1,112 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"unicode/utf8"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// DefaultMaxMetadataPerSend matches the default max_samples_per_send of Prometheus's metadata_config
+const DefaultMaxMetadataPerSend = 500
+
+// separateMetadata removes the metadata from batches when the writer pushes metadata on its own interval, or from
+// each batch that carries more than maxMetadataPerSend entries, and returns the metadata that is due to be sent as
+// metadata-only batches for the same destinations. Each batch only carries the metadata of its own series, so metadata
+// sent on its own never reaches another tenant or route either
+func (w *writerImpl) separateMetadata(batches []batch) []batch {
+	var separated []batch
+	if w.metadataInterval <= 0 {
+		for i, b := range batches {
+			if len(b.request.Metadata) <= w.maxMetadataPerSend {
+				continue
+			}
+
+			separated = append(separated, batch{destination: b.destination, request: prompb.WriteRequest{Metadata: b.request.Metadata}})
+			batches[i].request.Metadata = nil
+		}
+
+		return separated
+	}
+
+	for i, b := range batches {
+		if len(b.request.Metadata) > 0 {
+			separated = append(separated, batch{destination: b.destination, request: prompb.WriteRequest{Metadata: b.request.Metadata}})
+		}
+		batches[i].request.Metadata = nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.clock.Now().Sub(w.lastMetadataSend) < w.metadataInterval {
+		return nil
+	}
+
+	return separated
+}
+
+// trimHelp returns metadata with its help text removed if the writer strips help, or truncated to maxHelpLength
+// characters if it has a limit. The caller's metadata is copied rather than changed
+func (w *writerImpl) trimHelp(metadata []prompb.MetricMetadata) []prompb.MetricMetadata {
+	if !w.stripHelp && w.maxHelpLength <= 0 {
+		return metadata
+	}
+
+	for i := range metadata {
+		if metadata[i].Help == "" || (!w.stripHelp && utf8.RuneCountInString(metadata[i].Help) <= w.maxHelpLength) {
+			continue
+		}
+
+		trimmed := slices.Clone(metadata)
+		for j := i; j < len(trimmed); j++ {
+			if w.stripHelp {
+				trimmed[j].Help = ""
+			} else {
+				trimmed[j].Help = truncateRunes(trimmed[j].Help, w.maxHelpLength)
+			}
+		}
+
+		return trimmed
+	}
+
+	return metadata
+}
+
+// writeMetadata sends the metadata of each batch to its destination, in metadata-only requests of at most
+// maxMetadataPerSend entries. The interval only restarts once every request has succeeded, so failed metadata is
+// retried on the next push
+func (w *writerImpl) writeMetadata(ctx context.Context, batches []batch) error {
+	var errs []error
+	for _, b := range batches {
+		for _, wr := range w.metadataRequests(b.request.Metadata) {
+			if _, err := w.deliver(ctx, wr, b.destination); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", b.destination, err))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	w.mu.Lock()
+	w.lastMetadataSend = w.clock.Now()
+	w.mu.Unlock()
+
+	return nil
+}
+
+// metadataRequests divides metadata between metadata-only requests of at most maxMetadataPerSend entries
+func (w *writerImpl) metadataRequests(metadata []prompb.MetricMetadata) []prompb.WriteRequest {
+	requests := make([]prompb.WriteRequest, 0, (len(metadata)+w.maxMetadataPerSend-1)/w.maxMetadataPerSend)
+	for len(metadata) > 0 {
+		n := min(len(metadata), w.maxMetadataPerSend)
+		requests = append(requests, prompb.WriteRequest{Metadata: metadata[:n]})
+		metadata = metadata[n:]
+	}
+
+	return requests
+}
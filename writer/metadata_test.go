@@ -0,0 +1,200 @@
+package writer_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("Metadata send interval", func() {
+	var s *httptest.Server
+	var mu sync.Mutex
+	var requests []prompb.WriteRequest
+
+	series := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+	}}
+	metadata := []prompb.MetricMetadata{
+		{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "up"},
+		{Type: prompb.MetricMetadata_COUNTER, MetricFamilyName: "requests"},
+		{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "temperature"},
+	}
+
+	BeforeEach(func() {
+		requests = nil
+		s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			defer req.Body.Close()
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			var wr prompb.WriteRequest
+			if err := wr.Unmarshal(body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			mu.Lock()
+			requests = append(requests, wr)
+			mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	})
+
+	AfterEach(func() {
+		s.Close()
+	})
+
+	It("Sends metadata separately, in batches, once per interval", func() {
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:           s.Client(),
+			MetadataSendInterval: time.Hour,
+			MaxMetadataPerSend:   2,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		n, err := w.WriteTimeSeries(context.Background(), series, metadata)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).Should(Equal(1))
+
+		Expect(requests).Should(HaveLen(3))
+		Expect(requests[0].Timeseries).Should(BeEmpty())
+		Expect(requests[0].Metadata).Should(HaveLen(2))
+		Expect(requests[1].Timeseries).Should(BeEmpty())
+		Expect(requests[1].Metadata).Should(HaveLen(1))
+		Expect(requests[2].Timeseries).Should(HaveLen(1))
+		Expect(requests[2].Metadata).Should(BeEmpty())
+
+		_, err = w.WriteTimeSeries(context.Background(), series, metadata)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(requests).Should(HaveLen(4))
+		Expect(requests[3].Metadata).Should(BeEmpty())
+	})
+
+	It("Bundles metadata into every push by default", func() {
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{HTTPClient: s.Client()})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		for range 2 {
+			_, err = w.WriteTimeSeries(context.Background(), series, metadata)
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+
+		Expect(requests).Should(HaveLen(2))
+		Expect(requests[1].Metadata).Should(HaveLen(3))
+	})
+
+	It("Sends metadata in dedicated batches when a push carries too much of it", func() {
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:         s.Client(),
+			MaxMetadataPerSend: 2,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), series, metadata)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(requests).Should(HaveLen(3))
+		Expect(requests[0].Metadata).Should(HaveLen(2))
+		Expect(requests[1].Metadata).Should(HaveLen(1))
+		Expect(requests[2].Timeseries).Should(HaveLen(1))
+		Expect(requests[2].Metadata).Should(BeEmpty())
+
+		_, err = w.WriteTimeSeries(context.Background(), series, metadata[:2])
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(requests).Should(HaveLen(4))
+		Expect(requests[3].Metadata).Should(HaveLen(2))
+	})
+
+	It("Omits metadata when SendMetadata is false", func() {
+		sendMetadata := false
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:   s.Client(),
+			SendMetadata: &sendMetadata,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		n, err := w.WriteTimeSeries(context.Background(), series, metadata)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).Should(Equal(1))
+
+		n, err = w.WriteTimeSeries(context.Background(), nil, metadata)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).Should(BeZero())
+
+		Expect(requests).Should(HaveLen(1))
+		Expect(requests[0].Metadata).Should(BeEmpty())
+	})
+
+	It("Strips help text when StripHelp is set", func() {
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient: s.Client(),
+			StripHelp:  true,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		helpful := []prompb.MetricMetadata{{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "up", Help: "Whether the target is up", Unit: "ratio"}}
+		_, err = w.WriteTimeSeries(context.Background(), series, helpful)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(requests).Should(HaveLen(1))
+		Expect(requests[0].Metadata).Should(Equal([]prompb.MetricMetadata{{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "up", Unit: "ratio"}}))
+		Expect(helpful[0].Help).Should(Equal("Whether the target is up"))
+	})
+
+	It("Truncates help text longer than MaxHelpLength", func() {
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:    s.Client(),
+			MaxHelpLength: 10,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), series, []prompb.MetricMetadata{
+			{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "up", Help: "Short"},
+			{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "temperature", Help: "Température du processeur"},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(requests).Should(HaveLen(1))
+		Expect(requests[0].Metadata[0].Help).Should(Equal("Short"))
+		Expect(requests[0].Metadata[1].Help).Should(Equal("Températur"))
+	})
+
+	It("Only sends metadata for new series when NewSeriesMetadataOnly is set", func() {
+		registry := prometheus.NewRegistry()
+		depth := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "queue_depth", Help: "Jobs waiting"}, []string{"queue"})
+		registry.MustRegister(depth)
+		depth.WithLabelValues("a").Set(1)
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:            s.Client(),
+			NewSeriesMetadataOnly: true,
+		}, registry)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		for range 2 {
+			_, err = w.WriteMetrics(context.Background())
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+		depth.WithLabelValues("b").Set(2)
+		_, err = w.WriteMetrics(context.Background())
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(requests).Should(HaveLen(3))
+		Expect(requests[0].Metadata).Should(HaveLen(1))
+		Expect(requests[1].Metadata).Should(BeEmpty())
+		Expect(requests[1].Timeseries).Should(HaveLen(1))
+		Expect(requests[2].Metadata).Should(HaveLen(1))
+	})
+})
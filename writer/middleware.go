@@ -0,0 +1,78 @@
+package writer
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Middleware wraps a RemoteMetricsWriter to add behaviour around its pushes, such as metrics, logging or rate
+// limiting. A middleware usually returns a type that embeds the writer it is given and overrides the methods it cares
+// about, so the others pass straight through. It should also have an Unwrap() RemoteMetricsWriter method returning the
+// writer it wraps, so AsInspector can find the writer underneath
+type Middleware func(RemoteMetricsWriter) RemoteMetricsWriter
+
+// Chain wraps w in middleware. The first middleware is the outermost, so a push goes through each middleware in the
+// order given before it reaches w
+func Chain(w RemoteMetricsWriter, middleware ...Middleware) RemoteMetricsWriter {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		w = middleware[i](w)
+	}
+
+	return w
+}
+
+// LogPushes returns a Middleware that logs every push to logger (slog.Default() if it is nil): at debug level with the
+// number of series sent and how long the push took, or as a warning with its error if it failed
+func LogPushes(logger *slog.Logger) Middleware {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return func(w RemoteMetricsWriter) RemoteMetricsWriter {
+		return &loggingWriter{RemoteMetricsWriter: w, logger: logger}
+	}
+}
+
+type loggingWriter struct {
+	RemoteMetricsWriter
+	logger *slog.Logger
+}
+
+func (l *loggingWriter) Unwrap() RemoteMetricsWriter {
+	return l.RemoteMetricsWriter
+}
+
+func (l *loggingWriter) WriteMetrics(ctx context.Context) (int, error) {
+	start := time.Now()
+	n, err := l.RemoteMetricsWriter.WriteMetrics(ctx)
+	l.log(ctx, "WriteMetrics", start, n, err)
+	return n, err
+}
+
+func (l *loggingWriter) WriteMetricFamilies(ctx context.Context, metricFamilies []*dto.MetricFamily) (int, error) {
+	start := time.Now()
+	n, err := l.RemoteMetricsWriter.WriteMetricFamilies(ctx, metricFamilies)
+	l.log(ctx, "WriteMetricFamilies", start, n, err)
+	return n, err
+}
+
+func (l *loggingWriter) WriteTimeSeries(ctx context.Context, series []prompb.TimeSeries, metadata []prompb.MetricMetadata) (int, error) {
+	start := time.Now()
+	n, err := l.RemoteMetricsWriter.WriteTimeSeries(ctx, series, metadata)
+	l.log(ctx, "WriteTimeSeries", start, n, err)
+	return n, err
+}
+
+func (l *loggingWriter) log(ctx context.Context, method string, start time.Time, n int, err error) {
+	attrs := []any{slog.String("method", method), slog.Int("series", n), slog.Duration("duration", time.Since(start))}
+	if err != nil {
+		l.logger.WarnContext(ctx, "remote write push failed", append(attrs, slog.Any("error", err))...)
+		return
+	}
+
+	l.logger.DebugContext(ctx, "remote write push", attrs...)
+}
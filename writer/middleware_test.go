@@ -0,0 +1,90 @@
+package writer_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// recordingWriter is a Middleware that records the order calls pass through it in
+type recordingWriter struct {
+	writer.RemoteMetricsWriter
+	name  string
+	calls *[]string
+}
+
+func (r *recordingWriter) Unwrap() writer.RemoteMetricsWriter {
+	return r.RemoteMetricsWriter
+}
+
+func (r *recordingWriter) WriteTimeSeries(ctx context.Context, series []prompb.TimeSeries, metadata []prompb.MetricMetadata) (int, error) {
+	*r.calls = append(*r.calls, r.name)
+	return r.RemoteMetricsWriter.WriteTimeSeries(ctx, series, metadata)
+}
+
+var _ = Describe("Middleware", func() {
+	series := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+	}}
+
+	var (
+		status int
+		s      *httptest.Server
+	)
+
+	BeforeEach(func() {
+		status = http.StatusNoContent
+		s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(status)
+		}))
+		DeferCleanup(s.Close)
+	})
+
+	record := func(name string, calls *[]string) writer.Middleware {
+		return func(w writer.RemoteMetricsWriter) writer.RemoteMetricsWriter {
+			return &recordingWriter{RemoteMetricsWriter: w, name: name, calls: calls}
+		}
+	}
+
+	It("Runs middleware outermost first", func() {
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{HTTPClient: s.Client()})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var calls []string
+		chained := writer.Chain(w, record("first", &calls), record("second", &calls))
+
+		n, err := chained.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).Should(Equal(1))
+		Expect(calls).Should(Equal([]string{"first", "second"}))
+		inspector, ok := writer.AsInspector(chained)
+		Expect(ok).Should(BeTrue())
+		Expect(inspector.TargetStats()).Should(HaveLen(1))
+	})
+
+	It("Logs pushes", func() {
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{HTTPClient: s.Client()})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var logs bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		chained := writer.Chain(w, writer.LogPushes(logger))
+
+		_, err = chained.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(logs.String()).Should(ContainSubstring(`level=DEBUG msg="remote write push" method=WriteTimeSeries series=1`))
+
+		status = http.StatusBadRequest
+		_, err = chained.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).Should(HaveOccurred())
+		Expect(logs.String()).Should(ContainSubstring(`level=WARN msg="remote write push failed" method=WriteTimeSeries series=0`))
+	})
+})
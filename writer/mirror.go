@@ -0,0 +1,78 @@
+package writer
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+)
+
+// maxMirrorsInFlight bounds how many payloads can be on their way to the MirrorURL at once. A mirror that falls
+// further behind than this misses payloads rather than holding on to them
+const maxMirrorsInFlight = 4
+
+// mirror copies payloads to a secondary URL without waiting for, retrying or reporting its result
+type mirror struct {
+	url   string
+	slots chan struct{}
+}
+
+func newMirror(url string) *mirror {
+	if url == "" {
+		return nil
+	}
+
+	return &mirror{url: url, slots: make(chan struct{}, maxMirrorsInFlight)}
+}
+
+// mirror sends a copy of payload to the MirrorURL in the background. Failures are logged at debug level and never
+// affect the push
+func (w *writerImpl) mirror(ctx context.Context, payload Payload) {
+	m := w.mirrors
+	if m == nil {
+		return
+	}
+
+	select {
+	case m.slots <- struct{}{}:
+	default:
+		w.log().DebugContext(ctx, "remote write mirror is behind, skipping payload", "url", m.url)
+		return
+	}
+
+	// the mirror may finish after the push it copies, so it is not cancelled with it
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), DefaultTransportTimeout)
+	go func() {
+		defer func() { <-m.slots }()
+		defer cancel()
+
+		if err := w.sendMirror(ctx, m.url, payload); err != nil {
+			w.log().DebugContext(ctx, "mirroring remote write payload failed", "url", m.url, "error", err)
+		}
+	}()
+}
+
+// sendMirror posts payload to url with the headers that describe it, but none of the credentials, custom headers or
+// signature meant for the target
+func (w *writerImpl) sendMirror(ctx context.Context, url string, payload Payload) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload.Body))
+	if err != nil {
+		return err
+	}
+
+	w.describe(req, payload)
+	if payload.Tenant != "" {
+		req.Header.Set(w.tenantHeader, payload.Tenant)
+	}
+
+	resp, err := w.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package writer_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("Mirroring", func() {
+	series := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+	}}
+
+	var (
+		mu       sync.Mutex
+		mirrored []http.Header
+		status   int
+		primary  *httptest.Server
+		mirror   *httptest.Server
+	)
+
+	BeforeEach(func() {
+		mirrored = nil
+		status = http.StatusNoContent
+
+		primary = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		DeferCleanup(primary.Close)
+
+		mirror = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			mirrored = append(mirrored, r.Header.Clone())
+			mu.Unlock()
+			w.WriteHeader(status)
+		}))
+		DeferCleanup(mirror.Close)
+	})
+
+	received := func() []http.Header {
+		mu.Lock()
+		defer mu.Unlock()
+		return mirrored
+	}
+
+	It("Copies every payload to the mirror without the target's credentials", func() {
+		w, err := writer.NewRemoteMetricsWriter(primary.URL, writer.RemoteMetricsWriterOptions{
+			Compression: writer.Snappy,
+			BearerToken: "secret",
+			Tenant:      "team-a",
+			MirrorURL:   mirror.URL,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Eventually(received).Should(HaveLen(1))
+		headers := received()[0]
+		Expect(headers.Get("Content-Encoding")).Should(Equal("snappy"))
+		Expect(headers.Get(writer.DefaultTenantHeader)).Should(Equal("team-a"))
+		Expect(headers.Get("Authorization")).Should(BeEmpty())
+	})
+
+	It("Never fails a push because the mirror did", func() {
+		status = http.StatusInternalServerError
+		w, err := writer.NewRemoteMetricsWriter(primary.URL, writer.RemoteMetricsWriterOptions{
+			MaxRetries: 3,
+			MirrorURL:  mirror.URL,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		n, err := w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).Should(Equal(1))
+
+		Eventually(received).Should(HaveLen(1))
+		Consistently(received, "100ms").Should(HaveLen(1))
+	})
+})
@@ -0,0 +1,57 @@
+package writer
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// sortSeries puts the labels of every series in name order and its samples, histograms and exemplars in timestamp
+// order, since the remote write specification requires sorted labels and receivers only append samples in order.
+// Series that are already in order are left alone, and the others are copied before sorting so the caller's slices
+// are never modified
+func sortSeries(series []prompb.TimeSeries) []prompb.TimeSeries {
+	var sorted []prompb.TimeSeries
+	for i, ts := range series {
+		if inOrder(ts) {
+			if sorted != nil {
+				sorted = append(sorted, ts)
+			}
+			continue
+		}
+
+		if sorted == nil {
+			sorted = make([]prompb.TimeSeries, i, len(series))
+			copy(sorted, series[:i])
+		}
+
+		ts.Labels = slices.Clone(ts.Labels)
+		slices.SortStableFunc(ts.Labels, compareLabelNames)
+		ts.Samples = slices.Clone(ts.Samples)
+		slices.SortStableFunc(ts.Samples, func(a, b prompb.Sample) int { return cmp.Compare(a.Timestamp, b.Timestamp) })
+		ts.Histograms = slices.Clone(ts.Histograms)
+		slices.SortStableFunc(ts.Histograms, func(a, b prompb.Histogram) int { return cmp.Compare(a.Timestamp, b.Timestamp) })
+		ts.Exemplars = slices.Clone(ts.Exemplars)
+		slices.SortStableFunc(ts.Exemplars, func(a, b prompb.Exemplar) int { return cmp.Compare(a.Timestamp, b.Timestamp) })
+		sorted = append(sorted, ts)
+	}
+
+	if sorted == nil {
+		return series
+	}
+
+	return sorted
+}
+
+func inOrder(ts prompb.TimeSeries) bool {
+	return slices.IsSortedFunc(ts.Labels, compareLabelNames) &&
+		slices.IsSortedFunc(ts.Samples, func(a, b prompb.Sample) int { return cmp.Compare(a.Timestamp, b.Timestamp) }) &&
+		slices.IsSortedFunc(ts.Histograms, func(a, b prompb.Histogram) int { return cmp.Compare(a.Timestamp, b.Timestamp) }) &&
+		slices.IsSortedFunc(ts.Exemplars, func(a, b prompb.Exemplar) int { return cmp.Compare(a.Timestamp, b.Timestamp) })
+}
+
+func compareLabelNames(a, b prompb.Label) int {
+	return strings.Compare(a.Name, b.Name)
+}
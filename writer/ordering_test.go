@@ -0,0 +1,76 @@
+package writer_test
+
+import (
+	"context"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("Sample ordering", func() {
+	It("Sends the samples of each series in timestamp order", func() {
+		var requests []prompb.WriteRequest
+		w, err := writer.NewRemoteMetricsWriter("http://example.invalid/push", writer.RemoteMetricsWriterOptions{
+			Sender: senderFunc(func(_ context.Context, p writer.Payload) error {
+				var wr prompb.WriteRequest
+				if err := wr.Unmarshal(p.Body); err != nil {
+					return err
+				}
+				requests = append(requests, wr)
+				return nil
+			}),
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		series := []prompb.TimeSeries{
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "ordered"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}, {Value: 2, Timestamp: 2000}},
+			},
+			{
+				Labels:    []prompb.Label{{Name: "__name__", Value: "shuffled"}},
+				Samples:   []prompb.Sample{{Value: 3, Timestamp: 3000}, {Value: 1, Timestamp: 1000}, {Value: 2, Timestamp: 2000}},
+				Exemplars: []prompb.Exemplar{{Value: 2, Timestamp: 2000}, {Value: 1, Timestamp: 1000}},
+			},
+		}
+
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(requests[0].Timeseries[0].Samples).Should(Equal(series[0].Samples))
+		Expect(requests[0].Timeseries[1].Samples).Should(Equal([]prompb.Sample{
+			{Value: 1, Timestamp: 1000}, {Value: 2, Timestamp: 2000}, {Value: 3, Timestamp: 3000},
+		}))
+		Expect(requests[0].Timeseries[1].Exemplars[0].Timestamp).Should(BeEquivalentTo(1000))
+		Expect(series[1].Samples[0].Timestamp).Should(BeEquivalentTo(3000))
+	})
+
+	It("Sends labels sorted by name", func() {
+		var requests []prompb.WriteRequest
+		w, err := writer.NewRemoteMetricsWriter("http://example.invalid/push", writer.RemoteMetricsWriterOptions{
+			Sender: senderFunc(func(_ context.Context, p writer.Payload) error {
+				var wr prompb.WriteRequest
+				if err := wr.Unmarshal(p.Body); err != nil {
+					return err
+				}
+				requests = append(requests, wr)
+				return nil
+			}),
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		labels := []prompb.Label{{Name: "zone", Value: "a"}, {Name: "__name__", Value: "up"}, {Name: "job", Value: "api"}}
+		_, err = w.WriteTimeSeries(context.Background(), []prompb.TimeSeries{{
+			Labels:  labels,
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+		}}, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(requests[0].Timeseries[0].Labels).Should(Equal([]prompb.Label{
+			{Name: "__name__", Value: "up"}, {Name: "job", Value: "api"}, {Name: "zone", Value: "a"},
+		}))
+		Expect(labels[0].Name).Should(Equal("zone"))
+	})
+})
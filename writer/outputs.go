@@ -5,10 +5,13 @@ import (
 	"compress/gzip"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 
 	"github.com/golang/snappy"
+	"github.com/jghiloni/prometheus-remote-write/internal/remotepb"
 	"github.com/prometheus/prometheus/prompb"
+	"google.golang.org/protobuf/proto"
 )
 
 // String returns the name of the Format
@@ -18,6 +21,10 @@ func (f Format) String() string {
 		return "protobuf"
 	case JSON:
 		return "json"
+	case JSONProto:
+		return "jsonproto"
+	case GoProtobuf:
+		return "goprotobuf"
 	default:
 		return fmt.Sprintf("%%INVALID!(%d)", f)
 	}
@@ -30,18 +37,54 @@ func (f Format) Marshal(wr prompb.WriteRequest) ([]byte, error) {
 		return wr.Marshal()
 	case JSON:
 		return json.Marshal(wr)
+	case JSONProto:
+		return remotepb.MarshalJSON(wr)
+	case GoProtobuf:
+		return proto.Marshal(remotepb.FromPrompb(wr))
 	default:
 		return nil, fmt.Errorf("unrecognized format %s", f)
 	}
 }
 
+// Unmarshal is the inverse of Marshal
+func (f Format) Unmarshal(data []byte) (prompb.WriteRequest, error) {
+	var wr prompb.WriteRequest
+	switch f {
+	case Protobuf:
+		return wr, wr.Unmarshal(data)
+	case GoProtobuf:
+		var m remotepb.WriteRequest
+		if err := proto.Unmarshal(data, &m); err != nil {
+			return wr, err
+		}
+		return remotepb.ToPrompb(&m), nil
+	case JSON:
+		return wr, json.Unmarshal(data, &wr)
+	case JSONProto:
+		return remotepb.UnmarshalJSON(data)
+	default:
+		return wr, fmt.Errorf("cannot unmarshal format %s", f)
+	}
+}
+
+// ParseFormat returns the Format whose String() is name
+func ParseFormat(name string) (Format, error) {
+	for _, f := range []Format{Protobuf, JSON, JSONProto, GoProtobuf} {
+		if f.String() == name {
+			return f, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unrecognized format %q", name)
+}
+
 // UpdateRequest adds the approprate Content-Type header to the given request
 func (f Format) UpdateRequest(req *http.Request) {
 	contentType := "application/octet-stream"
 	switch f {
-	case Protobuf:
+	case Protobuf, GoProtobuf:
 		contentType = "application/x-protobuf"
-	case JSON:
+	case JSON, JSONProto:
 		contentType = "application/json"
 	}
 	req.Header.Set("Content-Type", contentType)
@@ -84,6 +127,37 @@ func (e Compression) Compress(data []byte) ([]byte, error) {
 	}
 }
 
+// Decompress is the inverse of Compress
+func (e Compression) Decompress(data []byte) ([]byte, error) {
+	switch e {
+	case None:
+		return data, nil
+	case Snappy:
+		return snappy.Decode(nil, data)
+	case Gzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		return io.ReadAll(r)
+	default:
+		return nil, fmt.Errorf("unsupported encoding %s", e)
+	}
+}
+
+// ParseCompression returns the Compression whose String() is name
+func ParseCompression(name string) (Compression, error) {
+	for _, e := range []Compression{None, Snappy, Gzip} {
+		if e.String() == name {
+			return e, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unrecognized compression %q", name)
+}
+
 // UpdateRequest adds the appropriate Content-Encoding header to the given request
 func (e Compression) UpdateRequest(req *http.Request) {
 	if e != None {
@@ -0,0 +1,105 @@
+package writer
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PayloadStats describes one request a writer delivered, so compression choices can be tuned with real data
+type PayloadStats struct {
+	TargetURL         string
+	Tenant            string
+	Format            Format
+	Compression       Compression
+	Series            int
+	Samples           int
+	UncompressedBytes int
+	CompressedBytes   int
+	// Network holds the network timings of the request, which are zero for payloads handed to a Sender
+	Network RequestTimings
+}
+
+// CompressionRatio returns how many times smaller compression made the payload, or 1 if it was sent uncompressed
+func (s PayloadStats) CompressionRatio() float64 {
+	if s.CompressedBytes == 0 {
+		return 1
+	}
+
+	return float64(s.UncompressedBytes) / float64(s.CompressedBytes)
+}
+
+// PayloadMetrics is a prometheus.Collector reporting the sizes of the payloads a writer delivers. Pass its Observe
+// method as OnPayload and register it with a registry; if that registry is one of the writer's gatherers, the writer
+// pushes its own payload statistics along with everything else
+type PayloadMetrics struct {
+	uncompressed *prometheus.HistogramVec
+	compressed   *prometheus.HistogramVec
+	ratio        *prometheus.HistogramVec
+	network      *prometheus.HistogramVec
+}
+
+// NewPayloadMetrics creates a PayloadMetrics whose metrics are labelled with the target URL and compression used
+func NewPayloadMetrics() *PayloadMetrics {
+	labels := []string{"url", "compression"}
+	sizes := prometheus.ExponentialBuckets(256, 4, 10)
+
+	return &PayloadMetrics{
+		uncompressed: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "prometheus_remote_write_payload_uncompressed_bytes",
+			Help:    "Size of delivered remote write payloads before compression.",
+			Buckets: sizes,
+		}, labels),
+		compressed: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "prometheus_remote_write_payload_compressed_bytes",
+			Help:    "Size of delivered remote write payloads as sent.",
+			Buckets: sizes,
+		}, labels),
+		ratio: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "prometheus_remote_write_payload_compression_ratio",
+			Help:    "Uncompressed size of delivered remote write payloads divided by their size as sent.",
+			Buckets: []float64{1, 1.5, 2, 3, 4, 6, 8, 12, 16, 24, 32},
+		}, labels),
+		network: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "prometheus_remote_write_request_phase_seconds",
+			Help:    "Time delivered remote write requests spent in each network phase (dns, connect, tls and ttfb).",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"url", "phase"}),
+	}
+}
+
+// Observe records a delivered payload
+func (m *PayloadMetrics) Observe(s PayloadStats) {
+	labels := prometheus.Labels{"url": s.TargetURL, "compression": s.Compression.String()}
+	m.uncompressed.With(labels).Observe(float64(s.UncompressedBytes))
+	m.compressed.With(labels).Observe(float64(s.CompressedBytes))
+	m.ratio.With(labels).Observe(s.CompressionRatio())
+
+	if s.Network.Total == 0 {
+		return
+	}
+
+	// a reused connection skips the dns, connect and tls phases, which would otherwise show up as instant
+	if !s.Network.ReusedConn {
+		m.network.WithLabelValues(s.TargetURL, "dns").Observe(s.Network.DNS.Seconds())
+		m.network.WithLabelValues(s.TargetURL, "connect").Observe(s.Network.Connect.Seconds())
+		if s.Network.TLS > 0 {
+			m.network.WithLabelValues(s.TargetURL, "tls").Observe(s.Network.TLS.Seconds())
+		}
+	}
+	m.network.WithLabelValues(s.TargetURL, "ttfb").Observe(s.Network.TimeToFirstByte.Seconds())
+}
+
+// Describe implements prometheus.Collector
+func (m *PayloadMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.uncompressed.Describe(ch)
+	m.compressed.Describe(ch)
+	m.ratio.Describe(ch)
+	m.network.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (m *PayloadMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.uncompressed.Collect(ch)
+	m.compressed.Collect(ch)
+	m.ratio.Collect(ch)
+	m.network.Collect(ch)
+}
@@ -0,0 +1,106 @@
+package writer_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("Payload statistics", func() {
+	var s *httptest.Server
+
+	BeforeEach(func() {
+		s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		DeferCleanup(s.Close)
+	})
+
+	series := func(n int) []prompb.TimeSeries {
+		ts := make([]prompb.TimeSeries, n)
+		for i := range ts {
+			ts[i] = prompb.TimeSeries{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "requests_total"}, {Name: "path", Value: "/api/" + strconv.Itoa(i)}},
+				Samples: []prompb.Sample{{Value: float64(i), Timestamp: 1000}, {Value: float64(i + 1), Timestamp: 2000}},
+			}
+		}
+		return ts
+	}
+
+	It("reports the size of every payload before and after compression", func() {
+		var stats []writer.PayloadStats
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:  s.Client(),
+			Compression: writer.Snappy,
+			OnPayload:   func(p writer.PayloadStats) { stats = append(stats, p) },
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), series(50), nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(stats).Should(HaveLen(1))
+		Expect(stats[0].TargetURL).Should(Equal(s.URL))
+		Expect(stats[0].Compression).Should(Equal(writer.Snappy))
+		Expect(stats[0].Series).Should(Equal(50))
+		Expect(stats[0].Samples).Should(Equal(100))
+		Expect(stats[0].CompressedBytes).Should(BeNumerically("<", stats[0].UncompressedBytes))
+		Expect(stats[0].CompressionRatio()).Should(BeNumerically(">", 1))
+
+		target := inspect(w).TargetStats()[0]
+		Expect(target.BytesSent).Should(BeEquivalentTo(stats[0].CompressedBytes))
+		Expect(target.UncompressedBytesSent).Should(BeEquivalentTo(stats[0].UncompressedBytes))
+		Expect(target.CompressionRatio()).Should(Equal(stats[0].CompressionRatio()))
+	})
+
+	It("turns the statistics into metrics", func() {
+		metrics := writer.NewPayloadMetrics()
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(metrics)
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient: s.Client(),
+			OnPayload:  metrics.Observe,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), series(5), nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		families, err := registry.Gather()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(families).Should(HaveLen(4))
+		for _, family := range families {
+			Expect(family.GetMetric()).ShouldNot(BeEmpty())
+			Expect(family.GetMetric()[0].GetHistogram().GetSampleCount()).Should(BeEquivalentTo(1))
+		}
+	})
+
+	It("reports the network timings of each request", func() {
+		var stats []writer.PayloadStats
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient: s.Client(),
+			OnPayload:  func(p writer.PayloadStats) { stats = append(stats, p) },
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		for range 2 {
+			_, err = w.WriteTimeSeries(context.Background(), series(5), nil)
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+
+		Expect(stats).Should(HaveLen(2))
+		Expect(stats[0].Network.ReusedConn).Should(BeFalse())
+		Expect(stats[0].Network.Connect).Should(BeNumerically(">", 0))
+		Expect(stats[0].Network.Total).Should(BeNumerically(">=", stats[0].Network.TimeToFirstByte))
+		Expect(stats[1].Network.ReusedConn).Should(BeTrue())
+		Expect(stats[1].Network.Connect).Should(BeZero())
+	})
+})
@@ -0,0 +1,160 @@
+package writer_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("JSONProto format", func() {
+	It("Uses the canonical protobuf JSON mapping", func() {
+		data, err := writer.JSONProto.Marshal(prompb.WriteRequest{
+			Timeseries: []prompb.TimeSeries{{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1700000000000}, {Value: math.NaN(), Timestamp: 1700000015000}},
+			}},
+			Metadata: []prompb.MetricMetadata{{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "up"}},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(json.Valid(data)).Should(BeTrue())
+		Expect(string(data)).Should(Equal(`{"timeseries":[{"labels":[{"name":"__name__","value":"up"}],` +
+			`"samples":[{"value":1,"timestamp":"1700000000000"},{"value":"NaN","timestamp":"1700000015000"}]}],` +
+			`"metadata":[{"type":"GAUGE","metricFamilyName":"up"}]}`))
+	})
+
+	It("Writes histogram counts and enums", func() {
+		data, err := writer.JSONProto.Marshal(prompb.WriteRequest{
+			Timeseries: []prompb.TimeSeries{{
+				Histograms: []prompb.Histogram{{
+					Count:          &prompb.Histogram_CountInt{CountInt: 0},
+					Schema:         3,
+					PositiveSpans:  []prompb.BucketSpan{{Offset: -1, Length: 2}},
+					PositiveDeltas: []int64{1, -1},
+					ResetHint:      prompb.Histogram_GAUGE,
+				}},
+			}},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(data)).Should(Equal(`{"timeseries":[{"histograms":[{"countInt":"0","schema":3,` +
+			`"positiveSpans":[{"offset":-1,"length":2}],"positiveDeltas":["1","-1"],"resetHint":"GAUGE"}]}]}`))
+	})
+
+	It("Has a name", func() {
+		Expect(writer.JSONProto.String()).Should(Equal("jsonproto"))
+	})
+})
+
+// goldenRequests are the requests whose JSONProto encodings are kept in testdata/jsonproto. The encodings are the
+// format's contract with whatever parses them, so a change that breaks one of these files is a breaking change
+var goldenRequests = map[string]prompb.WriteRequest{
+	"empty": {},
+	"samples": {
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels: []prompb.Label{{Name: "__name__", Value: "http_requests_total"}, {Name: "code", Value: "200"}},
+				Samples: []prompb.Sample{
+					{Value: 1027, Timestamp: 1700000000000},
+					{Value: 0, Timestamp: 1700000015000},
+					{Value: math.Inf(1), Timestamp: 1700000030000},
+					{Value: math.Inf(-1), Timestamp: 1700000045000},
+					{Value: math.NaN(), Timestamp: 1700000060000},
+				},
+				Exemplars: []prompb.Exemplar{{
+					Labels:    []prompb.Label{{Name: "trace_id", Value: "4bf92f3577b34da6"}},
+					Value:     0.25,
+					Timestamp: 1700000000000,
+				}},
+			},
+		},
+		Metadata: []prompb.MetricMetadata{
+			{Type: prompb.MetricMetadata_COUNTER, MetricFamilyName: "http_requests_total", Help: "Requests served.", Unit: "requests"},
+			{Type: prompb.MetricMetadata_UNKNOWN, MetricFamilyName: "untyped"},
+		},
+	},
+	"histograms": {
+		Timeseries: []prompb.TimeSeries{{
+			Labels: []prompb.Label{{Name: "__name__", Value: "request_duration_seconds"}},
+			Histograms: []prompb.Histogram{
+				{
+					Count:          &prompb.Histogram_CountInt{CountInt: 12},
+					Sum:            3.5,
+					Schema:         3,
+					ZeroThreshold:  1e-128,
+					ZeroCount:      &prompb.Histogram_ZeroCountInt{ZeroCountInt: 2},
+					NegativeSpans:  []prompb.BucketSpan{{Offset: 0, Length: 1}},
+					NegativeDeltas: []int64{1},
+					PositiveSpans:  []prompb.BucketSpan{{Offset: -2, Length: 3}},
+					PositiveDeltas: []int64{4, -1, 3},
+					ResetHint:      prompb.Histogram_NO,
+					Timestamp:      1700000000000,
+				},
+				{
+					Count:          &prompb.Histogram_CountFloat{CountFloat: 6.5},
+					Sum:            -1.25,
+					Schema:         -53,
+					ZeroCount:      &prompb.Histogram_ZeroCountFloat{ZeroCountFloat: 0},
+					PositiveSpans:  []prompb.BucketSpan{{Offset: 0, Length: 2}},
+					PositiveCounts: []float64{2.5, 4},
+					ResetHint:      prompb.Histogram_GAUGE,
+					Timestamp:      1700000015000,
+					CustomValues:   []float64{0.1, 1},
+				},
+			},
+		}},
+		Metadata: []prompb.MetricMetadata{
+			{Type: prompb.MetricMetadata_HISTOGRAM, MetricFamilyName: "request_duration_seconds", Unit: "seconds"},
+		},
+	},
+}
+
+var _ = Describe("JSONProto golden files", func() {
+	for name, wr := range goldenRequests {
+		It("Encodes the "+name+" request exactly as testdata/jsonproto/"+name+".json", func() {
+			golden, err := os.ReadFile(filepath.Join("testdata", "jsonproto", name+".json"))
+			Expect(err).ShouldNot(HaveOccurred())
+
+			data, err := writer.JSONProto.Marshal(wr)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			// the files are indented for review; the encoding itself is compact
+			var compact bytes.Buffer
+			Expect(json.Compact(&compact, golden)).Should(Succeed())
+			Expect(string(data)).Should(Equal(compact.String()))
+		})
+
+		It("Reads testdata/jsonproto/"+name+".json back to the request it was written from", func() {
+			golden, err := os.ReadFile(filepath.Join("testdata", "jsonproto", name+".json"))
+			Expect(err).ShouldNot(HaveOccurred())
+
+			decoded, err := writer.JSONProto.Unmarshal(golden)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			// NaN never equals itself, so compare the re-encoded requests rather than the requests
+			expected, err := writer.JSONProto.Marshal(wr)
+			Expect(err).ShouldNot(HaveOccurred())
+			actual, err := writer.JSONProto.Marshal(decoded)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(actual)).Should(Equal(string(expected)))
+			Expect(decoded.Timeseries).Should(HaveLen(len(wr.Timeseries)))
+			Expect(decoded.Metadata).Should(HaveLen(len(wr.Metadata)))
+		})
+	}
+
+	It("Round trips histograms exactly", func() {
+		wr := goldenRequests["histograms"]
+		data, err := writer.JSONProto.Marshal(wr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		decoded, err := writer.JSONProto.Unmarshal(data)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(decoded).Should(Equal(wr))
+	})
+})
@@ -0,0 +1,121 @@
+package writer_test
+
+import (
+	"math"
+	"slices"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// fields splits one level of a protobuf message into its field numbers and raw values
+func fields(data []byte) ([]protowire.Number, [][]byte) {
+	var nums []protowire.Number
+	var values [][]byte
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		Expect(n).Should(BeNumerically(">", 0))
+		data = data[n:]
+
+		m := protowire.ConsumeFieldValue(num, typ, data)
+		Expect(m).Should(BeNumerically(">", 0))
+		value := data[:m]
+		if typ == protowire.BytesType {
+			value, _ = protowire.ConsumeBytes(value)
+		}
+
+		nums = append(nums, num)
+		values = append(values, value)
+		data = data[m:]
+	}
+
+	return nums, values
+}
+
+var _ = Describe("GoProtobuf format", func() {
+	It("Encodes the remote write wire format", func() {
+		data, err := writer.GoProtobuf.Marshal(prompb.WriteRequest{
+			Timeseries: []prompb.TimeSeries{{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+			}},
+			Metadata: []prompb.MetricMetadata{{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "up"}},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		nums, values := fields(data)
+		Expect(nums).Should(Equal([]protowire.Number{1, 3}))
+
+		nums, series := fields(values[0])
+		Expect(nums).Should(Equal([]protowire.Number{1, 2}))
+
+		nums, label := fields(series[0])
+		Expect(nums).Should(Equal([]protowire.Number{1, 2}))
+		Expect(string(label[0])).Should(Equal("__name__"))
+		Expect(string(label[1])).Should(Equal("up"))
+
+		nums, sample := fields(series[1])
+		Expect(nums).Should(Equal([]protowire.Number{1, 2}))
+		bits, _ := protowire.ConsumeFixed64(sample[0])
+		Expect(math.Float64frombits(bits)).Should(Equal(1.0))
+		ts, _ := protowire.ConsumeVarint(sample[1])
+		Expect(ts).Should(Equal(uint64(1000)))
+
+		nums, metadata := fields(values[1])
+		Expect(nums).Should(Equal([]protowire.Number{1, 2}))
+		typ, _ := protowire.ConsumeVarint(metadata[0])
+		Expect(typ).Should(Equal(uint64(prompb.MetricMetadata_GAUGE)))
+	})
+
+	It("Writes zero valued oneof members of histograms", func() {
+		data, err := writer.GoProtobuf.Marshal(prompb.WriteRequest{
+			Timeseries: []prompb.TimeSeries{{
+				Histograms: []prompb.Histogram{{
+					Count:     &prompb.Histogram_CountInt{CountInt: 0},
+					ZeroCount: &prompb.Histogram_ZeroCountFloat{ZeroCountFloat: 0},
+					Schema:    -2,
+				}},
+			}},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, values := fields(data)
+		_, series := fields(values[0])
+		// proto.Marshal writes oneof members after the other fields, which the wire format allows
+		nums, histogram := fields(series[0])
+		Expect(nums).Should(ConsistOf(protowire.Number(1), protowire.Number(4), protowire.Number(7)))
+		schema, _ := protowire.ConsumeVarint(histogram[slices.Index(nums, 4)])
+		Expect(protowire.DecodeZigZag(schema)).Should(Equal(int64(-2)))
+	})
+
+	It("Reads back what it writes", func() {
+		wr := prompb.WriteRequest{
+			Timeseries: []prompb.TimeSeries{{
+				Labels:    []prompb.Label{{Name: "__name__", Value: "latency"}},
+				Samples:   []prompb.Sample{{Value: 2.5, Timestamp: 1000}},
+				Exemplars: []prompb.Exemplar{{Labels: []prompb.Label{{Name: "trace_id", Value: "abc"}}, Value: 2, Timestamp: 900}},
+				Histograms: []prompb.Histogram{{
+					Count:          &prompb.Histogram_CountInt{CountInt: 3},
+					ZeroCount:      &prompb.Histogram_ZeroCountInt{ZeroCountInt: 1},
+					Sum:            7.5,
+					Schema:         1,
+					PositiveSpans:  []prompb.BucketSpan{{Offset: 0, Length: 2}},
+					PositiveDeltas: []int64{1, 1},
+					ResetHint:      prompb.Histogram_GAUGE,
+					Timestamp:      1000,
+				}},
+			}},
+			Metadata: []prompb.MetricMetadata{{Type: prompb.MetricMetadata_HISTOGRAM, MetricFamilyName: "latency", Help: "Request latency", Unit: "seconds"}},
+		}
+
+		data, err := writer.GoProtobuf.Marshal(wr)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		decoded, err := writer.GoProtobuf.Unmarshal(data)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(decoded).Should(Equal(wr))
+	})
+})
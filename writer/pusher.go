@@ -0,0 +1,145 @@
+package writer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// DefaultPushInterval is how often a Pusher pushes when it has no Interval
+const DefaultPushInterval = 15 * time.Second
+
+// PusherOptions holds the settings of a Pusher.
+//
+//	If Interval is not set, it defaults to DefaultPushInterval
+//	If OnError is set, it is called with the error of every push that fails, and of every failed reload in
+//	WatchConfigFile
+//	If OnStart is set, it is called when Run starts, before the first push, and if OnStop is set, it is called when Run
+//	returns, after the final push
+//	If OnConfigReload is set, it is called every time Reload gives the Pusher a new writer
+//	If ConfigCheckInterval is not set, WatchConfigFile checks its file every DefaultConfigCheckInterval
+//	If Clock is set, the Pusher waits on it between pushes instead of the system clock (see writertest.FakeClock)
+//
+// The hooks let a program tie the Pusher into its own health checks and supervision. They are called on the goroutine
+// that runs the Pusher (or calls Reload), so they should not block
+type PusherOptions struct {
+	Interval       time.Duration
+	OnError        func(error)
+	OnStart        func()
+	OnStop         func()
+	OnConfigReload func()
+
+	ConfigCheckInterval time.Duration
+
+	Clock Clock
+}
+
+// Pusher calls WriteMetrics on a RemoteMetricsWriter at a fixed interval, for programs that only want their metrics
+// sent in the background
+type Pusher struct {
+	mu       sync.Mutex
+	w        RemoteMetricsWriter
+	interval time.Duration
+	options  PusherOptions
+}
+
+// NewPusher returns a Pusher that pushes through w
+func NewPusher(w RemoteMetricsWriter, options PusherOptions) *Pusher {
+	if options.Interval <= 0 {
+		options.Interval = DefaultPushInterval
+	}
+
+	if options.ConfigCheckInterval <= 0 {
+		options.ConfigCheckInterval = DefaultConfigCheckInterval
+	}
+
+	if options.Clock == nil {
+		options.Clock = systemClock{}
+	}
+
+	return &Pusher{w: w, interval: options.Interval, options: options}
+}
+
+// NewRuntimePusher returns a Pusher that sends the Go runtime and process metrics of the program to targetURL, along
+// with the registry they are gathered from, which the program can register its own collectors with too. Sending them
+// to Mimir, say, takes no more than
+//
+//	pusher, _, err := writer.NewRuntimePusher(url, writer.RemoteMetricsWriterOptions{BearerToken: token}, writer.PusherOptions{})
+//	if err == nil {
+//		go pusher.Run(ctx)
+//	}
+func NewRuntimePusher(targetURL string, options RemoteMetricsWriterOptions, pusherOptions PusherOptions) (*Pusher, *prometheus.Registry, error) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	w, err := NewRemoteMetricsWriter(targetURL, options, registry)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return NewPusher(w, pusherOptions), registry, nil
+}
+
+// Run pushes straight away and then every Interval (or the push_interval of the file WatchConfigFile watches) until
+// ctx is done. It then pushes once more, waiting at most DefaultTransportTimeout, so the metrics of the last interval
+// are not lost when the program shuts down
+func (p *Pusher) Run(ctx context.Context) {
+	if p.options.OnStart != nil {
+		p.options.OnStart()
+	}
+	if p.options.OnStop != nil {
+		defer p.options.OnStop()
+	}
+
+	for {
+		p.mu.Lock()
+		interval := p.interval
+		p.mu.Unlock()
+
+		next := p.options.Clock.After(interval)
+		p.push(ctx)
+
+		select {
+		case <-ctx.Done():
+			final, cancel := context.WithTimeout(context.WithoutCancel(ctx), DefaultTransportTimeout)
+			defer cancel()
+			p.push(final)
+			return
+		case <-next:
+		}
+	}
+}
+
+// Reload replaces the writer the Pusher pushes through, such as with one built from a configuration file that has
+// changed. A push in progress finishes with the old writer; the next one uses w
+func (p *Pusher) Reload(w RemoteMetricsWriter) {
+	p.mu.Lock()
+	p.w = w
+	p.mu.Unlock()
+
+	if p.options.OnConfigReload != nil {
+		p.options.OnConfigReload()
+	}
+}
+
+func (p *Pusher) push(ctx context.Context) {
+	p.mu.Lock()
+	w := p.w
+	p.mu.Unlock()
+
+	if _, err := w.WriteMetrics(ctx); err != nil {
+		p.reportError(err)
+	}
+}
+
+func (p *Pusher) reportError(err error) {
+	if p.options.OnError != nil {
+		p.options.OnError(err)
+	}
+}
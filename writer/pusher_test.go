@@ -0,0 +1,138 @@
+package writer_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("Pusher", func() {
+	var (
+		mu       sync.Mutex
+		requests []prompb.WriteRequest
+		s        *httptest.Server
+	)
+
+	BeforeEach(func() {
+		requests = nil
+		s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			var wr prompb.WriteRequest
+			Expect(wr.Unmarshal(body)).Should(Succeed())
+
+			mu.Lock()
+			requests = append(requests, wr)
+			mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		DeferCleanup(s.Close)
+	})
+
+	pushes := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(requests)
+	}
+
+	It("Pushes the runtime metrics every interval and once more when stopped", func() {
+		pusher, registry, err := writer.NewRuntimePusher(s.URL, writer.RemoteMetricsWriterOptions{HTTPClient: s.Client()},
+			writer.PusherOptions{Interval: 20 * time.Millisecond})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(registry).ShouldNot(BeNil())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			pusher.Run(ctx)
+		}()
+
+		Eventually(pushes).Should(BeNumerically(">=", 2))
+		cancel()
+		Eventually(done).Should(BeClosed())
+
+		mu.Lock()
+		defer mu.Unlock()
+		var names []string
+		for _, ts := range requests[0].Timeseries {
+			for _, l := range ts.Labels {
+				if l.Name == "__name__" {
+					names = append(names, l.Value)
+				}
+			}
+		}
+		Expect(names).Should(ContainElements("go_goroutines", "go_memstats_alloc_bytes"))
+	})
+
+	It("Reports failed pushes", func() {
+		rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer rejecting.Close()
+
+		w, err := writer.NewRemoteMetricsWriter(rejecting.URL, writer.RemoteMetricsWriterOptions{HTTPClient: rejecting.Client()})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var errs []error
+		pusher := writer.NewPusher(w, writer.PusherOptions{OnError: func(err error) { errs = append(errs, err) }})
+
+		// a stopped pusher still makes its first and final pushes
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		pusher.Run(ctx)
+
+		Expect(errs).Should(HaveLen(2))
+		Expect(errs[1]).Should(MatchError(writer.ErrUnexpectedStatus))
+	})
+
+	It("Calls its lifecycle hooks and pushes through the writer it is reloaded with", func() {
+		rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer rejecting.Close()
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "up"}))
+
+		old, err := writer.NewRemoteMetricsWriter(rejecting.URL,
+			writer.RemoteMetricsWriterOptions{HTTPClient: rejecting.Client()}, registry)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{HTTPClient: s.Client()}, registry)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var events []string
+		pusher := writer.NewPusher(old, writer.PusherOptions{
+			OnStart:        func() { events = append(events, "start") },
+			OnStop:         func() { events = append(events, "stop") },
+			OnError:        func(error) { events = append(events, "error") },
+			OnConfigReload: func() { events = append(events, "reload") },
+		})
+		pusher.Reload(w)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			pusher.Run(ctx)
+		}()
+
+		Eventually(pushes).Should(BeNumerically(">=", 1))
+		cancel()
+		Eventually(done).Should(BeClosed())
+
+		Expect(events).Should(Equal([]string{"reload", "start", "stop"}))
+		Expect(pushes()).Should(BeNumerically(">=", 2))
+	})
+})
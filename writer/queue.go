@@ -0,0 +1,406 @@
+package writer
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// The queue defaults are the defaults of Prometheus's own queue_config
+const (
+	DefaultQueueCapacity     = 10000
+	DefaultMaxShards         = 50
+	DefaultMinShards         = 1
+	DefaultMaxSamplesPerSend = 2000
+	DefaultBatchSendDeadline = 5 * time.Second
+	DefaultQueueMinBackoff   = DefaultMinBackoff
+	DefaultQueueMaxBackoff   = DefaultMaxBackoff
+
+	// DefaultShardUpdateInterval is how often a QueueManager reconsiders its number of shards, as in Prometheus
+	DefaultShardUpdateInterval = 10 * time.Second
+)
+
+// QueueConfig holds the settings of a QueueManager. They mean what the settings of the same names in Prometheus's
+// queue_config mean, so existing tuning carries over.
+//
+//	If Capacity is not set, it defaults to DefaultQueueCapacity. It is the number of series each shard buffers
+//	If MaxShards is not set, it defaults to DefaultMaxShards
+//	If MinShards is not set, it defaults to DefaultMinShards. The queue starts with MinShards shards and scales between
+//	MinShards and MaxShards the way Prometheus does, from how fast series are appended and how long sends take
+//	If MaxSamplesPerSend is not set, it defaults to DefaultMaxSamplesPerSend
+//	If BatchSendDeadline is not set, it defaults to DefaultBatchSendDeadline. A shard sends what it has buffered at
+//	least this often, even if it has fewer than MaxSamplesPerSend samples
+//	If MinBackoff or MaxBackoff is not set, it defaults to DefaultQueueMinBackoff or DefaultQueueMaxBackoff. A batch
+//	that fails with a recoverable error (a network error, 429 or 5xx) is sent again after MinBackoff, doubling the
+//	wait each time up to MaxBackoff, until it succeeds or the queue manager is stopped. Batches that fail with any
+//	other error are dropped
+//	If ShardUpdateInterval is not set, it defaults to DefaultShardUpdateInterval. Prometheus does not make this
+//	configurable
+//	FamilyPriorities gives metric families a priority, as the writer option of the same name does. Series of families
+//	with a negative priority (debug metrics, say) are dropped rather than waited for when their shard is full, so
+//	that under pressure they are shed before anything else holds up Append
+//	If Clock is set, batch deadlines, backoff and shard updates wait on it instead of the system clock, as a writer's
+//	Clock does
+type QueueConfig struct {
+	Capacity          int
+	MaxShards         int
+	MinShards         int
+	MaxSamplesPerSend int
+	BatchSendDeadline time.Duration
+	MinBackoff        time.Duration
+	MaxBackoff        time.Duration
+
+	ShardUpdateInterval time.Duration
+
+	FamilyPriorities map[string]int
+
+	Clock Clock
+}
+
+// QueueManager buffers series appended to it and sends them through a RemoteMetricsWriter in batches, from several
+// shards at once. Each series always goes to the same shard, so its samples are sent in the order they were appended.
+// The writer's own retries add to the queue's, so it is usually best to give it no MaxRetries
+type QueueManager struct {
+	w      RemoteMetricsWriter
+	config QueueConfig
+
+	// ctx is canceled when Stop gives up waiting for the shards to flush
+	ctx    context.Context
+	cancel context.CancelFunc
+	// stop is closed when Stop is called, which ends shard scaling
+	stop chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+	shards []*queueShard
+	wg     sync.WaitGroup
+
+	sent    atomic.Uint64
+	dropped atomic.Uint64
+
+	// the measurements shard scaling is based on, reset every ShardUpdateInterval
+	samplesIn      atomic.Int64
+	samplesOut     atomic.Int64
+	sendDurationNs atomic.Int64
+	scaling        shardScaling
+}
+
+type queueShard struct {
+	queue chan prompb.TimeSeries
+	done  chan struct{}
+	// space is signalled when the shard takes a series off a full queue, to wake an Append waiting for room
+	space chan struct{}
+	// retired is closed along with queue, to wake the Appends waiting for room in a shard that is going away
+	retired chan struct{}
+	// ready is closed once the shards this one replaced are done, and the shard waits for it before its first send,
+	// so series stay in order across a reshard. It is nil for the first shards
+	ready <-chan struct{}
+
+	// ctx is canceled to abandon the shard's batches, when it is replaced and cannot drain in time
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// retire stops the shard taking series. Its runner sends what it has buffered and exits. q.mu must be held for
+// writing, so no Append is sending to queue
+func (s *queueShard) retire() {
+	close(s.queue)
+	close(s.retired)
+}
+
+// signal wakes one Append waiting for room in the shard, if there is one
+func (s *queueShard) signal() {
+	select {
+	case s.space <- struct{}{}:
+	default:
+	}
+}
+
+// NewQueueManager fills in config's defaults and starts a QueueManager that sends through w
+func NewQueueManager(w RemoteMetricsWriter, config QueueConfig) *QueueManager {
+	if config.Capacity <= 0 {
+		config.Capacity = DefaultQueueCapacity
+	}
+
+	if config.MaxShards <= 0 {
+		config.MaxShards = DefaultMaxShards
+	}
+
+	if config.MinShards <= 0 {
+		config.MinShards = DefaultMinShards
+	}
+
+	if config.MinShards > config.MaxShards {
+		config.MinShards = config.MaxShards
+	}
+
+	if config.MaxSamplesPerSend <= 0 {
+		config.MaxSamplesPerSend = DefaultMaxSamplesPerSend
+	}
+
+	if config.BatchSendDeadline <= 0 {
+		config.BatchSendDeadline = DefaultBatchSendDeadline
+	}
+
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = DefaultQueueMinBackoff
+	}
+
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = DefaultQueueMaxBackoff
+	}
+
+	if config.MaxBackoff < config.MinBackoff {
+		config.MaxBackoff = config.MinBackoff
+	}
+
+	if config.ShardUpdateInterval <= 0 {
+		config.ShardUpdateInterval = DefaultShardUpdateInterval
+	}
+
+	config.FamilyPriorities = maps.Clone(config.FamilyPriorities)
+
+	if config.Clock == nil {
+		config.Clock = systemClock{}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &QueueManager{
+		w:      w,
+		config: config,
+		ctx:    ctx,
+		cancel: cancel,
+		stop:   make(chan struct{}),
+	}
+	q.startShards(config.MinShards, nil)
+
+	go q.autoscale()
+
+	return q
+}
+
+// startShards starts n new shards, which send nothing until ready is closed. q.mu must be held for writing, unless
+// q is not running yet
+func (q *QueueManager) startShards(n int, ready <-chan struct{}) {
+	q.shards = make([]*queueShard, n)
+	for i := range q.shards {
+		ctx, cancel := context.WithCancel(q.ctx)
+		q.shards[i] = &queueShard{
+			queue:   make(chan prompb.TimeSeries, q.config.Capacity),
+			done:    make(chan struct{}),
+			space:   make(chan struct{}, 1),
+			retired: make(chan struct{}),
+			ready:   ready,
+			ctx:     ctx,
+			cancel:  cancel,
+		}
+		q.wg.Add(1)
+		go q.run(q.shards[i])
+	}
+}
+
+// Shards returns the number of shards the queue manager is currently sending from
+func (q *QueueManager) Shards() int {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	return len(q.shards)
+}
+
+// Append queues series to be sent. It blocks while the shard a series belongs to is full, until ctx is done or the
+// queue manager is stopped, except for series of a negative priority in FamilyPriorities, which are dropped instead
+func (q *QueueManager) Append(ctx context.Context, series ...prompb.TimeSeries) error {
+	if ctx == nil {
+		return ErrNilContext
+	}
+
+	for _, ts := range series {
+		if err := q.append(ctx, ts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// append queues one series. The lock is only held to send without blocking, never while waiting for room, so that a
+// shard stuck retrying during an outage cannot keep Stop or a reshard from taking the lock
+func (q *QueueManager) append(ctx context.Context, ts prompb.TimeSeries) error {
+	shed := familyPriority(q.config.FamilyPriorities, ts.Labels) < 0
+	for {
+		q.mu.RLock()
+		if q.closed {
+			q.mu.RUnlock()
+			return ErrQueueClosed
+		}
+
+		s := q.shards[shardOf(ts.Labels, len(q.shards))]
+		sent := false
+		select {
+		case s.queue <- ts:
+			sent = true
+			// the series may have taken the space another Append was woken for, so pass the wake-up on
+			if len(s.queue) < cap(s.queue) {
+				s.signal()
+			}
+		default:
+		}
+		q.mu.RUnlock()
+
+		if sent {
+			q.samplesIn.Add(int64(sampleCount(ts)))
+			return nil
+		}
+
+		if shed {
+			q.dropped.Add(1)
+			return nil
+		}
+
+		select {
+		case <-s.space:
+		case <-s.retired:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Stop stops accepting series and waits for the shards to send what they have buffered. If ctx is done first, the
+// batches still being sent are abandoned and ctx's error is returned
+func (q *QueueManager) Stop(ctx context.Context) error {
+	if ctx == nil {
+		return ErrNilContext
+	}
+
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.stop)
+		for _, s := range q.shards {
+			s.retire()
+		}
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		q.cancel()
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// Sent returns the number of series the queue manager has sent
+func (q *QueueManager) Sent() uint64 {
+	return q.sent.Load()
+}
+
+// Dropped returns the number of series the queue manager gave up on
+func (q *QueueManager) Dropped() uint64 {
+	return q.dropped.Load()
+}
+
+func (q *QueueManager) run(s *queueShard) {
+	defer q.wg.Done()
+	defer close(s.done)
+	defer s.cancel()
+
+	deadline := q.config.Clock.After(q.config.BatchSendDeadline)
+
+	var pending []prompb.TimeSeries
+	samples := 0
+	flush := func() {
+		if len(pending) > 0 {
+			if s.ready != nil {
+				select {
+				case <-s.ready:
+				case <-s.ctx.Done():
+				}
+				s.ready = nil
+			}
+			q.sendBatch(s.ctx, pending)
+		}
+		pending, samples = nil, 0
+	}
+
+	for {
+		select {
+		case ts, ok := <-s.queue:
+			if !ok {
+				flush()
+				return
+			}
+			s.signal()
+
+			pending = append(pending, ts)
+			samples += sampleCount(ts)
+			if samples >= q.config.MaxSamplesPerSend {
+				flush()
+			}
+		case <-deadline:
+			flush()
+			deadline = q.config.Clock.After(q.config.BatchSendDeadline)
+		}
+	}
+}
+
+// sendBatch sends batch, backing off and sending it again for as long as it fails with a recoverable error, until ctx
+// is canceled
+func (q *QueueManager) sendBatch(ctx context.Context, batch []prompb.TimeSeries) {
+	backoff := q.config.MinBackoff
+	for {
+		start := time.Now()
+		n, err := q.w.WriteTimeSeries(ctx, batch, nil)
+		q.sent.Add(uint64(n))
+		if err == nil {
+			q.samplesOut.Add(int64(batchSamples(batch)))
+			q.sendDurationNs.Add(int64(time.Since(start)))
+			return
+		}
+
+		if n > 0 || !isRecoverable(err, false) || ctx.Err() != nil {
+			// part of the batch may have been sent, so sending it again could duplicate samples
+			q.dropped.Add(uint64(len(batch) - n))
+			return
+		}
+
+		if sleep(ctx, q.config.Clock, backoff) != nil {
+			q.dropped.Add(uint64(len(batch)))
+			return
+		}
+		backoff = min(backoff*2, q.config.MaxBackoff)
+	}
+}
+
+func sampleCount(ts prompb.TimeSeries) int {
+	return max(len(ts.Samples)+len(ts.Histograms), 1)
+}
+
+func batchSamples(batch []prompb.TimeSeries) int {
+	n := 0
+	for _, ts := range batch {
+		n += sampleCount(ts)
+	}
+
+	return n
+}
+
+// shardOf picks the shard for a series from the fingerprint of its labels, with a consistent hash so that resharding
+// moves as few series as possible to other shards
+func shardOf(labels []prompb.Label, shards int) int {
+	return jumpHash(fingerprint(labels), shards)
+}
@@ -0,0 +1,260 @@
+package writer_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("QueueManager", func() {
+	var (
+		mu        sync.Mutex
+		batches   []int
+		failFirst int
+		s         *httptest.Server
+		w         writer.RemoteMetricsWriter
+	)
+
+	BeforeEach(func() {
+		batches, failFirst = nil, 0
+		s = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if failFirst > 0 {
+				failFirst--
+				http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			Expect(err).ShouldNot(HaveOccurred())
+			decoded, err := snappy.Decode(nil, body)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			var wr prompb.WriteRequest
+			Expect(wr.Unmarshal(decoded)).Should(Succeed())
+			batches = append(batches, len(wr.Timeseries))
+			rw.WriteHeader(http.StatusNoContent)
+		}))
+		DeferCleanup(s.Close)
+
+		var err error
+		w, err = writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:  s.Client(),
+			Compression: writer.Snappy,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	series := func(n int) []prompb.TimeSeries {
+		ts := make([]prompb.TimeSeries, n)
+		for i := range ts {
+			ts[i] = prompb.TimeSeries{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "i", Value: strconv.Itoa(i)}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+			}
+		}
+		return ts
+	}
+
+	total := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+
+		sum := 0
+		for _, n := range batches {
+			sum += n
+		}
+		return sum
+	}
+
+	It("Sends full batches at once and the rest on stop", func() {
+		q := writer.NewQueueManager(w, writer.QueueConfig{MaxSamplesPerSend: 4, BatchSendDeadline: time.Hour})
+		Expect(q.Append(context.Background(), series(10)...)).Should(Succeed())
+
+		Eventually(total).Should(Equal(8))
+		Expect(q.Stop(context.Background())).Should(Succeed())
+		Expect(total()).Should(Equal(10))
+		Expect(batches).Should(Equal([]int{4, 4, 2}))
+		Expect(q.Sent()).Should(Equal(uint64(10)))
+
+		Expect(q.Append(context.Background(), series(1)...)).Should(MatchError(writer.ErrQueueClosed))
+	})
+
+	It("Sends partial batches after the deadline", func() {
+		q := writer.NewQueueManager(w, writer.QueueConfig{BatchSendDeadline: 20 * time.Millisecond})
+		defer q.Stop(context.Background())
+
+		Expect(q.Append(context.Background(), series(3)...)).Should(Succeed())
+		Eventually(total).Should(Equal(3))
+	})
+
+	It("Sheds series of negative priority families when a shard is full", func() {
+		q := writer.NewQueueManager(w, writer.QueueConfig{
+			Capacity:          1,
+			MaxShards:         1,
+			MaxSamplesPerSend: 1,
+			BatchSendDeadline: time.Hour,
+			FamilyPriorities:  map[string]int{"debug_info": -1},
+		})
+
+		debug := prompb.TimeSeries{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "debug_info"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+		}
+
+		// holding the lock stalls the first send, so the second series fills the only shard
+		mu.Lock()
+		Expect(q.Append(context.Background(), series(2)...)).Should(Succeed())
+		Expect(q.Append(context.Background(), debug)).Should(Succeed())
+		Expect(q.Dropped()).Should(Equal(uint64(1)))
+		mu.Unlock()
+
+		Expect(q.Stop(context.Background())).Should(Succeed())
+		Expect(total()).Should(Equal(2))
+	})
+
+	It("Backs off and resends batches that fail with recoverable errors", func() {
+		failFirst = 2
+		q := writer.NewQueueManager(w, writer.QueueConfig{
+			MinShards:         4,
+			BatchSendDeadline: 10 * time.Millisecond,
+			MinBackoff:        time.Millisecond,
+		})
+
+		Expect(q.Append(context.Background(), series(20)...)).Should(Succeed())
+		Eventually(total).Should(Equal(20))
+		Expect(q.Stop(context.Background())).Should(Succeed())
+		Expect(q.Dropped()).Should(BeZero())
+	})
+
+	It("Stops within its deadline while Append waits on a shard stuck retrying", func() {
+		failing := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+		}))
+		defer failing.Close()
+
+		fw, err := writer.NewRemoteMetricsWriter(failing.URL, writer.RemoteMetricsWriterOptions{HTTPClient: failing.Client()})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		q := writer.NewQueueManager(fw, writer.QueueConfig{
+			Capacity:          2,
+			MaxShards:         1,
+			MaxSamplesPerSend: 1,
+			MinBackoff:        time.Millisecond,
+			MaxBackoff:        10 * time.Millisecond,
+		})
+
+		appended := make(chan error, 1)
+		go func() {
+			appended <- q.Append(context.Background(), series(10)...)
+		}()
+		Consistently(appended, 100*time.Millisecond).ShouldNot(Receive())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+
+		stopped := make(chan error, 1)
+		go func() {
+			stopped <- q.Stop(ctx)
+		}()
+
+		Eventually(stopped, 2*time.Second).Should(Receive(MatchError(context.DeadlineExceeded)))
+		Eventually(appended).Should(Receive(MatchError(writer.ErrQueueClosed)))
+		Expect(q.Sent()).Should(BeZero())
+	})
+
+	It("Scales shards up under load and back down when idle", func() {
+		slow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			time.Sleep(10 * time.Millisecond)
+			rw.WriteHeader(http.StatusNoContent)
+		}))
+		defer slow.Close()
+
+		sw, err := writer.NewRemoteMetricsWriter(slow.URL, writer.RemoteMetricsWriterOptions{HTTPClient: slow.Client()})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		q := writer.NewQueueManager(sw, writer.QueueConfig{
+			Capacity:            5,
+			MaxShards:           8,
+			MaxSamplesPerSend:   1,
+			ShardUpdateInterval: 50 * time.Millisecond,
+		})
+		defer q.Stop(context.Background())
+		Expect(q.Shards()).Should(Equal(1))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			defer GinkgoRecover()
+			for ctx.Err() == nil {
+				_ = q.Append(ctx, series(10)...)
+			}
+		}()
+
+		Eventually(q.Shards, 5*time.Second).Should(BeNumerically(">", 1))
+		cancel()
+		Eventually(q.Shards, 5*time.Second).Should(Equal(1))
+	})
+
+	It("Keeps resharding from blocking Append and Stop when the target starts failing", func() {
+		var failing atomic.Bool
+		flaky := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			if failing.Load() {
+				http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+			rw.WriteHeader(http.StatusNoContent)
+		}))
+		defer flaky.Close()
+
+		fw, err := writer.NewRemoteMetricsWriter(flaky.URL, writer.RemoteMetricsWriterOptions{HTTPClient: flaky.Client()})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		q := writer.NewQueueManager(fw, writer.QueueConfig{
+			Capacity:            5,
+			MaxShards:           8,
+			MaxSamplesPerSend:   1,
+			MinBackoff:          time.Millisecond,
+			MaxBackoff:          10 * time.Millisecond,
+			ShardUpdateInterval: 50 * time.Millisecond,
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			for ctx.Err() == nil {
+				_ = q.Append(ctx, series(10)...)
+			}
+		}()
+
+		Eventually(q.Shards, 5*time.Second).Should(BeNumerically(">", 1))
+		failing.Store(true)
+
+		// the shards are now stuck retrying, and every reshard has to give up on them
+		cancel()
+		Consistently(q.Shards, 300*time.Millisecond).Should(BeNumerically(">=", 1))
+
+		stopCtx, stopCancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer stopCancel()
+
+		stopped := make(chan error, 1)
+		go func() {
+			stopped <- q.Stop(stopCtx)
+		}()
+		Eventually(stopped, 2*time.Second).Should(Receive())
+	})
+})
@@ -0,0 +1,61 @@
+package writer
+
+import (
+	"fmt"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// checkRelabelConfigs validates configs, including the regex YAML fills in but a config built in code may lack
+func checkRelabelConfigs(configs []*relabel.Config) error {
+	for i, c := range configs {
+		if c == nil {
+			return fmt.Errorf("relabel config %d is nil", i)
+		}
+		if err := c.Validate(); err != nil {
+			return fmt.Errorf("relabel config %d: %w", i, err)
+		}
+
+		switch c.Action {
+		case relabel.Replace, relabel.Keep, relabel.Drop, relabel.LabelMap, relabel.LabelDrop, relabel.LabelKeep:
+			if c.Regex.Regexp == nil {
+				return fmt.Errorf("relabel config %d: %s action requires a regex", i, c.Action)
+			}
+		}
+	}
+
+	return nil
+}
+
+// relabelSeries applies the writer's relabel configs to the labels of every series, leaving out the series they drop
+// or leave with no labels. The series passed in are not modified
+func (w *writerImpl) relabelSeries(series []prompb.TimeSeries) []prompb.TimeSeries {
+	if len(w.relabelConfigs) == 0 {
+		return series
+	}
+
+	kept := make([]prompb.TimeSeries, 0, len(series))
+	b := labels.NewScratchBuilder(0)
+	for _, ts := range series {
+		b.Reset()
+		for _, l := range ts.Labels {
+			b.Add(l.Name, l.Value)
+		}
+		b.Sort()
+
+		relabelled, keep := relabel.Process(b.Labels(), w.relabelConfigs...)
+		if !keep || relabelled.IsEmpty() {
+			continue
+		}
+
+		ts.Labels = make([]prompb.Label, 0, relabelled.Len())
+		relabelled.Range(func(l labels.Label) {
+			ts.Labels = append(ts.Labels, prompb.Label{Name: l.Name, Value: l.Value})
+		})
+		kept = append(kept, ts)
+	}
+
+	return kept
+}
@@ -0,0 +1,130 @@
+package writer_test
+
+import (
+	"context"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("RelabelConfigs", func() {
+	var requests []prompb.WriteRequest
+
+	series := func() []prompb.TimeSeries {
+		return []prompb.TimeSeries{
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "go_goroutines"}},
+				Samples: []prompb.Sample{{Value: 12, Timestamp: 1000}},
+			},
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "pod", Value: "api-7f9c"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+			},
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "queue_length"}},
+				Samples: []prompb.Sample{{Value: 4, Timestamp: 1000}},
+			},
+		}
+	}
+
+	config := func(c relabel.Config) *relabel.Config {
+		merged := relabel.DefaultRelabelConfig
+		merged.SourceLabels, merged.TargetLabel, merged.Action = c.SourceLabels, c.TargetLabel, c.Action
+		if c.Regex.Regexp != nil {
+			merged.Regex = c.Regex
+		}
+		if c.Replacement != "" {
+			merged.Replacement = c.Replacement
+		}
+		return &merged
+	}
+
+	dropGo := func() *relabel.Config {
+		return config(relabel.Config{
+			SourceLabels: []model.LabelName{"__name__"},
+			Regex:        relabel.MustNewRegexp("go_.*"),
+			Action:       relabel.Drop,
+		})
+	}
+
+	newWriter := func(configs ...*relabel.Config) (writer.RemoteMetricsWriter, error) {
+		requests = nil
+		return writer.NewRemoteMetricsWriter("http://example.invalid/push", writer.RemoteMetricsWriterOptions{
+			RelabelConfigs: configs,
+			Sender: senderFunc(func(_ context.Context, p writer.Payload) error {
+				var wr prompb.WriteRequest
+				if err := wr.Unmarshal(p.Body); err != nil {
+					return err
+				}
+				requests = append(requests, wr)
+				return nil
+			}),
+		})
+	}
+
+	It("Drops and rewrites series before they are sent", func() {
+		w, err := newWriter(
+			dropGo(),
+			config(relabel.Config{
+				SourceLabels: []model.LabelName{"pod"},
+				Regex:        relabel.MustNewRegexp("(.*)-[0-9a-f]+"),
+				TargetLabel:  "app",
+				Action:       relabel.Replace,
+			}),
+			config(relabel.Config{Regex: relabel.MustNewRegexp("pod"), Action: relabel.LabelDrop}),
+		)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		input := series()
+		n, err := w.WriteTimeSeries(context.Background(), input, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).Should(Equal(2))
+
+		Expect(requests).Should(HaveLen(1))
+		Expect(requests[0].Timeseries).Should(HaveLen(2))
+		Expect(requests[0].Timeseries[0].Labels).Should(Equal([]prompb.Label{
+			{Name: "__name__", Value: "up"},
+			{Name: "app", Value: "api"},
+		}))
+		Expect(requests[0].Timeseries[1].Labels).Should(Equal([]prompb.Label{{Name: "__name__", Value: "queue_length"}}))
+		Expect(input[1].Labels).Should(HaveLen(2))
+	})
+
+	It("Leaves out series left with no labels", func() {
+		w, err := newWriter(config(relabel.Config{Regex: relabel.MustNewRegexp(".*"), Action: relabel.LabelDrop}))
+		Expect(err).ShouldNot(HaveOccurred())
+
+		n, err := w.WriteTimeSeries(context.Background(), series(), nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).Should(BeZero())
+		Expect(requests).Should(BeEmpty())
+	})
+
+	It("Does not count dropped series towards MaxSeriesPerPush", func() {
+		w, err := writer.NewRemoteMetricsWriter("http://example.invalid/push", writer.RemoteMetricsWriterOptions{
+			MaxSeriesPerPush: 2,
+			RelabelConfigs:   []*relabel.Config{dropGo()},
+			Sender:           senderFunc(func(context.Context, writer.Payload) error { return nil }),
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		n, err := w.WriteTimeSeries(context.Background(), series(), nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).Should(Equal(2))
+	})
+
+	It("Rejects invalid configs", func() {
+		_, err := newWriter(nil)
+		Expect(err).Should(MatchError(ContainSubstring("relabel config 0 is nil")))
+
+		_, err = newWriter(config(relabel.Config{Action: relabel.Replace}))
+		Expect(err).Should(MatchError(ContainSubstring("target_label")))
+
+		_, err = newWriter(&relabel.Config{Action: relabel.Drop})
+		Expect(err).Should(MatchError(ContainSubstring("requires a regex")))
+	})
+})
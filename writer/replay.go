@@ -0,0 +1,94 @@
+package writer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// ReplayOptions are the optional settings for Replay.
+//
+//	TimeShift is added to the timestamp of every sample, histogram and exemplar, for example to move data captured
+//	during an outage into a window the receiver still accepts
+//	If RemoveReplayed is set, each file is deleted once its payload has been sent
+type ReplayOptions struct {
+	TimeShift      time.Duration
+	RemoveReplayed bool
+}
+
+// Replay sends the payloads that a FileSender wrote to dir through w, oldest first, and returns the number of series
+// sent. Payloads are decoded and sent again with w's own settings, so they go to w's target URL and tenant, whatever
+// was recorded when they were captured. Replay stops at the first payload that cannot be read or sent, so it can be
+// run again to pick up where it left off when RemoveReplayed is set
+func Replay(ctx context.Context, dir string, w RemoteMetricsWriter, options ReplayOptions) (int, error) {
+	if ctx == nil {
+		return 0, ErrNilContext
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"+PayloadFileExtension))
+	if err != nil {
+		return 0, err
+	}
+	sort.Strings(files)
+
+	sent := 0
+	for _, name := range files {
+		payload, err := ReadPayloadFile(name)
+		if err != nil {
+			return sent, err
+		}
+
+		wr, err := decodePayload(payload)
+		if err != nil {
+			return sent, fmt.Errorf("%s: %w", name, err)
+		}
+		shiftTimestamps(wr.Timeseries, options.TimeShift)
+
+		n, err := w.WriteTimeSeries(ctx, wr.Timeseries, wr.Metadata)
+		if err != nil {
+			return sent, fmt.Errorf("%s: %w", name, err)
+		}
+		sent += n
+
+		if options.RemoveReplayed {
+			if err = os.Remove(name); err != nil {
+				return sent, err
+			}
+		}
+	}
+
+	return sent, nil
+}
+
+func decodePayload(payload Payload) (prompb.WriteRequest, error) {
+	decompressed, err := payload.Compression.Decompress(payload.Body)
+	if err != nil {
+		return prompb.WriteRequest{}, err
+	}
+
+	return unmarshalFor(payload.Format, payload.RemoteWriteVersion, decompressed)
+}
+
+func shiftTimestamps(series []prompb.TimeSeries, shift time.Duration) {
+	ms := shift.Milliseconds()
+	if ms == 0 {
+		return
+	}
+
+	for i := range series {
+		for j := range series[i].Samples {
+			series[i].Samples[j].Timestamp += ms
+		}
+		for j := range series[i].Histograms {
+			series[i].Histograms[j].Timestamp += ms
+		}
+		for j := range series[i].Exemplars {
+			series[i].Exemplars[j].Timestamp += ms
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package writer_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("Replay", func() {
+	record := func(dir string, format writer.Format, compression writer.Compression, timestamps ...int64) {
+		sender, err := writer.NewFileSender(dir)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		w, err := writer.NewRemoteMetricsWriter("http://example.invalid/push", writer.RemoteMetricsWriterOptions{
+			Format:      format,
+			Compression: compression,
+			Sender:      sender,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		for _, ts := range timestamps {
+			_, err = w.WriteTimeSeries(context.Background(), []prompb.TimeSeries{{
+				Labels:    []prompb.Label{{Name: "__name__", Value: "up"}},
+				Samples:   []prompb.Sample{{Value: 1, Timestamp: ts}},
+				Exemplars: []prompb.Exemplar{{Value: 1, Timestamp: ts}},
+			}}, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+	}
+
+	var (
+		mu       sync.Mutex
+		received []prompb.WriteRequest
+		s        *httptest.Server
+	)
+
+	BeforeEach(func() {
+		received = nil
+		s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			Expect(err).ShouldNot(HaveOccurred())
+			decoded, err := snappy.Decode(nil, body)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			var wr prompb.WriteRequest
+			Expect(wr.Unmarshal(decoded)).Should(Succeed())
+
+			mu.Lock()
+			received = append(received, wr)
+			mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		DeferCleanup(s.Close)
+	})
+
+	newWriter := func() writer.RemoteMetricsWriter {
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:  s.Client(),
+			Compression: writer.Snappy,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		return w
+	}
+
+	It("Sends recorded payloads in order with their timestamps shifted", func() {
+		dir := GinkgoT().TempDir()
+		record(dir, writer.JSON, writer.Gzip, 1000, 2000)
+
+		n, err := writer.Replay(context.Background(), dir, newWriter(), writer.ReplayOptions{TimeShift: time.Hour})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).Should(Equal(2))
+
+		Expect(received).Should(HaveLen(2))
+		Expect(received[0].Timeseries[0].Samples[0].Timestamp).Should(Equal(int64(1000 + 3600000)))
+		Expect(received[0].Timeseries[0].Exemplars[0].Timestamp).Should(Equal(int64(1000 + 3600000)))
+		Expect(received[1].Timeseries[0].Samples[0].Timestamp).Should(Equal(int64(2000 + 3600000)))
+
+		files, err := filepath.Glob(filepath.Join(dir, "*"+writer.PayloadFileExtension))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(files).Should(HaveLen(2))
+	})
+
+	It("Removes replayed files when asked", func() {
+		dir := GinkgoT().TempDir()
+		record(dir, writer.Protobuf, writer.Snappy, 1000)
+
+		n, err := writer.Replay(context.Background(), dir, newWriter(), writer.ReplayOptions{RemoveReplayed: true})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).Should(Equal(1))
+		Expect(received[0].Timeseries[0].Samples[0].Timestamp).Should(Equal(int64(1000)))
+
+		files, err := filepath.Glob(filepath.Join(dir, "*"+writer.PayloadFileExtension))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(files).Should(BeEmpty())
+	})
+
+	It("Replays JSONProto payloads", func() {
+		dir := GinkgoT().TempDir()
+		record(dir, writer.JSONProto, writer.None, 1000)
+
+		n, err := writer.Replay(context.Background(), dir, newWriter(), writer.ReplayOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).Should(Equal(1))
+		Expect(received[0].Timeseries[0].Samples[0].Timestamp).Should(Equal(int64(1000)))
+	})
+
+	It("Fails on payloads it cannot decode", func() {
+		dir := GinkgoT().TempDir()
+		record(dir, writer.JSONProto, writer.None, 1000)
+
+		files, err := filepath.Glob(filepath.Join(dir, "*"+writer.PayloadFileExtension))
+		Expect(err).ShouldNot(HaveOccurred())
+		data, err := os.ReadFile(files[0])
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(os.WriteFile(files[0], data[:len(data)-2], 0o600)).Should(Succeed())
+
+		_, err = writer.Replay(context.Background(), dir, newWriter(), writer.ReplayOptions{})
+		Expect(err).Should(MatchError(ContainSubstring(filepath.Base(files[0]))))
+		Expect(received).Should(BeEmpty())
+	})
+
+	It("Replays remote write 2.0 payloads", func() {
+		dir := GinkgoT().TempDir()
+		sender, err := writer.NewFileSender(dir)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		w, err := writer.NewRemoteMetricsWriter("http://example.invalid/push", writer.RemoteMetricsWriterOptions{
+			Compression:        writer.Snappy,
+			RemoteWriteVersion: "2.0.0",
+			Sender:             sender,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "api"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+		}}, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		n, err := writer.Replay(context.Background(), dir, newWriter(), writer.ReplayOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).Should(Equal(1))
+		Expect(received).Should(HaveLen(1))
+		Expect(received[0].Timeseries[0].Labels).Should(Equal([]prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "api"}}))
+		Expect(received[0].Timeseries[0].Samples).Should(Equal([]prompb.Sample{{Value: 1, Timestamp: 1000}}))
+	})
+})
@@ -0,0 +1,148 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// StatusError is returned when the target answers with a status other than 2xx. It wraps ErrUnexpectedStatus. If the
+// target asked the writer to slow down, Throttle describes how
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Throttle   *ThrottleSignal
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("expected 2xx HTTP code, but got %s", e.Status)
+}
+
+func (e *StatusError) Unwrap() error {
+	return ErrUnexpectedStatus
+}
+
+// RetryMode decides which failed requests a writer with MaxRetries retries
+type RetryMode int
+
+const (
+	// RetryRecoverable retries network errors, 429 and 5xx responses, and 409 responses if RetryOnConflict is set
+	RetryRecoverable RetryMode = iota
+	// RetryNetworkErrors only retries requests that got no HTTP response at all (connection refused, timeouts and so
+	// on), for receivers that may have written part of a request before answering with an error and so are not safe
+	// to send it to again
+	RetryNetworkErrors
+)
+
+// String returns the name of the RetryMode
+func (m RetryMode) String() string {
+	switch m {
+	case RetryRecoverable:
+		return "recoverable"
+	case RetryNetworkErrors:
+		return "network-errors"
+	default:
+		return fmt.Sprintf("%%INVALID!(%d)", m)
+	}
+}
+
+// isRetryable reports whether a failed attempt may succeed if sent again. Anything that is not an HTTP response
+// (connection refused, timeouts, etc.) is retried, as are 429 and 5xx responses (and 409 if the writer retries
+// conflicts) unless the writer only retries network errors; other responses mean the payload was rejected and will be
+// rejected again
+func (w *writerImpl) isRetryable(err error) bool {
+	if w.retryMode == RetryNetworkErrors {
+		var se *StatusError
+		if errors.As(err, &se) {
+			return false
+		}
+	}
+
+	return isRecoverable(err, w.retryOnConflict)
+}
+
+// isRecoverable is isRetryable for callers without a writer, such as a QueueManager
+func isRecoverable(err error, retryOnConflict bool) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.StatusCode == http.StatusTooManyRequests || se.StatusCode >= 500 ||
+			(retryOnConflict && se.StatusCode == http.StatusConflict)
+	}
+
+	return true
+}
+
+func sleep(ctx context.Context, clock Clock, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-clock.After(d):
+		return nil
+	}
+}
+
+// RetryBudget limits retries to a fraction of all the requests a writer sends, so aggressive retry settings cannot
+// multiply the load on a backend that is already struggling. A budget may be shared by several writers by giving them
+// the same *RetryBudget.
+//
+//	Ratio is the largest fraction of the requests sent in a window that may be retries, e.g. 0.1
+//	If Window is not set, it defaults to DefaultRetryBudgetWindow
+//	MinRetries retries are allowed in every window whatever the ratio, so a writer that pushes rarely can still retry
+type RetryBudget struct {
+	Ratio      float64
+	Window     time.Duration
+	MinRetries int
+
+	mu          sync.Mutex
+	windowStart time.Time
+	requests    int
+	retries     int
+}
+
+// DefaultRetryBudgetWindow is the window a RetryBudget counts requests over when it has no Window
+const DefaultRetryBudgetWindow = 10 * time.Second
+
+// roll starts a new window if the current one has ended. b.mu must be held
+func (b *RetryBudget) roll(now time.Time) {
+	window := b.Window
+	if window <= 0 {
+		window = DefaultRetryBudgetWindow
+	}
+
+	if now.Sub(b.windowStart) >= window {
+		b.windowStart = now
+		b.requests, b.retries = 0, 0
+	}
+}
+
+// request records a first attempt made at now
+func (b *RetryBudget) request(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.roll(now)
+	b.requests++
+}
+
+// allowRetry reports whether a retry at now fits in the budget, and records it if it does
+func (b *RetryBudget) allowRetry(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.roll(now)
+	if b.retries >= b.MinRetries && float64(b.retries+1) > b.Ratio*float64(b.requests+1) {
+		return false
+	}
+
+	b.requests++
+	b.retries++
+
+	return true
+}
@@ -0,0 +1,195 @@
+package writer_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("Retry budget", func() {
+	series := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+	}}
+
+	It("Stops retrying once the budget is spent", func() {
+		var requests atomic.Int32
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			requests.Add(1)
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+		}))
+		defer s.Close()
+
+		budget := &writer.RetryBudget{Ratio: 0, Window: time.Hour, MinRetries: 1}
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:  s.Client(),
+			MaxRetries:  5,
+			MinBackoff:  time.Millisecond,
+			RetryBudget: budget,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).Should(MatchError(ContainSubstring("retry budget exhausted")))
+		Expect(requests.Load()).Should(Equal(int32(2)))
+
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).Should(HaveOccurred())
+		Expect(requests.Load()).Should(Equal(int32(3)))
+	})
+
+	It("Allows retries in proportion to requests", func() {
+		var requests atomic.Int32
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			if requests.Add(1)%2 == 1 {
+				http.Error(w, "overloaded", http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer s.Close()
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:  s.Client(),
+			MaxRetries:  1,
+			MinBackoff:  time.Millisecond,
+			RetryBudget: &writer.RetryBudget{Ratio: 0.5, Window: time.Hour},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		for range 3 {
+			_, err = w.WriteTimeSeries(context.Background(), series, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+		Expect(requests.Load()).Should(Equal(int32(6)))
+	})
+})
+
+var _ = Describe("Retry deadline", func() {
+	series := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+	}}
+
+	It("Stops retrying once MaxRetryElapsedTime would be exceeded", func() {
+		var requests atomic.Int32
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			requests.Add(1)
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+		}))
+		defer s.Close()
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:          s.Client(),
+			MaxRetries:          100,
+			MinBackoff:          20 * time.Millisecond,
+			MaxBackoff:          20 * time.Millisecond,
+			MaxRetryElapsedTime: 50 * time.Millisecond,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		start := time.Now()
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).Should(MatchError(ContainSubstring("retry deadline exceeded")))
+		Expect(err).Should(MatchError(writer.ErrUnexpectedStatus))
+		Expect(time.Since(start)).Should(BeNumerically("<", time.Second))
+		Expect(requests.Load()).Should(BeNumerically("<=", 3))
+	})
+})
+
+var _ = Describe("Retry modes", func() {
+	series := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+	}}
+
+	It("Only retries network errors in RetryNetworkErrors mode", func() {
+		var requests atomic.Int32
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			requests.Add(1)
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+		}))
+		defer s.Close()
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient: s.Client(),
+			MaxRetries: 3,
+			MinBackoff: time.Millisecond,
+			RetryMode:  writer.RetryNetworkErrors,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).Should(MatchError(writer.ErrUnexpectedStatus))
+		Expect(requests.Load()).Should(Equal(int32(1)))
+
+		var attempts atomic.Int32
+		w, err = writer.NewRemoteMetricsWriter("http://example.invalid/push", writer.RemoteMetricsWriterOptions{
+			MaxRetries: 3,
+			MinBackoff: time.Millisecond,
+			RetryMode:  writer.RetryNetworkErrors,
+			Sender: senderFunc(func(context.Context, writer.Payload) error {
+				attempts.Add(1)
+				return errors.New("connection refused")
+			}),
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).Should(HaveOccurred())
+		Expect(attempts.Load()).Should(Equal(int32(4)))
+	})
+})
+
+var _ = Describe("Idempotency keys", func() {
+	series := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+	}}
+
+	It("Sends the same key with every attempt of a request, and a new key for the next", func() {
+		var mu sync.Mutex
+		var keys []string
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			keys = append(keys, r.Header.Get("Idempotency-Key"))
+			first := len(keys)%2 == 1
+			mu.Unlock()
+
+			if first {
+				http.Error(w, "overloaded", http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer s.Close()
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:           s.Client(),
+			MaxRetries:           1,
+			MinBackoff:           time.Millisecond,
+			IdempotencyKeyHeader: "Idempotency-Key",
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		for range 2 {
+			_, err = w.WriteTimeSeries(context.Background(), series, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+
+		Expect(keys).Should(HaveLen(4))
+		Expect(keys[0]).ShouldNot(BeEmpty())
+		Expect(keys[1]).Should(Equal(keys[0]))
+		Expect(keys[3]).Should(Equal(keys[2]))
+		Expect(keys[2]).ShouldNot(Equal(keys[0]))
+	})
+})
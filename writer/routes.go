@@ -0,0 +1,140 @@
+package writer
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Route sends the series it matches to its own target URL instead of the writer's.
+//
+//	Matchers maps label names (__name__ for the metric name) to regular expressions, which must match the whole label
+//	value. A series matches when all of the route's matchers match it; a label the series does not have is matched as
+//	the empty string
+//	TargetURL is used exactly as given, without the defaults a TargetFlavor adds to the writer's own target URL
+type Route struct {
+	Matchers  map[string]string
+	TargetURL string
+}
+
+type route struct {
+	matchers  map[string]*regexp.Regexp
+	targetURL string
+}
+
+// destination is where one request of a push is sent
+type destination struct {
+	targetURL string
+	tenant    string
+}
+
+func (d destination) String() string {
+	if d.tenant == "" {
+		return d.targetURL
+	}
+
+	return fmt.Sprintf("%s (tenant %q)", d.targetURL, d.tenant)
+}
+
+// batch is the part of a push that is sent to one destination
+type batch struct {
+	destination
+	request prompb.WriteRequest
+}
+
+func compileRoutes(routes []Route) ([]route, error) {
+	compiled := make([]route, len(routes))
+	for i, r := range routes {
+		if r.TargetURL == "" {
+			return nil, fmt.Errorf("route %d has no target URL", i)
+		}
+
+		compiled[i] = route{matchers: make(map[string]*regexp.Regexp, len(r.Matchers)), targetURL: r.TargetURL}
+		for name, expr := range r.Matchers {
+			re, err := regexp.Compile("^(?:" + expr + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("route %d matcher for %s: %w", i, name, err)
+			}
+			compiled[i].matchers[name] = re
+		}
+	}
+
+	return compiled, nil
+}
+
+func (r route) matches(labels []prompb.Label) bool {
+	for name, re := range r.matchers {
+		value := ""
+		for _, l := range labels {
+			if l.Name == name {
+				value = l.Value
+				break
+			}
+		}
+
+		if !re.MatchString(value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// split divides wr into the batches it is sent as. Each series goes to the first route it matches, or to the writer's
+// target URL if it matches none, and each of those groups is split again by tenant if the writer has a TenantLabel.
+// Batches are ordered by route, with the writer's own target URL last
+func (w *writerImpl) split(wr prompb.WriteRequest) []batch {
+	if len(wr.Timeseries) == 0 {
+		return []batch{{destination: destination{targetURL: w.targetURL, tenant: w.tenant}, request: wr}}
+	}
+
+	targets := []string{w.targetURL}
+	requests := []prompb.WriteRequest{wr}
+	if len(w.routes) > 0 {
+		groups := make([][]prompb.TimeSeries, len(w.routes)+1)
+		for _, ts := range wr.Timeseries {
+			i := len(w.routes)
+			for j, r := range w.routes {
+				if r.matches(ts.Labels) {
+					i = j
+					break
+				}
+			}
+			groups[i] = append(groups[i], ts)
+		}
+
+		targets, requests = nil, nil
+		for i, series := range groups {
+			if len(series) == 0 {
+				continue
+			}
+
+			target := w.targetURL
+			if i < len(w.routes) {
+				target = w.routes[i].targetURL
+			}
+			targets = append(targets, target)
+			requests = append(requests, prompb.WriteRequest{Timeseries: series, Metadata: metadataFor(series, wr.Metadata)})
+		}
+	}
+
+	var batches []batch
+	for i, request := range requests {
+		if w.tenantLabel == "" {
+			batches = append(batches, batch{destination: destination{targetURL: targets[i], tenant: w.tenant}, request: request})
+			continue
+		}
+
+		tenants, byTenant := splitByTenant(request, w.tenantLabel)
+		for _, value := range tenants {
+			tenant := value
+			if tenant == "" {
+				tenant = w.tenant
+			}
+			batches = append(batches, batch{destination: destination{targetURL: targets[i], tenant: tenant}, request: byTenant[value]})
+		}
+	}
+
+	return batches
+}
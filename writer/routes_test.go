@@ -0,0 +1,106 @@
+package writer_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("Routes", func() {
+	var s *httptest.Server
+	var mu sync.Mutex
+	var received map[string][]string
+
+	BeforeEach(func() {
+		received = map[string][]string{}
+		s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			defer req.Body.Close()
+			if req.URL.Path == "/broken" {
+				http.Error(w, "nope", http.StatusBadRequest)
+				return
+			}
+
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			var wr prompb.WriteRequest
+			if err := wr.Unmarshal(body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for _, ts := range wr.Timeseries {
+				received[req.URL.Path] = append(received[req.URL.Path], ts.Labels[0].Value)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	})
+
+	AfterEach(func() {
+		s.Close()
+	})
+
+	series := []prompb.TimeSeries{
+		{Labels: []prompb.Label{{Name: "__name__", Value: "node_cpu_seconds_total"}}, Samples: []prompb.Sample{{Value: 1, Timestamp: 1}}},
+		{Labels: []prompb.Label{{Name: "__name__", Value: "orders_total"}, {Name: "team", Value: "shop"}}, Samples: []prompb.Sample{{Value: 2, Timestamp: 1}}},
+		{Labels: []prompb.Label{{Name: "__name__", Value: "other"}}, Samples: []prompb.Sample{{Value: 3, Timestamp: 1}}},
+	}
+
+	It("Sends series to the first matching route or the default target", func() {
+		w, err := writer.NewRemoteMetricsWriter(s.URL+"/default", writer.RemoteMetricsWriterOptions{
+			HTTPClient: s.Client(),
+			Routes: []writer.Route{
+				{Matchers: map[string]string{"__name__": "node_.*"}, TargetURL: s.URL + "/infra"},
+				{Matchers: map[string]string{"team": "shop|payments"}, TargetURL: s.URL + "/business"},
+			},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		n, err := w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).Should(Equal(3))
+		Expect(received).Should(Equal(map[string][]string{
+			"/infra":    {"node_cpu_seconds_total"},
+			"/business": {"orders_total"},
+			"/default":  {"other"},
+		}))
+	})
+
+	It("Sends the other batches when one route fails", func() {
+		w, err := writer.NewRemoteMetricsWriter(s.URL+"/default", writer.RemoteMetricsWriterOptions{
+			HTTPClient: s.Client(),
+			Routes:     []writer.Route{{Matchers: map[string]string{"__name__": "node_.*"}, TargetURL: s.URL + "/broken"}},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		n, err := w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).Should(HaveOccurred())
+		Expect(err.Error()).Should(ContainSubstring("/broken"))
+		Expect(n).Should(Equal(2))
+		Expect(received["/default"]).Should(HaveLen(2))
+	})
+
+	It("Rejects invalid routes", func() {
+		_, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			Routes: []writer.Route{{Matchers: map[string]string{"job": "("}, TargetURL: s.URL}},
+		})
+		Expect(err).Should(HaveOccurred())
+
+		_, err = writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			Routes: []writer.Route{{Matchers: map[string]string{"job": ".*"}}},
+		})
+		Expect(err).Should(HaveOccurred())
+	})
+})
@@ -0,0 +1,154 @@
+package writer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// secretFile is a credential kept in a file. The file is checked before every request and read again whenever its
+// size or modification time changes, so credentials can be rotated without restarting the process
+type secretFile struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	value   string
+}
+
+func newSecretFile(path string) (*secretFile, error) {
+	f := &secretFile{path: path}
+	if _, err := f.get(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// get returns the file's contents without surrounding whitespace
+func (f *secretFile) get() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return "", err
+	}
+
+	if info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.value, nil
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return "", err
+	}
+
+	f.value = strings.TrimSpace(string(data))
+	f.modTime, f.size = info.ModTime(), info.Size()
+
+	return f.value, nil
+}
+
+// TokenProvider supplies the bearer token of every request, for tokens that come from Vault, workload identity or a
+// custom STS flow rather than a string or a file. Token is called before every request, retries included, with the
+// request's context, so implementations should cache a token until it nears expiry rather than fetch one each time
+type TokenProvider interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// credentials are the authentication settings of a writer
+type credentials struct {
+	basicAuth       *BasicAuth
+	passwordFile    *secretFile
+	bearerToken     string
+	bearerTokenFile *secretFile
+	tokenProvider   TokenProvider
+	headerNames     []string
+	headerFiles     map[string]*secretFile
+}
+
+func newCredentials(options RemoteMetricsWriterOptions) (credentials, error) {
+	c := credentials{basicAuth: options.BasicAuth, bearerToken: options.BearerToken, tokenProvider: options.TokenProvider}
+	if c.tokenProvider != nil && (options.BearerToken != "" || options.BearerTokenFile != "") {
+		return c, fmt.Errorf("options.TokenProvider cannot be set with options.BearerToken or options.BearerTokenFile")
+	}
+
+	var err error
+	if options.BasicAuth != nil && options.BasicAuth.PasswordFile != "" {
+		if c.passwordFile, err = newSecretFile(options.BasicAuth.PasswordFile); err != nil {
+			return c, fmt.Errorf("password file: %w", err)
+		}
+	}
+
+	if options.BearerTokenFile != "" {
+		if options.BearerToken != "" {
+			return c, fmt.Errorf("options.BearerToken and options.BearerTokenFile cannot both be set")
+		}
+
+		if c.bearerTokenFile, err = newSecretFile(options.BearerTokenFile); err != nil {
+			return c, fmt.Errorf("bearer token file: %w", err)
+		}
+	}
+
+	if len(options.HeaderFiles) > 0 {
+		c.headerFiles = make(map[string]*secretFile, len(options.HeaderFiles))
+		for name, path := range options.HeaderFiles {
+			if c.headerFiles[name], err = newSecretFile(path); err != nil {
+				return c, fmt.Errorf("header file for %s: %w", name, err)
+			}
+			c.headerNames = append(c.headerNames, name)
+		}
+		sort.Strings(c.headerNames)
+	}
+
+	return c, nil
+}
+
+// apply adds the credentials to req
+func (c credentials) apply(req *http.Request) error {
+	if c.basicAuth != nil {
+		password := c.basicAuth.Password
+		if c.passwordFile != nil {
+			var err error
+			if password, err = c.passwordFile.get(); err != nil {
+				return fmt.Errorf("password file: %w", err)
+			}
+		}
+
+		req.SetBasicAuth(c.basicAuth.Username, password)
+	}
+
+	token := c.bearerToken
+	if c.bearerTokenFile != nil {
+		var err error
+		if token, err = c.bearerTokenFile.get(); err != nil {
+			return fmt.Errorf("bearer token file: %w", err)
+		}
+	}
+	if c.tokenProvider != nil {
+		var err error
+		if token, err = c.tokenProvider.Token(req.Context()); err != nil {
+			return fmt.Errorf("token provider: %w", err)
+		}
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	for _, name := range c.headerNames {
+		value, err := c.headerFiles[name].get()
+		if err != nil {
+			return fmt.Errorf("header file for %s: %w", name, err)
+		}
+		req.Header.Set(name, value)
+	}
+
+	return nil
+}
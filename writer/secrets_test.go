@@ -0,0 +1,147 @@
+package writer_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// tokenSequence is a TokenProvider that hands out numbered tokens, or fails with err
+type tokenSequence struct {
+	issued int
+	err    error
+}
+
+func (t *tokenSequence) Token(ctx context.Context) (string, error) {
+	if t.err != nil {
+		return "", t.err
+	}
+
+	t.issued++
+	return "token-" + strconv.Itoa(t.issued), ctx.Err()
+}
+
+var _ = Describe("Credential files", func() {
+	series := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+	}}
+
+	var (
+		headers http.Header
+		s       *httptest.Server
+		dir     string
+	)
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			headers = r.Header.Clone()
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		DeferCleanup(s.Close)
+	})
+
+	// rotate rewrites a credential file, moving its modification time so the change is seen even on file systems
+	// with coarse timestamps
+	rotate := func(path, value string, age time.Duration) {
+		Expect(os.WriteFile(path, []byte(value+"\n"), 0o600)).Should(Succeed())
+		at := time.Now().Add(-age)
+		Expect(os.Chtimes(path, at, at)).Should(Succeed())
+	}
+
+	It("Reads rotated passwords, tokens and header values", func() {
+		passwordFile := filepath.Join(dir, "password")
+		tokenFile := filepath.Join(dir, "token")
+		keyFile := filepath.Join(dir, "key")
+		rotate(passwordFile, "first-password", time.Hour)
+		rotate(tokenFile, "first-token", time.Hour)
+		rotate(keyFile, "first-key", time.Hour)
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:  s.Client(),
+			BasicAuth:   &writer.BasicAuth{Username: "agent", PasswordFile: passwordFile},
+			HeaderFiles: map[string]string{"X-API-Key": keyFile},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		req := &http.Request{Header: headers}
+		username, password, ok := req.BasicAuth()
+		Expect(ok).Should(BeTrue())
+		Expect(username).Should(Equal("agent"))
+		Expect(password).Should(Equal("first-password"))
+		Expect(headers.Get("X-API-Key")).Should(Equal("first-key"))
+
+		rotate(passwordFile, "second-password", 0)
+		rotate(keyFile, "second-key", 0)
+
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		req = &http.Request{Header: headers}
+		_, password, _ = req.BasicAuth()
+		Expect(password).Should(Equal("second-password"))
+		Expect(headers.Get("X-API-Key")).Should(Equal("second-key"))
+
+		w, err = writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:      s.Client(),
+			BearerTokenFile: tokenFile,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(headers.Get("Authorization")).Should(Equal("Bearer first-token"))
+
+		rotate(tokenFile, "second-token", 0)
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(headers.Get("Authorization")).Should(Equal("Bearer second-token"))
+	})
+
+	It("Fails to create a writer whose credential files cannot be read", func() {
+		_, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			BearerTokenFile: filepath.Join(dir, "missing"),
+		})
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("Asks the TokenProvider for the token of every request", func() {
+		tokens := &tokenSequence{}
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:    s.Client(),
+			TokenProvider: tokens,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		for _, want := range []string{"Bearer token-1", "Bearer token-2"} {
+			_, err = w.WriteTimeSeries(context.Background(), series, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(headers.Get("Authorization")).Should(Equal(want))
+		}
+
+		vaultDown := errors.New("vault is sealed")
+		tokens.err = vaultDown
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).Should(MatchError(vaultDown))
+	})
+
+	It("Refuses a TokenProvider along with a bearer token", func() {
+		_, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			BearerToken:   "static",
+			TokenProvider: &tokenSequence{},
+		})
+		Expect(err).Should(HaveOccurred())
+	})
+})
@@ -0,0 +1,27 @@
+package writer
+
+import (
+	"context"
+	"time"
+)
+
+// Payload is one marshalled and compressed request body, along with everything needed to deliver it. IdempotencyKey
+// is only set when the writer has an IdempotencyKeyHeader, and is the same for every attempt to deliver the payload.
+// Format, Compression and RemoteWriteVersion are those of the target the payload is for; a payload without a
+// RemoteWriteVersion is sent with the writer's
+type Payload struct {
+	Body               []byte
+	Format             Format
+	Compression        Compression
+	RemoteWriteVersion string
+	TargetURL          string
+	Tenant             string
+	CreatedAt          time.Time
+	IdempotencyKey     string
+}
+
+// Sender delivers payloads somewhere other than over HTTP to the target URL, which is what a RemoteMetricsWriter does
+// when it has no Sender. Errors returned by a Sender are retried like network errors
+type Sender interface {
+	Send(context.Context, Payload) error
+}
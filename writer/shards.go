@@ -0,0 +1,121 @@
+package writer
+
+import (
+	"math"
+	"time"
+)
+
+// These are the constants Prometheus's queue manager scales shards with
+const (
+	shardEWMAWeight     = 0.2
+	shardTolerance      = 0.3
+	shardIntegralFactor = 0.1
+)
+
+// shardScaling holds moving averages of the rates a QueueManager scales its shards from. It is only used by the
+// autoscale goroutine
+type shardScaling struct {
+	initialized  bool
+	inRate       float64
+	outRate      float64
+	durationRate float64
+}
+
+func (s *shardScaling) update(in, out, durationNs int64, interval time.Duration) {
+	seconds := interval.Seconds()
+	rates := [3]float64{float64(in) / seconds, float64(out) / seconds, float64(durationNs) / seconds}
+	if !s.initialized {
+		s.inRate, s.outRate, s.durationRate = rates[0], rates[1], rates[2]
+		s.initialized = true
+		return
+	}
+
+	s.inRate += shardEWMAWeight * (rates[0] - s.inRate)
+	s.outRate += shardEWMAWeight * (rates[1] - s.outRate)
+	s.durationRate += shardEWMAWeight * (rates[2] - s.durationRate)
+}
+
+// desired returns the number of shards needed to keep up with the append rate and work off backlog pending samples,
+// or current if that is within shardTolerance of current or nothing has been sent to measure by
+func (s *shardScaling) desired(current, backlog int, interval time.Duration) int {
+	if s.outRate <= 0 {
+		return current
+	}
+
+	// the time a shard spends sending each sample, in seconds
+	timePerSample := s.durationRate / s.outRate / float64(time.Second)
+	integralGain := shardIntegralFactor / interval.Seconds()
+	desired := timePerSample * (s.inRate + integralGain*float64(backlog))
+
+	lower := float64(current) * (1 - shardTolerance)
+	upper := float64(current) * (1 + shardTolerance)
+	if desired >= lower && desired <= upper {
+		return current
+	}
+
+	return int(math.Ceil(desired))
+}
+
+// autoscale recomputes the number of shards every ShardUpdateInterval until the queue manager is stopped
+func (q *QueueManager) autoscale() {
+	for {
+		select {
+		case <-q.stop:
+			return
+		case <-q.config.Clock.After(q.config.ShardUpdateInterval):
+			q.scaling.update(q.samplesIn.Swap(0), q.samplesOut.Swap(0), q.sendDurationNs.Swap(0),
+				q.config.ShardUpdateInterval)
+
+			current, backlog := q.backlog()
+			desired := q.scaling.desired(current, backlog, q.config.ShardUpdateInterval)
+			q.reshard(min(max(desired, q.config.MinShards), q.config.MaxShards))
+		}
+	}
+}
+
+// backlog returns the number of shards and the number of series waiting in them
+func (q *QueueManager) backlog() (int, int) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	pending := 0
+	for _, s := range q.shards {
+		pending += len(s.queue)
+	}
+
+	return len(q.shards), pending
+}
+
+// reshard replaces the shards with n new ones. The new shards take series straight away but hold off sending until
+// the old ones have sent everything they had buffered, so series stay in order. Old shards that are still sending
+// after ShardUpdateInterval, such as ones retrying during an outage, have their batches abandoned. Only the swap is
+// done under the lock, so Append and Stop never wait on the old shards
+func (q *QueueManager) reshard(n int) {
+	q.mu.Lock()
+	if q.closed || n == len(q.shards) {
+		q.mu.Unlock()
+		return
+	}
+
+	old := q.shards
+	for _, s := range old {
+		s.retire()
+	}
+
+	drained := make(chan struct{})
+	defer close(drained)
+	q.startShards(n, drained)
+	q.mu.Unlock()
+
+	deadline := q.config.Clock.After(q.config.ShardUpdateInterval)
+	for i, s := range old {
+		select {
+		case <-s.done:
+		case <-deadline:
+			for _, rest := range old[i:] {
+				rest.cancel()
+			}
+			<-s.done
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// errFlightPanicked is the error the calls waiting on a call get when its fn panics
+var errFlightPanicked = errors.New("collapsed call panicked")
+
+// flight collapses overlapping calls into one: a call made while another is in progress waits for it and shares its
+// result instead of running again
+type flight struct {
+	mu   sync.Mutex
+	call *flightCall
+}
+
+type flightCall struct {
+	done chan struct{}
+	n    int
+	err  error
+}
+
+// do runs fn, unless a call is already in progress, in which case it returns that call's result once it finishes. A
+// nil flight always runs fn. If fn panics, the panic reaches the caller that ran it, the calls waiting on it return
+// errFlightPanicked, and the next call runs fn again
+func (f *flight) do(ctx context.Context, fn func() (int, error)) (int, error) {
+	if f == nil {
+		return fn()
+	}
+
+	f.mu.Lock()
+	if c := f.call; c != nil {
+		f.mu.Unlock()
+
+		select {
+		case <-c.done:
+			return c.n, c.err
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+
+	c := &flightCall{done: make(chan struct{})}
+	f.call = c
+	f.mu.Unlock()
+
+	returned := false
+	defer func() {
+		if !returned {
+			c.err = errFlightPanicked
+		}
+
+		f.mu.Lock()
+		f.call = nil
+		f.mu.Unlock()
+		close(c.done)
+	}()
+
+	c.n, c.err = fn()
+	returned = true
+
+	return c.n, c.err
+}
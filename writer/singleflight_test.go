@@ -0,0 +1,134 @@
+package writer_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var _ = Describe("Concurrent WriteMetrics", func() {
+	var registry *prometheus.Registry
+
+	BeforeEach(func() {
+		registry = prometheus.NewRegistry()
+		c := prometheus.NewCounter(prometheus.CounterOpts{Name: "concurrent_total"})
+		registry.MustRegister(c)
+		c.Inc()
+	})
+
+	It("Pushes once per call by default", func() {
+		var pushes atomic.Int64
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pushes.Add(1)
+			receiveMetrics(w, r)
+		}))
+		defer s.Close()
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{HTTPClient: s.Client()}, registry)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var wg sync.WaitGroup
+		for range 10 {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				n, err := w.WriteMetrics(context.Background())
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(n).Should(Equal(1))
+			}()
+		}
+		wg.Wait()
+
+		Expect(pushes.Load()).Should(BeEquivalentTo(10))
+	})
+
+	It("Collapses overlapping calls into the push in progress", func() {
+		var pushes atomic.Int64
+		arrived := make(chan struct{}, 1)
+		release := make(chan struct{})
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pushes.Add(1)
+			arrived <- struct{}{}
+			<-release
+			receiveMetrics(w, r)
+		}))
+		defer s.Close()
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:               s.Client(),
+			CollapseConcurrentWrites: true,
+		}, registry)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var wg sync.WaitGroup
+		write := func() {
+			defer GinkgoRecover()
+			defer wg.Done()
+
+			n, err := w.WriteMetrics(context.Background())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(n).Should(Equal(1))
+		}
+
+		wg.Add(1)
+		go write()
+		Eventually(arrived).Should(Receive())
+
+		for range 4 {
+			wg.Add(1)
+			go write()
+		}
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		Expect(pushes.Load()).Should(BeEquivalentTo(1))
+
+		_, err = w.WriteMetrics(context.Background())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(pushes.Load()).Should(BeEquivalentTo(2))
+	})
+
+	It("Runs the next call after a collapsed call panics", func() {
+		var calls atomic.Int64
+		gatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			if calls.Add(1) == 1 {
+				panic("gatherer failed")
+			}
+			return registry.Gather()
+		})
+
+		s := httptest.NewServer(http.HandlerFunc(receiveMetrics))
+		defer s.Close()
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:               s.Client(),
+			CollapseConcurrentWrites: true,
+		}, gatherer)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(func() { _, _ = w.WriteMetrics(context.Background()) }).Should(PanicWith("gatherer failed"))
+
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			defer close(done)
+
+			n, err := w.WriteMetrics(context.Background())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(n).Should(Equal(1))
+		}()
+		Eventually(done).Should(BeClosed())
+	})
+})
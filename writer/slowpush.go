@@ -0,0 +1,130 @@
+package writer
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// phase is one of the stages of a push that slow pushes are broken down into
+type phase int
+
+const (
+	gatherPhase phase = iota
+	convertPhase
+	marshalPhase
+	compressPhase
+	sendPhase
+	phaseCount
+)
+
+var phaseNames = [phaseCount]string{"gather", "convert", "marshal", "compress", "send"}
+
+// pushStats adds up how long each phase of a push takes, and what the push sent. Requests sent in parallel add their
+// times together, so the marshal, compress and send times of a push to several targets can exceed the push's duration
+type pushStats struct {
+	phases  [phaseCount]atomic.Int64
+	samples atomic.Int64
+	bytes   atomic.Int64
+	retries atomic.Int64
+	status  atomic.Int64
+}
+
+type pushStatsKey struct{}
+
+// statsFrom returns the stats of the push ctx belongs to, or nil if the push is not being timed or summarized
+func statsFrom(ctx context.Context) *pushStats {
+	s, _ := ctx.Value(pushStatsKey{}).(*pushStats)
+	return s
+}
+
+// record adds the time since start to p. It does nothing when s is nil, as do the other methods
+func (s *pushStats) record(p phase, start time.Time) {
+	if s != nil {
+		s.phases[p].Add(int64(time.Since(start)))
+	}
+}
+
+// sent adds a request that was delivered with samples samples in a body of size bytes
+func (s *pushStats) sent(samples, size int) {
+	if s != nil {
+		s.samples.Add(int64(samples))
+		s.bytes.Add(int64(size))
+	}
+}
+
+// retried counts a retry of a request
+func (s *pushStats) retried() {
+	if s != nil {
+		s.retries.Add(1)
+	}
+}
+
+// responded records the status code of the latest response
+func (s *pushStats) responded(statusCode int) {
+	if s != nil {
+		s.status.Store(int64(statusCode))
+	}
+}
+
+// timed runs fn, the whole of a push, logs a warning with its phase timings if it took SlowPushThreshold or longer,
+// and logs its summary if the writer has LogPushSummaries. A push made by another one (WriteMetrics calling
+// WriteMetricFamilies, say) is timed as part of it
+func (w *writerImpl) timed(ctx context.Context, fn func(context.Context) (int, error)) (int, error) {
+	if (w.slowPushThreshold <= 0 && !w.logPushSummaries) || statsFrom(ctx) != nil {
+		return fn(ctx)
+	}
+
+	stats := &pushStats{}
+	start := time.Now()
+	n, err := fn(context.WithValue(ctx, pushStatsKey{}, stats))
+	elapsed := time.Since(start)
+
+	if w.logPushSummaries {
+		w.logSummary(ctx, stats, elapsed, n, err)
+	}
+
+	if w.slowPushThreshold > 0 && elapsed >= w.slowPushThreshold {
+		attrs := []any{slog.String("url", w.targetURL), slog.Duration("duration", elapsed)}
+		for p, name := range phaseNames {
+			attrs = append(attrs, slog.Duration(name, time.Duration(stats.phases[p].Load())))
+		}
+		attrs = append(attrs, slog.Int("series", n))
+		if err != nil {
+			attrs = append(attrs, slog.Any("error", err))
+		}
+		w.log().WarnContext(ctx, "slow remote write push", attrs...)
+	}
+
+	return n, err
+}
+
+// logSummary logs a push in a single line: at info level with what it sent, or as a warning with its error if it
+// failed. status is the status code of the last response received, or 0 if there was none
+func (w *writerImpl) logSummary(ctx context.Context, stats *pushStats, elapsed time.Duration, n int, err error) {
+	attrs := []any{
+		slog.String("url", w.targetURL),
+		slog.Int("series", n),
+		slog.Int64("samples", stats.samples.Load()),
+		slog.Int64("bytes", stats.bytes.Load()),
+		slog.Duration("duration", elapsed),
+		slog.Int64("status", stats.status.Load()),
+		slog.Int64("retries", stats.retries.Load()),
+	}
+	if err != nil {
+		w.log().WarnContext(ctx, "remote write push failed", append(attrs, slog.Any("error", err))...)
+		return
+	}
+
+	w.log().InfoContext(ctx, "remote write push", attrs...)
+}
+
+// log returns the writer's Logger, or slog.Default() if it has none
+func (w *writerImpl) log() *slog.Logger {
+	if w.logger == nil {
+		return slog.Default()
+	}
+
+	return w.logger
+}
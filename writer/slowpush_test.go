@@ -0,0 +1,114 @@
+package writer_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ = Describe("Slow push warnings", func() {
+	var (
+		registry *prometheus.Registry
+		logs     *bytes.Buffer
+		logger   *slog.Logger
+	)
+
+	BeforeEach(func() {
+		registry = prometheus.NewRegistry()
+		registry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "up"}))
+
+		logs = &bytes.Buffer{}
+		logger = slog.New(slog.NewJSONHandler(logs, nil))
+	})
+
+	serve := func(delay time.Duration) *httptest.Server {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(delay)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		DeferCleanup(s.Close)
+		return s
+	}
+
+	It("logs pushes slower than the threshold with their phase timings", func() {
+		s := serve(50 * time.Millisecond)
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:        s.Client(),
+			SlowPushThreshold: 20 * time.Millisecond,
+			Logger:            logger,
+		}, registry)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteMetrics(context.Background())
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var entry map[string]any
+		Expect(json.Unmarshal(logs.Bytes(), &entry)).Should(Succeed())
+		Expect(entry).Should(HaveKeyWithValue("level", "WARN"))
+		Expect(entry).Should(HaveKeyWithValue("url", s.URL))
+		Expect(entry).Should(HaveKeyWithValue("series", BeEquivalentTo(1)))
+		for _, phase := range []string{"gather", "convert", "marshal", "compress", "send"} {
+			Expect(entry).Should(HaveKey(phase))
+		}
+		Expect(entry["send"]).Should(BeNumerically(">=", float64(50*time.Millisecond)))
+	})
+
+	It("does not log fast pushes", func() {
+		s := serve(0)
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:        s.Client(),
+			SlowPushThreshold: time.Minute,
+			Logger:            logger,
+		}, registry)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteMetrics(context.Background())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(logs.Len()).Should(BeZero())
+	})
+
+	It("logs a summary of every push when asked to", func() {
+		var calls atomic.Int32
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		DeferCleanup(s.Close)
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:       s.Client(),
+			MaxRetries:       1,
+			MinBackoff:       time.Millisecond,
+			LogPushSummaries: true,
+			Logger:           logger,
+		}, registry)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteMetrics(context.Background())
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var entry map[string]any
+		Expect(json.Unmarshal(logs.Bytes(), &entry)).Should(Succeed())
+		Expect(entry).Should(HaveKeyWithValue("level", "INFO"))
+		Expect(entry).Should(HaveKeyWithValue("msg", "remote write push"))
+		Expect(entry).Should(HaveKeyWithValue("series", BeEquivalentTo(1)))
+		Expect(entry).Should(HaveKeyWithValue("samples", BeEquivalentTo(1)))
+		Expect(entry).Should(HaveKeyWithValue("bytes", BeNumerically(">", 0)))
+		Expect(entry).Should(HaveKeyWithValue("status", BeEquivalentTo(http.StatusNoContent)))
+		Expect(entry).Should(HaveKeyWithValue("retries", BeEquivalentTo(1)))
+		Expect(entry).Should(HaveKey("duration"))
+	})
+})
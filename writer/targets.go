@@ -0,0 +1,524 @@
+package writer
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const (
+	// DefaultHedgeDelay is how long a Hedge mode writer waits for a target before also trying the next one
+	DefaultHedgeDelay = 50 * time.Millisecond
+	// DefaultResolveInterval is how often a writer asks its TargetResolver for targets when it has no ResolveInterval
+	DefaultResolveInterval = 30 * time.Second
+)
+
+// Target is a target URL a writer sends to besides its own. If Compression is set, requests to the target are
+// compressed with it rather than with the writer's Compression, so a fan-out can compress lightly for a receiver on
+// the same network and harder for one across a WAN. Likewise, Format and RemoteWriteVersion, if set, replace the
+// writer's for the target, so one writer can feed receivers that expect different encodings or protocol versions,
+// such as a remote write 1.0 Thanos and a remote write 2.0 Mimir. The series are gathered and converted once either
+// way
+type Target struct {
+	URL                string
+	Compression        *Compression
+	Format             Format
+	RemoteWriteVersion string
+}
+
+// TargetResolver finds the targets of a writer while it runs, for example from service discovery
+type TargetResolver interface {
+	Resolve(context.Context) ([]Target, error)
+}
+
+// MultiTargetMode decides how a writer with Targets uses them
+type MultiTargetMode int
+
+const (
+	// FanOut sends every push to the writer's target URL and to each of its Targets
+	FanOut MultiTargetMode = iota
+	// Hedge sends every push to the healthy target that has been answering fastest and, if that has not succeeded
+	// after HedgeDelay (or has failed), to the next one as well. The first success is used and the other request is
+	// abandoned
+	Hedge
+	// Partition sends each series to one target, chosen by hashing its labels, so a series always goes to the same
+	// target for as long as the targets stay the same. When a target is added or removed, only the series that go to
+	// that target move
+	Partition
+)
+
+// String returns the name of the MultiTargetMode
+func (m MultiTargetMode) String() string {
+	switch m {
+	case FanOut:
+		return "fan-out"
+	case Hedge:
+		return "hedge"
+	case Partition:
+		return "partition"
+	default:
+		return fmt.Sprintf("%%INVALID!(%d)", m)
+	}
+}
+
+// errHedgeLost cancels the request that lost a hedge race. Payloads abandoned that way were delivered elsewhere, so
+// they are not handed to the fallback sender
+var errHedgeLost = errors.New("another target accepted the request first")
+
+// TargetStats describes how pushes to one target URL have gone since the writer was created
+type TargetStats struct {
+	URL                 string
+	Pushes              uint64
+	Failures            uint64
+	ConsecutiveFailures int
+	BytesSent           uint64
+	// UncompressedBytesSent is what BytesSent would have been without compression
+	UncompressedBytesSent uint64
+	// Latency is a moving average of how long successful pushes take
+	Latency     time.Duration
+	LastError   error
+	LastErrorAt time.Time
+}
+
+// CompressionRatio returns how many times smaller compression made the payloads sent, or 1 if nothing has been sent
+func (s TargetStats) CompressionRatio() float64 {
+	if s.BytesSent == 0 {
+		return 1
+	}
+
+	return float64(s.UncompressedBytesSent) / float64(s.BytesSent)
+}
+
+// SuccessRate returns the fraction of pushes that succeeded, or 1 if there have been none
+func (s TargetStats) SuccessRate() float64 {
+	if s.Pushes == 0 {
+		return 1
+	}
+
+	return float64(s.Pushes-s.Failures) / float64(s.Pushes)
+}
+
+// targetState is what the writer has observed about one of its target URLs
+type targetState struct {
+	url string
+
+	mu                  sync.Mutex
+	compression         *Compression
+	format              Format
+	version             string
+	pushes              uint64
+	failures            uint64
+	bytesSent           uint64
+	uncompressedSent    uint64
+	latency             time.Duration
+	consecutiveFailures int
+	lastError           error
+	lastErrorAt         time.Time
+}
+
+func (t *targetState) observe(d time.Duration, err error, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.pushes++
+	if err != nil {
+		t.failures++
+		t.consecutiveFailures++
+		t.lastError = err
+		t.lastErrorAt = at
+		return
+	}
+
+	t.consecutiveFailures = 0
+	if t.latency == 0 {
+		t.latency = d
+	} else {
+		t.latency += (d - t.latency) / 5
+	}
+}
+
+// abandon records a request that lost a hedge race after d. It neither succeeded nor failed, so it is not counted
+// as a push and leaves the failure streak alone, but the target took at least d, so its latency is raised to d if it
+// was lower
+func (t *targetState) abandon(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if d > t.latency {
+		t.latency = d
+	}
+}
+
+// override returns the format, compression and remote write version of requests to the target: the target's own
+// where it has them, and the writer's, passed in, otherwise
+func (t *targetState) override(format Format, compression Compression, version string) (Format, Compression, string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.format != 0 {
+		format = t.format
+	}
+
+	if t.compression != nil {
+		compression = *t.compression
+	}
+
+	if t.version != "" {
+		version = t.version
+	}
+
+	return format, compression, version
+}
+
+// configure takes the settings of target, which a TargetResolver may change between resolutions
+func (t *targetState) configure(target Target) {
+	compression := target.Compression
+	if compression != nil {
+		copied := *compression
+		compression = &copied
+	}
+
+	t.mu.Lock()
+	t.compression, t.format, t.version = compression, target.Format, strings.TrimSpace(target.RemoteWriteVersion)
+	t.mu.Unlock()
+}
+
+func (t *targetState) addBytes(sent, uncompressed int) {
+	t.mu.Lock()
+	t.bytesSent += uint64(sent)
+	t.uncompressedSent += uint64(uncompressed)
+	t.mu.Unlock()
+}
+
+func (t *targetState) stats() TargetStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return TargetStats{
+		URL:                   t.url,
+		Pushes:                t.pushes,
+		Failures:              t.failures,
+		ConsecutiveFailures:   t.consecutiveFailures,
+		BytesSent:             t.bytesSent,
+		UncompressedBytesSent: t.uncompressedSent,
+		Latency:               t.latency,
+		LastError:             t.lastError,
+		LastErrorAt:           t.lastErrorAt,
+	}
+}
+
+func newTargetStates(targetURL string, targets []Target, format Format, version string) ([]*targetState, error) {
+	states := []*targetState{{url: targetURL}}
+	for i, t := range targets {
+		if t.URL == "" {
+			return nil, fmt.Errorf("target %d has no URL", i)
+		}
+		if err := checkRemoteWriteVersion(cmp.Or(strings.TrimSpace(t.RemoteWriteVersion), version), cmp.Or(t.Format, format)); err != nil {
+			return nil, fmt.Errorf("target %d: %w", i, err)
+		}
+		state := &targetState{url: t.URL}
+		state.configure(t)
+		states = append(states, state)
+	}
+
+	return states, nil
+}
+
+// TargetStats returns the statistics of the writer's target URL followed by those of its Targets, or of the targets
+// its TargetResolver found. Series sent by Routes are not counted
+func (w *writerImpl) TargetStats() []TargetStats {
+	targets := w.currentTargets()
+	stats := make([]TargetStats, len(targets))
+	for i, t := range targets {
+		stats[i] = t.stats()
+	}
+
+	return stats
+}
+
+// targetFor returns the state of the target with url, or nil for URLs that only Routes send to
+func (w *writerImpl) targetFor(url string) *targetState {
+	for _, t := range w.currentTargets() {
+		if t.url == url {
+			return t
+		}
+	}
+
+	return nil
+}
+
+// encodingFor returns the format and compression of requests to url, the remote write version its Target sends
+// instead of the writer's, if any, and the Target itself, or nil if url is not one of the writer's targets
+func (w *writerImpl) encodingFor(url string) (Format, Compression, string, *targetState) {
+	format, encoding, version := w.format, w.encoding, ""
+	target := w.targetFor(url)
+	if target != nil {
+		format, encoding, version = target.override(format, encoding, version)
+	}
+
+	return format, encoding, version, target
+}
+
+// deliver sends wr to dest and, if dest is the writer's own target URL, to or across its other Targets
+func (w *writerImpl) deliver(ctx context.Context, wr prompb.WriteRequest, dest destination) (int, error) {
+	if dest.targetURL != w.targetURL {
+		return w.writeTo(ctx, wr, dest)
+	}
+
+	w.resolveTargets(ctx)
+	targets := w.currentTargets()
+
+	switch {
+	case len(targets) == 1:
+		return w.writeToTarget(ctx, wr, targets[0], dest.tenant)
+	case w.multiTargetMode == Hedge:
+		return w.hedge(ctx, wr, targets, dest.tenant)
+	case w.multiTargetMode == Partition:
+		return w.partition(ctx, wr, targets, dest.tenant)
+	default:
+		return w.fanOut(ctx, wr, targets, dest.tenant)
+	}
+}
+
+func (w *writerImpl) currentTargets() []*targetState {
+	w.targetsMu.RLock()
+	defer w.targetsMu.RUnlock()
+
+	return w.targets
+}
+
+// resolveTargets replaces the targets with the TargetResolver's, if the writer has one and it has not been asked for
+// ResolveInterval. Targets that are still there keep their statistics. If the resolver fails or finds no targets, the
+// writer logs a warning, keeps sending to the targets it has and asks the resolver again on the next push
+func (w *writerImpl) resolveTargets(ctx context.Context) {
+	if w.resolver == nil {
+		return
+	}
+
+	w.targetsMu.Lock()
+	now := w.clock.Now()
+	previous := w.lastResolve
+	due := now.Sub(previous) >= w.resolveInterval
+	if due {
+		w.lastResolve = now
+	}
+	w.targetsMu.Unlock()
+
+	if !due {
+		return
+	}
+
+	resolved, err := w.resolver.Resolve(ctx)
+	if err == nil && len(resolved) == 0 {
+		err = errors.New("no targets found")
+	}
+	if err != nil {
+		w.log().WarnContext(ctx, "resolving remote write targets failed", slog.String("url", w.targetURL),
+			slog.Int("targets", len(w.currentTargets())), slog.Any("error", err))
+
+		w.targetsMu.Lock()
+		// unless another push has resolved since, the next one tries again
+		if w.lastResolve.Equal(now) {
+			w.lastResolve = previous
+		}
+		w.targetsMu.Unlock()
+		return
+	}
+
+	w.targetsMu.Lock()
+	defer w.targetsMu.Unlock()
+
+	existing := make(map[string]*targetState, len(w.targets))
+	for _, t := range w.targets {
+		existing[t.url] = t
+	}
+
+	targets := make([]*targetState, 0, len(resolved))
+	for _, r := range resolved {
+		t, ok := existing[r.URL]
+		if !ok {
+			t = &targetState{url: r.URL}
+		}
+		t.configure(r)
+		targets = append(targets, t)
+	}
+	w.targets = targets
+}
+
+func (w *writerImpl) writeToTarget(ctx context.Context, wr prompb.WriteRequest, t *targetState, tenant string) (int, error) {
+	start := w.clock.Now()
+	n, err := w.writeTo(ctx, wr, destination{targetURL: t.url, tenant: tenant})
+	if !errors.Is(context.Cause(ctx), errHedgeLost) {
+		now := w.clock.Now()
+		t.observe(now.Sub(start), err, now)
+	}
+
+	return n, err
+}
+
+// fanOut sends wr to every target at once. It returns the most series any target accepted, along with every target's
+// errors
+func (w *writerImpl) fanOut(ctx context.Context, wr prompb.WriteRequest, targets []*targetState, tenant string) (int, error) {
+	sent := make([]int, len(targets))
+	errs := make([]error, len(targets))
+
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			sent[i], errs[i] = w.writeToTarget(ctx, wr, t, tenant)
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("%s: %w", t.url, errs[i])
+			}
+		}()
+	}
+	wg.Wait()
+
+	return slices.Max(sent), errors.Join(errs...)
+}
+
+// partition sends each series in wr to the target it hashes to, all targets at once. It returns the number of series
+// accepted, along with every target's errors
+func (w *writerImpl) partition(ctx context.Context, wr prompb.WriteRequest, targets []*targetState, tenant string) (int, error) {
+	groups := map[*targetState][]prompb.TimeSeries{}
+	for _, ts := range wr.Timeseries {
+		t := rendezvous(fingerprint(ts.Labels), targets)
+		groups[t] = append(groups[t], ts)
+	}
+
+	if len(groups) == 0 {
+		// a request with only metadata goes to every target
+		return w.fanOut(ctx, wr, targets, tenant)
+	}
+
+	var (
+		mu   sync.Mutex
+		sent int
+		errs []error
+		wg   sync.WaitGroup
+	)
+	for t, series := range groups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			n, err := w.writeToTarget(ctx, prompb.WriteRequest{
+				Timeseries: series,
+				Metadata:   metadataFor(series, wr.Metadata),
+			}, t, tenant)
+
+			mu.Lock()
+			defer mu.Unlock()
+			sent += n
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", t.url, err))
+			}
+		}()
+	}
+	wg.Wait()
+
+	return sent, errors.Join(errs...)
+}
+
+// hedgeOrder returns the targets healthy ones first, fastest first, and the rest by how long they have been failing
+func hedgeOrder(targets []*targetState) []*targetState {
+	type candidate struct {
+		t        *targetState
+		latency  time.Duration
+		failures int
+	}
+
+	candidates := make([]candidate, len(targets))
+	for i, t := range targets {
+		t.mu.Lock()
+		candidates[i] = candidate{t: t, latency: t.latency, failures: t.consecutiveFailures}
+		t.mu.Unlock()
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].failures != candidates[j].failures {
+			return candidates[i].failures < candidates[j].failures
+		}
+		return candidates[i].latency < candidates[j].latency
+	})
+
+	ordered := make([]*targetState, len(candidates))
+	for i, c := range candidates {
+		ordered[i] = c.t
+	}
+
+	return ordered
+}
+
+// hedge sends wr to the best target and, after HedgeDelay or a failure, to the second best, returning the first success
+func (w *writerImpl) hedge(ctx context.Context, wr prompb.WriteRequest, targets []*targetState, tenant string) (int, error) {
+	type result struct {
+		t   *targetState
+		n   int
+		err error
+	}
+
+	hedgeCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(errHedgeLost)
+
+	ordered := hedgeOrder(targets)
+	results := make(chan result, 2)
+	started := map[*targetState]time.Time{}
+	start := func(t *targetState) {
+		started[t] = w.clock.Now()
+		go func() {
+			n, err := w.writeToTarget(hedgeCtx, wr, t, tenant)
+			if err != nil {
+				err = fmt.Errorf("%s: %w", t.url, err)
+			}
+			results <- result{t, n, err}
+		}()
+	}
+
+	// only the two best targets are ever tried
+	start(ordered[0])
+	next := 1
+
+	hedge := w.clock.After(w.hedgeDelay)
+
+	var errs []error
+	for len(started) > 0 {
+		select {
+		case <-hedge:
+			if next < 2 {
+				start(ordered[next])
+				next++
+			}
+		case r := <-results:
+			delete(started, r.t)
+			if r.err == nil {
+				// the request still in flight is abandoned rather than failed, but the time it has already taken
+				// counts against its target
+				now := w.clock.Now()
+				for t, at := range started {
+					t.abandon(now.Sub(at))
+				}
+				return r.n, nil
+			}
+			errs = append(errs, r.err)
+
+			if next < 2 {
+				start(ordered[next])
+				next++
+			}
+		}
+	}
+
+	return 0, errors.Join(errs...)
+}
@@ -0,0 +1,370 @@
+package writer_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jghiloni/prometheus-remote-write/receiver"
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	"github.com/jghiloni/prometheus-remote-write/writertest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("Multiple targets", func() {
+	series := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+	}}
+
+	newServer := func(delay time.Duration, status int, requests *atomic.Int32) *httptest.Server {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+			w.WriteHeader(status)
+		}))
+		DeferCleanup(s.Close)
+
+		return s
+	}
+
+	It("Fans out to every target", func() {
+		var first, second, third atomic.Int32
+		s1 := newServer(0, http.StatusNoContent, &first)
+		s2 := newServer(0, http.StatusNoContent, &second)
+		s3 := newServer(0, http.StatusInternalServerError, &third)
+
+		w, err := writer.NewRemoteMetricsWriter(s1.URL, writer.RemoteMetricsWriterOptions{
+			Targets: []writer.Target{{URL: s2.URL}, {URL: s3.URL}},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		n, err := w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).Should(MatchError(ContainSubstring(s3.URL)))
+		Expect(n).Should(Equal(1))
+		Expect(first.Load()).Should(Equal(int32(1)))
+		Expect(second.Load()).Should(Equal(int32(1)))
+		Expect(third.Load()).Should(Equal(int32(1)))
+	})
+
+	It("Hedges slow targets and prefers the fastest one afterwards", func() {
+		var slow, fast atomic.Int32
+		s1 := newServer(time.Second, http.StatusNoContent, &slow)
+		s2 := newServer(0, http.StatusNoContent, &fast)
+
+		w, err := writer.NewRemoteMetricsWriter(s1.URL, writer.RemoteMetricsWriterOptions{
+			Targets:         []writer.Target{{URL: s2.URL}},
+			MultiTargetMode: writer.Hedge,
+			HedgeDelay:      20 * time.Millisecond,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		start := time.Now()
+		n, err := w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).Should(Equal(1))
+		Expect(time.Since(start)).Should(BeNumerically("<", 500*time.Millisecond))
+		Expect(slow.Load()).Should(Equal(int32(1)))
+		Expect(fast.Load()).Should(Equal(int32(1)))
+
+		// the slow target's request was abandoned, not completed: it is slower than the fast one, but has no pushes
+		stats := inspect(w).TargetStats()
+		Expect(stats[0].URL).Should(Equal(s1.URL))
+		Expect(stats[0].Pushes).Should(BeZero())
+		Expect(stats[0].Latency).Should(BeNumerically(">=", 20*time.Millisecond))
+		Expect(stats[0].Latency).Should(BeNumerically(">", stats[1].Latency))
+		Expect(stats[1].Pushes).Should(Equal(uint64(1)))
+
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(slow.Load()).Should(Equal(int32(1)))
+		Expect(fast.Load()).Should(Equal(int32(2)))
+	})
+
+	It("Tries the next target at once when the first fails", func() {
+		var failing, healthy atomic.Int32
+		s1 := newServer(0, http.StatusBadRequest, &failing)
+		s2 := newServer(0, http.StatusNoContent, &healthy)
+
+		w, err := writer.NewRemoteMetricsWriter(s1.URL, writer.RemoteMetricsWriterOptions{
+			Targets:         []writer.Target{{URL: s2.URL}},
+			MultiTargetMode: writer.Hedge,
+			HedgeDelay:      time.Hour,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(failing.Load()).Should(Equal(int32(1)))
+		Expect(healthy.Load()).Should(Equal(int32(1)))
+	})
+
+	It("Keeps statistics for every target", func() {
+		var ok, failing atomic.Int32
+		s1 := newServer(0, http.StatusNoContent, &ok)
+		s2 := newServer(0, http.StatusBadRequest, &failing)
+
+		clock := writertest.NewFakeClock(time.Unix(1700000000, 0))
+		w, err := writer.NewRemoteMetricsWriter(s1.URL, writer.RemoteMetricsWriterOptions{
+			Targets: []writer.Target{{URL: s2.URL}},
+			Clock:   clock,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		for range 2 {
+			_, err = w.WriteTimeSeries(context.Background(), series, nil)
+			Expect(err).Should(HaveOccurred())
+		}
+
+		stats := inspect(w).TargetStats()
+		Expect(stats).Should(HaveLen(2))
+
+		Expect(stats[0].URL).Should(Equal(s1.URL))
+		Expect(stats[0].Pushes).Should(Equal(uint64(2)))
+		Expect(stats[0].SuccessRate()).Should(Equal(1.0))
+		Expect(stats[0].BytesSent).Should(BeNumerically(">", 0))
+		Expect(stats[0].LastError).ShouldNot(HaveOccurred())
+
+		Expect(stats[1].URL).Should(Equal(s2.URL))
+		Expect(stats[1].SuccessRate()).Should(BeZero())
+		Expect(stats[1].ConsecutiveFailures).Should(Equal(2))
+		Expect(stats[1].BytesSent).Should(BeZero())
+		Expect(stats[1].LastError).Should(MatchError(ContainSubstring("400")))
+		Expect(stats[1].LastErrorAt).Should(Equal(clock.Now()))
+	})
+
+	It("Partitions series across targets consistently", func() {
+		var mu sync.Mutex
+		seen := map[string]string{}
+		servers := make([]*httptest.Server, 3)
+		for i := range servers {
+			servers[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := io.ReadAll(r.Body)
+				Expect(err).ShouldNot(HaveOccurred())
+
+				var wr prompb.WriteRequest
+				Expect(wr.Unmarshal(body)).Should(Succeed())
+
+				mu.Lock()
+				defer mu.Unlock()
+				for _, ts := range wr.Timeseries {
+					seen[ts.Labels[1].Value] = r.Host
+				}
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			DeferCleanup(servers[i].Close)
+		}
+
+		var current atomic.Int32
+		current.Store(3)
+		resolver := resolverFunc(func(context.Context) ([]writer.Target, error) {
+			var targets []writer.Target
+			for _, s := range servers[:current.Load()] {
+				targets = append(targets, writer.Target{URL: s.URL})
+			}
+			return targets, nil
+		})
+
+		w, err := writer.NewRemoteMetricsWriter(servers[0].URL, writer.RemoteMetricsWriterOptions{
+			TargetResolver:  resolver,
+			ResolveInterval: time.Nanosecond,
+			MultiTargetMode: writer.Partition,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		many := make([]prompb.TimeSeries, 60)
+		for i := range many {
+			many[i] = prompb.TimeSeries{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "i", Value: strconv.Itoa(i)}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+			}
+		}
+
+		n, err := w.WriteTimeSeries(context.Background(), many, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).Should(Equal(60))
+		Expect(seen).Should(HaveLen(60))
+
+		first := maps.Clone(seen)
+		hosts := map[string]int{}
+		for _, host := range first {
+			hosts[host]++
+		}
+		Expect(hosts).Should(HaveLen(3))
+
+		_, err = w.WriteTimeSeries(context.Background(), many, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(seen).Should(Equal(first))
+
+		current.Store(2)
+		removed := strings.TrimPrefix(servers[2].URL, "http://")
+		_, err = w.WriteTimeSeries(context.Background(), many, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		for series, host := range seen {
+			if first[series] != removed {
+				Expect(host).Should(Equal(first[series]))
+			} else {
+				Expect(host).ShouldNot(Equal(removed))
+			}
+		}
+	})
+
+	It("Logs a failed resolution and tries again on the next push", func() {
+		var primary, secondary atomic.Int32
+		s1 := newServer(0, http.StatusNoContent, &primary)
+		s2 := newServer(0, http.StatusNoContent, &secondary)
+
+		var calls atomic.Int32
+		resolver := resolverFunc(func(context.Context) ([]writer.Target, error) {
+			if calls.Add(1) == 1 {
+				return nil, errors.New("discovery unavailable")
+			}
+			return []writer.Target{{URL: s1.URL}, {URL: s2.URL}}, nil
+		})
+
+		var logs bytes.Buffer
+		w, err := writer.NewRemoteMetricsWriter(s1.URL, writer.RemoteMetricsWriterOptions{
+			TargetResolver:  resolver,
+			ResolveInterval: time.Hour,
+			Clock:           writertest.NewFakeClock(time.Unix(1700000000, 0)),
+			Logger:          slog.New(slog.NewTextHandler(&logs, nil)),
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(logs.String()).Should(ContainSubstring("discovery unavailable"))
+		Expect(secondary.Load()).Should(BeZero())
+
+		// the interval has not passed, but the failed resolution does not count
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(calls.Load()).Should(Equal(int32(2)))
+		Expect(primary.Load()).Should(Equal(int32(2)))
+		Expect(secondary.Load()).Should(Equal(int32(1)))
+	})
+})
+
+type resolverFunc func(context.Context) ([]writer.Target, error)
+
+func (f resolverFunc) Resolve(ctx context.Context) ([]writer.Target, error) {
+	return f(ctx)
+}
+
+var _ = Describe("Per-target compression", func() {
+	It("Compresses requests to each target with its own Compression", func() {
+		encodings := func() (*httptest.Server, *[]string) {
+			var mu sync.Mutex
+			seen := &[]string{}
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				*seen = append(*seen, r.Header.Get("Content-Encoding"))
+				mu.Unlock()
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			DeferCleanup(s.Close)
+			return s, seen
+		}
+		lan, lanEncodings := encodings()
+		wan, wanEncodings := encodings()
+
+		gzip := writer.Gzip
+		w, err := writer.NewRemoteMetricsWriter(lan.URL, writer.RemoteMetricsWriterOptions{
+			Compression: writer.Snappy,
+			Targets:     []writer.Target{{URL: wan.URL, Compression: &gzip}},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+		}}, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(*lanEncodings).Should(Equal([]string{"snappy"}))
+		Expect(*wanEncodings).Should(Equal([]string{"gzip"}))
+	})
+})
+
+var _ = Describe("Per-target format and version", func() {
+	It("Encodes requests to each target with its own Format and RemoteWriteVersion", func() {
+		type received struct {
+			header http.Header
+			wr     *prompb.WriteRequest
+		}
+		receiving := func() (*httptest.Server, *[]received) {
+			var mu sync.Mutex
+			seen := &[]received{}
+			var header http.Header
+			handler, err := receiver.NewHandler(func(_ context.Context, wr *prompb.WriteRequest) error {
+				*seen = append(*seen, received{header: header, wr: wr})
+				return nil
+			}, receiver.HandlerOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				header = r.Header.Clone()
+				handler.ServeHTTP(w, r)
+			}))
+			DeferCleanup(s.Close)
+			return s, seen
+		}
+		thanos, thanosSeen := receiving()
+		mimir, mimirSeen := receiving()
+
+		w, err := writer.NewRemoteMetricsWriter(thanos.URL, writer.RemoteMetricsWriterOptions{
+			Targets: []writer.Target{{URL: mimir.URL, RemoteWriteVersion: "2.0.0"}},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		series := []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "api"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+		}}
+		metadata := []prompb.MetricMetadata{{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "up", Help: "Is it up."}}
+		_, err = w.WriteTimeSeries(context.Background(), series, metadata)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(*thanosSeen).Should(HaveLen(1))
+		thanosRequest := (*thanosSeen)[0]
+		Expect(thanosRequest.header.Get("Content-Type")).Should(Equal("application/x-protobuf"))
+		Expect(thanosRequest.header.Get("X-Prometheus-Remote-Write-Version")).Should(Equal(writer.DefaultRemoteWriteVersion))
+		Expect(thanosRequest.wr.Timeseries).Should(Equal(series))
+
+		Expect(*mimirSeen).Should(HaveLen(1))
+		mimirRequest := (*mimirSeen)[0]
+		Expect(mimirRequest.header.Get("Content-Type")).Should(Equal("application/x-protobuf;proto=io.prometheus.write.v2.Request"))
+		Expect(mimirRequest.header.Get("X-Prometheus-Remote-Write-Version")).Should(Equal("2.0.0"))
+		Expect(mimirRequest.wr.Timeseries).Should(HaveLen(1))
+		Expect(mimirRequest.wr.Timeseries[0].Labels).Should(Equal(series[0].Labels))
+		Expect(mimirRequest.wr.Timeseries[0].Samples).Should(Equal(series[0].Samples))
+		Expect(mimirRequest.wr.Metadata).Should(Equal(metadata))
+	})
+
+	It("Rejects versions it cannot encode requests for", func() {
+		_, err := writer.NewRemoteMetricsWriter("http://localhost", writer.RemoteMetricsWriterOptions{
+			Targets: []writer.Target{{URL: "http://mimir", Format: writer.JSON, RemoteWriteVersion: "2.0.0"}},
+		})
+		Expect(err).Should(MatchError(writer.ErrUnsupportedRemoteWriteVersion))
+
+		_, err = writer.NewRemoteMetricsWriter("http://localhost", writer.RemoteMetricsWriterOptions{RemoteWriteVersion: "3.0.0"})
+		Expect(err).Should(MatchError(writer.ErrUnsupportedRemoteWriteVersion))
+	})
+})
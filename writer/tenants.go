@@ -0,0 +1,78 @@
+package writer
+
+import (
+	"sort"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// series names that belong to a metric family without sharing its exact name
+var familySuffixes = []string{"_bucket", "_sum", "_count", "_total", "_created", "_gsum", "_gcount", "_info"}
+
+// splitByTenant groups the series of wr by the value of label, removing the label from every series. Series without
+// the label are grouped under the empty tenant. Each group only carries the metadata of the families it contains, so
+// one tenant never learns the metric names of another. The tenants are returned sorted so pushes are deterministic
+func splitByTenant(wr prompb.WriteRequest, label string) ([]string, map[string]prompb.WriteRequest) {
+	groups := map[string][]prompb.TimeSeries{}
+	for _, ts := range wr.Timeseries {
+		tenant := ""
+		labels := make([]prompb.Label, 0, len(ts.Labels))
+		for _, l := range ts.Labels {
+			if l.Name == label {
+				tenant = l.Value
+				continue
+			}
+			labels = append(labels, l)
+		}
+
+		ts.Labels = labels
+		groups[tenant] = append(groups[tenant], ts)
+	}
+
+	tenants := make([]string, 0, len(groups))
+	requests := make(map[string]prompb.WriteRequest, len(groups))
+	for tenant, series := range groups {
+		tenants = append(tenants, tenant)
+		requests[tenant] = prompb.WriteRequest{
+			Timeseries: series,
+			Metadata:   metadataFor(series, wr.Metadata),
+		}
+	}
+	sort.Strings(tenants)
+
+	return tenants, requests
+}
+
+// metadataFor returns the entries of metadata that describe at least one of series
+func metadataFor(series []prompb.TimeSeries, metadata []prompb.MetricMetadata) []prompb.MetricMetadata {
+	if len(metadata) == 0 {
+		return nil
+	}
+
+	names := map[string]bool{}
+	for _, ts := range series {
+		for _, l := range ts.Labels {
+			if l.Name == "__name__" {
+				names[l.Value] = true
+				break
+			}
+		}
+	}
+
+	var matched []prompb.MetricMetadata
+	for _, md := range metadata {
+		if names[md.MetricFamilyName] {
+			matched = append(matched, md)
+			continue
+		}
+
+		for _, suffix := range familySuffixes {
+			if names[md.MetricFamilyName+suffix] {
+				matched = append(matched, md)
+				break
+			}
+		}
+	}
+
+	return matched
+}
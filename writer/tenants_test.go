@@ -0,0 +1,135 @@
+package writer_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("Tenant routing", func() {
+	var s *httptest.Server
+	var mu sync.Mutex
+	var received map[string]prompb.WriteRequest
+
+	BeforeEach(func() {
+		received = map[string]prompb.WriteRequest{}
+		s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			defer req.Body.Close()
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			var wr prompb.WriteRequest
+			if err := wr.Unmarshal(body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			mu.Lock()
+			received[req.Header.Get("X-Scope-OrgID")] = wr
+			mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	})
+
+	AfterEach(func() {
+		s.Close()
+	})
+
+	It("Splits series by the tenant label", func() {
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:  s.Client(),
+			Tenant:      "fallback",
+			TenantLabel: "tenant",
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		n, err := w.WriteTimeSeries(context.Background(), []prompb.TimeSeries{
+			{Labels: []prompb.Label{{Name: "__name__", Value: "a_total"}, {Name: "tenant", Value: "team-a"}}, Samples: []prompb.Sample{{Value: 1, Timestamp: 1}}},
+			{Labels: []prompb.Label{{Name: "__name__", Value: "b"}, {Name: "tenant", Value: "team-b"}}, Samples: []prompb.Sample{{Value: 2, Timestamp: 1}}},
+			{Labels: []prompb.Label{{Name: "__name__", Value: "b"}, {Name: "job", Value: "x"}}, Samples: []prompb.Sample{{Value: 3, Timestamp: 1}}},
+		}, []prompb.MetricMetadata{
+			{Type: prompb.MetricMetadata_COUNTER, MetricFamilyName: "a"},
+			{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "b"},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).Should(Equal(3))
+
+		Expect(received).Should(HaveLen(3))
+		Expect(received["team-a"].Timeseries).Should(HaveLen(1))
+		Expect(received["team-a"].Timeseries[0].Labels).Should(Equal([]prompb.Label{{Name: "__name__", Value: "a_total"}}))
+		Expect(received["team-a"].Metadata).Should(HaveLen(1))
+		Expect(received["team-a"].Metadata[0].MetricFamilyName).Should(Equal("a"))
+
+		Expect(received["team-b"].Timeseries).Should(HaveLen(1))
+		Expect(received["team-b"].Metadata[0].MetricFamilyName).Should(Equal("b"))
+
+		Expect(received["fallback"].Timeseries).Should(HaveLen(1))
+		Expect(received["fallback"].Timeseries[0].Labels[1].Value).Should(Equal("x"))
+	})
+
+	It("Sends separated metadata to the tenant of its series only", func() {
+		var requests []struct {
+			tenant string
+			wr     prompb.WriteRequest
+		}
+		all := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			body, err := io.ReadAll(req.Body)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			var wr prompb.WriteRequest
+			Expect(wr.Unmarshal(body)).Should(Succeed())
+
+			mu.Lock()
+			requests = append(requests, struct {
+				tenant string
+				wr     prompb.WriteRequest
+			}{req.Header.Get("X-Scope-OrgID"), wr})
+			mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		DeferCleanup(all.Close)
+
+		w, err := writer.NewRemoteMetricsWriter(all.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:         all.Client(),
+			Tenant:             "fallback",
+			TenantLabel:        "tenant",
+			MaxMetadataPerSend: 2,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var series []prompb.TimeSeries
+		var metadata []prompb.MetricMetadata
+		for _, family := range []struct{ name, tenant string }{{"a1", "team-a"}, {"a2", "team-a"}, {"a3", "team-a"}, {"b1", "team-b"}} {
+			series = append(series, prompb.TimeSeries{
+				Labels:  []prompb.Label{{Name: "__name__", Value: family.name}, {Name: "tenant", Value: family.tenant}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+			})
+			metadata = append(metadata, prompb.MetricMetadata{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: family.name})
+		}
+
+		n, err := w.WriteTimeSeries(context.Background(), series, metadata)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).Should(Equal(4))
+
+		families := map[string][]string{}
+		for _, r := range requests {
+			for _, md := range r.wr.Metadata {
+				families[r.tenant] = append(families[r.tenant], md.MetricFamilyName)
+			}
+		}
+		Expect(families).Should(Equal(map[string][]string{
+			"team-a": {"a1", "a2", "a3"},
+			"team-b": {"b1"},
+		}))
+	})
+})
@@ -0,0 +1,167 @@
+package writer
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// ThrottleMode decides what happens to a push made less than MinPushInterval after the previous one
+type ThrottleMode int
+
+const (
+	// ThrottleReject fails the push with ErrThrottled
+	ThrottleReject ThrottleMode = iota
+	// ThrottleCoalesce holds the push until MinPushInterval has passed, merging it with any other push held in the
+	// meantime, and sends them all in one push. Each caller waits for that push and gets its result
+	ThrottleCoalesce
+)
+
+// String returns the name of the ThrottleMode
+func (m ThrottleMode) String() string {
+	switch m {
+	case ThrottleReject:
+		return "reject"
+	case ThrottleCoalesce:
+		return "coalesce"
+	default:
+		return fmt.Sprintf("%%INVALID!(%d)", m)
+	}
+}
+
+// throttle keeps pushes at least interval apart
+type throttle struct {
+	interval time.Duration
+	mode     ThrottleMode
+	clock    Clock
+
+	mu       sync.Mutex
+	lastPush time.Time
+	pending  *coalescedPush
+}
+
+// coalescedPush is the push that held pushes are merged into
+type coalescedPush struct {
+	request prompb.WriteRequest
+	// index finds the merged series with a given fingerprint
+	index map[uint64][]int
+	// families holds the names of the metric families whose metadata is in the push
+	families map[string]bool
+	done     chan struct{}
+	err      error
+}
+
+func newThrottle(interval time.Duration, mode ThrottleMode, clock Clock) *throttle {
+	if interval <= 0 {
+		return nil
+	}
+
+	return &throttle{interval: interval, mode: mode, clock: clock}
+}
+
+// do calls push with wr now if the interval has passed, and otherwise rejects or coalesces it. A nil throttle always
+// pushes right away
+func (t *throttle) do(ctx context.Context, wr prompb.WriteRequest, push func(context.Context, prompb.WriteRequest) (int, error)) (int, error) {
+	if t == nil {
+		return push(ctx, wr)
+	}
+
+	t.mu.Lock()
+	now := t.clock.Now()
+	wait := t.interval - now.Sub(t.lastPush)
+	if t.pending == nil && wait <= 0 {
+		t.lastPush = now
+		t.mu.Unlock()
+
+		return push(ctx, wr)
+	}
+
+	if t.mode != ThrottleCoalesce {
+		t.mu.Unlock()
+		return 0, fmt.Errorf("%w: next push allowed in %s", ErrThrottled, wait)
+	}
+
+	p := t.pending
+	if p == nil {
+		p = &coalescedPush{index: map[uint64][]int{}, families: map[string]bool{}, done: make(chan struct{})}
+		t.pending = p
+		// the merged push outlives the caller that started it, but keeps its values for HeadersFromContext
+		go t.flush(context.WithoutCancel(ctx), p, wait, push)
+	}
+	p.merge(wr)
+	t.mu.Unlock()
+
+	select {
+	case <-p.done:
+		if p.err != nil {
+			return 0, p.err
+		}
+		return len(wr.Timeseries), nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// flush sends p once wait has passed
+func (t *throttle) flush(ctx context.Context, p *coalescedPush, wait time.Duration, push func(context.Context, prompb.WriteRequest) (int, error)) {
+	<-t.clock.After(wait)
+
+	t.mu.Lock()
+	t.pending = nil
+	t.lastPush = t.clock.Now()
+	t.mu.Unlock()
+
+	_, p.err = push(ctx, p.request)
+	close(p.done)
+}
+
+// merge adds wr to the push. Samples, exemplars and histograms of a series already in the push are added to it, so
+// every series is sent once, and metadata for a family already in the push is skipped
+func (p *coalescedPush) merge(wr prompb.WriteRequest) {
+	for _, ts := range wr.Timeseries {
+		fp := fingerprint(ts.Labels)
+
+		merged := false
+		for _, i := range p.index[fp] {
+			existing := &p.request.Timeseries[i]
+			if !sameLabels(existing.Labels, ts.Labels) {
+				continue
+			}
+
+			existing.Samples = append(existing.Samples, ts.Samples...)
+			existing.Exemplars = append(existing.Exemplars, ts.Exemplars...)
+			existing.Histograms = append(existing.Histograms, ts.Histograms...)
+			merged = true
+			break
+		}
+		if merged {
+			continue
+		}
+
+		// copy the sample slices so appending to them later cannot write into the caller's arrays
+		p.index[fp] = append(p.index[fp], len(p.request.Timeseries))
+		p.request.Timeseries = append(p.request.Timeseries, prompb.TimeSeries{
+			Labels:     ts.Labels,
+			Samples:    append([]prompb.Sample(nil), ts.Samples...),
+			Exemplars:  append([]prompb.Exemplar(nil), ts.Exemplars...),
+			Histograms: append([]prompb.Histogram(nil), ts.Histograms...),
+		})
+	}
+
+	for _, md := range wr.Metadata {
+		if !p.families[md.MetricFamilyName] {
+			p.families[md.MetricFamilyName] = true
+			p.request.Metadata = append(p.request.Metadata, md)
+		}
+	}
+}
+
+func sameLabels(a, b []prompb.Label) bool {
+	return slices.EqualFunc(a, b, func(x, y prompb.Label) bool {
+		return x.Name == y.Name && x.Value == y.Value
+	})
+}
@@ -0,0 +1,121 @@
+package writer_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("MinPushInterval", func() {
+	var s *httptest.Server
+	var mu sync.Mutex
+	var requests []prompb.WriteRequest
+
+	seriesAt := func(name string, ts int64) []prompb.TimeSeries {
+		return []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: name}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: ts}},
+		}}
+	}
+
+	BeforeEach(func() {
+		requests = nil
+		s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			body, _ := io.ReadAll(req.Body)
+
+			var wr prompb.WriteRequest
+			if err := wr.Unmarshal(body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			mu.Lock()
+			requests = append(requests, wr)
+			mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	})
+
+	AfterEach(func() {
+		s.Close()
+	})
+
+	It("Rejects pushes made too soon", func() {
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:      s.Client(),
+			MinPushInterval: time.Hour,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), seriesAt("up", 1000), nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		n, err := w.WriteTimeSeries(context.Background(), seriesAt("up", 2000), nil)
+		Expect(err).Should(MatchError(writer.ErrThrottled))
+		Expect(n).Should(BeZero())
+		Expect(requests).Should(HaveLen(1))
+	})
+
+	It("Coalesces pushes made too soon into one", func() {
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:      s.Client(),
+			MinPushInterval: 200 * time.Millisecond,
+			ThrottleMode:    writer.ThrottleCoalesce,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), seriesAt("up", 1000), nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var wg sync.WaitGroup
+		for i, name := range []string{"up", "up", "down"} {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				n, err := w.WriteTimeSeries(context.Background(), seriesAt(name, int64(2000+i)), []prompb.MetricMetadata{
+					{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: name},
+				})
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(n).Should(Equal(1))
+			}()
+		}
+		wg.Wait()
+
+		Expect(requests).Should(HaveLen(2))
+		merged := requests[1]
+		Expect(merged.Timeseries).Should(HaveLen(2))
+		Expect(merged.Metadata).Should(HaveLen(2))
+		for _, ts := range merged.Timeseries {
+			if ts.Labels[0].Value == "up" {
+				Expect(ts.Samples).Should(HaveLen(2))
+			}
+		}
+	})
+
+	It("Gives up waiting when the caller's context ends", func() {
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:      s.Client(),
+			MinPushInterval: time.Hour,
+			ThrottleMode:    writer.ThrottleCoalesce,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), seriesAt("up", 1000), nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err = w.WriteTimeSeries(ctx, seriesAt("up", 2000), nil)
+		Expect(err).Should(MatchError(context.DeadlineExceeded))
+	})
+})
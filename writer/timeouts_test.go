@@ -0,0 +1,81 @@
+package writer_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// stuckCollector blocks in Collect until release is closed
+type stuckCollector struct {
+	release chan struct{}
+}
+
+func (c stuckCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c stuckCollector) Collect(ch chan<- prometheus.Metric) {
+	<-c.release
+}
+
+var _ = Describe("Gather and send timeouts", func() {
+	It("fails with ErrGatherTimeout when a collector does not return in time", func() {
+		var requests atomic.Int32
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		DeferCleanup(s.Close)
+
+		release := make(chan struct{})
+		DeferCleanup(func() { close(release) })
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(stuckCollector{release: release})
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:    s.Client(),
+			GatherTimeout: 20 * time.Millisecond,
+		}, registry)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteMetrics(context.Background())
+		Expect(err).Should(MatchError(writer.ErrGatherTimeout))
+		Expect(err).Should(MatchError(writer.ErrGather))
+		Expect(err).ShouldNot(MatchError(writer.ErrSendTimeout))
+		Expect(requests.Load()).Should(BeZero())
+	})
+
+	It("fails with ErrSendTimeout when the target does not answer in time, and retries", func() {
+		var requests atomic.Int32
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			time.Sleep(200 * time.Millisecond)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		DeferCleanup(s.Close)
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "up"}))
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:  s.Client(),
+			SendTimeout: 20 * time.Millisecond,
+			MaxRetries:  1,
+			MinBackoff:  time.Millisecond,
+		}, registry)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteMetrics(context.Background())
+		Expect(err).Should(MatchError(writer.ErrSendTimeout))
+		Expect(err).Should(MatchError(writer.ErrSend))
+		Expect(err).ShouldNot(MatchError(writer.ErrGatherTimeout))
+		Expect(requests.Load()).Should(BeEquivalentTo(2))
+	})
+})
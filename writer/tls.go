@@ -0,0 +1,173 @@
+package writer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// TLSConfig holds the TLS settings of a writer whose certificates live on disk.
+//
+//	CAFile is a PEM bundle of the certificate authorities to trust instead of the system's
+//	CertFile and KeyFile are a PEM client certificate and its key, for targets that require mutual TLS. Either both or
+//	neither must be set
+//	ServerName overrides the name the target's certificate is checked against
+//	If InsecureSkipVerify is set, the target's certificate is not checked at all
+//
+// The files are checked before every request and loaded again when any of them changes, so certificates can be rotated
+// without restarting the process. Connections made with the old certificates are closed once they are idle. If the
+// files cannot be read or do not hold valid certificates, for instance while they are halfway through being replaced,
+// the error is logged and requests keep using the certificates last loaded until the files are fixed
+type TLSConfig struct {
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+// tlsTransport is an http.Transport that is rebuilt whenever the files of its TLSConfig change
+type tlsTransport struct {
+	config TLSConfig
+	files  []*secretFile
+	// dial replaces the transport's dialer when it is set
+	dial   dialFunc
+	logger *slog.Logger
+
+	mu        sync.Mutex
+	loaded    []string
+	transport *http.Transport
+	// lastErr is the error of the last failed reload, so that a file that stays broken is only logged once
+	lastErr string
+}
+
+func newTLSTransport(config TLSConfig, dial dialFunc, logger *slog.Logger) (*tlsTransport, error) {
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, errors.New("TLS CertFile and KeyFile must be set together")
+	}
+
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	t := &tlsTransport{config: config, dial: dial, logger: logger}
+	for _, path := range []string{config.CAFile, config.CertFile, config.KeyFile} {
+		if path == "" {
+			t.files = append(t.files, nil)
+			continue
+		}
+
+		f, err := newSecretFile(path)
+		if err != nil {
+			return nil, err
+		}
+		t.files = append(t.files, f)
+	}
+
+	if _, err := t.current(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (t *tlsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport, err := t.current()
+	if err != nil {
+		return nil, err
+	}
+
+	return transport.RoundTrip(req)
+}
+
+// current returns the transport for the files as they are now, building a new one if they have changed. If that
+// fails once a transport has been built, the error is logged and the last good transport is returned; the files are
+// tried again on the next call
+func (t *tlsTransport) current() (*http.Transport, error) {
+	contents, err := t.read()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err == nil {
+		if t.transport != nil && slices.Equal(contents, t.loaded) {
+			t.lastErr = ""
+			return t.transport, nil
+		}
+
+		var transport *http.Transport
+		if transport, err = t.build(contents); err == nil {
+			if t.transport != nil {
+				t.transport.CloseIdleConnections()
+			}
+			t.transport, t.loaded, t.lastErr = transport, contents, ""
+
+			return transport, nil
+		}
+	}
+
+	if t.transport == nil {
+		return nil, err
+	}
+
+	if err.Error() != t.lastErr {
+		t.lastErr = err.Error()
+		t.logger.Warn("reloading TLS certificates failed, keeping the ones last loaded", slog.Any("error", err))
+	}
+
+	return t.transport, nil
+}
+
+// read returns the contents of the CA, certificate and key files, with an empty string for those that are not set
+func (t *tlsTransport) read() ([]string, error) {
+	contents := make([]string, len(t.files))
+	for i, f := range t.files {
+		if f == nil {
+			continue
+		}
+
+		var err error
+		if contents[i], err = f.get(); err != nil {
+			return nil, err
+		}
+	}
+
+	return contents, nil
+}
+
+// build returns a transport using the CA, certificate and key in contents
+func (t *tlsTransport) build(contents []string) (*http.Transport, error) {
+	config := &tls.Config{
+		ServerName:         t.config.ServerName,
+		InsecureSkipVerify: t.config.InsecureSkipVerify,
+	}
+
+	ca, cert, key := contents[0], contents[1], contents[2]
+	if ca != "" {
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM([]byte(ca)) {
+			return nil, fmt.Errorf("no certificates found in %s", t.config.CAFile)
+		}
+	}
+
+	if cert != "" {
+		pair, err := tls.X509KeyPair([]byte(cert), []byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	if t.dial != nil {
+		transport.DialContext = t.dial
+	}
+
+	return transport, nil
+}
@@ -0,0 +1,124 @@
+package writer_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("TLS", func() {
+	series := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+	}}
+
+	writeCA := func(path string, der []byte, age time.Duration) {
+		data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+		Expect(os.WriteFile(path, data, 0o600)).Should(Succeed())
+		at := time.Now().Add(-age)
+		Expect(os.Chtimes(path, at, at)).Should(Succeed())
+	}
+
+	It("Trusts a rotated CA bundle without being recreated", func() {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+		target := httptest.NewTLSServer(handler)
+		defer target.Close()
+
+		// an unrelated self-signed CA, which the target's certificate does not chain to
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).ShouldNot(HaveOccurred())
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "unrelated CA"},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign,
+		}
+		unrelated, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		caFile := filepath.Join(GinkgoT().TempDir(), "ca.pem")
+		writeCA(caFile, unrelated, time.Hour)
+
+		w, err := writer.NewRemoteMetricsWriter(target.URL, writer.RemoteMetricsWriterOptions{
+			TLS: &writer.TLSConfig{CAFile: caFile, ServerName: "example.com"},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).Should(MatchError(ContainSubstring("certificate")))
+
+		writeCA(caFile, target.Certificate().Raw, 0)
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	It("Keeps the last good certificates when a reload fails", func() {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+		target := httptest.NewTLSServer(handler)
+		defer target.Close()
+
+		caFile := filepath.Join(GinkgoT().TempDir(), "ca.pem")
+		writeCA(caFile, target.Certificate().Raw, time.Hour)
+
+		var logs bytes.Buffer
+		w, err := writer.NewRemoteMetricsWriter(target.URL, writer.RemoteMetricsWriterOptions{
+			TLS:    &writer.TLSConfig{CAFile: caFile, ServerName: "example.com"},
+			Logger: slog.New(slog.NewTextHandler(&logs, nil)),
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		// a bundle caught halfway through being rewritten
+		Expect(os.WriteFile(caFile, []byte("-----BEGIN CERTIFICATE-----\n"), 0o600)).Should(Succeed())
+		for range 3 {
+			_, err = w.WriteTimeSeries(context.Background(), series, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+		Expect(strings.Count(logs.String(), "reloading TLS certificates failed")).Should(Equal(1))
+		Expect(logs.String()).Should(ContainSubstring("no certificates found in " + caFile))
+
+		Expect(os.Remove(caFile)).Should(Succeed())
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(strings.Count(logs.String(), "reloading TLS certificates failed")).Should(Equal(2))
+
+		writeCA(caFile, target.Certificate().Raw, 0)
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(strings.Count(logs.String(), "reloading TLS certificates failed")).Should(Equal(2))
+	})
+
+	It("Requires a client certificate and key together", func() {
+		_, err := writer.NewRemoteMetricsWriter("https://example.invalid", writer.RemoteMetricsWriterOptions{
+			TLS: &writer.TLSConfig{CertFile: "cert.pem"},
+		})
+		Expect(err).Should(HaveOccurred())
+	})
+})
@@ -0,0 +1,182 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// isTooLarge reports whether err is a 413 Payload Too Large response
+func isTooLarge(err error) bool {
+	var se *StatusError
+	return errors.As(err, &se) && se.StatusCode == http.StatusRequestEntityTooLarge
+}
+
+// DefaultMinSplitSamples is the smallest request that is split when rejected with 413 Payload Too Large if
+// MinSplitSamples is not set. A request of one sample cannot be split any further
+const DefaultMinSplitSamples = 2
+
+// relaxSampleLimitAfter is how many pushes in a row have to be split by a target's sample limit and accepted before
+// the limit is doubled, to find out whether the target takes larger requests again
+const relaxSampleLimitAfter = 100
+
+// sampleLimit is the most samples one target takes in a request, learned from its 413 responses
+type sampleLimit struct {
+	mu sync.Mutex
+	// limit is zero while no limit is known
+	limit     int
+	successes int
+}
+
+// get returns the limit, or zero if none is known
+func (l *sampleLimit) get() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.limit
+}
+
+// tooLarge records that a request of n samples was too large, so later requests are split into at most n/2 samples up
+// front instead of being rejected again
+func (l *sampleLimit) tooLarge(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.successes = 0
+	if limit := max(n/2, 1); l.limit == 0 || limit < l.limit {
+		l.limit = limit
+	}
+}
+
+// accepted records a push that was split by the limit and accepted in full. After relaxSampleLimitAfter of them in a
+// row the limit is doubled, so a target whose limit was raised, or that only rejected requests while overloaded, is
+// not sent needlessly small requests forever
+func (l *sampleLimit) accepted() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limit == 0 {
+		return
+	}
+
+	l.successes++
+	if l.successes >= relaxSampleLimitAfter {
+		l.limit *= 2
+		l.successes = 0
+	}
+}
+
+// sampleLimitFor returns the sample limit of the target at url
+func (w *writerImpl) sampleLimitFor(url string) *sampleLimit {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	l, ok := w.sampleLimits[url]
+	if !ok {
+		if w.sampleLimits == nil {
+			w.sampleLimits = map[string]*sampleLimit{}
+		}
+		l = &sampleLimit{}
+		w.sampleLimits[url] = l
+	}
+
+	return l
+}
+
+// splitMetadata splits a request carrying only metadata into two requests carrying half of it each, or returns false
+// if there is nothing to split
+func splitMetadata(wr prompb.WriteRequest) ([]prompb.WriteRequest, bool) {
+	if len(wr.Timeseries) > 0 || len(wr.Metadata) < 2 {
+		return nil, false
+	}
+
+	half := len(wr.Metadata) / 2
+	return []prompb.WriteRequest{{Metadata: wr.Metadata[:half]}, {Metadata: wr.Metadata[half:]}}, true
+}
+
+// writeHalves sends each of the requests splitMetadata returned on its own
+func (w *writerImpl) writeHalves(ctx context.Context, halves []prompb.WriteRequest, dest destination) error {
+	var errs []error
+	for _, half := range halves {
+		if _, err := w.writeRequest(ctx, half, dest); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// requestChunk is one of the requests chunk splits a request into. series counts the series that start in it, so
+// that a series split over several chunks is only counted once
+type requestChunk struct {
+	request prompb.WriteRequest
+	series  int
+}
+
+// chunk splits wr into requests of at most limit samples (counting native histograms, and series without either, as
+// one sample each), each carrying the metadata for its own series. A series with more samples than limit is split
+// over several requests, in timestamp order; its exemplars travel with its first part
+func chunk(wr prompb.WriteRequest, limit int) []requestChunk {
+	if limit <= 0 || batchSamples(wr.Timeseries) <= limit {
+		return []requestChunk{{request: wr, series: len(wr.Timeseries)}}
+	}
+
+	var chunks []requestChunk
+	var current []prompb.TimeSeries
+	size, started := 0, 0
+	flush := func() {
+		if len(current) > 0 {
+			chunks = append(chunks, requestChunk{
+				request: prompb.WriteRequest{Timeseries: current, Metadata: metadataFor(current, wr.Metadata)},
+				series:  started,
+			})
+		}
+		current, size, started = nil, 0, 0
+	}
+
+	for _, ts := range wr.Timeseries {
+		first := true
+		for {
+			if size == limit {
+				flush()
+			}
+
+			part, rest := splitSeries(ts, limit-size)
+			current = append(current, part)
+			size += sampleCount(part)
+			if first {
+				started++
+				first = false
+			}
+
+			if rest == nil {
+				break
+			}
+			ts = *rest
+		}
+	}
+	flush()
+
+	return chunks
+}
+
+// splitSeries returns the first n samples and histograms of ts as one series and, if there are more, the rest as
+// another
+func splitSeries(ts prompb.TimeSeries, n int) (prompb.TimeSeries, *prompb.TimeSeries) {
+	if sampleCount(ts) <= n {
+		return ts, nil
+	}
+
+	part := prompb.TimeSeries{Labels: ts.Labels, Exemplars: ts.Exemplars}
+	rest := prompb.TimeSeries{Labels: ts.Labels}
+
+	samples := min(n, len(ts.Samples))
+	part.Samples, rest.Samples = ts.Samples[:samples], ts.Samples[samples:]
+	histograms := min(n-samples, len(ts.Histograms))
+	part.Histograms, rest.Histograms = ts.Histograms[:histograms], ts.Histograms[histograms:]
+
+	return part, &rest
+}
@@ -0,0 +1,253 @@
+package writer_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("Payload too large", func() {
+	var (
+		mu       sync.Mutex
+		accepted int
+		rejected int
+		s        *httptest.Server
+	)
+
+	BeforeEach(func() {
+		accepted, rejected = 0, 0
+		s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			Expect(err).ShouldNot(HaveOccurred())
+			decoded, err := snappy.Decode(nil, body)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			var wr prompb.WriteRequest
+			Expect(wr.Unmarshal(decoded)).Should(Succeed())
+
+			samples := 0
+			for _, ts := range wr.Timeseries {
+				samples += len(ts.Samples)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if samples > 3 {
+				rejected++
+				http.Error(w, "too many samples", http.StatusRequestEntityTooLarge)
+				return
+			}
+			accepted += samples
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		DeferCleanup(s.Close)
+	})
+
+	series := func(n, samples int) []prompb.TimeSeries {
+		ts := make([]prompb.TimeSeries, n)
+		for i := range ts {
+			ts[i] = prompb.TimeSeries{
+				Labels: []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "i", Value: strconv.Itoa(i)}},
+			}
+			for j := range samples {
+				ts[i].Samples = append(ts[i].Samples, prompb.Sample{Value: 1, Timestamp: int64(1000 * (j + 1))})
+			}
+		}
+		return ts
+	}
+
+	It("Splits rejected requests and remembers the limit", func() {
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:  s.Client(),
+			Compression: writer.Snappy,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		n, err := w.WriteTimeSeries(context.Background(), series(10, 1), nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).Should(Equal(10))
+		Expect(accepted).Should(Equal(10))
+		Expect(rejected).Should(BeNumerically(">", 0))
+
+		rejected = 0
+		n, err = w.WriteTimeSeries(context.Background(), series(10, 1), nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).Should(Equal(10))
+		Expect(accepted).Should(Equal(20))
+		Expect(rejected).Should(BeZero())
+	})
+
+	It("Splits series with more samples than the limit", func() {
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:  s.Client(),
+			Compression: writer.Snappy,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		n, err := w.WriteTimeSeries(context.Background(), series(2, 5), nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).Should(Equal(2))
+		Expect(accepted).Should(Equal(10))
+		Expect(rejected).Should(BeNumerically(">", 0))
+	})
+})
+
+var _ = Describe("Payload too large limits", func() {
+	// limited serves a target that rejects requests carrying more than maxSamples samples or maxMetadata metadata
+	// entries, and counts the requests, samples and metadata it sees
+	type limited struct {
+		*httptest.Server
+
+		mu          sync.Mutex
+		maxSamples  int
+		maxMetadata int
+		requests    int
+		samples     int
+		metadata    int
+	}
+
+	newLimited := func(maxSamples, maxMetadata int) *limited {
+		l := &limited{maxSamples: maxSamples, maxMetadata: maxMetadata}
+		l.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			Expect(err).ShouldNot(HaveOccurred())
+			decoded, err := snappy.Decode(nil, body)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			var wr prompb.WriteRequest
+			Expect(wr.Unmarshal(decoded)).Should(Succeed())
+
+			samples := 0
+			for _, ts := range wr.Timeseries {
+				samples += len(ts.Samples)
+			}
+
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.requests++
+			if samples > l.maxSamples || len(wr.Metadata) > l.maxMetadata {
+				http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			l.samples += samples
+			l.metadata += len(wr.Metadata)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		DeferCleanup(l.Close)
+		return l
+	}
+
+	requests := func(l *limited) int {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		n := l.requests
+		l.requests = 0
+		return n
+	}
+
+	series := func(n int) []prompb.TimeSeries {
+		ts := make([]prompb.TimeSeries, n)
+		for i := range ts {
+			ts[i] = prompb.TimeSeries{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "i", Value: strconv.Itoa(i)}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+			}
+		}
+		return ts
+	}
+
+	It("Learns a limit for each target on its own", func() {
+		small, large := newLimited(3, 1000), newLimited(1000, 1000)
+		w, err := writer.NewRemoteMetricsWriter(small.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:  small.Client(),
+			Compression: writer.Snappy,
+			Targets:     []writer.Target{{URL: large.URL}},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		for range 2 {
+			_, err = w.WriteTimeSeries(context.Background(), series(10), nil)
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+
+		Expect(small.samples).Should(Equal(20))
+		Expect(large.samples).Should(Equal(20))
+		Expect(requests(large)).Should(Equal(2))
+	})
+
+	It("Returns the 413 of requests smaller than MinSplitSamples as is", func() {
+		small := newLimited(3, 1000)
+		w, err := writer.NewRemoteMetricsWriter(small.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:      small.Client(),
+			Compression:     writer.Snappy,
+			MinSplitSamples: 20,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), series(10), nil)
+		var se *writer.StatusError
+		Expect(errors.As(err, &se)).Should(BeTrue())
+		Expect(se.StatusCode).Should(Equal(http.StatusRequestEntityTooLarge))
+		Expect(requests(small)).Should(Equal(1))
+	})
+
+	It("Splits requests carrying only metadata", func() {
+		small := newLimited(1000, 2)
+		w, err := writer.NewRemoteMetricsWriter(small.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:  small.Client(),
+			Compression: writer.Snappy,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		metadata := make([]prompb.MetricMetadata, 7)
+		for i := range metadata {
+			metadata[i] = prompb.MetricMetadata{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "m" + strconv.Itoa(i)}
+		}
+
+		_, err = w.WriteTimeSeries(context.Background(), nil, metadata)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(small.metadata).Should(Equal(7))
+	})
+
+	It("Relaxes the limit after a run of accepted pushes", func() {
+		small := newLimited(3, 1000)
+		w, err := writer.NewRemoteMetricsWriter(small.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:  small.Client(),
+			Compression: writer.Snappy,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		// ten samples are rejected, then five, so the limit settles at two
+		_, err = w.WriteTimeSeries(context.Background(), series(10), nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		requests(small)
+
+		small.mu.Lock()
+		small.maxSamples = 1000
+		small.mu.Unlock()
+
+		_, err = w.WriteTimeSeries(context.Background(), series(10), nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(requests(small)).Should(Equal(5))
+
+		for range 100 {
+			_, err = w.WriteTimeSeries(context.Background(), series(10), nil)
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+		requests(small)
+
+		_, err = w.WriteTimeSeries(context.Background(), series(10), nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(requests(small)).Should(Equal(3))
+	})
+})
@@ -0,0 +1,202 @@
+package writer
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jghiloni/prometheus-remote-write/receiver"
+	"github.com/prometheus/prometheus/prompb"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+)
+
+// contentTypeV2 is the Content-Type of a remote write 2.0 request
+const contentTypeV2 = "application/x-protobuf;proto=io.prometheus.write.v2.Request"
+
+// isRemoteWriteV2 reports whether version is a remote write 2.x version, whose requests are encoded as
+// io.prometheus.write.v2.Request rather than prometheus.WriteRequest
+func isRemoteWriteV2(version string) bool {
+	return strings.HasPrefix(version, "2.")
+}
+
+// checkRemoteWriteVersion returns an error wrapping ErrUnsupportedRemoteWriteVersion unless requests of version can be
+// sent in format. 1.x versions (and 0.1.0, which 1.0 receivers are sent) work with every Format; 2.x versions only
+// with the protobuf formats, as 2.0 has no JSON encoding
+func checkRemoteWriteVersion(version string, format Format) error {
+	switch {
+	case version == DefaultRemoteWriteVersion || strings.HasPrefix(version, "1."):
+		return nil
+	case isRemoteWriteV2(version):
+		if format != Protobuf && format != GoProtobuf {
+			return fmt.Errorf("%w: %s requests cannot be sent as %s", ErrUnsupportedRemoteWriteVersion, version, format)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupportedRemoteWriteVersion, version)
+	}
+}
+
+// describeV2 replaces the Content-Type the format set with that of a remote write 2.0 request
+func describeV2(req *http.Request) {
+	req.Header.Set("Content-Type", contentTypeV2)
+}
+
+// marshalFor encodes wr in format for a receiver of version: as a 2.0 request for 2.x versions, and with
+// Format.marshalPooled otherwise. release returns the buffer of the encoded request to its pool
+func marshalFor(format Format, version string, wr prompb.WriteRequest) (data []byte, release func(), err error) {
+	if isRemoteWriteV2(version) {
+		data, err = marshalV2(wr)
+		return data, func() {}, err
+	}
+
+	return format.marshalPooled(wr)
+}
+
+// unmarshalFor is the inverse of marshalFor
+func unmarshalFor(format Format, version string, data []byte) (prompb.WriteRequest, error) {
+	if !isRemoteWriteV2(version) {
+		return format.Unmarshal(data)
+	}
+
+	wr, err := receiver.DecodeV2(data)
+	if err != nil {
+		return prompb.WriteRequest{}, err
+	}
+
+	return *wr, nil
+}
+
+// marshalV2 encodes wr as a remote write 2.0 request. 2.0 has no metadata-only requests: each series carries the
+// metadata of its family, so metadata whose family has no series in wr is not sent
+func marshalV2(wr prompb.WriteRequest) ([]byte, error) {
+	req := toV2(wr)
+	return req.Marshal()
+}
+
+// toV2 converts wr to a remote write 2.0 request, interning every string in its symbols table
+func toV2(wr prompb.WriteRequest) writev2.Request {
+	symbols := writev2.NewSymbolTable()
+	refs := func(labels []prompb.Label) []uint32 {
+		refs := make([]uint32, 0, 2*len(labels))
+		for _, l := range labels {
+			refs = append(refs, symbols.Symbolize(l.Name), symbols.Symbolize(l.Value))
+		}
+		return refs
+	}
+
+	families := make(map[string]prompb.MetricMetadata, len(wr.Metadata))
+	for _, md := range wr.Metadata {
+		families[md.MetricFamilyName] = md
+	}
+
+	series := make([]writev2.TimeSeries, 0, len(wr.Timeseries))
+	for _, ts := range wr.Timeseries {
+		converted := writev2.TimeSeries{
+			LabelsRefs: refs(ts.Labels),
+			Samples:    make([]writev2.Sample, 0, len(ts.Samples)),
+		}
+
+		for _, s := range ts.Samples {
+			converted.Samples = append(converted.Samples, writev2.Sample{Value: s.Value, Timestamp: s.Timestamp})
+		}
+		for _, h := range ts.Histograms {
+			converted.Histograms = append(converted.Histograms, histogramToV2(h))
+		}
+		for _, e := range ts.Exemplars {
+			converted.Exemplars = append(converted.Exemplars, writev2.Exemplar{
+				LabelsRefs: refs(e.Labels),
+				Value:      e.Value,
+				Timestamp:  e.Timestamp,
+			})
+		}
+
+		if md, ok := familyMetadata(ts.Labels, families); ok {
+			converted.Metadata = writev2.Metadata{
+				// the 1.0 and 2.0 metric type enums share their values
+				Type:    writev2.Metadata_MetricType(md.Type),
+				HelpRef: symbols.Symbolize(md.Help),
+				UnitRef: symbols.Symbolize(md.Unit),
+			}
+		}
+
+		series = append(series, converted)
+	}
+
+	return writev2.Request{Symbols: symbols.Symbols(), Timeseries: series}
+}
+
+// familyMetadata finds the metadata of the family a series with labels belongs to, by its metric name with or without
+// one of the suffixes series of a family carry
+func familyMetadata(labels []prompb.Label, families map[string]prompb.MetricMetadata) (prompb.MetricMetadata, bool) {
+	if len(families) == 0 {
+		return prompb.MetricMetadata{}, false
+	}
+
+	name := ""
+	for _, l := range labels {
+		if l.Name == "__name__" {
+			name = l.Value
+			break
+		}
+	}
+
+	if md, ok := families[name]; ok {
+		return md, true
+	}
+	for _, suffix := range familySuffixes {
+		if base, ok := strings.CutSuffix(name, suffix); ok {
+			if md, ok := families[base]; ok {
+				return md, true
+			}
+		}
+	}
+
+	return prompb.MetricMetadata{}, false
+}
+
+func bucketSpansToV2(spans []prompb.BucketSpan) []writev2.BucketSpan {
+	if spans == nil {
+		return nil
+	}
+
+	converted := make([]writev2.BucketSpan, len(spans))
+	for i, s := range spans {
+		converted[i] = writev2.BucketSpan{Offset: s.Offset, Length: s.Length}
+	}
+
+	return converted
+}
+
+func histogramToV2(h prompb.Histogram) writev2.Histogram {
+	converted := writev2.Histogram{
+		Sum:            h.Sum,
+		Schema:         h.Schema,
+		ZeroThreshold:  h.ZeroThreshold,
+		NegativeSpans:  bucketSpansToV2(h.NegativeSpans),
+		NegativeDeltas: h.NegativeDeltas,
+		NegativeCounts: h.NegativeCounts,
+		PositiveSpans:  bucketSpansToV2(h.PositiveSpans),
+		PositiveDeltas: h.PositiveDeltas,
+		PositiveCounts: h.PositiveCounts,
+		// the 1.0 and 2.0 reset hint enums share their values
+		ResetHint:    writev2.Histogram_ResetHint(h.ResetHint),
+		Timestamp:    h.Timestamp,
+		CustomValues: h.CustomValues,
+	}
+
+	switch c := h.GetCount().(type) {
+	case *prompb.Histogram_CountInt:
+		converted.Count = &writev2.Histogram_CountInt{CountInt: c.CountInt}
+	case *prompb.Histogram_CountFloat:
+		converted.Count = &writev2.Histogram_CountFloat{CountFloat: c.CountFloat}
+	}
+
+	switch z := h.GetZeroCount().(type) {
+	case *prompb.Histogram_ZeroCountInt:
+		converted.ZeroCount = &writev2.Histogram_ZeroCountInt{ZeroCountInt: z.ZeroCountInt}
+	case *prompb.Histogram_ZeroCountFloat:
+		converted.ZeroCount = &writev2.Histogram_ZeroCountFloat{ZeroCountFloat: z.ZeroCountFloat}
+	}
+
+	return converted
+}
@@ -0,0 +1,189 @@
+package writer
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jghiloni/prometheus-remote-write/receiver"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Validate gathers and converts the writer's metrics exactly as WriteMetrics would, and takes them through the same
+// limits, checks and division between tenants, routes and targets, but instead of sending them reports every problem
+// that would get them rejected or mangled: gather errors (such as metrics whose value does not match their family's
+// type), series the writer's limits would leave out or its checks would reject, series the remote write specification
+// does not allow (invalid metric or label names, duplicate labels or series, and so on), and requests that come out
+// larger than MaxPayloadBytes once encoded for the target they go to. Problems are joined with errors.Join; gather
+// errors wrap ErrGather, limits and checks wrap the errors a push would return, and the others wrap ErrInvalidMetric.
+// Validating does not affect what later pushes send
+func (w *writerImpl) Validate(ctx context.Context) error {
+	if ctx == nil {
+		return ErrNilContext
+	}
+
+	if len(w.gatherers) == 0 {
+		return ErrNoGatherersDefined
+	}
+
+	var errs []error
+	families, _, err := w.gatherWithin(ctx)
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+		// whatever was gathered despite the error is still worth checking
+		errs = append(errs, fmt.Errorf("%w: %w", ErrGather, err))
+	}
+
+	p, err := w.dryRun(ctx, families)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	errs = append(errs, p.errs...)
+
+	for _, b := range p.batches {
+		if err = receiver.Validate(&b.request); err != nil {
+			errs = append(errs, fmt.Errorf("%w: %w", ErrInvalidMetric, err))
+		}
+	}
+
+	if w.maxPayloadBytes > 0 {
+		for _, b := range w.requests(p) {
+			for _, url := range w.destinationURLs(b.destination) {
+				if err = w.validateSize(b.request, url); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// EstimateSize gathers and converts the writer's metrics and prepares the requests a push would make, as Validate
+// does, and returns the number of series they hold and their total size in bytes, each marshalled in the format of the
+// target it goes to but not yet compressed, without sending anything. Requests to the writer's own URL are sized once,
+// in its format, however many Targets they are copied or spread across. It is a guide for choosing MaxPayloadBytes,
+// SampleLimit and Compression
+func (w *writerImpl) EstimateSize(ctx context.Context) (int, int, error) {
+	if ctx == nil {
+		return 0, 0, ErrNilContext
+	}
+
+	if len(w.gatherers) == 0 {
+		return 0, 0, ErrNoGatherersDefined
+	}
+
+	families, _, err := w.gatherWithin(ctx)
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return 0, 0, err
+		}
+		return 0, 0, fmt.Errorf("%w: %w", ErrGather, err)
+	}
+
+	p, err := w.dryRun(ctx, families)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	series, size := 0, 0
+	for _, b := range w.requests(p) {
+		uncompressed, _, err := w.encodedSize(b.request, b.destination.targetURL)
+		if err != nil {
+			return 0, 0, err
+		}
+		series += len(b.request.Timeseries)
+		size += uncompressed
+	}
+
+	return series, size, nil
+}
+
+// dryRun converts families and prepares the requests a push would make of them, without the tracker, so that
+// validating and estimating cannot change the reset hints or zero samples of the next push
+func (w *writerImpl) dryRun(ctx context.Context, families []*dto.MetricFamily) (preparedPush, error) {
+	wr, err := w.convertFamilies(ctx, families, nil)
+	if err != nil {
+		return preparedPush{}, err
+	}
+
+	return w.prepare(wr)
+}
+
+// requests returns every request the push would make: its metadata-only requests, then one per batch
+func (w *writerImpl) requests(p preparedPush) []batch {
+	var requests []batch
+	for _, b := range p.metadata {
+		for _, wr := range w.metadataRequests(b.request.Metadata) {
+			requests = append(requests, batch{destination: b.destination, request: wr})
+		}
+	}
+
+	return append(requests, p.batches...)
+}
+
+// destinationURLs returns the URLs requests to dest are sent to: dest's own, or those of every target the writer has
+// if dest is its own URL
+func (w *writerImpl) destinationURLs(dest destination) []string {
+	if dest.targetURL != w.targetURL {
+		return []string{dest.targetURL}
+	}
+
+	targets := w.currentTargets()
+	urls := make([]string, len(targets))
+	for i, t := range targets {
+		urls[i] = t.url
+	}
+
+	return urls
+}
+
+// encodedSize marshals and compresses wr as writeRequest would for the target at url, and returns its size before and
+// after compressing. A request writeRequest would not send, such as one with only metadata for a 2.x receiver, has
+// no size
+func (w *writerImpl) encodedSize(wr prompb.WriteRequest, url string) (int, int, error) {
+	format, encoding, version, _ := w.encodingFor(url)
+	version = cmp.Or(version, w.version)
+	if err := checkRemoteWriteVersion(version, format); err != nil {
+		return 0, 0, err
+	}
+	if isRemoteWriteV2(version) && len(wr.Timeseries) == 0 {
+		return 0, 0, nil
+	}
+
+	uncompressed, release, err := marshalFor(format, version, wr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: %w", ErrMarshal, err)
+	}
+	defer release()
+
+	if len(uncompressed) < w.compressionMinBytes {
+		encoding = None
+	}
+
+	compressed, err := encoding.Compress(uncompressed)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: %w", ErrCompress, err)
+	}
+
+	return len(uncompressed), len(compressed), nil
+}
+
+// validateSize reports a request to the target at url that would be larger than MaxPayloadBytes
+func (w *writerImpl) validateSize(wr prompb.WriteRequest, url string) error {
+	_, compressed, err := w.encodedSize(wr, url)
+	if err != nil {
+		return err
+	}
+
+	if compressed > w.maxPayloadBytes {
+		return fmt.Errorf("%w: request to %s is %d bytes, more than the %d allowed", ErrInvalidMetric, url,
+			compressed, w.maxPayloadBytes)
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package writer_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+
+	"github.com/jghiloni/prometheus-remote-write/receiver"
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+var _ = Describe("Validate", func() {
+	var s *httptest.Server
+	var pushes atomic.Int64
+
+	BeforeEach(func() {
+		pushes.Store(0)
+		s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pushes.Add(1)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	})
+
+	AfterEach(func() {
+		s.Close()
+	})
+
+	It("Finds nothing wrong with a healthy registry", func() {
+		registry := prometheus.NewRegistry()
+		c := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests_total"}, []string{"code"})
+		registry.MustRegister(c)
+		c.WithLabelValues("200").Inc()
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{HTTPClient: s.Client()}, registry)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(inspect(w).Validate(context.Background())).Should(Succeed())
+		Expect(pushes.Load()).Should(BeZero())
+	})
+
+	It("Reports every problem without sending", func() {
+		broken := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			return []*dto.MetricFamily{
+				{
+					Name:   proto.String("bad-name"),
+					Type:   dto.MetricType_GAUGE.Enum(),
+					Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(1)}}},
+				},
+				{
+					Name:   proto.String("mismatched_total"),
+					Type:   dto.MetricType_COUNTER.Enum(),
+					Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(1)}}},
+				},
+			}, nil
+		})
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{HTTPClient: s.Client()}, broken)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		err = inspect(w).Validate(context.Background())
+		Expect(err).Should(MatchError(writer.ErrInvalidMetric))
+		Expect(err).Should(MatchError(receiver.ErrInvalidMetricName))
+		Expect(err).Should(MatchError(writer.ErrGather))
+		Expect(err.Error()).Should(ContainSubstring(`"mismatched_total"`))
+		Expect(pushes.Load()).Should(BeZero())
+	})
+
+	It("Reports payloads larger than MaxPayloadBytes", func() {
+		registry := prometheus.NewRegistry()
+		g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "wide"}, []string{"value"})
+		registry.MustRegister(g)
+		for i := range 100 {
+			g.WithLabelValues(strings.Repeat("x", i)).Set(1)
+		}
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:      s.Client(),
+			MaxPayloadBytes: 1024,
+		}, registry)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		err = inspect(w).Validate(context.Background())
+		Expect(errors.Is(err, writer.ErrInvalidMetric)).Should(BeTrue())
+		Expect(err.Error()).Should(ContainSubstring("more than the 1024 allowed"))
+	})
+
+	It("Estimates the size of a push without sending", func() {
+		registry := prometheus.NewRegistry()
+		c := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests_total", Help: "Requests."}, []string{"code"})
+		registry.MustRegister(c)
+		c.WithLabelValues("200").Inc()
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{HTTPClient: s.Client()}, registry)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		series, size, err := inspect(w).EstimateSize(context.Background())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(series).Should(Equal(1))
+		Expect(size).Should(BeNumerically(">", 0))
+
+		c.WithLabelValues("500").Inc()
+		series, larger, err := inspect(w).EstimateSize(context.Background())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(series).Should(Equal(2))
+		Expect(larger).Should(BeNumerically(">", size))
+
+		Expect(pushes.Load()).Should(BeZero())
+	})
+
+	It("Checks the requests a push would make rather than what was gathered", func() {
+		registry := prometheus.NewRegistry()
+		for _, name := range []string{"apples", "bananas", "cherries"} {
+			registry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: name, Help: strings.Repeat("h", 1000)}))
+		}
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:       s.Client(),
+			MaxSeriesPerPush: 2,
+			StripHelp:        true,
+		}, registry)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		err = inspect(w).Validate(context.Background())
+		Expect(err).Should(MatchError(writer.ErrSeriesLimitExceeded))
+
+		// two of the three gauges, with the help text stripped
+		series, size, err := inspect(w).EstimateSize(context.Background())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(series).Should(Equal(2))
+		Expect(size).Should(BeNumerically("<", 1000))
+		Expect(pushes.Load()).Should(BeZero())
+	})
+})
@@ -3,27 +3,187 @@ package writer
 import (
 	"context"
 	"errors"
+	"log/slog"
+	"maps"
+	"net"
 	"net/http"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/jghiloni/prometheus-remote-write/convert"
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/prompb"
 )
 
-const DefaultRemoteWriteVersion = "0.1.0"
+const (
+	DefaultRemoteWriteVersion = "0.1.0"
+	DefaultMinBackoff         = 30 * time.Millisecond
+	DefaultMaxBackoff         = 5 * time.Second
+	DefaultTenantHeader       = "X-Scope-OrgID"
+	DefaultTransportTimeout   = 30 * time.Second
+)
 
 // RemoteMetricsWriter knows how to marshal a set of metrics and send them to a remote
-// prometheus endpoint
+// prometheus endpoint. It is safe for concurrent use.
+//
+// Besides WriteMetrics, which sends what the writer's gatherers collect, a RemoteMetricsWriter sends metric families
+// gathered or parsed elsewhere with WriteMetricFamilies, and series already converted (by the convert package, say)
+// with WriteTimeSeries. Those two methods were added after WriteMetrics, so types outside this module that implement
+// RemoteMetricsWriter, such as test doubles and middleware, have to add them too; middleware can pass them to the
+// writer it wraps
 type RemoteMetricsWriter interface {
 	WriteMetrics(context.Context) (int, error)
+	WriteMetricFamilies(context.Context, []*dto.MetricFamily) (int, error)
+	WriteTimeSeries(context.Context, []prompb.TimeSeries, []prompb.MetricMetadata) (int, error)
+}
+
+// Inspector looks at a writer without pushing through it. The writers NewRemoteMetricsWriter returns implement it,
+// while RemoteMetricsWriter stays limited to pushing, so that other implementations do not have to. Use AsInspector
+// to reach it from a RemoteMetricsWriter
+type Inspector interface {
+	TargetStats() []TargetStats
+	Validate(context.Context) error
+	EstimateSize(context.Context) (series int, uncompressedBytes int, err error)
+}
+
+// AsInspector returns w as an Inspector if it implements one. Otherwise, if w has an Unwrap() RemoteMetricsWriter
+// method, as middleware should, it looks through the writer that returns, and so on down the chain
+func AsInspector(w RemoteMetricsWriter) (Inspector, bool) {
+	for w != nil {
+		if i, ok := w.(Inspector); ok {
+			return i, true
+		}
+
+		u, ok := w.(interface{ Unwrap() RemoteMetricsWriter })
+		if !ok {
+			return nil, false
+		}
+		w = u.Unwrap()
+	}
+
+	return nil, false
 }
 
 type writerImpl struct {
-	hc        *http.Client
-	targetURL string
-	gatherers prometheus.Gatherers
-	format    Format
-	encoding  Compression
-	version   string
+	hc         *http.Client
+	targetURL  string
+	gatherers  prometheus.Gatherers
+	format     Format
+	encoding   Compression
+	version    string
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	credentials credentials
+
+	compressionMinBytes     int
+	identityContentEncoding bool
+	maxPayloadBytes         int
+	minSplitSamples         int
+
+	retryOnConflict bool
+	retryBudget     *RetryBudget
+	maxRetryElapsed time.Duration
+	retryMode       RetryMode
+	onThrottle      func(ThrottleSignal)
+	onPayload       func(PayloadStats)
+	onGather        func([]GatherResult)
+	gatherTimeout   time.Duration
+	sendTimeout     time.Duration
+	tenant          string
+	tenantHeader    string
+	tenantLabel     string
+	routes          []route
+	headers         http.Header
+	ctxHeaders      func(context.Context) http.Header
+	signer          RequestSigner
+
+	sendMetadata       bool
+	metadataInterval   time.Duration
+	maxMetadataPerSend int
+	stripHelp          bool
+	maxHelpLength      int
+
+	mu               sync.Mutex
+	lastMetadataSend time.Time
+	// sampleLimits holds the sample limit of each target URL that has rejected a request with 413
+	sampleLimits map[string]*sampleLimit
+
+	// tracker carries what conversions need to know about earlier pushes, such as the counts behind the reset hints
+	// of native histograms, from one WriteMetrics call to the next. interner shares label strings across them too
+	tracker               *convert.SeriesTracker
+	interner              *convert.Interner
+	createdTimestampZeros bool
+	missingExemplarTS     convert.ExemplarTimestampPolicy
+	timestampFunc         func(*dto.MetricFamily, *dto.Metric) int64
+	dropZeroCounters      bool
+	counterResetZeros     bool
+	mergeDuplicateSeries  bool
+	nameLabelPolicy       convert.NameLabelPolicy
+	valueTransforms       map[string]convert.ValueTransform
+	normalizeUnits        bool
+	untypedAsGauge        bool
+	untypedGaugeSuffix    string
+	newSeriesMetadataOnly bool
+
+	// targets holds the writer's own target URL followed by its Targets, until a TargetResolver replaces them
+	targetsMu       sync.RWMutex
+	targets         []*targetState
+	resolver        TargetResolver
+	resolveInterval time.Duration
+	lastResolve     time.Time
+	multiTargetMode MultiTargetMode
+	hedgeDelay      time.Duration
+
+	// haLabels are stamped on every series sent
+	haLabels       []prompb.Label
+	relabelConfigs []*relabel.Config
+
+	resourceAttributes map[string]string
+	heartbeat          bool
+	heartbeatLabels    map[string]string
+
+	sender   Sender
+	fallback Sender
+
+	sampleLimit          int
+	sampleLimitAction    SampleLimitAction
+	duplicateLabelPolicy DuplicateLabelPolicy
+	exemplarLabelPolicy  ExemplarLabelPolicy
+
+	maxSeriesPerPush int
+	seriesPriority   func([]prompb.Label) int
+	familyPriorities map[string]int
+
+	maxExemplarsPerSend int
+	exemplarSampleRate  float64
+	onExemplarsDropped  func(ExemplarDrops)
+
+	memoryBudget       int
+	memoryBudgetAction MemoryBudgetAction
+
+	// throttle keeps pushes MinPushInterval apart; it is nil without one
+	throttle *throttle
+	// gathers collapses overlapping WriteMetrics calls when CollapseConcurrentWrites is set; it is nil otherwise
+	gathers *flight
+
+	slowPushThreshold time.Duration
+	logPushSummaries  bool
+	logger            *slog.Logger
+
+	idempotencyKeyHeader string
+
+	clock Clock
+
+	// archiver copies payloads to Archive; it is nil without one
+	archiver *archiver
+	// mirrors copies payloads to MirrorURL; it is nil without one
+	mirrors *mirror
 }
 
 // Format represents the format to which metrics will be marshalled before sending to Prometheus
@@ -32,8 +192,20 @@ type Format int
 const (
 	// Protobuf serializes to the protobuf description from the Prometheus github repository
 	Protobuf Format = iota + 1
-	// JSON serializes to standard JSON according to the Prometheus objects' JSON tags
+	// JSON serializes to standard JSON according to the Prometheus objects' JSON tags. Its field names are whatever
+	// prompb's Go structs say, so they can change when the Prometheus module is upgraded; use JSONProto for payloads
+	// that other systems parse
 	JSON
+	// JSONProto serializes to the canonical protobuf JSON mapping (lowerCamelCase field names, enum names, 64 bit
+	// integers as strings) with protojson, which other protobuf implementations can read. Format.Unmarshal and the
+	// receiver package both read it back. Fields are written in the order remote.proto declares them and fields left
+	// at their default are omitted; the golden files in writer/testdata/jsonproto pin the encoding, and are decoded
+	// back in the tests, so that it does not change between releases
+	JSONProto
+	// GoProtobuf serializes to the same protobuf wire format as Protobuf, but encodes it with proto.Marshal from the
+	// official google.golang.org/protobuf runtime, using messages generated from the same remote.proto, rather than
+	// the gogo generated code in prompb
+	GoProtobuf
 )
 
 // Compression is the compression algorithm used on the marshalled data before sending
@@ -48,17 +220,344 @@ const (
 	Gzip
 )
 
+// TargetFlavor adjusts how requests are built for backends that have their own remote write conventions
+type TargetFlavor int
+
+const (
+	// Generic sends requests exactly as configured and works with any remote write receiver
+	Generic TargetFlavor = iota
+	// VictoriaMetrics appends /api/v1/write to target URLs without a path, sends ExtraLabels as extra_label query
+	// parameters and only allows the encodings VictoriaMetrics accepts (snappy compressed protobuf)
+	VictoriaMetrics
+	// ThanosReceive appends /api/v1/receive to target URLs without a path, sends Tenant in the THANOS-TENANT header
+	// (unless TenantHeader says otherwise) and retries 409 Conflict responses, which Thanos returns when a hashring
+	// member could not accept the write
+	ThanosReceive
+	// InfluxDB appends /api/v1/prom/write to target URLs without a path and only allows the encodings InfluxDB's
+	// Prometheus remote write compatibility endpoint accepts (snappy compressed protobuf)
+	InfluxDB
+)
+
+// BasicAuth holds the credentials sent with every request using HTTP basic authentication. If PasswordFile is set,
+// the password is read from that file instead, and read again whenever the file changes
+type BasicAuth struct {
+	Username     string
+	Password     string
+	PasswordFile string
+}
+
 // RemoteMetricsWriterOptions are the optional settings for a RemoteMetricsWriter.
 //
-//	If HTTPClient is not set, http.DefaultClient is used
+//	If HTTPClient is not set, http.DefaultClient is used, unless Transport is set
+//	If Transport is set, requests are sent through it by a client the writer builds, which gives up on a request after
+//	DefaultTransportTimeout and does not follow redirects. Transport and HTTPClient cannot both be set
+//	If TLS is set, requests are sent through a transport built from its certificate files, which are reloaded when
+//	they change, by a client like the one built for Transport. TLS cannot be used with HTTPClient or Transport
+//	If Resolver is set, the target's host name is resolved with it rather than the system's resolver, and if
+//	DNSCacheTTL is set, the addresses resolved are reused for that long instead of being resolved again for every new
+//	connection, for frequent pushes where DNS is slow or rate limited
+//	DialTimeout and KeepAlive default to DefaultDialTimeout and DefaultKeepAlive; a negative KeepAlive disables keep-
+//	alive probes. AddressFamily restricts connections to IPv4 or IPv6 addresses, or tries one family's addresses
+//	before the other's, for networks where dual-stack connections to the target time out intermittently
+//	These dialer options are applied to a transport built like the one for TLS (with it, if TLS is set), so none of
+//	them can be used with HTTPClient or Transport
+//	If InstrumentTransport is set, requests are counted and timed with the promhttp client instrumentation (see
+//	InstrumentRoundTripper), with collectors labelled with the target URL registered with it. The writer's HTTP client
+//	(HTTPClient, or the one built for Transport or TLS) is copied with its transport wrapped, never changed in place
 //	If Format is not set, it defaults to Protobuf
-//	If Compression is not set, it defaults to None
+//	If Compression is not set, it defaults to None (or the flavor's preferred compression, if it has one)
+//	If CompressionMinBytes is set, payloads smaller than that many bytes once marshalled are sent uncompressed. Flavors
+//	that only accept snappy compression do not allow it
+//	If IdentityContentEncoding is set, uncompressed payloads are sent with an explicit "Content-Encoding: identity"
+//	header rather than none, for proxies and receivers that treat the two differently
+//	If MaxPayloadBytes is set, Validate reports requests that would be larger than that once encoded
 //	If RemoteWriteVersion is not set, it defaults to DefaultRemoteWriteVersion (0.1.0, currently). This should never change
+//	A RemoteWriteVersion of 2.x sends remote write 2.0 requests (io.prometheus.write.v2.Request), which carry each
+//	family's metadata on its series rather than in metadata-only requests; it needs the Protobuf or GoProtobuf Format.
+//	Versions other than 0.1.0, 1.x and 2.x are rejected with ErrUnsupportedRemoteWriteVersion
+//	If TargetFlavor is not set, it defaults to Generic
+//	ExtraLabels are only used by flavors that can add labels on the server side (VictoriaMetrics)
+//	If BasicAuth is set, every request is sent with its credentials
+//	If BearerToken or BearerTokenFile is set, every request is sent with that token in an Authorization: Bearer header
+//	If TokenProvider is set, every request is sent with the token it returns in an Authorization: Bearer header, for
+//	tokens from Vault, workload identity and the like. It cannot be used with BearerToken or BearerTokenFile
+//	HeaderFiles maps header names to files holding their values, such as API keys
+//	Credential files are read when the writer is created and again whenever they change, so they can be rotated
+//	Requests rejected with 413 Payload Too Large are split in half by samples and sent again, and later requests to
+//	the same target URL are split to the smaller size up front. Series carrying several samples are split across
+//	requests if need be, and requests carrying only metadata are split in half by metadata entries. Requests of fewer
+//	than MinSplitSamples samples (default DefaultMinSplitSamples) are not split; their 413 is returned as is. Once
+//	100 pushes in a row have been split and accepted, the learned size is doubled, so a target that takes larger
+//	requests again gets them
+//	If MaxRetries is not set, or is negative, failed requests are not retried. Network errors, 429 and 5xx responses
+//	are retried up to MaxRetries times, waiting MinBackoff (default DefaultMinBackoff) before the first retry and
+//	doubling the wait each time up to MaxBackoff (default DefaultMaxBackoff). If RetryOnConflict is set, 409 responses are retried as well
+//	If RetryBudget is set, a failed request is only retried while retries stay within the budget
+//	If MaxRetryElapsedTime is set, a failed request is not retried once the retry would start more than that long after
+//	the first attempt, which bounds the time a push spends retrying however high MaxRetries and MaxBackoff are
+//	If RetryMode is RetryNetworkErrors, only requests that got no response at all are retried, never ones the target
+//	answered, for receivers that are not idempotent across retries
+//	If OnThrottle is set, it is called with a ThrottleSignal every time the target answers with 429 Too Many Requests
+//	(or 503 Service Unavailable with a Retry-After header), including attempts that are retried, so the application can
+//	slow down. The signal is also on the *StatusError a failed push returns
+//	If OnPayload is set, it is called with the PayloadStats (series, samples, size before and after compression, and
+//	network timings) of every request the target accepts. PayloadMetrics turns them into metrics. TargetStats keeps
+//	running totals too
+//	If OnGather is set, it is called by every WriteMetrics call with a GatherResult for each gatherer: the families and
+//	metrics it returned and its error. Gatherers that fail are also logged to Logger, and the error WriteMetrics
+//	returns joins a *GatherError for each of them, so a failure can be traced to its gatherer. NamedGatherer gives a
+//	gatherer a name to use instead of its index
+//	If GatherTimeout is set, WriteMetrics stops waiting for its gatherers after that long and fails with an error
+//	wrapping ErrGather and ErrGatherTimeout, so a Collector stuck in Collect is told apart from a slow target. The
+//	stuck Collect cannot be interrupted, and is left to finish on its own
+//	If SendTimeout is set, each request to the target (each attempt, when retrying) is abandoned after that long with
+//	an error wrapping ErrSend and ErrSendTimeout, which is retried like any other network error
+//	Headers are added to every request, after all other headers are set
+//	If HeadersFromContext is set, it is called with the context of every push and the headers it returns are added to
+//	that push's requests after Headers, so values like request IDs can be passed per push
+//	If Signer is set, it signs every request after Headers are added, for example with an HMACSigner
+//	If Tenant is set, it is sent in the TenantHeader header, which defaults to the flavor's tenant header (X-Scope-OrgID
+//	for Generic)
+//	If TenantLabel is set, series are grouped by the value of that label, which is removed from them, and each group is
+//	sent in its own request with the value as its tenant. Series without the label are sent with Tenant
+//	Routes send the series they match to other target URLs; each series goes to the first route it matches, and series
+//	that match no route go to the writer's target URL
+//	If SendMetadata points to false, metadata is never sent, for older receivers that reject requests carrying it. It
+//	is sent when SendMetadata is nil
+//	If MetadataSendInterval is set, metadata is no longer bundled into every push. Instead, a push made at least
+//	MetadataSendInterval after the last successful metadata push also sends the metadata on its own, in requests of at
+//	most MaxMetadataPerSend (default DefaultMaxMetadataPerSend) entries. Without MetadataSendInterval, a push carrying
+//	more than MaxMetadataPerSend entries sends its metadata the same way, so a registry with a huge number of families
+//	does not push one oversized request. With Routes or a TenantLabel, each route and tenant is sent the metadata of
+//	its own series only, whether bundled or on its own
+//	If StripHelp is set, metadata is sent without its help text, which makes metadata-heavy pushes much smaller for
+//	backends that do not show it anyway
+//	If MaxHelpLength is set, longer help text is truncated to that many characters, for receivers that reject or
+//	poorly store the lengthy documentation some client libraries generate
+//	If CreatedTimestampZeros is set, counters with a created timestamp get an extra zero sample at that timestamp the
+//	first time the writer sends them, so receivers without created timestamp support compute rate() correctly
+//	If NewSeriesMetadataOnly is set, gathered metadata is only sent for families with a series the writer has not sent
+//	before, rather than with every push, which shrinks steady-state pushes considerably
+//	If SeriesCacheTTL is set, the writer forgets series it has not sent for that long, so they count as new again when
+//	they reappear: they get their created timestamp zero sample and their metadata once more. This bounds the memory
+//	the writer uses to remember series, and resends what a failed push may have lost. Series are otherwise remembered
+//	for the lifetime of the writer
+//	MissingExemplarTimestamp decides what happens to exemplars without a timestamp: by default they get the timestamp
+//	of their sample, but they can get the current time or be dropped instead
+//	If TimestampFunc is set, it supplies the timestamp (in milliseconds) of every gathered metric's samples, overriding
+//	the metric's own timestamp and the time of the push, to backfill measurements taken earlier, say. Returning 0 keeps
+//	the timestamp the metric would otherwise get
+//	If DropZeroCounters is set, counters that have never been incremented are not sent, which keeps large metric vectors
+//	whose children mostly never fire from bloating every push
+//	If CounterResetZeros is set, a counter that went down since the previous push is sent with an explicit zero sample
+//	before its new value, for backends that mishandle resets that happen between pushes. Native histograms always
+//	carry reset hints
+//	If MergeDuplicateSeries is set, gathered metrics with identical label sets (from overlapping gatherers, say) are
+//	sent as one series with all of their samples instead of as duplicate series
+//	NameLabelPolicy decides what happens to gathered metrics that carry their own __name__ label: by default the
+//	family's name replaces it, but it can be used as the series' name instead, or the push can fail with
+//	convert.ErrNameLabel
+//	ValueTransforms maps family names to a convert.ValueTransform (convert.Scale or convert.Clamp, say) applied to the
+//	values of their gathered metrics, for light corrections without changing the instrumented code
+//	NormalizeUnits converts gathered families named in a non-base unit (milliseconds, kilobytes) to the base unit
+//	(seconds, bytes) with convert.NormalizeUnits, renaming them and scaling their values. ValueTransforms are then
+//	looked up by the new names
+//	If UntypedAsGauge is set, gathered untyped families are sent with GAUGE metadata instead of UNKNOWN, which some
+//	backends handle better, and renamed with UntypedGaugeSuffix appended to their names if it is set
+//	If Targets are set, series sent to the writer's target URL are also sent to each of them (FanOut, the default
+//	MultiTargetMode), or to whichever of them (or the writer's target URL) has been answering fastest, hedged by the
+//	next best after HedgeDelay (default DefaultHedgeDelay) when MultiTargetMode is Hedge, or divided between them by
+//	a hash of their labels when MultiTargetMode is Partition. A Target with its own Compression, Format or
+//	RemoteWriteVersion is sent requests encoded with those instead of the writer's
+//	If TargetResolver is set, it is asked for the targets every ResolveInterval (default DefaultResolveInterval), at
+//	the next push. The targets it returns replace the writer's target URL and Targets as the places series sent to
+//	the writer's target URL go. If it fails or returns no targets, a warning is logged, the writer keeps the targets it
+//	has, and the resolver is asked again at the next push rather than after another ResolveInterval
+//	If HACluster or HAReplica is set, every series is sent with a cluster label (named HAClusterLabel, default
+//	DefaultHAClusterLabel) or replica label (named HAReplicaLabel, default DefaultHAReplicaLabel) with that value, so
+//	that Mimir and Cortex can deduplicate series pushed by several replicas of the same agent
+//	If ResourceAttributes are set, every WriteMetrics and WriteMetricFamilies push also sends a target_info series
+//	carrying them (see convert.TargetInfo), following the OpenTelemetry to Prometheus convention for resource
+//	attributes. Pushes of pre-built series through WriteTimeSeries do not get one
+//	If Heartbeat is set, every WriteMetrics and WriteMetricFamilies push also sends a remote_write_client_up series
+//	(HeartbeatName) with a value of 1 and HeartbeatLabels as its labels, even when there are no metrics to push, so
+//	backends can alert when an agent stops reporting
+//	If Sender is set, payloads are handed to it instead of being posted to the target URL
+//	If FallbackSender is set, payloads that could not be delivered (after retries) are handed to it, for example a
+//	FileSender to capture pushes while the target is down. A push the fallback accepts is reported as successful
+//	If MinPushInterval is set, pushes are kept at least that far apart, however often the writer is called. A push made
+//	too soon fails with ErrThrottled, or, if ThrottleMode is ThrottleCoalesce, is held and merged with any other early
+//	pushes into one push made once the interval has passed
+//	RelabelConfigs rewrite or drop series before they are sent, like the write_relabel_configs of Prometheus, and are
+//	applied in order to the labels of each series. A series they drop, or leave with no labels, is not sent and does
+//	not count towards SampleLimit or MaxSeriesPerPush. The HA labels are added afterwards. Configs built in code should
+//	start from relabel.DefaultRelabelConfig, as those read from YAML do
+//	If SampleLimit is set, a push with more samples (counting native histograms as one each) than that is rejected with
+//	a *SampleLimitExceededError, or, if SampleLimitAction is SampleLimitTruncate, sent with only the families of highest
+//	priority that fit, along with a *SampleLimitExceededError listing the labels of the series dropped. This keeps a
+//	cardinality explosion in the instrumented application from reaching a shared backend
+//	If MaxSeriesPerPush is set, a push with more series than that is sent with only the families of highest priority
+//	that fit within that many series, along with a *SeriesLimitExceededError listing the labels of the series dropped.
+//	Both limits keep or drop a family's series (a histogram's _bucket, _sum and _count series, say) together, so a
+//	family is never sent in part; a family too large to fit is skipped for smaller ones of lower priority
+//	SeriesPriority ranks series when SampleLimit or MaxSeriesPerPush truncates a push, so that low priority series
+//	(debug metrics, say) are shed before critical ones, and a family ranks as its highest priority series. If it is not
+//	set, a series has its family's priority in FamilyPriorities, or 0 if its family is not there. Families of equal
+//	priority are kept in the order they were pushed.
+//	A QueueManager given the same FamilyPriorities sheds low priority series under pressure too
+//	DuplicateLabelPolicy decides what happens to series with the same label name more than once, which can happen
+//	when const labels collide with dynamic ones. By default only the first of those labels is kept, but the series
+//	can be dropped or the push failed with ErrDuplicateLabel instead
+//	ExemplarLabelPolicy decides what happens to exemplars whose labels are longer than MaxExemplarLabelLength
+//	characters combined, which receivers reject. By default they are dropped, but their labels can be truncated instead
+//	If ExemplarSampleRate is set (between 0 and 1), only that fraction of exemplars is sent. Whether an exemplar is
+//	sent depends only on its series, labels and timestamp, so the same exemplar is kept or dropped every time
+//	If MaxExemplarsPerSend is set, a push sends at most that many exemplars, keeping the first in the order of series
+//	If OnExemplarsDropped is set, it is called with the ExemplarDrops of every push that left exemplars out
+//	If MemoryBudget is set, a request whose marshalled size (what encoding it allocates at once) would exceed that many
+//	bytes is split into smaller requests that fit, so a large push cannot exhaust the memory of a small container.
+//	With a MemoryBudgetAction of MemoryBudgetError, the push fails with a *MemoryBudgetExceededError instead
+//	If CollapseConcurrentWrites is set, a WriteMetrics call made while another is in progress does not gather and push
+//	again, but waits for the push in progress and returns its result, so overlapping calls do not send duplicate
+//	payloads
+//	If SlowPushThreshold is set, a push that takes at least that long is logged as a warning to Logger (slog.Default()
+//	if it is not set), with how long it spent gathering, converting, marshalling, compressing and sending
+//	If LogPushSummaries is set, every push is logged to Logger in a single line, at info level (or as a warning if it
+//	failed), with the series and samples it sent, their compressed size, its duration, the status code of the last
+//	response and how many times requests were retried, for an audit trail without debug logging
+//	If IdempotencyKeyHeader is set (to Idempotency-Key, say), every request is sent with a random key in that header.
+//	Retries of a request carry the same key, so receivers and gateways that deduplicate on it can discard deliveries
+//	that were retried after the first one had in fact succeeded
+//	If Clock is set, the writer takes the time from it and waits on it instead of the system clock, so tests can make
+//	timestamps deterministic and step through backoff and scheduling without sleeping (see writertest.FakeClock)
+//	If Archive is set, every payload is copied to it once its push is over, for audit and later replay: the payloads
+//	the target accepted under sent/ and the ones that could not be sent under failed/, in the file format of a
+//	FileSender. ArchiveMode limits this to either kind. A payload that could not be archived is logged to Logger, and
+//	never fails the push
+//	If MirrorURL is set, every payload is also posted once to it in the background, for a local inspector or a staging
+//	receiver, say. Mirrored requests carry the format, compression, version and tenant headers but none of the
+//	credentials, Headers or signature meant for the target. They are not retried, and their failures are only logged
+//	to Logger at debug level, so the mirror never changes the result or the latency of a push
 type RemoteMetricsWriterOptions struct {
 	HTTPClient         *http.Client
+	Transport          http.RoundTripper
+	TLS                *TLSConfig
+	Resolver           *net.Resolver
+	DNSCacheTTL        time.Duration
+	DialTimeout        time.Duration
+	KeepAlive          time.Duration
+	AddressFamily      AddressFamily
 	Format             Format
 	Compression        Compression
 	RemoteWriteVersion string
+	TargetFlavor       TargetFlavor
+	ExtraLabels        map[string]string
+	BasicAuth          *BasicAuth
+	BearerToken        string
+	BearerTokenFile    string
+	TokenProvider      TokenProvider
+	HeaderFiles        map[string]string
+	MaxRetries         int
+	MinBackoff         time.Duration
+	MaxBackoff         time.Duration
+	RetryOnConflict    bool
+	RetryBudget        *RetryBudget
+	OnThrottle         func(ThrottleSignal)
+	OnPayload          func(PayloadStats)
+	OnGather           func([]GatherResult)
+	Tenant             string
+	TenantHeader       string
+	TenantLabel        string
+	Routes             []Route
+	Headers            http.Header
+	HeadersFromContext func(context.Context) http.Header
+	Signer             RequestSigner
+
+	InstrumentTransport prometheus.Registerer
+	MaxRetryElapsedTime time.Duration
+	RetryMode           RetryMode
+
+	GatherTimeout time.Duration
+	SendTimeout   time.Duration
+
+	CompressionMinBytes     int
+	IdentityContentEncoding bool
+	MaxPayloadBytes         int
+	MinSplitSamples         int
+
+	SendMetadata         *bool
+	MetadataSendInterval time.Duration
+	MaxMetadataPerSend   int
+	StripHelp            bool
+	MaxHelpLength        int
+
+	CreatedTimestampZeros    bool
+	MissingExemplarTimestamp convert.ExemplarTimestampPolicy
+	TimestampFunc            func(family *dto.MetricFamily, m *dto.Metric) int64
+	DropZeroCounters         bool
+	CounterResetZeros        bool
+	MergeDuplicateSeries     bool
+	NameLabelPolicy          convert.NameLabelPolicy
+	ValueTransforms          map[string]convert.ValueTransform
+	NormalizeUnits           bool
+	UntypedAsGauge           bool
+	UntypedGaugeSuffix       string
+	NewSeriesMetadataOnly    bool
+	SeriesCacheTTL           time.Duration
+
+	Targets         []Target
+	TargetResolver  TargetResolver
+	ResolveInterval time.Duration
+	MultiTargetMode MultiTargetMode
+	HedgeDelay      time.Duration
+
+	HACluster      string
+	HAClusterLabel string
+	HAReplica      string
+	HAReplicaLabel string
+
+	ResourceAttributes map[string]string
+	Heartbeat          bool
+	HeartbeatLabels    map[string]string
+
+	Sender         Sender
+	FallbackSender Sender
+
+	MinPushInterval time.Duration
+	ThrottleMode    ThrottleMode
+
+	RelabelConfigs []*relabel.Config
+
+	SampleLimit          int
+	SampleLimitAction    SampleLimitAction
+	DuplicateLabelPolicy DuplicateLabelPolicy
+	ExemplarLabelPolicy  ExemplarLabelPolicy
+
+	MaxSeriesPerPush int
+	SeriesPriority   func(labels []prompb.Label) int
+	FamilyPriorities map[string]int
+
+	MaxExemplarsPerSend int
+	ExemplarSampleRate  float64
+	OnExemplarsDropped  func(ExemplarDrops)
+
+	MemoryBudget       int
+	MemoryBudgetAction MemoryBudgetAction
+
+	CollapseConcurrentWrites bool
+
+	SlowPushThreshold time.Duration
+	LogPushSummaries  bool
+	Logger            *slog.Logger
+
+	IdempotencyKeyHeader string
+
+	Clock Clock
+
+	Archive     BlobStore
+	ArchiveMode ArchiveMode
+
+	MirrorURL string
 }
 
 // NewRemoteMetricsWriter attempts to create and return a new RemoteMetricsWriter, and will do so unless targetURL is
@@ -68,6 +567,46 @@ func NewRemoteMetricsWriter(targetURL string, options RemoteMetricsWriterOptions
 		return nil, errors.New("options.TargetURL must be set")
 	}
 
+	var dial dialFunc
+	if usesDialer(options) {
+		if options.HTTPClient != nil || options.Transport != nil {
+			return nil, errors.New("the dialer options cannot be used with options.HTTPClient or options.Transport")
+		}
+
+		dial = newDialer(options).DialContext
+	}
+
+	if options.TLS != nil {
+		if options.HTTPClient != nil || options.Transport != nil {
+			return nil, errors.New("options.TLS cannot be used with options.HTTPClient or options.Transport")
+		}
+
+		transport, err := newTLSTransport(*options.TLS, dial, options.Logger)
+		if err != nil {
+			return nil, err
+		}
+		options.Transport = transport
+	} else if dial != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = dial
+		options.Transport = transport
+	}
+
+	if options.Transport != nil {
+		if options.HTTPClient != nil {
+			return nil, errors.New("options.HTTPClient and options.Transport cannot both be set")
+		}
+
+		options.HTTPClient = &http.Client{
+			Transport: options.Transport,
+			Timeout:   DefaultTransportTimeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				// a redirected POST loses its body, so the redirect itself is returned as the response
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+
 	if options.HTTPClient == nil {
 		options.HTTPClient = http.DefaultClient
 	}
@@ -84,12 +623,200 @@ func NewRemoteMetricsWriter(targetURL string, options RemoteMetricsWriterOptions
 		options.RemoteWriteVersion = DefaultRemoteWriteVersion
 	}
 
+	if options.MinBackoff <= 0 {
+		options.MinBackoff = DefaultMinBackoff
+	}
+
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = DefaultMaxBackoff
+	}
+
+	if options.MaxBackoff < options.MinBackoff {
+		options.MaxBackoff = options.MinBackoff
+	}
+
+	targetURL, err := options.TargetFlavor.apply(targetURL, &options)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.InstrumentTransport != nil {
+		transport, err := InstrumentRoundTripper(options.HTTPClient.Transport, options.InstrumentTransport,
+			prometheus.Labels{"url": targetURL})
+		if err != nil {
+			return nil, err
+		}
+
+		client := *options.HTTPClient
+		client.Transport = transport
+		options.HTTPClient = &client
+	}
+
+	if strings.TrimSpace(options.TenantHeader) == "" {
+		options.TenantHeader = DefaultTenantHeader
+	}
+
+	if strings.TrimSpace(options.HAClusterLabel) == "" {
+		options.HAClusterLabel = DefaultHAClusterLabel
+	}
+
+	if strings.TrimSpace(options.HAReplicaLabel) == "" {
+		options.HAReplicaLabel = DefaultHAReplicaLabel
+	}
+
+	if options.MaxRetries < 0 {
+		options.MaxRetries = 0
+	}
+
+	if options.MinSplitSamples <= 0 {
+		options.MinSplitSamples = DefaultMinSplitSamples
+	}
+
+	if options.MaxMetadataPerSend <= 0 {
+		options.MaxMetadataPerSend = DefaultMaxMetadataPerSend
+	}
+
+	if options.HedgeDelay <= 0 {
+		options.HedgeDelay = DefaultHedgeDelay
+	}
+
+	if options.ResolveInterval <= 0 {
+		options.ResolveInterval = DefaultResolveInterval
+	}
+
+	if options.Clock == nil {
+		options.Clock = systemClock{}
+	}
+
+	routes, err := compileRoutes(options.Routes)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkRelabelConfigs(options.RelabelConfigs); err != nil {
+		return nil, err
+	}
+
+	options.RemoteWriteVersion = strings.TrimSpace(options.RemoteWriteVersion)
+	if err := checkRemoteWriteVersion(options.RemoteWriteVersion, options.Format); err != nil {
+		return nil, err
+	}
+
+	targets, err := newTargetStates(targetURL, options.Targets, options.Format, options.RemoteWriteVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := newCredentials(options)
+	if err != nil {
+		return nil, err
+	}
+
+	var gathers *flight
+	if options.CollapseConcurrentWrites {
+		gathers = &flight{}
+	}
+
 	return &writerImpl{
-		hc:        options.HTTPClient,
-		targetURL: targetURL,
-		gatherers: gatherers,
-		format:    options.Format,
-		encoding:  options.Compression,
-		version:   options.RemoteWriteVersion,
+		hc:         options.HTTPClient,
+		targetURL:  targetURL,
+		gatherers:  gatherers,
+		format:     options.Format,
+		encoding:   options.Compression,
+		version:    options.RemoteWriteVersion,
+		maxRetries: options.MaxRetries,
+		minBackoff: options.MinBackoff,
+		maxBackoff: options.MaxBackoff,
+
+		credentials: credentials,
+
+		compressionMinBytes:     options.CompressionMinBytes,
+		identityContentEncoding: options.IdentityContentEncoding,
+		maxPayloadBytes:         options.MaxPayloadBytes,
+		minSplitSamples:         options.MinSplitSamples,
+
+		retryOnConflict: options.RetryOnConflict,
+		retryBudget:     options.RetryBudget,
+		maxRetryElapsed: options.MaxRetryElapsedTime,
+		retryMode:       options.RetryMode,
+		onThrottle:      options.OnThrottle,
+		onPayload:       options.OnPayload,
+		onGather:        options.OnGather,
+		gatherTimeout:   options.GatherTimeout,
+		sendTimeout:     options.SendTimeout,
+		tenant:          options.Tenant,
+		tenantHeader:    options.TenantHeader,
+		tenantLabel:     options.TenantLabel,
+		routes:          routes,
+		headers:         options.Headers.Clone(),
+		ctxHeaders:      options.HeadersFromContext,
+		signer:          options.Signer,
+
+		sendMetadata:       options.SendMetadata == nil || *options.SendMetadata,
+		metadataInterval:   options.MetadataSendInterval,
+		maxMetadataPerSend: options.MaxMetadataPerSend,
+		stripHelp:          options.StripHelp,
+		maxHelpLength:      options.MaxHelpLength,
+
+		tracker:               convert.NewExpiringSeriesTracker(options.SeriesCacheTTL),
+		interner:              convert.NewInterner(0),
+		createdTimestampZeros: options.CreatedTimestampZeros,
+		missingExemplarTS:     options.MissingExemplarTimestamp,
+		timestampFunc:         options.TimestampFunc,
+		dropZeroCounters:      options.DropZeroCounters,
+		counterResetZeros:     options.CounterResetZeros,
+		mergeDuplicateSeries:  options.MergeDuplicateSeries,
+		nameLabelPolicy:       options.NameLabelPolicy,
+		valueTransforms:       maps.Clone(options.ValueTransforms),
+		normalizeUnits:        options.NormalizeUnits,
+		untypedAsGauge:        options.UntypedAsGauge,
+		untypedGaugeSuffix:    options.UntypedGaugeSuffix,
+		newSeriesMetadataOnly: options.NewSeriesMetadataOnly,
+
+		targets:         targets,
+		resolver:        options.TargetResolver,
+		resolveInterval: options.ResolveInterval,
+		multiTargetMode: options.MultiTargetMode,
+		hedgeDelay:      options.HedgeDelay,
+
+		haLabels:       haLabels(options),
+		relabelConfigs: slices.Clone(options.RelabelConfigs),
+
+		resourceAttributes: maps.Clone(options.ResourceAttributes),
+		heartbeat:          options.Heartbeat,
+		heartbeatLabels:    maps.Clone(options.HeartbeatLabels),
+
+		sender:   options.Sender,
+		fallback: options.FallbackSender,
+
+		sampleLimit:          options.SampleLimit,
+		sampleLimitAction:    options.SampleLimitAction,
+		duplicateLabelPolicy: options.DuplicateLabelPolicy,
+		exemplarLabelPolicy:  options.ExemplarLabelPolicy,
+
+		maxSeriesPerPush: options.MaxSeriesPerPush,
+		seriesPriority:   options.SeriesPriority,
+		familyPriorities: maps.Clone(options.FamilyPriorities),
+
+		maxExemplarsPerSend: options.MaxExemplarsPerSend,
+		exemplarSampleRate:  options.ExemplarSampleRate,
+		onExemplarsDropped:  options.OnExemplarsDropped,
+
+		memoryBudget:       options.MemoryBudget,
+		memoryBudgetAction: options.MemoryBudgetAction,
+
+		throttle: newThrottle(options.MinPushInterval, options.ThrottleMode, options.Clock),
+		gathers:  gathers,
+
+		slowPushThreshold: options.SlowPushThreshold,
+		logPushSummaries:  options.LogPushSummaries,
+		logger:            options.Logger,
+
+		idempotencyKeyHeader: strings.TrimSpace(options.IdempotencyKeyHeader),
+
+		clock: options.Clock,
+
+		archiver: newArchiver(options.Archive, options.ArchiveMode),
+		mirrors:  newMirror(strings.TrimSpace(options.MirrorURL)),
 	}, nil
 }
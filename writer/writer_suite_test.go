@@ -3,6 +3,8 @@ package writer_test
 import (
 	"testing"
 
+	"github.com/jghiloni/prometheus-remote-write/writer"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 )
@@ -11,3 +13,11 @@ func TestWriter(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Writer Suite")
 }
+
+// inspect returns the Inspector of a writer made by NewRemoteMetricsWriter
+func inspect(w writer.RemoteMetricsWriter) writer.Inspector {
+	GinkgoHelper()
+	inspector, ok := writer.AsInspector(w)
+	Expect(ok).Should(BeTrue())
+	return inspector
+}
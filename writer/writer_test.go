@@ -8,9 +8,12 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/golang/snappy"
+	"github.com/jghiloni/prometheus-remote-write/convert"
 	"github.com/jghiloni/prometheus-remote-write/writer"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -55,6 +58,8 @@ func receiveMetrics(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+	default:
+		decoded = encoded
 	}
 
 	ct := r.Header.Get("Content-Type")
@@ -168,7 +173,398 @@ var _ = Describe("Writer", func() {
 
 		tsWritten, err := w.WriteMetrics(context.Background())
 		Expect(err).ShouldNot(HaveOccurred())
-		Expect(tsWritten).Should(Equal(3))
+		// one counter, one gauge, and eight buckets plus _sum and _count for the histogram
+		Expect(tsWritten).Should(Equal(12))
 
 	})
+
+	It("Sends pre-built series", func() {
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient: s.Client(),
+		}, prometheus.NewRegistry())
+		Expect(err).ShouldNot(HaveOccurred())
+
+		tsWritten, err := w.WriteTimeSeries(context.Background(), []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "prebuilt"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+		}}, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(tsWritten).Should(Equal(1))
+
+		tsWritten, err = w.WriteTimeSeries(context.Background(), nil, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(tsWritten).Should(BeZero())
+	})
+
+	It("Sends parsed exposition text", func() {
+		families, err := convert.ParseText(strings.NewReader(`# HELP textfile_up Whether the textfile was written
+# TYPE textfile_up gauge
+textfile_up{job="cron"} 1
+`))
+		Expect(err).ShouldNot(HaveOccurred())
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient: s.Client(),
+		}, prometheus.NewRegistry())
+		Expect(err).ShouldNot(HaveOccurred())
+
+		tsWritten, err := w.WriteMetricFamilies(context.Background(), families)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(tsWritten).Should(Equal(1))
+	})
+
+	It("Sends small payloads uncompressed", func() {
+		var encodings []string
+		cs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encodings = append(encodings, r.Header.Get("Content-Encoding"))
+			receiveMetrics(w, r)
+		}))
+		defer cs.Close()
+
+		w, err := writer.NewRemoteMetricsWriter(cs.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:          cs.Client(),
+			Compression:         writer.Snappy,
+			CompressionMinBytes: 1024,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		small := []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "small"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+		}}
+		_, err = w.WriteTimeSeries(context.Background(), small, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		large := make([]prompb.TimeSeries, 100)
+		for i := range large {
+			large[i] = prompb.TimeSeries{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "large"}, {Name: "i", Value: strconv.Itoa(i)}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+			}
+		}
+		_, err = w.WriteTimeSeries(context.Background(), large, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(encodings).Should(Equal([]string{"", "snappy"}))
+	})
+
+	It("Marks uncompressed payloads with an identity encoding when asked", func() {
+		var encodings []string
+		cs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encodings = append(encodings, r.Header.Get("Content-Encoding"))
+			receiveMetrics(w, r)
+		}))
+		defer cs.Close()
+
+		series := []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "identity"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+		}}
+		for _, compression := range []writer.Compression{writer.None, writer.Snappy} {
+			w, err := writer.NewRemoteMetricsWriter(cs.URL, writer.RemoteMetricsWriterOptions{
+				HTTPClient:              cs.Client(),
+				Compression:             compression,
+				IdentityContentEncoding: true,
+			})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			_, err = w.WriteTimeSeries(context.Background(), series, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+
+		Expect(encodings).Should(Equal([]string{"identity", "snappy"}))
+	})
+
+	It("Keeps payloads intact while reusing marshal buffers", func() {
+		var mu sync.Mutex
+		var received []string
+		cs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			decoded, err := snappy.Decode(nil, body)
+			var wr prompb.WriteRequest
+			if err == nil {
+				err = wr.Unmarshal(decoded)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			mu.Lock()
+			for _, ts := range wr.Timeseries {
+				received = append(received, ts.Labels[0].Value)
+			}
+			mu.Unlock()
+		}))
+		defer cs.Close()
+
+		w, err := writer.NewRemoteMetricsWriter(cs.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:  cs.Client(),
+			Compression: writer.Snappy,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var wg sync.WaitGroup
+		var expected []string
+		for i := range 20 {
+			name := "series_" + strings.Repeat("x", i*10) + strconv.Itoa(i)
+			expected = append(expected, name)
+
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				_, err := w.WriteTimeSeries(context.Background(), []prompb.TimeSeries{{
+					Labels:  []prompb.Label{{Name: "__name__", Value: name}},
+					Samples: []prompb.Sample{{Value: float64(i), Timestamp: 1000}},
+				}}, nil)
+				Expect(err).ShouldNot(HaveOccurred())
+			}()
+		}
+		wg.Wait()
+
+		Expect(received).Should(ConsistOf(expected))
+	})
+
+	It("Sends requests through a supplied transport without following redirects", func() {
+		var paths []string
+		rs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/moved" {
+				http.Redirect(w, r, "/elsewhere", http.StatusFound)
+				return
+			}
+			receiveMetrics(w, r)
+		}))
+		defer rs.Close()
+
+		transport := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			paths = append(paths, r.URL.Path)
+			return rs.Client().Transport.RoundTrip(r)
+		})
+
+		series := []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+		}}
+
+		w, err := writer.NewRemoteMetricsWriter(rs.URL+"/push", writer.RemoteMetricsWriterOptions{Transport: transport})
+		Expect(err).ShouldNot(HaveOccurred())
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		w, err = writer.NewRemoteMetricsWriter(rs.URL+"/moved", writer.RemoteMetricsWriterOptions{Transport: transport})
+		Expect(err).ShouldNot(HaveOccurred())
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).Should(MatchError(ContainSubstring("302")))
+
+		Expect(paths).Should(Equal([]string{"/push", "/moved"}))
+
+		_, err = writer.NewRemoteMetricsWriter(rs.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient: rs.Client(),
+			Transport:  transport,
+		})
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("Adds headers taken from the push's context", func() {
+		type requestIDKey struct{}
+
+		var requestIDs []string
+		hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestIDs = append(requestIDs, r.Header.Get("X-Request-Id"))
+			receiveMetrics(w, r)
+		}))
+		defer hs.Close()
+
+		w, err := writer.NewRemoteMetricsWriter(hs.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient: hs.Client(),
+			HeadersFromContext: func(ctx context.Context) http.Header {
+				id, ok := ctx.Value(requestIDKey{}).(string)
+				if !ok {
+					return nil
+				}
+				return http.Header{"X-Request-Id": []string{id}}
+			},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		series := []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+		}}
+		_, err = w.WriteTimeSeries(context.WithValue(context.Background(), requestIDKey{}, "abc123"), series, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(requestIDs).Should(Equal([]string{"abc123", ""}))
+	})
+
+	It("Sends a target_info series with the resource attributes", func() {
+		var requests []prompb.WriteRequest
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "up"}))
+
+		w, err := writer.NewRemoteMetricsWriter("http://example.invalid/push", writer.RemoteMetricsWriterOptions{
+			ResourceAttributes: map[string]string{"service.name": "checkout", "service.version": "1.2.3"},
+			Sender: senderFunc(func(_ context.Context, p writer.Payload) error {
+				var wr prompb.WriteRequest
+				if err := wr.Unmarshal(p.Body); err != nil {
+					return err
+				}
+				requests = append(requests, wr)
+				return nil
+			}),
+		}, registry)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		n, err := w.WriteMetrics(context.Background())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).Should(Equal(2))
+
+		Expect(requests).Should(HaveLen(1))
+		Expect(requests[0].Timeseries[1].Labels).Should(Equal([]prompb.Label{
+			{Name: "__name__", Value: "target_info"},
+			{Name: "job", Value: "checkout"},
+			{Name: "service_version", Value: "1.2.3"},
+		}))
+		Expect(requests[0].Metadata).Should(ContainElement(HaveField("MetricFamilyName", "target_info")))
+	})
+
+	It("Sends a heartbeat series even when there are no metrics", func() {
+		var requests []prompb.WriteRequest
+		w, err := writer.NewRemoteMetricsWriter("http://example.invalid/push", writer.RemoteMetricsWriterOptions{
+			Heartbeat:       true,
+			HeartbeatLabels: map[string]string{"job": "checkout", "instance": "pod-1"},
+			Sender: senderFunc(func(_ context.Context, p writer.Payload) error {
+				var wr prompb.WriteRequest
+				if err := wr.Unmarshal(p.Body); err != nil {
+					return err
+				}
+				requests = append(requests, wr)
+				return nil
+			}),
+		}, prometheus.NewRegistry())
+		Expect(err).ShouldNot(HaveOccurred())
+
+		n, err := w.WriteMetrics(context.Background())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).Should(Equal(1))
+
+		Expect(requests).Should(HaveLen(1))
+		Expect(requests[0].Timeseries[0].Labels).Should(Equal([]prompb.Label{
+			{Name: "__name__", Value: writer.HeartbeatName},
+			{Name: "instance", Value: "pod-1"},
+			{Name: "job", Value: "checkout"},
+		}))
+		Expect(requests[0].Timeseries[0].Samples[0].Value).Should(BeEquivalentTo(1))
+	})
+
+	It("Abandons a cancelled push before sending it", func() {
+		sent := 0
+		w, err := writer.NewRemoteMetricsWriter("http://example.invalid/push", writer.RemoteMetricsWriterOptions{
+			Sender: senderFunc(func(context.Context, writer.Payload) error {
+				sent++
+				return nil
+			}),
+		}, prometheus.NewRegistry())
+		Expect(err).ShouldNot(HaveOccurred())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		g := prometheus.NewGauge(prometheus.GaugeOpts{Name: "cancelled"})
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(g)
+		families, err := registry.Gather()
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteMetricFamilies(ctx, families)
+		Expect(err).Should(MatchError(context.Canceled))
+
+		_, err = w.WriteTimeSeries(ctx, []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "cancelled"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+		}}, nil)
+		Expect(err).Should(MatchError(context.Canceled))
+
+		Expect(sent).Should(BeZero())
+	})
+
+	It("Sends uncompressed protobuf requests intact", func() {
+		var mu sync.Mutex
+		received := map[string]bool{}
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			var wr prompb.WriteRequest
+			Expect(wr.Unmarshal(body)).Should(Succeed())
+			mu.Lock()
+			received[wr.Timeseries[0].Labels[1].Value] = true
+			mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		DeferCleanup(s.Close)
+
+		var kept []writer.Payload
+		senderWriter, err := writer.NewRemoteMetricsWriter("http://example.invalid/push", writer.RemoteMetricsWriterOptions{
+			Compression: writer.None,
+			Sender: senderFunc(func(_ context.Context, p writer.Payload) error {
+				kept = append(kept, p)
+				return nil
+			}),
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient:  s.Client(),
+			Compression: writer.None,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		push := func(w writer.RemoteMetricsWriter, i int) {
+			_, err := w.WriteTimeSeries(context.Background(), []prompb.TimeSeries{{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "push", Value: strconv.Itoa(i)}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+			}}, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+
+		// each push marshals into a buffer from the pool that the pushes before it gave back
+		var wg sync.WaitGroup
+		for i := range 20 {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				push(w, i)
+			}()
+		}
+		wg.Wait()
+		Expect(received).Should(HaveLen(20))
+
+		for i := range 5 {
+			push(senderWriter, i)
+		}
+		Expect(kept).Should(HaveLen(5))
+		for i, p := range kept {
+			var wr prompb.WriteRequest
+			Expect(wr.Unmarshal(p.Body)).Should(Succeed())
+			Expect(wr.Timeseries[0].Labels[1].Value).Should(Equal(strconv.Itoa(i)))
+		}
+	})
 })
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+type senderFunc func(context.Context, writer.Payload) error
+
+func (f senderFunc) Send(ctx context.Context, p writer.Payload) error {
+	return f(ctx, p)
+}
@@ -0,0 +1,221 @@
+package writertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+)
+
+// Redacted replaces the values of secret headers and URL credentials in recorded exchanges
+const Redacted = "REDACTED"
+
+// DefaultRedactedHeaders are the headers a Recorder always redacts
+var DefaultRedactedHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	"X-Auth-Token",
+}
+
+var (
+	// ErrCassetteExhausted is returned by a Replayer asked for more requests than its cassette holds
+	ErrCassetteExhausted = errors.New("no recorded exchanges left")
+	// ErrRequestMismatch is returned by a Replayer asked for a request other than the next one its cassette holds
+	ErrRequestMismatch = errors.New("request does not match the recorded exchange")
+)
+
+// Exchange is one recorded request and the response it got
+type Exchange struct {
+	Method         string      `json:"method"`
+	URL            string      `json:"url"`
+	RequestHeader  http.Header `json:"requestHeader,omitempty"`
+	RequestBody    []byte      `json:"requestBody,omitempty"`
+	StatusCode     int         `json:"statusCode"`
+	ResponseHeader http.Header `json:"responseHeader,omitempty"`
+	ResponseBody   []byte      `json:"responseBody,omitempty"`
+}
+
+// Cassette is a sequence of recorded exchanges, stored as JSON so recordings can be checked in next to the tests that
+// replay them
+type Cassette struct {
+	Exchanges []Exchange `json:"exchanges"`
+}
+
+// LoadCassette reads a cassette saved with Save
+func LoadCassette(path string) (Cassette, error) {
+	var c Cassette
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+
+	return c, json.Unmarshal(data, &c)
+}
+
+// Save writes the cassette to path
+func (c Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o644)
+}
+
+// Recorder is an http.RoundTripper that sends requests through another one and records every exchange, with secrets
+// redacted, so that a backend's behavior can be captured once and replayed in unit tests with a Replayer. Pass it as
+// the Transport of a writer pointed at the real backend, then save its Cassette
+type Recorder struct {
+	next   http.RoundTripper
+	redact []string
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// NewRecorder returns a Recorder that sends requests through next (http.DefaultTransport if it is nil). The values
+// of DefaultRedactedHeaders, of any redact headers, and of credentials in URLs are replaced with Redacted
+func NewRecorder(next http.RoundTripper, redact ...string) *Recorder {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &Recorder{next: next, redact: append(slices.Clone(DefaultRedactedHeaders), redact...)}
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cassette.Exchanges = append(r.cassette.Exchanges, Exchange{
+		Method:         req.Method,
+		URL:            redactURL(req.URL),
+		RequestHeader:  r.redactHeader(req.Header),
+		RequestBody:    body,
+		StatusCode:     resp.StatusCode,
+		ResponseHeader: r.redactHeader(resp.Header),
+		ResponseBody:   respBody,
+	})
+
+	return resp, nil
+}
+
+// Cassette returns the exchanges recorded so far
+func (r *Recorder) Cassette() Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return Cassette{Exchanges: slices.Clone(r.cassette.Exchanges)}
+}
+
+func (r *Recorder) redactHeader(h http.Header) http.Header {
+	h = h.Clone()
+	for _, name := range r.redact {
+		if values := h.Values(name); len(values) > 0 {
+			h.Set(name, Redacted)
+		}
+	}
+
+	return h
+}
+
+func redactURL(u *url.URL) string {
+	if u.User == nil {
+		return u.String()
+	}
+
+	redacted := *u
+	redacted.User = url.User(Redacted)
+	return redacted.String()
+}
+
+// Replayer is an http.RoundTripper that answers requests with the responses of a cassette, in order, without any
+// network access. Each request must have the method and URL path of the next recorded exchange; the host is ignored,
+// so exchanges recorded against a real backend can be replayed against any URL
+type Replayer struct {
+	mu        sync.Mutex
+	exchanges []Exchange
+	next      int
+}
+
+// NewReplayer returns a Replayer for c
+func NewReplayer(c Cassette) *Replayer {
+	return &Replayer{exchanges: slices.Clone(c.Exchanges)}
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.next >= len(r.exchanges) {
+		return nil, fmt.Errorf("%w: %s %s", ErrCassetteExhausted, req.Method, req.URL.Path)
+	}
+
+	exchange := r.exchanges[r.next]
+	recorded, err := url.Parse(exchange.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Method != exchange.Method || req.URL.Path != recorded.Path {
+		return nil, fmt.Errorf("%w: got %s %s, recorded %s %s", ErrRequestMismatch, req.Method, req.URL.Path,
+			exchange.Method, recorded.Path)
+	}
+	r.next++
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", exchange.StatusCode, http.StatusText(exchange.StatusCode)),
+		StatusCode:    exchange.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        exchange.ResponseHeader.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(exchange.ResponseBody)),
+		ContentLength: int64(len(exchange.ResponseBody)),
+		Request:       req,
+	}, nil
+}
+
+// Remaining returns the number of recorded exchanges that have not been replayed yet
+func (r *Replayer) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.exchanges) - r.next
+}
@@ -0,0 +1,86 @@
+package writertest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	"github.com/jghiloni/prometheus-remote-write/writertest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("Record and replay", func() {
+	series := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+	}}
+
+	It("replays a backend's quirks without the backend", func() {
+		calls := 0
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			// a backend that rejects the first push while it warms up
+			if calls == 1 {
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "warming up", http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+
+		recorder := writertest.NewRecorder(nil)
+		w, err := writer.NewRemoteMetricsWriter(backend.URL+"/api/v1/push", writer.RemoteMetricsWriterOptions{
+			Transport:   recorder,
+			BearerToken: "secret",
+			MaxRetries:  1,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		backend.Close()
+
+		path := filepath.Join(GinkgoT().TempDir(), "cassette.json")
+		Expect(recorder.Cassette().Save(path)).Should(Succeed())
+
+		cassette, err := writertest.LoadCassette(path)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(cassette.Exchanges).Should(HaveLen(2))
+		Expect(cassette.Exchanges[0].StatusCode).Should(Equal(http.StatusServiceUnavailable))
+		Expect(cassette.Exchanges[0].RequestHeader.Get("Authorization")).Should(Equal(writertest.Redacted))
+
+		replayer := writertest.NewReplayer(cassette)
+		w, err = writer.NewRemoteMetricsWriter("http://backend.invalid/api/v1/push", writer.RemoteMetricsWriterOptions{
+			Transport:  replayer,
+			MaxRetries: 1,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(replayer.Remaining()).Should(BeZero())
+
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).Should(MatchError(writertest.ErrCassetteExhausted))
+	})
+
+	It("rejects requests that do not match the recording", func() {
+		replayer := writertest.NewReplayer(writertest.Cassette{Exchanges: []writertest.Exchange{{
+			Method:     http.MethodPost,
+			URL:        "http://backend.invalid/api/v1/push",
+			StatusCode: http.StatusNoContent,
+		}}})
+
+		w, err := writer.NewRemoteMetricsWriter("http://backend.invalid/api/v1/write", writer.RemoteMetricsWriterOptions{
+			Transport: replayer,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = w.WriteTimeSeries(context.Background(), series, nil)
+		Expect(err).Should(MatchError(writertest.ErrRequestMismatch))
+	})
+})
@@ -0,0 +1,79 @@
+package writertest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+)
+
+var _ writer.Clock = (*FakeClock)(nil)
+
+// FakeClock is a writer.Clock whose time only moves when Advance is called, so tests can make the timestamps a
+// writer sends deterministic and step through its backoff and scheduling without sleeping. It is safe for concurrent
+// use
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+// NewFakeClock returns a FakeClock set to now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the clock's current time
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// After returns a channel that receives the clock's time once it has been advanced by d. If d is not positive, the
+// channel receives straight away
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+
+	c.waiters = append(c.waiters, fakeTimer{at: c.now.Add(d), c: ch})
+	return ch
+}
+
+// Advance moves the clock forward by d, firing every After whose time has come
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	pending := c.waiters[:0]
+	for _, t := range c.waiters {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.c <- c.now
+	}
+	c.waiters = pending
+}
+
+// Waiters returns the number of After calls still waiting for the clock to advance, so a test can tell when the code
+// under test is blocked on the clock
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.waiters)
+}
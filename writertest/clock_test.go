@@ -0,0 +1,140 @@
+package writertest_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	"github.com/jghiloni/prometheus-remote-write/writertest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("FakeClock", func() {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	It("fires After only once advanced far enough", func() {
+		clock := writertest.NewFakeClock(start)
+		after := clock.After(time.Minute)
+		Expect(clock.Waiters()).Should(Equal(1))
+
+		clock.Advance(30 * time.Second)
+		Expect(after).ShouldNot(Receive())
+
+		clock.Advance(30 * time.Second)
+		Expect(after).Should(Receive(Equal(start.Add(time.Minute))))
+		Expect(clock.Waiters()).Should(BeZero())
+	})
+
+	It("drives a writer's timestamps and backoff", func() {
+		var attempts atomic.Int32
+		var sent prompb.WriteRequest
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if attempts.Add(1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+
+			body, _ := io.ReadAll(r.Body)
+			Expect(sent.Unmarshal(body)).Should(Succeed())
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer s.Close()
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "queue_depth"}))
+
+		clock := writertest.NewFakeClock(start)
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{
+			HTTPClient: s.Client(),
+			MaxRetries: 2,
+			MinBackoff: time.Hour,
+			MaxBackoff: 2 * time.Hour,
+			Clock:      clock,
+		}, registry)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := w.WriteMetrics(context.Background())
+			done <- err
+		}()
+
+		Eventually(clock.Waiters).Should(Equal(1))
+		clock.Advance(time.Hour)
+		Eventually(clock.Waiters).Should(Equal(1))
+		clock.Advance(2 * time.Hour)
+
+		Eventually(done).Should(Receive(BeNil()))
+		Expect(attempts.Load()).Should(Equal(int32(3)))
+		Expect(sent.Timeseries[0].Samples[0].Timestamp).Should(Equal(start.UnixMilli()))
+	})
+
+	It("drives a Pusher's schedule", func() {
+		var pushes atomic.Int32
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pushes.Add(1)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer s.Close()
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "up"}))
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{HTTPClient: s.Client()}, registry)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		clock := writertest.NewFakeClock(start)
+		pusher := writer.NewPusher(w, writer.PusherOptions{Interval: time.Hour, Clock: clock})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			pusher.Run(ctx)
+		}()
+
+		Eventually(pushes.Load).Should(BeEquivalentTo(1))
+		Consistently(pushes.Load, 50*time.Millisecond).Should(BeEquivalentTo(1))
+
+		clock.Advance(time.Hour)
+		Eventually(pushes.Load).Should(BeEquivalentTo(2))
+
+		cancel()
+		Eventually(done).Should(BeClosed())
+	})
+
+	It("drives a QueueManager's batch deadline", func() {
+		var series atomic.Int32
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			var wr prompb.WriteRequest
+			Expect(wr.Unmarshal(body)).Should(Succeed())
+			series.Add(int32(len(wr.Timeseries)))
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer s.Close()
+
+		w, err := writer.NewRemoteMetricsWriter(s.URL, writer.RemoteMetricsWriterOptions{HTTPClient: s.Client()})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		clock := writertest.NewFakeClock(start)
+		q := writer.NewQueueManager(w, writer.QueueConfig{BatchSendDeadline: time.Minute, Clock: clock})
+		defer q.Stop(context.Background())
+
+		Expect(q.Append(context.Background(), prompb.TimeSeries{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: start.UnixMilli()}},
+		})).Should(Succeed())
+		Consistently(series.Load, 50*time.Millisecond).Should(BeZero())
+
+		clock.Advance(time.Minute)
+		Eventually(series.Load).Should(BeEquivalentTo(1))
+	})
+})
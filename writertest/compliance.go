@@ -0,0 +1,331 @@
+package writertest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jghiloni/prometheus-remote-write/receiver"
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Client sends wr to the remote write target at targetURL the way the implementation under test does. It returns the
+// error the implementation reports, after any retries it makes
+type Client func(ctx context.Context, targetURL string, wr prompb.WriteRequest) error
+
+// WriterClient returns a Client that creates a RemoteMetricsWriter with options for each target URL and sends series
+// with WriteTimeSeries. The remote write specification requires snappy compression and retries, so unless options say
+// otherwise, payloads are snappy compressed and failed requests are retried up to 3 times, 10ms apart
+func WriterClient(options writer.RemoteMetricsWriterOptions) Client {
+	if options.Compression == writer.None {
+		options.Compression = writer.Snappy
+	}
+
+	if options.MaxRetries == 0 {
+		options.MaxRetries = 3
+		options.MinBackoff = 10 * time.Millisecond
+		options.MaxBackoff = 10 * time.Millisecond
+	}
+
+	return func(ctx context.Context, targetURL string, wr prompb.WriteRequest) error {
+		w, err := writer.NewRemoteMetricsWriter(targetURL, options)
+		if err != nil {
+			return err
+		}
+
+		_, err = w.WriteTimeSeries(ctx, wr.Timeseries, wr.Metadata)
+		return err
+	}
+}
+
+// SenderClient returns a Client that delivers payloads through sender, as a writer with options and sender as its
+// Sender would. The writer only retries errors that are not a *writer.StatusError or that carry a retryable status,
+// so a sender must return a *writer.StatusError for HTTP error responses to pass the retry checks
+func SenderClient(sender writer.Sender, options writer.RemoteMetricsWriterOptions) Client {
+	options.Sender = sender
+	return WriterClient(options)
+}
+
+// ComplianceCheck is one of the checks RunCompliance makes. Run returns nil if client behaves as the remote write
+// specification requires
+type ComplianceCheck struct {
+	Name string
+	Run  func(ctx context.Context, client Client) error
+}
+
+// ComplianceResult is the outcome of one ComplianceCheck. Err is nil if the check passed
+type ComplianceResult struct {
+	Name string
+	Err  error
+}
+
+// ComplianceChecks are the checks RunCompliance makes, following the sender requirements of the Prometheus remote
+// write 1.0 specification:
+//
+//	headers: requests carry the Content-Type, Content-Encoding, X-Prometheus-Remote-Write-Version and User-Agent
+//	headers the specification requires
+//	encoding: the body is a snappy compressed protobuf WriteRequest holding exactly the series sent
+//	label ordering: labels are sent sorted by name, even when the caller's are not
+//	sample ordering: the samples of a series are sent in timestamp order, even when the caller's are not
+//	retry on 5xx: a request answered with 500 is sent again, unchanged
+//	retry on 429: a request answered with 429 is sent again, unchanged
+//	no retry on 4xx: a request answered with 400 is not sent again, and the error is reported
+var ComplianceChecks = []ComplianceCheck{
+	{Name: "headers", Run: checkHeaders},
+	{Name: "encoding", Run: checkEncoding},
+	{Name: "label ordering", Run: checkLabelOrdering},
+	{Name: "sample ordering", Run: checkSampleOrdering},
+	{Name: "retry on 5xx", Run: checkRetry(http.StatusInternalServerError)},
+	{Name: "retry on 429", Run: checkRetry(http.StatusTooManyRequests)},
+	{Name: "no retry on 4xx", Run: checkNoRetry},
+}
+
+// RunCompliance runs every ComplianceCheck against client, each with its own test server, and returns their results
+// in order. It can be used from a test as
+//
+//	for _, result := range writertest.RunCompliance(ctx, writertest.WriterClient(options)) {
+//		if result.Err != nil {
+//			t.Errorf("%s: %v", result.Name, result.Err)
+//		}
+//	}
+func RunCompliance(ctx context.Context, client Client) []ComplianceResult {
+	results := make([]ComplianceResult, len(ComplianceChecks))
+	for i, check := range ComplianceChecks {
+		results[i] = ComplianceResult{Name: check.Name, Err: check.Run(ctx, client)}
+	}
+
+	return results
+}
+
+// recordedRequest is a request a complianceServer received
+type recordedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// complianceServer records the requests it receives and answers them with statuses in turn, then with 204 No Content
+type complianceServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	requests []recordedRequest
+}
+
+func newComplianceServer(statuses ...int) *complianceServer {
+	s := &complianceServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.mu.Lock()
+		s.requests = append(s.requests, recordedRequest{header: r.Header.Clone(), body: body})
+		status := http.StatusNoContent
+		if len(s.statuses) > 0 {
+			status, s.statuses = s.statuses[0], s.statuses[1:]
+		}
+		s.mu.Unlock()
+
+		w.WriteHeader(status)
+	}))
+
+	return s
+}
+
+func (s *complianceServer) recorded() []recordedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.requests)
+}
+
+// push sends series to a new server answering with statuses, and returns what the server received along with the
+// client's error
+func push(ctx context.Context, client Client, series []prompb.TimeSeries, statuses ...int) ([]recordedRequest, error) {
+	s := newComplianceServer(statuses...)
+	defer s.Close()
+
+	err := client(ctx, s.URL, prompb.WriteRequest{Timeseries: series})
+	return s.recorded(), err
+}
+
+// pushOnce is push for checks that expect the client to succeed with a single request
+func pushOnce(ctx context.Context, client Client, series []prompb.TimeSeries) (recordedRequest, error) {
+	requests, err := push(ctx, client, series)
+	if err != nil {
+		return recordedRequest{}, fmt.Errorf("push failed: %w", err)
+	}
+
+	if len(requests) != 1 {
+		return recordedRequest{}, fmt.Errorf("expected 1 request, got %d", len(requests))
+	}
+
+	return requests[0], nil
+}
+
+// decode reads a request body the way the remote write specification says receivers should
+func decode(body []byte) (prompb.WriteRequest, error) {
+	decoded, err := writer.Snappy.Decompress(body)
+	if err != nil {
+		return prompb.WriteRequest{}, fmt.Errorf("body is not snappy compressed: %w", err)
+	}
+
+	wr, err := writer.Protobuf.Unmarshal(decoded)
+	if err != nil {
+		return prompb.WriteRequest{}, fmt.Errorf("body is not a protobuf WriteRequest: %w", err)
+	}
+
+	return wr, nil
+}
+
+func complianceSeries() []prompb.TimeSeries {
+	return []prompb.TimeSeries{
+		{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "compliance_up"}, {Name: "job", Value: "compliance"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}, {Value: 0, Timestamp: 2000}},
+		},
+		{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "compliance_requests_total"}, {Name: "code", Value: "200"}},
+			Samples: []prompb.Sample{{Value: 42, Timestamp: 1000}},
+		},
+	}
+}
+
+func checkHeaders(ctx context.Context, client Client) error {
+	request, err := pushOnce(ctx, client, complianceSeries())
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	expect := func(name, want string) {
+		if got := request.header.Get(name); got != want {
+			errs = append(errs, fmt.Errorf("%s header is %q, expected %q", name, got, want))
+		}
+	}
+	expect("Content-Type", "application/x-protobuf")
+	expect("Content-Encoding", "snappy")
+
+	if version := request.header.Get("X-Prometheus-Remote-Write-Version"); !strings.HasPrefix(version, "0.1.") {
+		errs = append(errs, fmt.Errorf("X-Prometheus-Remote-Write-Version header is %q, expected 0.1.x", version))
+	}
+	if request.header.Get("User-Agent") == "" {
+		errs = append(errs, errors.New("User-Agent header is missing"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func checkEncoding(ctx context.Context, client Client) error {
+	sent := complianceSeries()
+	request, err := pushOnce(ctx, client, sent)
+	if err != nil {
+		return err
+	}
+
+	wr, err := decode(request.body)
+	if err != nil {
+		return err
+	}
+
+	if len(wr.Timeseries) != len(sent) {
+		return fmt.Errorf("expected %d series, got %d", len(sent), len(wr.Timeseries))
+	}
+
+	for i, ts := range wr.Timeseries {
+		if !slices.EqualFunc(ts.Labels, sent[i].Labels, sameLabel) || !slices.EqualFunc(ts.Samples, sent[i].Samples, sameSample) {
+			return fmt.Errorf("series %d was sent as %v, expected %v", i, ts, sent[i])
+		}
+	}
+
+	return receiver.Validate(&wr)
+}
+
+func checkLabelOrdering(ctx context.Context, client Client) error {
+	series := complianceSeries()
+	for i := range series {
+		labels := slices.Clone(series[i].Labels)
+		slices.Reverse(labels)
+		series[i].Labels = labels
+	}
+
+	return checkValid(ctx, client, series)
+}
+
+func checkSampleOrdering(ctx context.Context, client Client) error {
+	series := complianceSeries()
+	for i := range series {
+		samples := slices.Clone(series[i].Samples)
+		slices.Reverse(samples)
+		series[i].Samples = samples
+	}
+
+	return checkValid(ctx, client, series)
+}
+
+// checkValid pushes series and checks that what was sent passes receiver.Validate
+func checkValid(ctx context.Context, client Client, series []prompb.TimeSeries) error {
+	request, err := pushOnce(ctx, client, series)
+	if err != nil {
+		return err
+	}
+
+	wr, err := decode(request.body)
+	if err != nil {
+		return err
+	}
+
+	return receiver.Validate(&wr)
+}
+
+func checkRetry(status int) func(context.Context, Client) error {
+	return func(ctx context.Context, client Client) error {
+		requests, err := push(ctx, client, complianceSeries(), status)
+		if err != nil {
+			return fmt.Errorf("push failed after a single %d response: %w", status, err)
+		}
+
+		if len(requests) != 2 {
+			return fmt.Errorf("expected the request answered with %d to be sent again, got %d requests", status, len(requests))
+		}
+
+		first, second := requests[0], requests[1]
+		if !slices.Equal(first.body, second.body) {
+			return errors.New("the retried request has a different body")
+		}
+
+		return nil
+	}
+}
+
+func checkNoRetry(ctx context.Context, client Client) error {
+	requests, err := push(ctx, client, complianceSeries(), http.StatusBadRequest)
+	if err == nil {
+		return errors.New("a push answered with 400 was reported as successful")
+	}
+
+	if len(requests) != 1 {
+		return fmt.Errorf("expected a request answered with 400 not to be sent again, got %d requests", len(requests))
+	}
+
+	return nil
+}
+
+func sameLabel(a, b prompb.Label) bool {
+	return a.Name == b.Name && a.Value == b.Value
+}
+
+func sameSample(a, b prompb.Sample) bool {
+	return a.Value == b.Value && a.Timestamp == b.Timestamp
+}
@@ -0,0 +1,72 @@
+package writertest_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+
+	"github.com/jghiloni/prometheus-remote-write/writer"
+	"github.com/jghiloni/prometheus-remote-write/writertest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// httpSender posts payloads itself, setting headers only if complete is true
+type httpSender struct {
+	complete bool
+}
+
+func (s httpSender) Send(ctx context.Context, p writer.Payload) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TargetURL, bytes.NewReader(p.Body))
+	if err != nil {
+		return err
+	}
+
+	if s.complete {
+		p.Format.UpdateRequest(req)
+		p.Compression.UpdateRequest(req)
+		req.Header.Set("X-Prometheus-Remote-Write-Version", writer.DefaultRemoteWriteVersion)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return &writer.StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	return nil
+}
+
+var _ = Describe("RunCompliance", func() {
+	failures := func(results []writertest.ComplianceResult) map[string]error {
+		failed := map[string]error{}
+		for _, r := range results {
+			if r.Err != nil {
+				failed[r.Name] = r.Err
+			}
+		}
+		return failed
+	}
+
+	It("passes for the writer", func() {
+		results := writertest.RunCompliance(context.Background(), writertest.WriterClient(writer.RemoteMetricsWriterOptions{}))
+		Expect(results).Should(HaveLen(len(writertest.ComplianceChecks)))
+		Expect(failures(results)).Should(BeEmpty())
+	})
+
+	It("passes for a compliant sender", func() {
+		client := writertest.SenderClient(httpSender{complete: true}, writer.RemoteMetricsWriterOptions{})
+		Expect(failures(writertest.RunCompliance(context.Background(), client))).Should(BeEmpty())
+	})
+
+	It("reports what a non-compliant sender gets wrong", func() {
+		client := writertest.SenderClient(httpSender{}, writer.RemoteMetricsWriterOptions{})
+		failed := failures(writertest.RunCompliance(context.Background(), client))
+		Expect(failed).Should(HaveLen(1))
+		Expect(failed).Should(HaveKeyWithValue("headers", MatchError(ContainSubstring("Content-Encoding"))))
+	})
+})
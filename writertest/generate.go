@@ -0,0 +1,167 @@
+// Package writertest provides helpers for testing code that uses the writer and convert packages, such as synthetic
+// metrics to convert and push.
+package writertest
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultTypes are the metric types GenerateFamilies cycles through when it is not given any
+var DefaultTypes = []dto.MetricType{
+	dto.MetricType_COUNTER,
+	dto.MetricType_GAUGE,
+	dto.MetricType_SUMMARY,
+	dto.MetricType_HISTOGRAM,
+}
+
+var (
+	methods   = []string{"GET", "POST", "PUT", "DELETE"}
+	codes     = []string{"200", "201", "400", "404", "500"}
+	quantiles = []float64{0.5, 0.9, 0.99}
+	buckets   = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+)
+
+// GenerateFamilies returns nFamilies metric families of nSeries metrics each, shaped like what a client_golang
+// registry gathers: families are named writertest_<type>_<n> (counters end in _total), metrics carry sorted code,
+// method and series labels, and their values are plausible for their type (cumulative histogram buckets, quantiles
+// in order, and so on). Families take their types from types in turn, or from DefaultTypes if there are none. The
+// values are random, but the same arguments always produce the same families, so tests built on them are repeatable
+func GenerateFamilies(nFamilies, nSeries int, types ...dto.MetricType) []*dto.MetricFamily {
+	if len(types) == 0 {
+		types = DefaultTypes
+	}
+
+	rng := rand.New(rand.NewPCG(uint64(nFamilies), uint64(nSeries)))
+	families := make([]*dto.MetricFamily, nFamilies)
+	for f := range families {
+		t := types[f%len(types)]
+		kind := strings.ToLower(t.String())
+		name := fmt.Sprintf("writertest_%s_%d", kind, f)
+		if t == dto.MetricType_COUNTER {
+			name += "_total"
+		}
+
+		family := &dto.MetricFamily{
+			Name:   proto.String(name),
+			Help:   proto.String(fmt.Sprintf("Synthetic %s number %d.", strings.ReplaceAll(kind, "_", " "), f)),
+			Type:   t.Enum(),
+			Metric: make([]*dto.Metric, nSeries),
+		}
+		for s := range family.Metric {
+			family.Metric[s] = generateMetric(rng, t, s)
+		}
+		families[f] = family
+	}
+
+	return families
+}
+
+func generateMetric(rng *rand.Rand, t dto.MetricType, series int) *dto.Metric {
+	metric := &dto.Metric{
+		Label: []*dto.LabelPair{
+			{Name: proto.String("code"), Value: proto.String(codes[series%len(codes)])},
+			{Name: proto.String("method"), Value: proto.String(methods[series%len(methods)])},
+			{Name: proto.String("series"), Value: proto.String(strconv.Itoa(series))},
+		},
+	}
+
+	switch t {
+	case dto.MetricType_COUNTER:
+		metric.Counter = &dto.Counter{Value: proto.Float64(float64(rng.IntN(100_000)))}
+	case dto.MetricType_GAUGE:
+		metric.Gauge = &dto.Gauge{Value: proto.Float64(rng.NormFloat64() * 100)}
+	case dto.MetricType_UNTYPED:
+		metric.Untyped = &dto.Untyped{Value: proto.Float64(rng.Float64() * 1000)}
+	case dto.MetricType_SUMMARY:
+		count := uint64(rng.IntN(10_000) + 1)
+		summary := &dto.Summary{SampleCount: proto.Uint64(count)}
+		value := 0.0
+		for _, q := range quantiles {
+			value += rng.ExpFloat64() / 10
+			summary.Quantile = append(summary.Quantile, &dto.Quantile{
+				Quantile: proto.Float64(q),
+				Value:    proto.Float64(value),
+			})
+		}
+		// the median times the count is a plausible sum for the skewed distributions summaries usually track
+		summary.SampleSum = proto.Float64(summary.Quantile[0].GetValue() * float64(count))
+		metric.Summary = summary
+	case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+		histogram := &dto.Histogram{}
+		var count uint64
+		sum := 0.0
+		for _, upper := range buckets {
+			observed := uint64(rng.IntN(1000))
+			count += observed
+			sum += float64(observed) * upper / 2
+			histogram.Bucket = append(histogram.Bucket, &dto.Bucket{
+				UpperBound:      proto.Float64(upper),
+				CumulativeCount: proto.Uint64(count),
+			})
+		}
+		histogram.SampleCount = proto.Uint64(count)
+		histogram.SampleSum = proto.Float64(sum)
+		metric.Histogram = histogram
+	}
+
+	return metric
+}
+
+// SeriesGenerator builds pushes of synthetic series named writertest_series whose values follow a random walk, for
+// pushing the same series again and again the way a scraper would
+type SeriesGenerator struct {
+	rng    *rand.Rand
+	labels [][]prompb.Label
+	values []float64
+}
+
+// NewSeriesGenerator returns a generator of nSeries series with nLabels labels each besides __name__, named label_0,
+// label_1 and so on. label_0 tells the series apart; the others spread them over a few values each, like real
+// dimensions. Like GenerateFamilies, the same arguments always produce the same values
+func NewSeriesGenerator(nSeries, nLabels int) *SeriesGenerator {
+	g := &SeriesGenerator{
+		rng:    rand.New(rand.NewPCG(uint64(nSeries), uint64(nLabels))),
+		labels: make([][]prompb.Label, nSeries),
+		values: make([]float64, nSeries),
+	}
+	for i := range nSeries {
+		ls := []prompb.Label{{Name: "__name__", Value: "writertest_series"}}
+		for l := range nLabels {
+			value := strconv.Itoa(i)
+			if l > 0 {
+				value = strconv.Itoa(i % (10 * l))
+			}
+			ls = append(ls, prompb.Label{Name: "label_" + strconv.Itoa(l), Value: value})
+		}
+		g.labels[i] = ls
+	}
+
+	return g
+}
+
+// Next advances every series by nSamples steps of its random walk and returns them as samples spaced evenly over the
+// interval ending at now. A SeriesGenerator is not safe for concurrent use
+func (g *SeriesGenerator) Next(nSamples int, interval time.Duration, now time.Time) []prompb.TimeSeries {
+	step := interval / time.Duration(nSamples)
+	ts := make([]prompb.TimeSeries, len(g.labels))
+	for i, labels := range g.labels {
+		ts[i] = prompb.TimeSeries{Labels: labels, Samples: make([]prompb.Sample, nSamples)}
+		for s := range nSamples {
+			g.values[i] += g.rng.NormFloat64()
+			ts[i].Samples[s] = prompb.Sample{
+				Value:     g.values[i],
+				Timestamp: now.Add(-time.Duration(nSamples-1-s) * step).UnixMilli(),
+			}
+		}
+	}
+
+	return ts
+}
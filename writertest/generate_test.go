@@ -0,0 +1,82 @@
+package writertest_test
+
+import (
+	"time"
+
+	"github.com/jghiloni/prometheus-remote-write/convert"
+	"github.com/jghiloni/prometheus-remote-write/writertest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var _ = Describe("GenerateFamilies", func() {
+	It("generates families of the requested types", func() {
+		families := writertest.GenerateFamilies(6, 10, dto.MetricType_COUNTER, dto.MetricType_HISTOGRAM)
+		Expect(families).Should(HaveLen(6))
+
+		for i, family := range families {
+			Expect(family.GetMetric()).Should(HaveLen(10))
+			if i%2 == 0 {
+				Expect(family.GetType()).Should(Equal(dto.MetricType_COUNTER))
+				Expect(family.GetName()).Should(HaveSuffix("_total"))
+			} else {
+				Expect(family.GetType()).Should(Equal(dto.MetricType_HISTOGRAM))
+				buckets := family.GetMetric()[0].GetHistogram().GetBucket()
+				Expect(buckets[len(buckets)-1].GetCumulativeCount()).Should(Equal(family.GetMetric()[0].GetHistogram().GetSampleCount()))
+			}
+		}
+	})
+
+	It("is repeatable", func() {
+		Expect(writertest.GenerateFamilies(4, 5)).Should(Equal(writertest.GenerateFamilies(4, 5)))
+	})
+
+	It("generates metrics that convert", func() {
+		families := writertest.GenerateFamilies(4, 5, writertest.DefaultTypes...)
+		series, metadata := convert.FromMetricFamilies(families, convert.MetricFamilyOptions{})
+		Expect(metadata).Should(HaveLen(4))
+		// a counter and a gauge give one series per metric; a summary gives 3 quantiles, _sum and _count; a histogram
+		// gives 11 buckets, the +Inf bucket, _sum and _count
+		Expect(series).Should(HaveLen(5 + 5 + 5*5 + 5*14))
+	})
+})
+
+var _ = Describe("SeriesGenerator", func() {
+	now := time.UnixMilli(1_700_000_000_000)
+
+	It("generates the same series on every push", func() {
+		g := writertest.NewSeriesGenerator(20, 3)
+		first := g.Next(4, time.Minute, now)
+		Expect(first).Should(HaveLen(20))
+		Expect(first[7].Labels).Should(Equal([]prompb.Label{
+			{Name: "__name__", Value: "writertest_series"},
+			{Name: "label_0", Value: "7"},
+			{Name: "label_1", Value: "7"},
+			{Name: "label_2", Value: "7"},
+		}))
+		Expect(first[17].Labels[2:]).Should(Equal([]prompb.Label{
+			{Name: "label_1", Value: "7"},
+			{Name: "label_2", Value: "17"},
+		}))
+
+		second := g.Next(4, time.Minute, now.Add(time.Minute))
+		for i := range first {
+			Expect(second[i].Labels).Should(Equal(first[i].Labels))
+		}
+	})
+
+	It("spaces samples evenly over the interval ending now", func() {
+		series := writertest.NewSeriesGenerator(1, 1).Next(4, time.Minute, now)
+		Expect(series[0].Samples).Should(HaveLen(4))
+		for i, s := range series[0].Samples {
+			Expect(s.Timestamp).Should(Equal(now.Add(-time.Duration(3-i) * 15 * time.Second).UnixMilli()))
+		}
+	})
+
+	It("is repeatable", func() {
+		Expect(writertest.NewSeriesGenerator(5, 2).Next(3, time.Minute, now)).
+			Should(Equal(writertest.NewSeriesGenerator(5, 2).Next(3, time.Minute, now)))
+	})
+})
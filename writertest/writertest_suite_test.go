@@ -0,0 +1,13 @@
+package writertest_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestWritertest(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Writertest Suite")
+}